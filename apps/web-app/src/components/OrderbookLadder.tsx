@@ -4,6 +4,9 @@ import { motion, AnimatePresence } from 'framer-motion';
 interface Order {
 	price: number;
 	quantity: number;
+	// Resting on this outcome vs. reachable through the opposite outcome
+	direct?: number;
+	implied?: number;
 }
 
 interface OrderbookLadderProps {
@@ -101,8 +104,20 @@ const OrderRow = React.memo(
 				<span className={`font-bold transition-all ${textColor}`}>{order.price.toFixed(1)}</span>
 				<span
 					className={`text-center font-semibold tracking-tight transition-colors ${isHighlighted ? 'text-foreground' : 'text-muted-foreground'}`}
+					title={
+						order.implied
+							? `${order.direct ?? 0} direct, ${order.implied} implied from the opposite outcome`
+							: undefined
+					}
 				>
-					{order.quantity}
+					{order.implied ? (
+						<>
+							{order.direct ?? 0}
+							<span className="italic font-normal opacity-60"> +{order.implied}</span>
+						</>
+					) : (
+						order.quantity
+					)}
 				</span>
 				<span
 					className={`text-right font-medium tracking-tight transition-colors ${isHighlighted ? 'text-foreground' : 'text-muted-foreground'}`}
@@ -33,6 +33,21 @@ interface TradeExecutedEvent {
 	makerName?: string;
 }
 
+// direct rests on the outcome itself, implied on the opposite outcome at 10 - price
+interface BookLevel {
+	price: number;
+	quantity: number;
+	direct: number;
+	implied: number;
+}
+
+interface OutcomeBook {
+	bids: BookLevel[];
+	asks: BookLevel[];
+}
+
+const emptyBook = (): OutcomeBook => ({ bids: [], asks: [] });
+
 interface Market {
 	symbol: string;
 	marketId: string;
@@ -41,8 +56,8 @@ interface Market {
 	yesPrice: number;
 	noPrice: number;
 	orderbook: {
-		yes: any[];
-		no: any[];
+		yes: OutcomeBook;
+		no: OutcomeBook;
 	};
 	timeline: any[];
 	trades: TradeExecutedEvent[];
@@ -132,35 +147,34 @@ export default function EventDetails() {
 				if (!prev) return prev;
 				try {
 					const updatedOrderbook = {
-						yes: [...(prev.orderbook?.yes || [])],
-						no: [...(prev.orderbook?.no || [])],
+						yes: { ...emptyBook(), ...prev.orderbook?.yes },
+						no: { ...emptyBook(), ...prev.orderbook?.no },
 					};
 
-					['yes', 'no'].forEach((side) => {
-						const sideKey = side as keyof typeof updatedOrderbook;
-						const capitalized = side.charAt(0).toUpperCase() + side.slice(1);
-						const updates = incomingOrderbook[side] || incomingOrderbook[capitalized];
-						if (!Array.isArray(updates)) return;
+					(['yes', 'no'] as const).forEach((outcome) => {
+						(['bids', 'asks'] as const).forEach((side) => {
+							const updates = incomingOrderbook[outcome]?.[side];
+							if (!Array.isArray(updates)) return;
 
-						updates.forEach((update: any) => {
-							const idx = updatedOrderbook[sideKey].findIndex((o: any) => o.price === update.price);
+							const levels = [...(updatedOrderbook[outcome][side] || [])];
+							updates.forEach((update: BookLevel) => {
+								const idx = levels.findIndex((l) => l.price === update.price);
 
-							if (idx > -1) {
-								if (update.quantity > 0) {
-									updatedOrderbook[sideKey][idx] = update;
-								} else {
-									updatedOrderbook[sideKey].splice(idx, 1);
-								}
-							} else {
-								if (update.quantity > 0) {
-									updatedOrderbook[sideKey].push(update);
+								if (idx > -1) {
+									if (update.quantity > 0) {
+										levels[idx] = update;
+									} else {
+										levels.splice(idx, 1);
+									}
+								} else if (update.quantity > 0) {
+									levels.push(update);
 								}
-							}
-						});
+							});
 
-						updatedOrderbook[sideKey].sort((a: any, b: any) =>
-							side === 'yes' ? b.price - a.price : a.price - b.price,
-						);
+							// Bids best (highest) first, asks best (lowest) first
+							levels.sort((a, b) => (side === 'bids' ? b.price - a.price : a.price - b.price));
+							updatedOrderbook[outcome] = { ...updatedOrderbook[outcome], [side]: levels };
+						});
 					});
 
 					return {
@@ -174,6 +188,42 @@ export default function EventDetails() {
 			});
 		};
 
+		// Diffs are applied in seq order; after a missed seq, or an engine restart that
+		// numbers from 1 again, the book waits for the next snapshot
+		let bookSeq: number | null = null;
+		let bookStale = false;
+
+		const handleBookDiff = (data: any) => {
+			if (typeof data?.seq !== 'number') return;
+			// Also delivered as MESSAGE when subscribed to both rooms
+			if (data.seq === bookSeq) return;
+			if (bookSeq !== null && data.seq !== bookSeq + 1) {
+				bookStale = true;
+			}
+			bookSeq = data.seq;
+			if (!bookStale) {
+				handleOrderbook(data);
+			}
+		};
+
+		const handleBookSnapshot = (data: any) => {
+			const incomingOrderbook = data?.orderbook;
+			if (!incomingOrderbook || typeof data.seq !== 'number') return;
+			bookSeq = data.seq;
+			bookStale = false;
+
+			setMarket((prev: Market | null) => {
+				if (!prev) return prev;
+				return {
+					...prev,
+					orderbook: {
+						yes: { ...emptyBook(), ...incomingOrderbook.yes },
+						no: { ...emptyBook(), ...incomingOrderbook.no },
+					},
+				};
+			});
+		};
+
 		const handleActivity = (data: any) => {
 			const incomingTrades = data?.trades || (data?.trade ? [data.trade] : null);
 			if (!incomingTrades || !Array.isArray(incomingTrades) || incomingTrades.length === 0) return;
@@ -214,8 +264,10 @@ export default function EventDetails() {
 			if (!data) return;
 			if (data.type === 'TICKER') {
 				handleTicker(data);
-			} else if (data.type === 'ORDERBOOK') {
-				handleOrderbook(data);
+			} else if (data.type === 'BOOK_DIFF') {
+				handleBookDiff(data);
+			} else if (data.type === 'BOOK_SNAPSHOT') {
+				handleBookSnapshot(data);
 			} else if (data.type === 'ACTIVITY') {
 				handleActivity(data);
 			} else {
@@ -238,14 +290,16 @@ export default function EventDetails() {
 		};
 
 		socket.on('TICKER', handleTicker);
-		socket.on('ORDERBOOK', handleOrderbook);
+		socket.on('BOOK_DIFF', handleBookDiff);
+		socket.on('BOOK_SNAPSHOT', handleBookSnapshot);
 		socket.on('ACTIVITY', handleActivity);
 		socket.on('MESSAGE', handleGenericMessage);
 
 		return () => {
 			socket.emit('UNSUBSCRIBE_MARKET', symbol);
 			socket.off('TICKER', handleTicker);
-			socket.off('ORDERBOOK', handleOrderbook);
+			socket.off('BOOK_DIFF', handleBookDiff);
+			socket.off('BOOK_SNAPSHOT', handleBookSnapshot);
 			socket.off('ACTIVITY', handleActivity);
 			socket.off('MESSAGE', handleGenericMessage);
 			socket.off('connect');
@@ -301,34 +355,14 @@ export default function EventDetails() {
 	if (!market) return <p className="p-4 text-foreground">Market not found.</p>;
 
 	const calculateOrderbookDisplay = (outcome: 'Yes' | 'No') => {
-		let bids: any[] = [];
-		let asks: any[] = [];
-
-		if (outcome === 'Yes') {
-			bids = (market.orderbook?.yes || [])
-				.filter((o) => o.price > 0 && o.quantity > 0)
-				.sort((a, b) => b.price - a.price)
-				.slice(0, 15);
-
-			asks = (market.orderbook?.no || [])
-				.filter((o) => o.price > 0 && o.quantity > 0 && o.price < 10)
-				.map((o) => ({ price: 10 - o.price, quantity: o.quantity }))
-				.sort((a, b) => b.price - a.price)
-				.slice(0, 15);
-		} else {
-			bids = (market.orderbook?.no || [])
-				.filter((o) => o.price > 0 && o.quantity > 0)
-				.sort((a, b) => b.price - a.price)
-				.slice(0, 15);
-
-			asks = (market.orderbook?.yes || [])
-				.filter((o) => o.price > 0 && o.quantity > 0 && o.price < 10)
-				.map((o) => ({ price: 10 - o.price, quantity: o.quantity }))
-				.sort((a, b) => b.price - a.price)
-				.slice(0, 15);
-		}
+		// The engine already folds in the opposite outcome's orders as implied levels
+		const book = (outcome === 'Yes' ? market.orderbook?.yes : market.orderbook?.no) || emptyBook();
+		const executable = (l: BookLevel) => l.price > 0 && l.price < 10 && l.quantity > 0;
 
-		return { bids, asks };
+		return {
+			bids: (book.bids || []).filter(executable).slice(0, 15),
+			asks: (book.asks || []).filter(executable).slice(0, 15),
+		};
 	};
 
 	const { bids, asks } = calculateOrderbookDisplay(innerTab);
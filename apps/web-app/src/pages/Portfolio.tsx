@@ -113,13 +113,21 @@ export default function Portfolio() {
 		if (isAuthenticated) fetchPortfolio();
 
 		if (isAuthenticated && user?.id) {
+			// The stream service only lets a socket into the user room named by a stream token
+			const subscribeUser = async () => {
+				try {
+					const res = await api.get('/auth/stream-token');
+					socket.emit('SUBSCRIBE_USER', res.data.data.token);
+				} catch (err) {
+					console.error('Failed to subscribe to portfolio updates', err);
+				}
+			};
+
 			if (socket.connected) {
-				socket.emit('SUBSCRIBE_USER', user.id);
+				subscribeUser();
 			} else {
 				socket.connect();
-				socket.once('connect', () => {
-					socket.emit('SUBSCRIBE_USER', user.id);
-				});
+				socket.once('connect', subscribeUser);
 			}
 
 			const handlePortfolioUpdate = () => {
@@ -127,19 +135,12 @@ export default function Portfolio() {
 				refetchBalance();
 			};
 
-			const handleMessage = (msgData: any) => {
-				if (msgData?.type === 'PORTFOLIO_UPDATE') {
-					handlePortfolioUpdate();
-				}
-			};
-
 			socket.on('PORTFOLIO_UPDATE', handlePortfolioUpdate);
-			socket.on('MESSAGE', handleMessage);
 
 			return () => {
-				socket.emit('UNSUBSCRIBE_USER', user.id);
+				socket.off('connect', subscribeUser);
+				socket.emit('UNSUBSCRIBE_USER');
 				socket.off('PORTFOLIO_UPDATE', handlePortfolioUpdate);
-				socket.off('MESSAGE', handleMessage);
 			};
 		}
 	}, [isAuthenticated, user?.id, refetchBalance]);
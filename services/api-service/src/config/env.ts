@@ -17,6 +17,7 @@ export const ENV = {
 	REFRESH_TOKEN_SECRET: checkEnv('REFRESH_TOKEN_SECRET'),
 	ACCESS_TOKEN_EXPIRY: checkEnv('ACCESS_TOKEN_EXPIRY'),
 	REFRESH_TOKEN_EXPIRY: checkEnv('REFRESH_TOKEN_EXPIRY'),
+	STREAM_TOKEN_SECRET: checkEnv('STREAM_TOKEN_SECRET'),
 
 	BACKEND_ORIGIN: checkEnv('BACKEND_ORIGIN'),
 
@@ -10,6 +10,7 @@ import { prisma, AuthProvider } from '@probstreet/database';
 import { client as redis } from '@/libs/redis/connection';
 import {
 	generateAccessToken,
+	generateStreamToken,
 	generateRefreshTokenString,
 	hashRefreshToken,
 	setAuthCookies,
@@ -631,6 +632,23 @@ export const getMe = async (c: Context) => {
 	}
 };
 
+/**
+ * @desc Issues a short-lived token the web app hands to the stream service to receive the authenticated user's private order and balance updates.
+ * @param c Hono Context
+ * @returns JSON response
+ */
+export const getStreamToken = async (c: Context) => {
+	try {
+		const user = c.get('user');
+		if (!user) return c.json({ success: false, error: 'Unauthorized' }, 401);
+		const token = await generateStreamToken(user.id);
+		return c.json({ success: true, data: { token } });
+	} catch (error) {
+		logger.error({ error }, 'Failed to issue stream token');
+		return c.json({ success: false, error: 'Internal server error' }, 500);
+	}
+};
+
 /**
  * @desc Retrieves a list of all active, non-revoked sessions for the authenticated user across all devices.
  * @param c Hono Context
@@ -5,6 +5,10 @@ import { client, pubsubClient } from '@/libs/redis/connection';
 /**
  * Pushes an event payload to the Redis queue and waits for a response.
  *
+ * The engine publishes the reply on `engine:response:<id>` and also stores it
+ * under `engine:result:<id>` for a short time, so a reply that was published
+ * while this instance was not subscribed can still be fetched.
+ *
  * @param eventType - The type of event being pushed (e.g., "ADD_BALANCE")
  * @param data - The payload data to send with the event
  * @returns The response from the engine if successful, or an error object
@@ -18,6 +22,39 @@ type EngineResponse = {
 	retryable?: boolean;
 };
 
+const parseEngineResponse = (message: string): EngineResponse => {
+	try {
+		const parsed = JSON.parse(message);
+
+		const status = parsed.status ?? parsed.Status;
+		const messageText = parsed.message ?? parsed.Message;
+		const retryable = parsed.retryable ?? parsed.Retryable;
+		const data = parsed.data ?? parsed.Data;
+
+		return {
+			success: status === 'success',
+			message: messageText || '',
+			data: data ?? null,
+			error: status === 'error' ? messageText : undefined,
+			retryable: retryable ?? true,
+		};
+	} catch (err) {
+		return {
+			success: false,
+			message: 'Invalid JSON from engine',
+			error: 'Parse error',
+		};
+	}
+};
+
+/**
+ * Fetches a stored engine reply, e.g. after the pub/sub notification was missed.
+ */
+export const fetchEngineResponse = async (responseId: string): Promise<EngineResponse | null> => {
+	const stored = await client.get(`engine:result:${responseId}`);
+	return stored ? parseEngineResponse(stored) : null;
+};
+
 export const pushToQueue = async (eventType: string, data: any): Promise<EngineResponse> => {
 	const responseId = uuid();
 	const responseChannel = `engine:response:${responseId}`;
@@ -34,37 +71,19 @@ export const pushToQueue = async (eventType: string, data: any): Promise<EngineR
 
 		logger.info({ responseId }, 'Waiting for engine response');
 
+		const finish = async (response: EngineResponse) => {
+			if (handled) return;
+			handled = true;
+			if (timeout) clearTimeout(timeout);
+			await pubsubClient.unsubscribe(responseChannel);
+			pubsubClient.removeListener('message', messageHandler);
+			resolve(response);
+		};
+
 		const messageHandler = async (channel: string, message: string) => {
 			if (channel === responseChannel) {
 				logger.info({ responseId, message }, 'Received engine response');
-
-				handled = true;
-				if (timeout) clearTimeout(timeout);
-				await pubsubClient.unsubscribe(responseChannel);
-				pubsubClient.removeListener('message', messageHandler);
-
-				try {
-					const parsed = JSON.parse(message);
-
-					const status = parsed.status ?? parsed.Status;
-					const messageText = parsed.message ?? parsed.Message;
-					const retryable = parsed.retryable ?? parsed.Retryable;
-					const data = parsed.data ?? parsed.Data;
-
-					resolve({
-						success: status === 'success',
-						message: messageText || '',
-						data: data ?? null,
-						error: status === 'error' ? messageText : undefined,
-						retryable: retryable ?? true,
-					});
-				} catch (err) {
-					resolve({
-						success: false,
-						message: 'Invalid JSON from engine',
-						error: 'Parse error',
-					});
-				}
+				await finish(parseEngineResponse(message));
 			}
 		};
 
@@ -72,20 +91,26 @@ export const pushToQueue = async (eventType: string, data: any): Promise<EngineR
 
 		await pubsubClient.subscribe(responseChannel);
 
-		await client.lpush('engine:queue', JSON.stringify(payload));
-		logger.info({ payload }, 'Pushed payload to engine queue');
+		await client.xadd('engine:stream', 'MAXLEN', '~', '100000', '*', 'payload', JSON.stringify(payload));
+		logger.info({ payload }, 'Pushed payload to engine stream');
 
 		timeout = setTimeout(async () => {
-			if (!handled) {
-				logger.warn({ responseChannel }, '⏰ Timeout waiting for engine response');
-				await pubsubClient.unsubscribe(responseChannel);
-				pubsubClient.removeListener('message', messageHandler);
-				resolve({
-					success: false,
-					message: 'Timeout waiting for engine response',
-					retryable: true,
-				});
+			if (handled) return;
+
+			// The notification may have been published while we were not subscribed
+			const stored = await fetchEngineResponse(responseId).catch(() => null);
+			if (stored) {
+				logger.info({ responseId }, 'Recovered engine response from result key');
+				await finish(stored);
+				return;
 			}
+
+			logger.warn({ responseChannel }, '⏰ Timeout waiting for engine response');
+			await finish({
+				success: false,
+				message: 'Timeout waiting for engine response',
+				retryable: true,
+			});
 		}, 5000);
 	});
 };
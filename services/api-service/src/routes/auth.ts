@@ -8,6 +8,7 @@ import {
 	logout,
 	refresh,
 	getMe,
+	getStreamToken,
 	getSessions,
 	logoutAll,
 } from '@/controllers/auth';
@@ -31,5 +32,6 @@ authRoutes.use('/*', authorization);
 authRoutes.post('/refresh', refresh);
 
 authRoutes.get('/me', getMe);
+authRoutes.get('/stream-token', getStreamToken);
 authRoutes.get('/sessions', getSessions);
 authRoutes.post('/logout-all', logoutAll);
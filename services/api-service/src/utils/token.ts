@@ -31,6 +31,18 @@ export const verifyAccessToken = async (token: string): Promise<AccessTokenPaylo
 	return verify(token, ENV.ACCESS_TOKEN_SECRET, 'HS256') as Promise<AccessTokenPayload>;
 };
 
+// Stream tokens only let a socket join its user's room on the stream service. They are
+// signed with their own secret so one read by the page cannot be used as an access token.
+const STREAM_TOKEN_EXPIRY = 60;
+
+export const generateStreamToken = async (id: string): Promise<string> => {
+	return sign(
+		{ id, scope: 'stream', exp: Math.floor(Date.now() / 1000) + STREAM_TOKEN_EXPIRY },
+		ENV.STREAM_TOKEN_SECRET,
+		'HS256',
+	);
+};
+
 export const generateRefreshTokenString = (): string => {
 	return crypto.randomBytes(40).toString('hex');
 };
@@ -0,0 +1,25 @@
+// Command eventdoc regenerates events.proto and docs/events.md from the event
+// catalogue. It runs through go generate in internals/schema, so paths are relative
+// to that directory.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"matching-engine/internals/schema"
+)
+
+func main() {
+	protoPath := flag.String("proto", "events.proto", "where to write the Protobuf schema")
+	docPath := flag.String("doc", "../../docs/events.md", "where to write the Markdown reference")
+	flag.Parse()
+
+	if err := os.WriteFile(*protoPath, schema.ProtoFile(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*docPath, schema.Markdown(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,283 @@
+// Command loadgen drives the engine through its Redis intake to observe inbox
+// backpressure. It creates its own markets and users, fires a stream of orders and
+// cancels at the requested rate and reports latency, MARKET_BUSY rejections and the
+// per-market inbox stats the engine recorded.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"matching-engine/internals/types"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type result struct {
+	response types.QueueResponse
+	latency  time.Duration
+	err      error
+}
+
+type generator struct {
+	client  *redis.Client
+	stream  string
+	runId   string
+	timeout time.Duration
+	pending sync.Map // responseId -> chan types.QueueResponse
+}
+
+func main() {
+	redisURL := flag.String("redis", os.Getenv("REDIS_URL"), "redis url")
+	stream := flag.String("stream", "engine:stream", "intake stream")
+	markets := flag.Int("markets", 3, "number of markets to create")
+	users := flag.Int("users", 50, "number of funded users to create")
+	orders := flag.Int("orders", 10000, "number of commands to send")
+	rate := flag.Int("rate", 1000, "commands per second (0 = as fast as possible)")
+	concurrency := flag.Int("concurrency", 128, "commands in flight at once")
+	cancelRatio := flag.Float64("cancel-ratio", 0.1, "share of commands that cancel an earlier order")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for each reply")
+	flag.Parse()
+
+	option, err := redis.ParseURL(*redisURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -redis url:", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	g := &generator{
+		client:  redis.NewClient(option),
+		stream:  *stream,
+		runId:   "lg" + uuid.New().String()[:8],
+		timeout: *timeout,
+	}
+
+	if err := g.listen(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "subscribe:", err)
+		os.Exit(1)
+	}
+
+	symbols, userIds, err := g.setup(ctx, *markets, *users)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "setup:", err)
+		os.Exit(1)
+	}
+
+	results := g.run(ctx, symbols, userIds, *orders, *rate, *concurrency, *cancelRatio)
+	report(results)
+
+	stats, _, err := g.request(ctx, "GET_INBOX_STATS", map[string]interface{}{})
+	if err == nil {
+		out, _ := json.MarshalIndent(stats.Data, "", "  ")
+		fmt.Println("inbox stats:")
+		fmt.Println(string(out))
+	}
+}
+
+// listen routes every reply for this run to the request waiting on it.
+func (g *generator) listen(ctx context.Context) error {
+	sub := g.client.PSubscribe(ctx, "engine:response:"+g.runId+"-*")
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range sub.Channel() {
+			var response types.QueueResponse
+			if err := json.Unmarshal([]byte(msg.Payload), &response); err != nil {
+				continue
+			}
+			if ch, ok := g.pending.LoadAndDelete(response.ResponseId); ok {
+				ch.(chan types.QueueResponse) <- response
+			}
+		}
+	}()
+	return nil
+}
+
+func (g *generator) request(ctx context.Context, eventType string, data map[string]interface{}) (types.QueueResponse, time.Duration, error) {
+	responseId := g.runId + "-" + uuid.New().String()
+	reply := make(chan types.QueueResponse, 1)
+	g.pending.Store(responseId, reply)
+	defer g.pending.Delete(responseId)
+
+	payload, _ := json.Marshal(types.QueuePayload{ResponseId: responseId, EventType: eventType, Data: data})
+
+	start := time.Now()
+	err := g.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: g.stream,
+		Values: map[string]interface{}{"payload": string(payload)},
+	}).Err()
+	if err != nil {
+		return types.QueueResponse{}, 0, err
+	}
+
+	select {
+	case response := <-reply:
+		return response, time.Since(start), nil
+	case <-time.After(g.timeout):
+		return types.QueueResponse{}, time.Since(start), fmt.Errorf("timed out waiting for %s", eventType)
+	}
+}
+
+func (g *generator) setup(ctx context.Context, markets, users int) ([]string, []string, error) {
+	symbols := make([]string, markets)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("%s-M%d", g.runId, i)
+		resp, _, err := g.request(ctx, "CREATE_MARKET", map[string]interface{}{
+			"marketId":  symbols[i],
+			"symbol":    symbols[i],
+			"title":     "load test " + symbols[i],
+			"startDate": time.Now().UTC().Format(time.RFC3339),
+			"endDate":   time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339),
+		})
+		if err != nil || resp.Status != types.Success {
+			return nil, nil, fmt.Errorf("create market %s: %v %s", symbols[i], err, resp.Message)
+		}
+	}
+
+	userIds := make([]string, users)
+	for i := range userIds {
+		userIds[i] = fmt.Sprintf("%s-U%d", g.runId, i)
+		if _, _, err := g.request(ctx, "CREATE_USER", map[string]interface{}{"id": userIds[i]}); err != nil {
+			return nil, nil, err
+		}
+		resp, _, err := g.request(ctx, "DEPOSIT_BALANCE", map[string]interface{}{"userId": userIds[i], "amount": 1e6})
+		if err != nil || resp.Status != types.Success {
+			return nil, nil, fmt.Errorf("fund user %s: %v %s", userIds[i], err, resp.Message)
+		}
+	}
+
+	return symbols, userIds, nil
+}
+
+type placed struct {
+	userId, symbol, orderId string
+}
+
+func (g *generator) run(ctx context.Context, symbols, userIds []string, orders, rate, concurrency int, cancelRatio float64) []result {
+	results := make([]result, orders)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	var mu sync.Mutex
+	var resting []placed
+
+	var tick <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var sent int64
+	start := time.Now()
+	for i := 0; i < orders; i++ {
+		if tick != nil {
+			<-tick
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+
+		var eventType string
+		var data map[string]interface{}
+
+		mu.Lock()
+		if len(resting) > 0 && rand.Float64() < cancelRatio {
+			k := rand.Intn(len(resting))
+			o := resting[k]
+			resting = append(resting[:k], resting[k+1:]...)
+			eventType = "CANCEL_ORDER"
+			data = map[string]interface{}{"userId": o.userId, "symbol": o.symbol, "orderId": o.orderId}
+		}
+		mu.Unlock()
+
+		if eventType == "" {
+			o := placed{
+				userId:  userIds[rand.Intn(len(userIds))],
+				symbol:  symbols[rand.Intn(len(symbols))],
+				orderId: uuid.New().String(),
+			}
+			side := types.Yes
+			if rand.Intn(2) == 1 {
+				side = types.No
+			}
+			eventType = "PLACE_ORDER"
+			data = map[string]interface{}{
+				"userId":    o.userId,
+				"orderId":   o.orderId,
+				"marketId":  o.symbol,
+				"symbol":    o.symbol,
+				"side":      string(side),
+				"price":     float64(1+rand.Intn(17)) * 0.5,
+				"quantity":  1 + rand.Intn(10),
+				"action":    string(types.BUY),
+				"orderType": string(types.LIMIT),
+			}
+			mu.Lock()
+			resting = append(resting, o)
+			mu.Unlock()
+		}
+
+		go func(i int, eventType string, data map[string]interface{}) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, latency, err := g.request(ctx, eventType, data)
+			results[i] = result{response: resp, latency: latency, err: err}
+			atomic.AddInt64(&sent, 1)
+		}(i, eventType, data)
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	fmt.Printf("sent %d commands in %s (%.0f/s)\n", sent, elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds())
+	return results
+}
+
+func report(results []result) {
+	outcomes := make(map[string]int)
+	latencies := make([]time.Duration, 0, len(results))
+
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			outcomes["timeout"]++
+			continue
+		case r.response.Status == types.Success:
+			outcomes["success"]++
+		case r.response.Message == "MARKET_BUSY":
+			outcomes["market_busy"]++
+		default:
+			outcomes["error: "+r.response.Message]++
+		}
+		latencies = append(latencies, r.latency)
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	pct := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+
+	keys := make([]string, 0, len(outcomes))
+	for k := range outcomes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%-40s %d\n", k, outcomes[k])
+	}
+	fmt.Printf("latency p50 %s p95 %s p99 %s max %s\n", pct(0.50), pct(0.95), pct(0.99), pct(1))
+}
@@ -2,19 +2,45 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"matching-engine/internals/admin"
+	"matching-engine/internals/config"
 	"matching-engine/internals/engine"
+	"matching-engine/internals/events"
+	"matching-engine/internals/journal"
+	"matching-engine/internals/outbox"
+	"matching-engine/internals/schema"
 	"matching-engine/internals/services/kafka"
 	"matching-engine/internals/services/redis"
+	"matching-engine/internals/tracing"
 	"matching-engine/internals/utils"
 
 	"github.com/joho/godotenv"
+	goredis "github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 )
 
 func main() {
 
+	reconcileSource := flag.String("reconcile", "", "reconcile engine memory against a balance export (file:<path> or redis:<key>) and exit")
+	emitAdjustments := flag.Bool("emit-adjustments", false, "not supported with -reconcile: adjustments must be requested on the running engine")
+	var recoverOpts recoverFlags
+	flag.BoolVar(&recoverOpts.fromKafka, "recover-from-kafka", false, "rebuild engine state from the Kafka event topics, verify it against the latest snapshot and exit")
+	flag.StringVar(&recoverOpts.file, "recover-file", "", "rebuild engine state from a file of JSON events (the spool format) instead of Kafka")
+	flag.Int64Var(&recoverOpts.offset, "recover-offset", 0, "with -recover-from-kafka, the offset to start every partition from; defaults to the oldest retained")
+	flag.StringVar(&recoverOpts.since, "recover-since", "", "with -recover-from-kafka, start from the first event at or after this RFC3339 time")
+	flag.StringVar(&recoverOpts.base, "recover-base", "", "snapshot file (JSON or .gz) to replay on top of; events up to its seq are skipped")
+	flag.StringVar(&recoverOpts.verify, "recover-verify", "", "snapshot file (JSON or .gz) to verify against instead of the latest one in Redis")
+	flag.BoolVar(&recoverOpts.dryRun, "recover-dry-run", false, "report on the recovered state without saving it as the latest snapshot")
+	flag.Parse()
+
 	// load env variables
 	if err := godotenv.Load(); err != nil {
 		fmt.Println("Failed to load env")
@@ -24,22 +50,142 @@ func main() {
 	utils.InitLogger()
 	log.Info().Msg("📄 Logger initialized")
 
+	// Load and validate configuration before connecting to anything
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+	config.Set(cfg)
+
+	// export spans; context still propagates if this fails
+	flushTraces, err := tracing.Init(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to initialize tracing")
+		flushTraces = func(context.Context) error { return nil }
+	}
+
 	// connect to redis
 	client := redis.ConnectRedis()
 
-	// conect to kafka
-	kafka.InitProducer()
-	defer kafka.CloseProducer()
+	// disaster recovery replays history into an engine of its own, so it needs none of
+	// the live engine's intake, journal or publisher
+	if recoverOpts.enabled() {
+		status := runRecovery(context.Background(), client, recoverOpts)
+		flushTraces(context.Background())
+		os.Exit(status)
+	}
+
+	// connect the event publisher, spooling what it cannot deliver
+	publisher := newEventPublisher(cfg.Events, client)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// open the command journal the stream consumer acknowledges against; reconciliation
+	// may run beside a live engine, so it never writes to it
+	var commandJournal *journal.Journal
+	if path := cfg.Intake.JournalPath; path != "" && *reconcileSource == "" {
+		j, err := journal.Open(path)
+		if err != nil {
+			log.Fatal().Err(err).Str("path", path).Msg("Failed to open command journal")
+		}
+		commandJournal = j
+	}
+
+	// events are journaled with the command that produced them and shipped from there
+	box, err := outbox.New(publisher, commandJournal)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to start event outbox")
+	}
+
+	// SIGINT/SIGTERM start a graceful shutdown; a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Initialize engine
-	engine.InitEngine(client)
+	engine.InitEngine(client, box)
 	log.Info().Msg("Matching engine initialized")
 
-	redis.Consumer(ctx, client)
+	if *reconcileSource != "" {
+		runReconciliation(ctx, *reconcileSource, *emitAdjustments)
+		box.Close(5 * time.Second)
+		flushTraces(context.Background())
+		return
+	}
+
+	// The admin server stays up through shutdown so /readyz can report it
+	admin.Start()
+	setReady(true)
+
+	timeout := cfg.Shutdown.Timeout
+	stopping := make(chan time.Time, 1)
+	go func() {
+		<-ctx.Done()
+		stop()
+		stopping <- time.Now()
+		setReady(false)
+		log.Warn().Dur("timeout", timeout).Msg("Shutdown requested, stopping intake")
+		time.AfterFunc(timeout, func() {
+			log.Error().Dur("timeout", timeout).Msg("Shutdown timed out, forcing exit")
+			os.Exit(exitForced)
+		})
+	}()
 
 	log.Info().Msg("Matching Engine started successfully")
 
+	// Returns once intake has stopped and every command it read has been answered
+	redis.Consumer(ctx, client, commandJournal, box)
+	stop()
+
+	os.Exit(shutdown(<-stopping, timeout, client, commandJournal, flushTraces))
+}
+
+// runReconciliation compares the restored engine state with the balance export and prints the report.
+// Adjustments would die with this process, so proposing them is left to RECONCILE_BALANCES.
+func runReconciliation(ctx context.Context, source string, emitAdjustments bool) {
+	if emitAdjustments {
+		log.Error().Msg("-emit-adjustments needs the running engine, send RECONCILE_BALANCES with emitAdjustments instead")
+		engine.EngineInstance.Events.Close(5 * time.Second)
+		os.Exit(1)
+	}
+
+	export, err := engine.EngineInstance.LoadBalanceExport(ctx, source)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load balance export")
+		engine.EngineInstance.Events.Close(5 * time.Second)
+		os.Exit(1)
+	}
+
+	report := engine.EngineInstance.Reconcile(ctx, export, source, false)
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+}
+
+// newEventPublisher builds the configured publisher and, unless spooling is disabled,
+// wraps it so undeliverable events wait on disk until the broker is back.
+func newEventPublisher(cfg config.Events, client *goredis.Client) events.Publisher {
+	encoding := schema.Encoding(cfg.Encoding)
+
+	var publisher events.Publisher
+	switch cfg.Publisher {
+	case "redis":
+		publisher = redis.NewStreamPublisher(client, cfg.StreamPrefix, int64(cfg.StreamMaxLen), encoding)
+	case "memory":
+		log.Warn().Msg("Events are kept in memory only, nothing reaches the DB processor")
+		publisher = events.NewRecorder()
+	default:
+		p, err := kafka.NewPublisher(config.Current().Kafka, encoding)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create Kafka producer")
+		}
+		publisher = p
+	}
+
+	if cfg.SpoolPath == "" {
+		log.Warn().Msg("Event spool disabled, undelivered events are only shipped again from the journal on restart")
+		return publisher
+	}
+	spool, err := events.NewSpool(publisher, cfg.SpoolPath, cfg.SpoolRetry)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", cfg.SpoolPath).Msg("Failed to open event spool")
+	}
+	return spool
 }
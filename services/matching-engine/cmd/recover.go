@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"matching-engine/internals/config"
+	"matching-engine/internals/engine"
+	"matching-engine/internals/events"
+	"matching-engine/internals/outbox"
+	"matching-engine/internals/recovery"
+	"matching-engine/internals/services/kafka"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// recoverFlags selects where a recovery reads history from and what it does with the
+// result.
+type recoverFlags struct {
+	fromKafka bool
+	file      string
+	offset    int64
+	since     string
+	base      string
+	verify    string
+	dryRun    bool
+}
+
+func (f recoverFlags) enabled() bool {
+	return f.fromKafka || f.file != ""
+}
+
+// runRecovery rebuilds engine state from the event history into a fresh engine and
+// prints the report. Only a clean replay is saved as the new snapshot, and a dry run
+// saves nothing. It returns the exit status.
+func runRecovery(ctx context.Context, client *goredis.Client, flags recoverFlags) int {
+	if flags.fromKafka == (flags.file != "") {
+		log.Error().Msg("Pass exactly one of -recover-from-kafka and -recover-file")
+		return exitFailed
+	}
+	if !flags.fromKafka && (flags.offset != 0 || flags.since != "") {
+		log.Error().Msg("-recover-offset and -recover-since only apply to -recover-from-kafka")
+		return exitFailed
+	}
+	if flags.offset != 0 && flags.since != "" {
+		log.Error().Msg("Pass at most one of -recover-offset and -recover-since")
+		return exitFailed
+	}
+
+	var opts recovery.Options
+	if flags.fromKafka {
+		var since time.Time
+		if flags.since != "" {
+			t, err := time.Parse(time.RFC3339, flags.since)
+			if err != nil {
+				log.Error().Err(err).Msg("-recover-since must be an RFC3339 time")
+				return exitFailed
+			}
+			since = t
+		}
+		topics := config.Current().Events.Topics
+		opts.Source = kafka.NewHistory(config.Current().Kafka, []string{topics.Market, topics.Wallet, topics.Ops}, flags.offset, since)
+		opts.Name = "kafka"
+	} else {
+		opts.Source = recovery.FileSource{Path: flags.file}
+		opts.Name = "file:" + flags.file
+	}
+
+	if flags.base != "" {
+		base, err := engine.ReadSnapshotFile(flags.base)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read base snapshot")
+			return exitFailed
+		}
+		opts.Base = base
+	}
+
+	// Nothing the recovered engine does is published; numbering still carries on
+	box, err := outbox.New(events.NewRecorder(), nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start event outbox")
+		return exitFailed
+	}
+	defer box.Close(time.Second)
+	e := engine.New(client, box)
+
+	if flags.verify != "" {
+		opts.Verify, err = engine.ReadSnapshotFile(flags.verify)
+	} else {
+		opts.Verify, err = e.ReadLatestSnapshot(ctx)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("No snapshot to verify against, the recovered state is unverified")
+		opts.Verify = nil
+	}
+
+	report, err := recovery.Run(ctx, e, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Recovery failed")
+		return exitFailed
+	}
+
+	status := exitClean
+	switch {
+	case !report.Clean():
+		log.Error().Msg("Recovered state is incomplete or disagrees with the snapshot, not saving it")
+		status = exitFailed
+	case flags.dryRun:
+		log.Info().Msg("Dry run, recovered state not saved")
+	case !config.Current().Snapshot.Enabled:
+		log.Warn().Msg("SNAPSHOT_ENABLED is not true, recovered state not saved")
+	default:
+		if err := e.PerformDrainedSnapshot(); err != nil {
+			log.Error().Err(err).Msg("Failed to save recovered state")
+			status = exitFailed
+		} else {
+			report.Written = true
+		}
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	return status
+}
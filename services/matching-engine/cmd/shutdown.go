@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"matching-engine/internals/config"
+	"matching-engine/internals/engine"
+	"matching-engine/internals/journal"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Exit statuses reported to the orchestrator.
+const (
+	exitClean  = 0
+	exitFailed = 1 // a shutdown step failed; the last snapshot or some events may be missing
+	exitForced = 2 // SHUTDOWN_TIMEOUT passed before shutdown finished
+)
+
+// setReady flips the engine's readiness flag and, when READY_FILE is set, creates or
+// removes that file for orchestrators that probe with a file check.
+func setReady(ready bool) {
+	engine.EngineInstance.SetReady(ready)
+
+	path := config.Current().Shutdown.ReadyFile
+	if path == "" {
+		return
+	}
+	if ready {
+		if err := os.WriteFile(path, []byte("ready\n"), 0644); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Failed to write readiness file")
+		}
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to remove readiness file")
+	}
+}
+
+// shutdown runs after intake has stopped. It lets every market finish its queue, sends
+// the market data still being coalesced, flushes the event publisher, writes the final
+// snapshot, closes the journal and flushes buffered spans, all within timeout of the
+// signal, and returns the process exit status.
+func shutdown(signalledAt time.Time, timeout time.Duration, client *goredis.Client, j *journal.Journal, flushTraces func(context.Context) error) int {
+	deadline := signalledAt.Add(timeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	status := exitClean
+
+	drained := true
+	if err := engine.EngineInstance.DrainInboxes(ctx); err != nil {
+		log.Error().Err(err).Msg("Market inboxes did not drain before the deadline")
+		status = exitFailed
+		drained = false
+	}
+
+	engine.EngineInstance.FlushBroadcasts()
+
+	// Leave half of what is left for the snapshot
+	if engine.EngineInstance.Events.Close(time.Until(deadline)/2) > 0 {
+		status = exitFailed
+	}
+
+	snapshot := engine.EngineInstance.PerformDrainedSnapshot
+	if !drained {
+		snapshot = engine.EngineInstance.PerformSnapshot
+	}
+	if err := snapshot(); err != nil {
+		log.Error().Err(err).Msg("Final snapshot failed")
+		status = exitFailed
+	}
+
+	if j != nil {
+		if err := j.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close command journal")
+			status = exitFailed
+		}
+	}
+	client.Close()
+
+	// Lost spans are not worth failing the shutdown over
+	if err := flushTraces(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to flush trace spans")
+	}
+
+	if status == exitClean {
+		log.Info().Dur("took", time.Since(signalledAt)).Msg("Matching engine shut down cleanly")
+	} else {
+		log.Error().Dur("took", time.Since(signalledAt)).Int("status", status).Msg("Matching engine shut down with errors")
+	}
+	return status
+}
@@ -0,0 +1,203 @@
+// Package admin serves the engine's health probes and operator API over HTTP.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"matching-engine/internals/config"
+	"matching-engine/internals/engine"
+	"matching-engine/internals/metrics"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Start serves the admin API on ADMIN_ADDR (default ":9090") until the process exits.
+// /healthz, /readyz and /metrics are open for probes and scrapers; everything else
+// needs ADMIN_TOKEN as a bearer token and is refused outright when no token is set.
+func Start() *http.Server {
+	cfg := config.Current().Admin
+	addr, token := cfg.Addr, cfg.Token
+	if token == "" {
+		log.Warn().Msg("ADMIN_TOKEN not set, admin endpoints other than health checks are disabled")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", healthz)
+	mux.HandleFunc("GET /readyz", readyz)
+	mux.Handle("GET /metrics", metrics.Handler())
+	metrics.RegisterInboxes(engine.EngineInstance.InboxDepths)
+
+	protected := http.NewServeMux()
+	protected.HandleFunc("GET /markets", listMarkets)
+	protected.HandleFunc("GET /markets/{symbol}/book", marketBook)
+	protected.HandleFunc("GET /markets/{symbol}/diagnostic", marketDiagnostic)
+	protected.HandleFunc("POST /markets/{symbol}/halt", haltMarket)
+	protected.HandleFunc("POST /markets/{symbol}/resume", resumeMarket)
+	protected.HandleFunc("GET /users/{id}", getUser)
+	protected.HandleFunc("POST /snapshot", snapshot)
+	protected.HandleFunc("GET /config", getConfig)
+	protected.HandleFunc("POST /config/reload", reloadConfig)
+	mux.Handle("/", requireToken(token, protected))
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Str("addr", addr).Msg("Admin server stopped")
+		}
+	}()
+
+	log.Info().Str("addr", addr).Msg("Admin server listening")
+	return server
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz turns 503 as soon as shutdown starts so load balancers stop routing here.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if !engine.EngineInstance.Ready() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func listMarkets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, engine.EngineInstance.InspectMarkets())
+}
+
+func marketBook(w http.ResponseWriter, r *http.Request) {
+	view, err := engine.EngineInstance.InspectMarket(r.PathValue("symbol"))
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func marketDiagnostic(w http.ResponseWriter, r *http.Request) {
+	diag, ok := engine.EngineInstance.MarketPanic(r.PathValue("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "no diagnostic recorded for this market")
+		return
+	}
+	writeJSON(w, http.StatusOK, diag)
+}
+
+func haltMarket(w http.ResponseWriter, r *http.Request) {
+	symbol := r.PathValue("symbol")
+	if err := engine.EngineInstance.HaltMarket(symbol); err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	log.Warn().Str("symbol", symbol).Str("operatorId", r.Header.Get("X-Operator-Id")).Msg("Market halted through admin API")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "halted"})
+}
+
+// resumeMarket restarts a halted market; the operator is named in X-Operator-Id.
+func resumeMarket(w http.ResponseWriter, r *http.Request) {
+	violations, err := engine.EngineInstance.RestartMarket(r.Context(), r.PathValue("symbol"), r.Header.Get("X-Operator-Id"))
+	if errors.Is(err, engine.ErrBookInvalid) {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "violations": violations})
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "open"})
+}
+
+func getUser(w http.ResponseWriter, r *http.Request) {
+	view, err := engine.EngineInstance.InspectUser(r.PathValue("id"))
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func snapshot(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if err := engine.EngineInstance.PerformSnapshot(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "done", "took": time.Since(start).String()})
+}
+
+// getConfig returns the running configuration as YAML, in the shape CONFIG_FILE takes,
+// with secrets left out.
+func getConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := *config.Current()
+	cfg.Redis.URL = "[redacted]"
+	cfg.Admin.Token = "[redacted]"
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(out)
+}
+
+// reloadConfig applies the hot-reloadable keys; the operator is named in X-Operator-Id.
+func reloadConfig(w http.ResponseWriter, r *http.Request) {
+	result, err := engine.EngineInstance.ReloadConfig()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Warn().Str("operatorId", r.Header.Get("X-Operator-Id")).Strs("applied", result.Applied).Msg("Configuration reloaded through admin API")
+	writeJSON(w, http.StatusOK, result)
+}
+
+// writeEngineError maps engine errors onto HTTP statuses.
+func writeEngineError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, engine.ErrMarketNotFound), errors.Is(err, engine.ErrUserNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, engine.ErrMarketBusy), errors.Is(err, engine.ErrReplyTimeout):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, engine.ErrMarketNotHalted), errors.Is(err, engine.ErrMissingOperator):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Warn().Err(err).Msg("Failed to write admin response")
+	}
+}
@@ -0,0 +1,393 @@
+// Package config holds the engine's settings. They are read once at startup from
+// defaults, an optional YAML file (CONFIG_FILE) and the environment, in that order, and
+// validated before anything else starts. Fees and limits can be reloaded at runtime.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"matching-engine/internals/types"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Redis    Redis    `yaml:"redis"`
+	Kafka    Kafka    `yaml:"kafka"`
+	Events   Events   `yaml:"events"`
+	Intake   Intake   `yaml:"intake"`
+	Response Response `yaml:"response"`
+	Dispatch Dispatch `yaml:"dispatch"`
+	Engine   Engine   `yaml:"engine"`
+	Snapshot Snapshot `yaml:"snapshot"`
+	Stream   Stream   `yaml:"stream"`
+	Trading  Trading  `yaml:"trading"`
+	Withdraw Withdraw `yaml:"withdraw"`
+	Admin    Admin    `yaml:"admin"`
+	Shutdown Shutdown `yaml:"shutdown"`
+
+	// Markets overrides trading settings for individual markets, keyed by symbol.
+	Markets map[string]MarketOverride `yaml:"markets"`
+}
+
+type Redis struct {
+	URL string `yaml:"url"`
+}
+
+type Kafka struct {
+	Brokers string `yaml:"brokers"`
+	// Acks is how many replicas must have a message before it counts as delivered:
+	// "all" (default), "1" or "0".
+	Acks string `yaml:"acks"`
+	// Idempotent makes the broker drop duplicates from producer retries, so retried
+	// messages keep their order within a partition. It requires acks "all".
+	Idempotent  bool `yaml:"idempotent"`
+	MaxInFlight int  `yaml:"maxInFlight"`
+}
+
+type Events struct {
+	// Publisher is "kafka" (default), "redis" for Redis Streams or "memory" to keep
+	// events in process, for running without a broker.
+	Publisher string `yaml:"publisher"`
+	// Encoding of event bodies, "json" (default) or "protobuf".
+	Encoding     string `yaml:"encoding"`
+	StreamPrefix string `yaml:"streamPrefix"`
+	StreamMaxLen int    `yaml:"streamMaxLen"`
+	// SpoolPath is the file undeliverable events wait in; empty disables spooling.
+	SpoolPath  string        `yaml:"spoolPath"`
+	SpoolRetry time.Duration `yaml:"spoolRetry"`
+	Topics     EventTopics   `yaml:"topics"`
+}
+
+// EventTopics names the topic each event family is published to.
+type EventTopics struct {
+	Market string `yaml:"market"`
+	Wallet string `yaml:"wallet"`
+	Ops    string `yaml:"ops"`
+}
+
+type Intake struct {
+	// Mode is "stream" (default) or "list" for the legacy BRPOP queue.
+	Mode        string        `yaml:"mode"`
+	Stream      string        `yaml:"stream"`
+	Group       string        `yaml:"group"`
+	Consumer    string        `yaml:"consumer"`
+	ClaimIdle   time.Duration `yaml:"claimIdle"`
+	JournalPath string        `yaml:"journalPath"`
+}
+
+type Response struct {
+	// Mode is "durable" (default) or "pubsub".
+	Mode    string        `yaml:"mode"`
+	TTL     time.Duration `yaml:"ttl"`
+	Retries int           `yaml:"retries"`
+}
+
+type Dispatch struct {
+	Workers   int           `yaml:"workers"`
+	QueueSize int           `yaml:"queueSize"`
+	Deadline  time.Duration `yaml:"deadline"`
+}
+
+type Engine struct {
+	RequestTimeout      time.Duration `yaml:"requestTimeout"`
+	InboxCapacity       int           `yaml:"inboxCapacity"`
+	InvariantInterval   uint64        `yaml:"invariantInterval"`
+	AdjustmentThreshold float64       `yaml:"adjustmentThreshold"`
+	IdempotencyTTL      time.Duration `yaml:"idempotencyTTL"`
+	IdempotencyMaxKeys  int           `yaml:"idempotencyMaxKeys"`
+}
+
+type Snapshot struct {
+	Enabled bool `yaml:"enabled"`
+	// Store is "redis" or "s3".
+	Store    string        `yaml:"store"`
+	Bucket   string        `yaml:"bucket"`
+	Interval time.Duration `yaml:"interval"`
+	// EvictAfter is how long a user may be idle before a snapshot drops them from memory.
+	EvictAfter time.Duration `yaml:"evictAfter"`
+}
+
+// Stream shapes the market data broadcast to the stream service.
+type Stream struct {
+	// BookSnapshotEvery is how many book updates a market sends between full snapshots.
+	BookSnapshotEvery int `yaml:"bookSnapshotEvery"`
+	// BookHistory is how many updates back GET_BOOK_SNAPSHOT can rebuild a book.
+	BookHistory int `yaml:"bookHistory"`
+	// Coalesce is how long a market's updates are held to go out as one message;
+	// 0 publishes each update as it happens.
+	Coalesce time.Duration `yaml:"coalesce"`
+	// Encoding of published updates, "json" (default) or "msgpack".
+	Encoding string `yaml:"encoding"`
+}
+
+type Trading struct {
+	// Fee is charged to both sides of every fill, as a fraction of notional.
+	Fee float64 `yaml:"fee"`
+	// PositionLimit caps the shares of one outcome a user may hold through buy orders.
+	PositionLimit int `yaml:"positionLimit"`
+	// PayoutPerShare is what a winning share pays and what one YES/NO pair is backed by.
+	PayoutPerShare float64 `yaml:"payoutPerShare"`
+	// TradeHistory is how many recent trades each market keeps.
+	TradeHistory int `yaml:"tradeHistory"`
+	// DefaultLiquidity is seeded by ADD_LIQUIDITY when the command names no levels.
+	DefaultLiquidity []LiquidityLevel `yaml:"defaultLiquidity"`
+	Pricing          Pricing          `yaml:"pricing"`
+}
+
+// Pricing picks how a market's fair value, the YES price it shows, is estimated.
+type Pricing struct {
+	// Strategy is "mid" (default), "last", "vwap" or "depth".
+	Strategy string `yaml:"strategy"`
+	// VWAPWindow is how far back "vwap" averages trades, among the TradeHistory kept.
+	VWAPWindow time.Duration `yaml:"vwapWindow"`
+	// DepthTicks is how many ticks behind the best price "depth" counts.
+	DepthTicks int     `yaml:"depthTicks"`
+	TickSize   float64 `yaml:"tickSize"`
+}
+
+type LiquidityLevel struct {
+	Price    float64 `yaml:"price"`
+	Quantity int     `yaml:"quantity"`
+}
+
+type Withdraw struct {
+	Cooldown time.Duration `yaml:"cooldown"`
+	// Limits caps withdrawals by the user's KYC status; a status without limits cannot
+	// withdraw at all.
+	Limits map[types.KycStatus]types.WithdrawalLimit `yaml:"limits"`
+}
+
+type Admin struct {
+	Addr  string `yaml:"addr"`
+	Token string `yaml:"token"`
+}
+
+type Shutdown struct {
+	Timeout   time.Duration `yaml:"timeout"`
+	ReadyFile string        `yaml:"readyFile"`
+}
+
+// MarketOverride replaces the global trading settings for one market. Unset fields
+// fall back to Trading.
+type MarketOverride struct {
+	Fee              *float64         `yaml:"fee,omitempty"`
+	PositionLimit    *int             `yaml:"positionLimit,omitempty"`
+	DefaultLiquidity []LiquidityLevel `yaml:"defaultLiquidity,omitempty"`
+	Pricing          *Pricing         `yaml:"pricing,omitempty"`
+}
+
+// Default returns the settings the engine ran with before they were configurable.
+func Default() Config {
+	return Config{
+		Kafka: Kafka{Brokers: "localhost", Acks: "all", Idempotent: true, MaxInFlight: 5},
+		Events: Events{
+			Publisher: "kafka", Encoding: "json", StreamPrefix: "events:", StreamMaxLen: 1000000, SpoolRetry: 5 * time.Second,
+			Topics: EventTopics{Market: "process_db.market", Wallet: "process_db.wallet", Ops: "process_db.ops"},
+		},
+		Intake:   Intake{Mode: "stream", Stream: "engine:stream", Group: "engine", ClaimIdle: time.Minute},
+		Response: Response{Mode: "durable", TTL: 2 * time.Minute, Retries: 5},
+		Dispatch: Dispatch{Workers: 32, QueueSize: 64, Deadline: 5 * time.Second},
+		Engine: Engine{
+			RequestTimeout:      2 * time.Second,
+			InboxCapacity:       100,
+			InvariantInterval:   100,
+			AdjustmentThreshold: 1000,
+			IdempotencyTTL:      24 * time.Hour,
+			IdempotencyMaxKeys:  1000,
+		},
+		Snapshot: Snapshot{Interval: 10 * time.Minute, EvictAfter: 7 * 24 * time.Hour},
+		Stream:   Stream{BookSnapshotEvery: 100, BookHistory: 1000, Coalesce: 50 * time.Millisecond, Encoding: "json"},
+		Trading: Trading{
+			Fee:            0.0025,
+			PositionLimit:  5000,
+			PayoutPerShare: 10,
+			TradeHistory:   50,
+			DefaultLiquidity: []LiquidityLevel{
+				{Price: 3.0, Quantity: 10},
+				{Price: 4.0, Quantity: 25},
+				{Price: 5.0, Quantity: 50},
+				{Price: 6.0, Quantity: 25},
+				{Price: 7.0, Quantity: 10},
+			},
+			Pricing: Pricing{Strategy: "mid", VWAPWindow: 5 * time.Minute, DepthTicks: 2, TickSize: 0.5},
+		},
+		Withdraw: Withdraw{
+			Cooldown: 24 * time.Hour,
+			Limits:   map[types.KycStatus]types.WithdrawalLimit{types.KYC_VERIFIED: {Daily: 50000, Monthly: 200000}},
+		},
+		Admin:    Admin{Addr: ":9090"},
+		Shutdown: Shutdown{Timeout: 30 * time.Second},
+	}
+}
+
+// Load builds the configuration from defaults, the YAML file named by CONFIG_FILE (if
+// any) and the environment, and validates the result.
+func Load() (Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("read config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(raw))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every setting that is out of range, not just the first.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Redis.URL != "", "redis.url (REDIS_URL) is required")
+	check(c.Kafka.Brokers != "", "kafka.brokers (KAFKA_BROKERS) is required")
+	check(c.Kafka.Acks == "all" || c.Kafka.Acks == "1" || c.Kafka.Acks == "0", "kafka.acks must be all, 1 or 0, got %q", c.Kafka.Acks)
+	check(c.Kafka.MaxInFlight > 0, "kafka.maxInFlight must be positive")
+	check(!c.Kafka.Idempotent || c.Kafka.Acks == "all", "kafka.idempotent requires kafka.acks all")
+	check(!c.Kafka.Idempotent || c.Kafka.MaxInFlight <= 5, "kafka.idempotent allows at most 5 requests in flight, got %d", c.Kafka.MaxInFlight)
+	check(c.Events.Publisher == "kafka" || c.Events.Publisher == "redis" || c.Events.Publisher == "memory", "events.publisher must be kafka, redis or memory, got %q", c.Events.Publisher)
+	check(c.Events.Encoding == "json" || c.Events.Encoding == "protobuf", "events.encoding must be json or protobuf, got %q", c.Events.Encoding)
+	check(c.Events.StreamMaxLen >= 0, "events.streamMaxLen must not be negative")
+	check(c.Events.SpoolRetry > 0, "events.spoolRetry must be positive")
+	check(c.Events.Topics.Market != "" && c.Events.Topics.Wallet != "" && c.Events.Topics.Ops != "", "events.topics must name a topic for market, wallet and ops")
+	check(c.Intake.Mode == "stream" || c.Intake.Mode == "list", "intake.mode must be stream or list, got %q", c.Intake.Mode)
+	check(c.Intake.ClaimIdle > 0, "intake.claimIdle must be positive")
+	check(c.Response.Mode == "durable" || c.Response.Mode == "pubsub", "response.mode must be durable or pubsub, got %q", c.Response.Mode)
+	check(c.Response.TTL > 0, "response.ttl must be positive")
+	check(c.Response.Retries >= 0, "response.retries must not be negative")
+	check(c.Dispatch.Workers > 0, "dispatch.workers must be positive")
+	check(c.Dispatch.QueueSize > 0, "dispatch.queueSize must be positive")
+	check(c.Dispatch.Deadline > 0, "dispatch.deadline must be positive")
+	check(c.Engine.RequestTimeout > 0, "engine.requestTimeout must be positive")
+	check(c.Engine.InboxCapacity > 0, "engine.inboxCapacity must be positive")
+	check(c.Engine.AdjustmentThreshold >= 0, "engine.adjustmentThreshold must not be negative")
+	check(c.Engine.IdempotencyTTL > 0, "engine.idempotencyTTL must be positive")
+	check(c.Engine.IdempotencyMaxKeys > 0, "engine.idempotencyMaxKeys must be positive")
+	check(c.Snapshot.Store == "" || c.Snapshot.Store == "redis" || c.Snapshot.Store == "s3", "snapshot.store must be redis or s3, got %q", c.Snapshot.Store)
+	check(c.Snapshot.Interval > 0, "snapshot.interval must be positive")
+	check(c.Snapshot.EvictAfter > 0, "snapshot.evictAfter must be positive")
+	check(c.Stream.BookSnapshotEvery > 0, "stream.bookSnapshotEvery must be positive")
+	check(c.Stream.BookHistory >= c.Stream.BookSnapshotEvery, "stream.bookHistory must be at least stream.bookSnapshotEvery")
+	check(c.Stream.Coalesce >= 0, "stream.coalesce must not be negative")
+	check(c.Stream.Encoding == "json" || c.Stream.Encoding == "msgpack", "stream.encoding must be json or msgpack, got %q", c.Stream.Encoding)
+	check(c.Trading.PayoutPerShare > 0, "trading.payoutPerShare must be positive")
+	check(c.Trading.TradeHistory > 0, "trading.tradeHistory must be positive")
+	check(c.Withdraw.Cooldown >= 0, "withdraw.cooldown must not be negative")
+	for status, limit := range c.Withdraw.Limits {
+		check(slices.Contains(types.KycStatuses, status), "withdraw.limits has unknown KYC status %q", status)
+		check(limit.Daily >= 0 && limit.Monthly >= limit.Daily, "withdraw.limits.%s must satisfy 0 <= daily <= monthly", status)
+	}
+	check(c.Shutdown.Timeout > 0, "shutdown.timeout must be positive")
+
+	errs = append(errs, c.validateTrading("trading", c.Trading.Fee, c.Trading.PositionLimit, c.Trading.DefaultLiquidity)...)
+	errs = append(errs, validatePricing("trading.pricing", c.Trading.Pricing)...)
+	for symbol, o := range c.Markets {
+		t := c.ForMarket(symbol)
+		errs = append(errs, c.validateTrading("markets."+symbol, t.Fee, t.PositionLimit, o.DefaultLiquidity)...)
+		if o.Pricing != nil {
+			errs = append(errs, validatePricing("markets."+symbol+".pricing", t.Pricing)...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c Config) validateTrading(prefix string, fee float64, positionLimit int, levels []LiquidityLevel) []error {
+	var errs []error
+	if fee < 0 || fee >= 1 {
+		errs = append(errs, fmt.Errorf("%s.fee must be in [0, 1), got %v", prefix, fee))
+	}
+	if positionLimit <= 0 {
+		errs = append(errs, fmt.Errorf("%s.positionLimit must be positive, got %d", prefix, positionLimit))
+	}
+	for i, l := range levels {
+		if l.Price <= 0 || l.Price >= c.Trading.PayoutPerShare || l.Quantity <= 0 {
+			errs = append(errs, fmt.Errorf("%s.defaultLiquidity[%d] needs 0 < price < %v and a positive quantity", prefix, i, c.Trading.PayoutPerShare))
+		}
+	}
+	return errs
+}
+
+func validatePricing(prefix string, p Pricing) []error {
+	var errs []error
+	switch p.Strategy {
+	case "mid", "last", "vwap", "depth":
+	default:
+		errs = append(errs, fmt.Errorf("%s.strategy must be mid, last, vwap or depth, got %q", prefix, p.Strategy))
+	}
+	if p.VWAPWindow <= 0 {
+		errs = append(errs, fmt.Errorf("%s.vwapWindow must be positive", prefix))
+	}
+	if p.DepthTicks <= 0 || p.TickSize <= 0 {
+		errs = append(errs, fmt.Errorf("%s.depthTicks and tickSize must be positive", prefix))
+	}
+	return errs
+}
+
+// MarketTrading is the trading configuration in force for one market.
+type MarketTrading struct {
+	Fee              float64
+	PositionLimit    int
+	DefaultLiquidity []LiquidityLevel
+	Pricing          Pricing
+}
+
+// ForMarket applies the market's overrides, if any, to the global trading settings.
+func (c Config) ForMarket(symbol string) MarketTrading {
+	t := MarketTrading{
+		Fee:              c.Trading.Fee,
+		PositionLimit:    c.Trading.PositionLimit,
+		DefaultLiquidity: c.Trading.DefaultLiquidity,
+		Pricing:          c.Trading.Pricing,
+	}
+	o, ok := c.Markets[symbol]
+	if !ok {
+		return t
+	}
+	if o.Fee != nil {
+		t.Fee = *o.Fee
+	}
+	if o.PositionLimit != nil {
+		t.PositionLimit = *o.PositionLimit
+	}
+	if len(o.DefaultLiquidity) > 0 {
+		t.DefaultLiquidity = o.DefaultLiquidity
+	}
+	if p := o.Pricing; p != nil {
+		if p.Strategy != "" {
+			t.Pricing.Strategy = p.Strategy
+		}
+		if p.VWAPWindow != 0 {
+			t.Pricing.VWAPWindow = p.VWAPWindow
+		}
+		if p.DepthTicks != 0 {
+			t.Pricing.DepthTicks = p.DepthTicks
+		}
+		if p.TickSize != 0 {
+			t.Pricing.TickSize = p.TickSize
+		}
+	}
+	return t
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"matching-engine/internals/types"
+	"os"
+	"strconv"
+	"time"
+)
+
+// applyEnv overlays every variable that is set. A value that does not parse is an
+// error rather than a silent fallback to the default.
+func applyEnv(c *Config) error {
+	p := envParser{}
+
+	p.str("REDIS_URL", &c.Redis.URL)
+	p.str("KAFKA_BROKERS", &c.Kafka.Brokers)
+	p.str("KAFKA_ACKS", &c.Kafka.Acks)
+	p.boolean("KAFKA_IDEMPOTENT", &c.Kafka.Idempotent)
+	p.integer("KAFKA_MAX_IN_FLIGHT", &c.Kafka.MaxInFlight)
+
+	p.str("EVENT_PUBLISHER", &c.Events.Publisher)
+	p.str("EVENT_ENCODING", &c.Events.Encoding)
+	p.str("EVENT_STREAM_PREFIX", &c.Events.StreamPrefix)
+	p.integer("EVENT_STREAM_MAXLEN", &c.Events.StreamMaxLen)
+	p.str("EVENT_SPOOL_PATH", &c.Events.SpoolPath)
+	p.duration("EVENT_SPOOL_RETRY", &c.Events.SpoolRetry)
+	p.str("EVENT_TOPIC_MARKET", &c.Events.Topics.Market)
+	p.str("EVENT_TOPIC_WALLET", &c.Events.Topics.Wallet)
+	p.str("EVENT_TOPIC_OPS", &c.Events.Topics.Ops)
+
+	p.str("INTAKE_MODE", &c.Intake.Mode)
+	p.str("INTAKE_STREAM", &c.Intake.Stream)
+	p.str("INTAKE_GROUP", &c.Intake.Group)
+	p.str("INTAKE_CONSUMER", &c.Intake.Consumer)
+	p.duration("INTAKE_CLAIM_IDLE", &c.Intake.ClaimIdle)
+	p.str("JOURNAL_PATH", &c.Intake.JournalPath)
+
+	p.str("RESPONSE_MODE", &c.Response.Mode)
+	p.duration("RESPONSE_TTL", &c.Response.TTL)
+	p.integer("RESPONSE_RETRIES", &c.Response.Retries)
+
+	p.integer("DISPATCH_WORKERS", &c.Dispatch.Workers)
+	p.integer("DISPATCH_QUEUE_SIZE", &c.Dispatch.QueueSize)
+	p.duration("DISPATCH_DEADLINE", &c.Dispatch.Deadline)
+
+	p.duration("MARKET_REQUEST_TIMEOUT", &c.Engine.RequestTimeout)
+	p.integer("MARKET_INBOX_CAPACITY", &c.Engine.InboxCapacity)
+	p.uint("INVARIANT_CHECK_INTERVAL", &c.Engine.InvariantInterval)
+	p.float("ADJUSTMENT_APPROVAL_THRESHOLD", &c.Engine.AdjustmentThreshold)
+	p.duration("IDEMPOTENCY_TTL", &c.Engine.IdempotencyTTL)
+	p.integer("IDEMPOTENCY_MAX_KEYS_PER_USER", &c.Engine.IdempotencyMaxKeys)
+
+	p.boolean("SNAPSHOT_ENABLED", &c.Snapshot.Enabled)
+	p.str("SNAPSHOT_STORE", &c.Snapshot.Store)
+	p.str("S3_SNAPSHOT_BUCKET", &c.Snapshot.Bucket)
+	p.duration("SNAPSHOT_INTERVAL", &c.Snapshot.Interval)
+	p.duration("EVICT_AFTER", &c.Snapshot.EvictAfter)
+
+	p.integer("STREAM_BOOK_SNAPSHOT_EVERY", &c.Stream.BookSnapshotEvery)
+	p.integer("STREAM_BOOK_HISTORY", &c.Stream.BookHistory)
+	p.duration("STREAM_COALESCE", &c.Stream.Coalesce)
+	p.str("STREAM_ENCODING", &c.Stream.Encoding)
+
+	p.float("TRADING_FEE", &c.Trading.Fee)
+	p.integer("POSITION_LIMIT", &c.Trading.PositionLimit)
+	p.float("PAYOUT_PER_SHARE", &c.Trading.PayoutPerShare)
+	p.integer("TRADE_HISTORY_LENGTH", &c.Trading.TradeHistory)
+	p.str("PRICING_STRATEGY", &c.Trading.Pricing.Strategy)
+	p.duration("PRICING_VWAP_WINDOW", &c.Trading.Pricing.VWAPWindow)
+	p.integer("PRICING_DEPTH_TICKS", &c.Trading.Pricing.DepthTicks)
+	p.float("PRICING_TICK_SIZE", &c.Trading.Pricing.TickSize)
+
+	p.duration("WITHDRAW_COOLDOWN", &c.Withdraw.Cooldown)
+	p.withdrawLimit(c, types.KYC_VERIFIED, "WITHDRAW_DAILY_LIMIT", "WITHDRAW_MONTHLY_LIMIT")
+	for _, status := range types.KycStatuses {
+		p.withdrawLimit(c, status, "WITHDRAW_DAILY_LIMIT_"+string(status), "WITHDRAW_MONTHLY_LIMIT_"+string(status))
+	}
+
+	p.str("ADMIN_ADDR", &c.Admin.Addr)
+	p.str("ADMIN_TOKEN", &c.Admin.Token)
+
+	p.duration("SHUTDOWN_TIMEOUT", &c.Shutdown.Timeout)
+	p.str("READY_FILE", &c.Shutdown.ReadyFile)
+
+	return errors.Join(p.errs...)
+}
+
+type envParser struct {
+	errs []error
+}
+
+func (p *envParser) lookup(key string) (string, bool) {
+	v, ok := os.LookupEnv(key)
+	return v, ok && v != ""
+}
+
+func (p *envParser) fail(key, v string, err error) {
+	p.errs = append(p.errs, fmt.Errorf("%s=%q: %w", key, v, err))
+}
+
+func (p *envParser) str(key string, dst *string) {
+	if v, ok := p.lookup(key); ok {
+		*dst = v
+	}
+}
+
+func (p *envParser) integer(key string, dst *int) {
+	if v, ok := p.lookup(key); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			p.fail(key, v, err)
+			return
+		}
+		*dst = n
+	}
+}
+
+func (p *envParser) uint(key string, dst *uint64) {
+	if v, ok := p.lookup(key); ok {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			p.fail(key, v, err)
+			return
+		}
+		*dst = n
+	}
+}
+
+func (p *envParser) float(key string, dst *float64) {
+	if v, ok := p.lookup(key); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			p.fail(key, v, err)
+			return
+		}
+		*dst = f
+	}
+}
+
+func (p *envParser) boolean(key string, dst *bool) {
+	if v, ok := p.lookup(key); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			p.fail(key, v, err)
+			return
+		}
+		*dst = b
+	}
+}
+
+func (p *envParser) duration(key string, dst *time.Duration) {
+	if v, ok := p.lookup(key); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			p.fail(key, v, err)
+			return
+		}
+		*dst = d
+	}
+}
+
+// withdrawLimit sets the limits of one KYC status, giving it limits if it had none.
+func (p *envParser) withdrawLimit(c *Config, status types.KycStatus, dailyKey, monthlyKey string) {
+	_, daily := p.lookup(dailyKey)
+	_, monthly := p.lookup(monthlyKey)
+	if !daily && !monthly {
+		return
+	}
+
+	limit := c.Withdraw.Limits[status]
+	p.float(dailyKey, &limit.Daily)
+	p.float(monthlyKey, &limit.Monthly)
+	if c.Withdraw.Limits == nil {
+		c.Withdraw.Limits = make(map[types.KycStatus]types.WithdrawalLimit)
+	}
+	c.Withdraw.Limits[status] = limit
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	current  atomic.Pointer[Config]
+	reloadMu sync.Mutex
+)
+
+// Set installs cfg as the running configuration. main calls it once after Load.
+func Set(cfg Config) {
+	current.Store(&cfg)
+}
+
+// Current returns the running configuration. Callers must not modify it; a reload
+// swaps in a new value rather than changing this one.
+func Current() *Config {
+	if cfg := current.Load(); cfg != nil {
+		return cfg
+	}
+	cfg := Default()
+	return &cfg
+}
+
+// ReloadResult lists what a reload changed. Ignored keys differ on disk or in the
+// environment but only take effect after a restart.
+type ReloadResult struct {
+	Applied []string `json:"applied"`
+	Ignored []string `json:"ignored"`
+}
+
+// Reload reads the configuration again and applies the keys that are safe to change on
+// a running engine: fees, position limits, default liquidity, pricing, per-market
+// overrides, withdrawal limits and how market data is batched and encoded. Nothing is
+// applied if the new configuration does not validate.
+func Reload() (ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	fresh, err := Load()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	old := Current()
+	next := *old
+	var result ReloadResult
+
+	safe := []struct {
+		key string
+		dst interface{}
+		src interface{}
+	}{
+		{"trading.fee", &next.Trading.Fee, fresh.Trading.Fee},
+		{"trading.positionLimit", &next.Trading.PositionLimit, fresh.Trading.PositionLimit},
+		{"trading.defaultLiquidity", &next.Trading.DefaultLiquidity, fresh.Trading.DefaultLiquidity},
+		{"trading.pricing", &next.Trading.Pricing, fresh.Trading.Pricing},
+		{"markets", &next.Markets, fresh.Markets},
+		{"withdraw.limits", &next.Withdraw.Limits, fresh.Withdraw.Limits},
+		{"stream.coalesce", &next.Stream.Coalesce, fresh.Stream.Coalesce},
+		{"stream.encoding", &next.Stream.Encoding, fresh.Stream.Encoding},
+	}
+	for _, s := range safe {
+		dst := reflect.ValueOf(s.dst).Elem()
+		if !reflect.DeepEqual(dst.Interface(), s.src) {
+			dst.Set(reflect.ValueOf(s.src))
+			result.Applied = append(result.Applied, s.key)
+		}
+	}
+
+	// Whatever still differs was not safe to apply
+	for _, section := range []struct {
+		key      string
+		old, new interface{}
+	}{
+		{"redis", next.Redis, fresh.Redis},
+		{"kafka", next.Kafka, fresh.Kafka},
+		{"events", next.Events, fresh.Events},
+		{"intake", next.Intake, fresh.Intake},
+		{"response", next.Response, fresh.Response},
+		{"dispatch", next.Dispatch, fresh.Dispatch},
+		{"engine", next.Engine, fresh.Engine},
+		{"snapshot", next.Snapshot, fresh.Snapshot},
+		{"stream", next.Stream, fresh.Stream},
+		{"trading.payoutPerShare", next.Trading.PayoutPerShare, fresh.Trading.PayoutPerShare},
+		{"trading.tradeHistory", next.Trading.TradeHistory, fresh.Trading.TradeHistory},
+		{"withdraw.cooldown", next.Withdraw.Cooldown, fresh.Withdraw.Cooldown},
+		{"admin", next.Admin, fresh.Admin},
+		{"shutdown", next.Shutdown, fresh.Shutdown},
+	} {
+		if !reflect.DeepEqual(section.old, section.new) {
+			result.Ignored = append(result.Ignored, section.key)
+		}
+	}
+
+	if err := next.Validate(); err != nil {
+		return ReloadResult{}, err
+	}
+	current.Store(&next)
+	return result, nil
+}
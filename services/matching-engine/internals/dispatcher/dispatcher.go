@@ -0,0 +1,114 @@
+package dispatcher
+
+import (
+	"context"
+	"hash/fnv"
+	"matching-engine/internals/config"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Dispatcher runs commands on a fixed pool of workers. Every key is pinned to one
+// worker, so commands sharing a key start in arrival order while different keys run
+// concurrently. Each worker has a bounded queue; Submit blocks once it is full.
+//
+// A command holds its worker until it finishes or calls its release func. Market
+// commands release once their message is on the market inbox, which keeps them in
+// order while letting the market queue up work instead of handling one at a time.
+type Dispatcher struct {
+	lanes    []chan job
+	deadline time.Duration
+	wg       sync.WaitGroup
+	inFlight sync.WaitGroup
+}
+
+type job struct {
+	run      func(release func())
+	expire   func()
+	queuedAt time.Time
+}
+
+// New starts workers goroutines with queueSize pending commands each. A command that
+// waits longer than deadline before a worker picks it up is expired instead of run.
+func New(workers, queueSize int, deadline time.Duration) *Dispatcher {
+	d := &Dispatcher{
+		lanes:    make([]chan job, workers),
+		deadline: deadline,
+	}
+
+	for i := range d.lanes {
+		d.lanes[i] = make(chan job, queueSize)
+		d.wg.Add(1)
+		go d.work(d.lanes[i])
+	}
+
+	return d
+}
+
+// FromConfig builds a dispatcher from the dispatch config.
+func FromConfig() *Dispatcher {
+	cfg := config.Current().Dispatch
+	workers, queueSize, deadline := cfg.Workers, cfg.QueueSize, cfg.Deadline
+
+	log.Info().
+		Int("workers", workers).
+		Int("queueSize", queueSize).
+		Dur("deadline", deadline).
+		Msg("Dispatcher started")
+
+	return New(workers, queueSize, deadline)
+}
+
+// Submit queues run on the worker that owns key. If the command is still queued once
+// its deadline passes, expire is called instead. Submit returns false if ctx is
+// cancelled while waiting for queue space.
+func (d *Dispatcher) Submit(ctx context.Context, key string, run func(release func()), expire func()) bool {
+	j := job{run: run, expire: expire, queuedAt: time.Now()}
+
+	select {
+	case d.lanes[d.lane(key)] <- j:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Close stops accepting commands and waits for every queued command to finish.
+func (d *Dispatcher) Close() {
+	for _, lane := range d.lanes {
+		close(lane)
+	}
+	d.wg.Wait()
+	d.inFlight.Wait()
+}
+
+func (d *Dispatcher) lane(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(d.lanes)))
+}
+
+func (d *Dispatcher) work(lane chan job) {
+	defer d.wg.Done()
+
+	for j := range lane {
+		if d.deadline > 0 && time.Since(j.queuedAt) > d.deadline {
+			j.expire()
+			continue
+		}
+
+		released := make(chan struct{})
+		var once sync.Once
+		release := func() { once.Do(func() { close(released) }) }
+
+		d.inFlight.Add(1)
+		go func() {
+			defer d.inFlight.Done()
+			defer release()
+			j.run(release)
+		}()
+		<-released
+	}
+}
@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/types"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrAdjustmentNotFound     = errors.New("adjustment not found")
+	ErrAdjustmentNotPending   = errors.New("adjustment is not pending approval")
+	ErrSameOperator           = errors.New("approver must be a different operator than the requester")
+	ErrInvalidReasonCode      = errors.New("invalid or missing reason code")
+	ErrMissingOperator        = errors.New("operator id is required")
+	ErrZeroDelta              = errors.New("delta must not be zero")
+	ErrInvalidPosition        = errors.New("position adjustments need a symbol, a YES/NO side and a whole number of shares")
+	ErrAdjustmentUserNotFound = errors.New("user not found")
+	ErrNegativeAfterAdjust    = errors.New("adjustment would make the balance negative")
+	ErrAdjustmentStale        = errors.New("the balance has changed since the adjustment was requested")
+)
+
+// RequestAdjustment validates and journals a manual correction. Small adjustments apply
+// immediately; anything above AdjustmentThreshold, or with an Expected value, waits for
+// ApproveAdjustment from a second operator.
+func (e *Engine) RequestAdjustment(ctx context.Context, adj types.Adjustment) (types.Adjustment, error) {
+	if adj.RequestedBy == "" {
+		return adj, ErrMissingOperator
+	}
+	if _, ok := types.ReasonCodes[adj.ReasonCode]; !ok {
+		return adj, ErrInvalidReasonCode
+	}
+	if adj.Delta == 0 {
+		return adj, ErrZeroDelta
+	}
+	if adj.Kind == types.PositionAdjustment {
+		if adj.Symbol == "" || (adj.Side != types.Yes && adj.Side != types.No) || adj.Delta != math.Trunc(adj.Delta) {
+			return adj, ErrInvalidPosition
+		}
+	}
+
+	e.UM.RLock()
+	_, exists := e.User[adj.UserId]
+	e.UM.RUnlock()
+	if !exists {
+		return adj, ErrAdjustmentUserNotFound
+	}
+
+	adj.AdjustmentId = uuid.New().String()
+	adj.RequestedAt = time.Now()
+	adj.Status = types.AdjustmentPending
+
+	if adj.Expected == nil && adjustmentNotional(adj) <= e.AdjustmentThreshold {
+		if err := e.applyAdjustment(&adj); err != nil {
+			return adj, err
+		}
+	}
+
+	e.AM.Lock()
+	e.Adjustments[adj.AdjustmentId] = &adj
+	e.AM.Unlock()
+
+	e.publishAdjustment(ctx, adj)
+	return adj, nil
+}
+
+// ApproveAdjustment applies a pending adjustment on behalf of a second operator.
+func (e *Engine) ApproveAdjustment(ctx context.Context, adjustmentId, operatorId string) (types.Adjustment, error) {
+	return e.resolveAdjustment(ctx, adjustmentId, operatorId, true)
+}
+
+// RejectAdjustment discards a pending adjustment without touching balances.
+func (e *Engine) RejectAdjustment(ctx context.Context, adjustmentId, operatorId string) (types.Adjustment, error) {
+	return e.resolveAdjustment(ctx, adjustmentId, operatorId, false)
+}
+
+func (e *Engine) resolveAdjustment(ctx context.Context, adjustmentId, operatorId string, approve bool) (types.Adjustment, error) {
+	if operatorId == "" {
+		return types.Adjustment{}, ErrMissingOperator
+	}
+
+	e.AM.Lock()
+	defer e.AM.Unlock()
+
+	adj, ok := e.Adjustments[adjustmentId]
+	if !ok {
+		return types.Adjustment{}, ErrAdjustmentNotFound
+	}
+	if adj.Status != types.AdjustmentPending {
+		return *adj, ErrAdjustmentNotPending
+	}
+	if adj.RequestedBy == operatorId {
+		return *adj, ErrSameOperator
+	}
+
+	adj.ApprovedBy = operatorId
+	if approve {
+		if err := e.applyAdjustment(adj); err != nil {
+			adj.ApprovedBy = ""
+			return *adj, err
+		}
+	} else {
+		adj.Status = types.AdjustmentRejected
+		adj.ResolvedAt = time.Now()
+	}
+
+	e.publishAdjustment(ctx, *adj)
+	return *adj, nil
+}
+
+// applyAdjustment mutates the user's wallet or position and books the change in the ledger.
+// An adjustment with an Expected value is refused if the wallet or position no longer holds it.
+func (e *Engine) applyAdjustment(adj *types.Adjustment) error {
+	e.UM.Lock()
+	defer e.UM.Unlock()
+
+	user, exists := e.User[adj.UserId]
+	if !exists {
+		return ErrAdjustmentUserNotFound
+	}
+
+	user.Mutex.Lock()
+	defer user.Mutex.Unlock()
+
+	if adj.Expected != nil && math.Abs(adjustedValue(user, adj)-*adj.Expected) > reconcileTolerance {
+		return ErrAdjustmentStale
+	}
+
+	switch adj.Kind {
+	case types.BalanceAdjustment:
+		if user.Balance.WalletBalance.Amount+adj.Delta < 0 {
+			return ErrNegativeAfterAdjust
+		}
+		user.Balance.WalletBalance.Amount += adj.Delta
+		if adj.Delta > 0 {
+			user.Funded = true
+		}
+		e.RecordFunding(adj.Delta)
+
+	case types.PositionAdjustment:
+		qty := int(adj.Delta)
+		if user.Balance.StockBalance == nil {
+			user.Balance.StockBalance = make(map[string]types.StockBalance)
+		}
+		stock := user.Balance.StockBalance[adj.Symbol]
+		if adj.Side == types.Yes {
+			stock.Yes += qty
+		} else {
+			stock.No += qty
+		}
+		if stock.Yes < 0 || stock.No < 0 {
+			return ErrNegativeAfterAdjust
+		}
+		user.Balance.StockBalance[adj.Symbol] = stock
+		e.recordShareAdjustment(adj.Symbol, adj.Side, qty)
+	}
+
+	adj.Status = types.AdjustmentApplied
+	adj.ResolvedAt = time.Now()
+
+	log.Info().
+		Str("adjustmentId", adj.AdjustmentId).
+		Str("kind", string(adj.Kind)).
+		Str("userId", adj.UserId).
+		Float64("delta", adj.Delta).
+		Str("reasonCode", string(adj.ReasonCode)).
+		Str("requestedBy", adj.RequestedBy).
+		Str("approvedBy", adj.ApprovedBy).
+		Msg("Adjustment applied")
+
+	return nil
+}
+
+func (e *Engine) publishAdjustment(ctx context.Context, adj types.Adjustment) {
+	e.Publish(ctx, types.ADJUSTMENT, schema.Adjustment{
+		AdjustmentId: adj.AdjustmentId, Kind: string(adj.Kind), UserId: adj.UserId,
+		Symbol: adj.Symbol, Side: string(adj.Side), Delta: adj.Delta,
+		ReasonCode: string(adj.ReasonCode), Note: adj.Note,
+		RequestedBy: adj.RequestedBy, ApprovedBy: adj.ApprovedBy, Status: string(adj.Status),
+		RequestedAt: adj.RequestedAt, ResolvedAt: adj.ResolvedAt,
+	})
+}
+
+// adjustedValue is the wallet or position adj changes. Caller must hold the user's mutex.
+func adjustedValue(user *types.User, adj *types.Adjustment) float64 {
+	if adj.Kind == types.BalanceAdjustment {
+		return user.Balance.WalletBalance.Amount
+	}
+	stock := user.Balance.StockBalance[adj.Symbol]
+	if adj.Side == types.Yes {
+		return float64(stock.Yes)
+	}
+	return float64(stock.No)
+}
+
+// adjustmentNotional values a position adjustment at the full payout per share.
+func adjustmentNotional(adj types.Adjustment) float64 {
+	if adj.Kind == types.PositionAdjustment {
+		return math.Abs(adj.Delta) * payoutPerShare()
+	}
+	return math.Abs(adj.Delta)
+}
@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/types"
+	"testing"
+)
+
+func expect(v float64) *float64 { return &v }
+
+func TestRequestAdjustment(t *testing.T) {
+	tests := []struct {
+		name       string
+		adj        types.Adjustment
+		err        error
+		status     types.AdjustmentStatus
+		wantAmount float64
+		wantYes    int
+	}{
+		{
+			name:       "small credit applies at once",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, UserId: "alice", Delta: 250, ReasonCode: types.ReasonGoodwill, RequestedBy: "ops-1"},
+			status:     types.AdjustmentApplied,
+			wantAmount: 350,
+		},
+		{
+			name:       "credit at the threshold applies at once",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, UserId: "alice", Delta: 1000, ReasonCode: types.ReasonGoodwill, RequestedBy: "ops-1"},
+			status:     types.AdjustmentApplied,
+			wantAmount: 1100,
+		},
+		{
+			name:       "credit above the threshold waits",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, UserId: "alice", Delta: 1000.01, ReasonCode: types.ReasonGoodwill, RequestedBy: "ops-1"},
+			status:     types.AdjustmentPending,
+			wantAmount: 100,
+		},
+		{
+			name:       "debit above the threshold waits",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, UserId: "alice", Delta: -1500, ReasonCode: types.ReasonOther, RequestedBy: "ops-1"},
+			status:     types.AdjustmentPending,
+			wantAmount: 100,
+		},
+		{
+			name:       "position valued at the payout per share",
+			adj:        types.Adjustment{Kind: types.PositionAdjustment, UserId: "alice", Symbol: "RAIN", Side: types.Yes, Delta: 101, ReasonCode: types.ReasonTradeCorrection, RequestedBy: "ops-1"},
+			status:     types.AdjustmentPending,
+			wantAmount: 100,
+		},
+		{
+			name:       "small position applies at once",
+			adj:        types.Adjustment{Kind: types.PositionAdjustment, UserId: "alice", Symbol: "RAIN", Side: types.Yes, Delta: 5, ReasonCode: types.ReasonTradeCorrection, RequestedBy: "ops-1"},
+			status:     types.AdjustmentApplied,
+			wantAmount: 100,
+			wantYes:    5,
+		},
+		{
+			name:       "expected value waits whatever its size",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, UserId: "alice", Delta: 1, ReasonCode: types.ReasonReconciliation, RequestedBy: ReconciliationOperator, Expected: expect(100)},
+			status:     types.AdjustmentPending,
+			wantAmount: 100,
+		},
+		{
+			name:       "overdraft is refused",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, UserId: "alice", Delta: -100.5, ReasonCode: types.ReasonOther, RequestedBy: "ops-1"},
+			err:        ErrNegativeAfterAdjust,
+			wantAmount: 100,
+		},
+		{
+			name:       "unknown reason",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, UserId: "alice", Delta: 1, ReasonCode: "BECAUSE", RequestedBy: "ops-1"},
+			err:        ErrInvalidReasonCode,
+			wantAmount: 100,
+		},
+		{
+			name:       "fractional shares",
+			adj:        types.Adjustment{Kind: types.PositionAdjustment, UserId: "alice", Symbol: "RAIN", Side: types.Yes, Delta: 1.5, ReasonCode: types.ReasonOther, RequestedBy: "ops-1"},
+			err:        ErrInvalidPosition,
+			wantAmount: 100,
+		},
+		{
+			name:       "no operator",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, UserId: "alice", Delta: 1, ReasonCode: types.ReasonOther},
+			err:        ErrMissingOperator,
+			wantAmount: 100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testEngine()
+			e.User["alice"] = testUser("alice", 100)
+
+			adj, err := e.RequestAdjustment(context.Background(), tt.adj)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && adj.Status != tt.status {
+				t.Errorf("status %s, want %s", adj.Status, tt.status)
+			}
+			if amount := e.User["alice"].Balance.WalletBalance.Amount; amount != tt.wantAmount {
+				t.Errorf("amount %v, want %v", amount, tt.wantAmount)
+			}
+			if yes := e.User["alice"].Balance.StockBalance["RAIN"].Yes; yes != tt.wantYes {
+				t.Errorf("yes %d, want %d", yes, tt.wantYes)
+			}
+		})
+	}
+}
+
+func TestResolveAdjustment(t *testing.T) {
+	tests := []struct {
+		name       string
+		operator   string
+		approve    bool
+		err        error
+		status     types.AdjustmentStatus
+		wantAmount float64
+	}{
+		{name: "approved by a second operator", operator: "ops-2", approve: true, status: types.AdjustmentApplied, wantAmount: 2100},
+		{name: "rejected by a second operator", operator: "ops-2", status: types.AdjustmentRejected, wantAmount: 100},
+		{name: "requester cannot approve", operator: "ops-1", approve: true, err: ErrSameOperator, status: types.AdjustmentPending, wantAmount: 100},
+		{name: "operator required", approve: true, err: ErrMissingOperator, wantAmount: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testEngine()
+			e.User["alice"] = testUser("alice", 100)
+			pending, err := e.RequestAdjustment(context.Background(), types.Adjustment{Kind: types.BalanceAdjustment, UserId: "alice", Delta: 2000, ReasonCode: types.ReasonDepositCorrection, RequestedBy: "ops-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resolve := e.RejectAdjustment
+			if tt.approve {
+				resolve = e.ApproveAdjustment
+			}
+			adj, err := resolve(context.Background(), pending.AdjustmentId, tt.operator)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if adj.Status != tt.status {
+				t.Errorf("status %s, want %s", adj.Status, tt.status)
+			}
+			if amount := e.User["alice"].Balance.WalletBalance.Amount; amount != tt.wantAmount {
+				t.Errorf("amount %v, want %v", amount, tt.wantAmount)
+			}
+
+			if _, err := e.ApproveAdjustment(context.Background(), pending.AdjustmentId, "ops-3"); tt.err == nil && !errors.Is(err, ErrAdjustmentNotPending) {
+				t.Errorf("resolving twice: error %v, want %v", err, ErrAdjustmentNotPending)
+			}
+		})
+	}
+}
+
+func TestApproveAdjustmentRechecksExpected(t *testing.T) {
+	tests := []struct {
+		name       string
+		adj        types.Adjustment
+		move       func(u *types.User)
+		err        error
+		status     types.AdjustmentStatus
+		wantAmount float64
+		wantYes    int
+	}{
+		{
+			name:       "wallet unchanged",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, Delta: 5, Expected: expect(100)},
+			move:       func(u *types.User) {},
+			status:     types.AdjustmentApplied,
+			wantAmount: 105,
+		},
+		{
+			name:       "wallet moved within tolerance",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, Delta: 5, Expected: expect(100)},
+			move:       func(u *types.User) { u.Balance.WalletBalance.Amount += reconcileTolerance / 2 },
+			status:     types.AdjustmentApplied,
+			wantAmount: 105 + reconcileTolerance/2,
+		},
+		{
+			name:       "wallet moved",
+			adj:        types.Adjustment{Kind: types.BalanceAdjustment, Delta: 5, Expected: expect(100)},
+			move:       func(u *types.User) { u.Balance.WalletBalance.Amount -= 20 },
+			err:        ErrAdjustmentStale,
+			status:     types.AdjustmentPending,
+			wantAmount: 80,
+		},
+		{
+			name:       "position unchanged",
+			adj:        types.Adjustment{Kind: types.PositionAdjustment, Symbol: "RAIN", Side: types.Yes, Delta: 2, Expected: expect(0)},
+			move:       func(u *types.User) {},
+			status:     types.AdjustmentApplied,
+			wantAmount: 100,
+			wantYes:    2,
+		},
+		{
+			name:       "position moved",
+			adj:        types.Adjustment{Kind: types.PositionAdjustment, Symbol: "RAIN", Side: types.Yes, Delta: 2, Expected: expect(0)},
+			move:       func(u *types.User) { u.Balance.StockBalance["RAIN"] = types.StockBalance{Yes: 2} },
+			err:        ErrAdjustmentStale,
+			status:     types.AdjustmentPending,
+			wantAmount: 100,
+			wantYes:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testEngine()
+			user := testUser("alice", 100)
+			e.User["alice"] = user
+			tt.adj.UserId, tt.adj.ReasonCode, tt.adj.RequestedBy = "alice", types.ReasonReconciliation, ReconciliationOperator
+			pending, err := e.RequestAdjustment(context.Background(), tt.adj)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.move(user)
+			adj, err := e.ApproveAdjustment(context.Background(), pending.AdjustmentId, "ops-1")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if adj.Status != tt.status {
+				t.Errorf("status %s, want %s", adj.Status, tt.status)
+			}
+			if amount := user.Balance.WalletBalance.Amount; amount != tt.wantAmount {
+				t.Errorf("amount %v, want %v", amount, tt.wantAmount)
+			}
+			if yes := user.Balance.StockBalance["RAIN"].Yes; yes != tt.wantYes {
+				t.Errorf("yes %d, want %d", yes, tt.wantYes)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/config"
+	"matching-engine/internals/types"
+	"sort"
+)
+
+var (
+	// ErrBookSeqExpired means the seq asked for is older than the book history kept.
+	ErrBookSeqExpired = errors.New("book seq is no longer kept, resync from the latest snapshot")
+	// ErrBookSeqAhead means the market has not sent the seq asked for yet.
+	ErrBookSeqAhead = errors.New("book seq has not been reached")
+)
+
+type bookLevel struct {
+	outcome types.Side
+	bid     bool
+	price   float64
+}
+
+// newBookFeed starts numbering from the book the market holds now, which is seq 0.
+func newBookFeed(market *types.Market) *types.L2Feed {
+	book := aggregateBook(market)
+	return &types.L2Feed{
+		Book:      book,
+		Snapshots: []types.L2Snapshot{{Symbol: market.Symbol, OrderBook: book}},
+	}
+}
+
+// publishBook sends the levels that changed since the last update as a BOOK_DIFF
+// under the next seq, and every BookSnapshotEvery seqs the whole book as a
+// BOOK_SNAPSHOT, so subscribers that missed a seq can resync. Runs on the market
+// goroutine after every command that may have changed the book.
+func (e *Engine) publishBook(ctx context.Context, market *types.Market) {
+	feed := market.Feed
+	book := aggregateBook(market)
+	changes, changed := diffBooks(feed.Book, book)
+	if !changed {
+		return
+	}
+
+	feed.Seq++
+	feed.Book = book
+	feed.Diffs = append(feed.Diffs, types.L2Diff{Seq: feed.Seq, Changes: changes})
+	e.broadcastMarket(ctx, market.Symbol, map[string]interface{}{
+		"type":      "BOOK_DIFF",
+		"symbol":    market.Symbol,
+		"seq":       feed.Seq,
+		"orderbook": changes,
+	})
+
+	stream := config.Current().Stream
+	if feed.Seq%uint64(stream.BookSnapshotEvery) != 0 {
+		return
+	}
+	feed.Snapshots = append(feed.Snapshots, types.L2Snapshot{Symbol: market.Symbol, Seq: feed.Seq, OrderBook: book})
+	e.broadcastMarket(ctx, market.Symbol, map[string]interface{}{
+		"type":      "BOOK_SNAPSHOT",
+		"symbol":    market.Symbol,
+		"seq":       feed.Seq,
+		"orderbook": book,
+	})
+
+	// Keep the snapshots that cover BookHistory and only the diffs after the oldest
+	oldest := 0
+	for oldest < len(feed.Snapshots)-1 && feed.Seq-feed.Snapshots[oldest+1].Seq >= uint64(stream.BookHistory) {
+		oldest++
+	}
+	feed.Snapshots = feed.Snapshots[oldest:]
+	first := sort.Search(len(feed.Diffs), func(i int) bool { return feed.Diffs[i].Seq > feed.Snapshots[0].Seq })
+	feed.Diffs = feed.Diffs[first:]
+}
+
+// bookAt rebuilds the book as of seq from the latest snapshot at or before it and the
+// diffs that followed; seq 0 is the current book.
+func bookAt(market *types.Market, seq uint64) (types.L2Snapshot, error) {
+	feed := market.Feed
+	if seq == 0 || seq == feed.Seq {
+		return types.L2Snapshot{Symbol: market.Symbol, Seq: feed.Seq, OrderBook: feed.Book}, nil
+	}
+	if seq > feed.Seq {
+		return types.L2Snapshot{}, ErrBookSeqAhead
+	}
+	if seq < feed.Snapshots[0].Seq {
+		return types.L2Snapshot{}, ErrBookSeqExpired
+	}
+
+	i := sort.Search(len(feed.Snapshots), func(i int) bool { return feed.Snapshots[i].Seq > seq }) - 1
+	base := feed.Snapshots[i]
+	levels := bookLevels(base.OrderBook)
+	for _, diff := range feed.Diffs {
+		if diff.Seq <= base.Seq {
+			continue
+		}
+		if diff.Seq > seq {
+			break
+		}
+		for key, level := range bookLevels(diff.Changes) {
+			if level.Quantity == 0 {
+				delete(levels, key)
+			} else {
+				levels[key] = level
+			}
+		}
+	}
+	return types.L2Snapshot{Symbol: market.Symbol, Seq: seq, OrderBook: levelsToBook(levels)}, nil
+}
+
+// BookAt returns the market's L2 book as of seq, or the current one for seq 0.
+func (e *Engine) BookAt(ctx context.Context, symbol string, seq uint64) (types.L2Snapshot, error) {
+	market, ok := e.GetMarket(symbol)
+	if !ok {
+		return types.L2Snapshot{}, ErrMarketNotFound
+	}
+	resp, err := e.Ask(ctx, market, types.MarketBookAt, seq)
+	if err != nil {
+		return types.L2Snapshot{}, err
+	}
+	if err, ok := resp.(error); ok {
+		return types.L2Snapshot{}, err
+	}
+	return resp.(types.L2Snapshot), nil
+}
+
+// diffBooks lists every level that differs between old and new with its new
+// quantities, and the levels new no longer has with all of them 0.
+func diffBooks(old, new types.AggregatedOrderBook) (types.AggregatedOrderBook, bool) {
+	before, after := bookLevels(old), bookLevels(new)
+	changes := make(map[bookLevel]types.BookLevel)
+	for key, level := range after {
+		if before[key] != level {
+			changes[key] = level
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changes[key] = types.BookLevel{Price: key.price}
+		}
+	}
+	return levelsToBook(changes), len(changes) > 0
+}
+
+func bookLevels(book types.AggregatedOrderBook) map[bookLevel]types.BookLevel {
+	levels := make(map[bookLevel]types.BookLevel)
+	for _, side := range levelSides(&book) {
+		for _, l := range *side.levels {
+			levels[bookLevel{side.outcome, side.bid, l.Price}] = l
+		}
+	}
+	return levels
+}
+
+// levelsToBook lays levels out the way AggregateOrderBook does, bids highest first and
+// asks lowest first.
+func levelsToBook(levels map[bookLevel]types.BookLevel) types.AggregatedOrderBook {
+	book := types.AggregatedOrderBook{
+		Yes: types.OutcomeBook{Bids: []types.BookLevel{}, Asks: []types.BookLevel{}},
+		No:  types.OutcomeBook{Bids: []types.BookLevel{}, Asks: []types.BookLevel{}},
+	}
+	sides := levelSides(&book)
+	for key, l := range levels {
+		for _, side := range sides {
+			if side.outcome == key.outcome && side.bid == key.bid {
+				*side.levels = append(*side.levels, l)
+			}
+		}
+	}
+	for _, side := range sides {
+		levels, bid := *side.levels, side.bid
+		sort.Slice(levels, func(i, j int) bool {
+			if bid {
+				return levels[i].Price > levels[j].Price
+			}
+			return levels[i].Price < levels[j].Price
+		})
+	}
+	return book
+}
+
+type levelSide struct {
+	outcome types.Side
+	bid     bool
+	levels  *[]types.BookLevel
+}
+
+func levelSides(book *types.AggregatedOrderBook) []levelSide {
+	return []levelSide{
+		{types.Yes, true, &book.Yes.Bids},
+		{types.Yes, false, &book.Yes.Asks},
+		{types.No, true, &book.No.Bids},
+		{types.No, false, &book.No.Asks},
+	}
+}
@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/config"
+	"matching-engine/internals/types"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// levels reads its arguments as price, direct, implied triples.
+func levels(triples ...int) []types.BookLevel {
+	out := []types.BookLevel{}
+	for i := 0; i < len(triples); i += 3 {
+		price, direct, implied := triples[i], triples[i+1], triples[i+2]
+		out = append(out, types.BookLevel{Price: float64(price), Quantity: direct + implied, Direct: direct, Implied: implied})
+	}
+	return out
+}
+
+func book(yesBids, yesAsks, noBids, noAsks []types.BookLevel) types.AggregatedOrderBook {
+	return types.AggregatedOrderBook{
+		Yes: types.OutcomeBook{Bids: yesBids, Asks: yesAsks},
+		No:  types.OutcomeBook{Bids: noBids, Asks: noAsks},
+	}
+}
+
+func TestDiffBooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new types.AggregatedOrderBook
+		changes  types.AggregatedOrderBook
+		changed  bool
+	}{
+		{
+			name:    "unchanged",
+			old:     book(levels(6, 10, 0), levels(), levels(), levels(4, 0, 10)),
+			new:     book(levels(6, 10, 0), levels(), levels(), levels(4, 0, 10)),
+			changes: book(levels(), levels(), levels(), levels()),
+		},
+		{
+			name:    "level added",
+			old:     book(levels(6, 10, 0), levels(), levels(), levels()),
+			new:     book(levels(7, 3, 0, 6, 10, 0), levels(), levels(), levels()),
+			changes: book(levels(7, 3, 0), levels(), levels(), levels()),
+			changed: true,
+		},
+		{
+			name:    "implied quantity changed",
+			old:     book(levels(6, 10, 0), levels(), levels(), levels(4, 0, 10)),
+			new:     book(levels(6, 10, 0), levels(), levels(), levels(4, 0, 7)),
+			changes: book(levels(), levels(), levels(), levels(4, 0, 7)),
+			changed: true,
+		},
+		{
+			name:    "level removed is sent as zero",
+			old:     book(levels(), levels(), levels(4, 5, 0, 3, 1, 0), levels()),
+			new:     book(levels(), levels(), levels(3, 1, 0), levels()),
+			changes: book(levels(), levels(), levels(4, 0, 0), levels()),
+			changed: true,
+		},
+		{
+			name:    "same price on the other side is another level",
+			old:     book(levels(5, 2, 0), levels(), levels(), levels()),
+			new:     book(levels(), levels(5, 2, 0), levels(), levels()),
+			changes: book(levels(5, 0, 0), levels(5, 2, 0), levels(), levels()),
+			changed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, changed := diffBooks(tt.old, tt.new)
+			if changed != tt.changed || !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("diff %+v (changed %v), want %+v (changed %v)", changes, changed, tt.changes, tt.changed)
+			}
+		})
+	}
+}
+
+// withStream runs a test with the book feed settings given, restoring the config after.
+func withStream(t *testing.T, snapshotEvery, history int) {
+	previous := *config.Current()
+	cfg := previous
+	cfg.Stream.BookSnapshotEvery, cfg.Stream.BookHistory = snapshotEvery, history
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+}
+
+// offlineRedis is a client whose broadcasts fail at once, for tests that only look at
+// what the engine keeps.
+func offlineRedis() *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 10 * time.Millisecond})
+}
+
+func TestBookAt(t *testing.T) {
+	withStream(t, 2, 4)
+	e := testEngine()
+	market := testMarket("RAIN")
+	market.Feed = newBookFeed(market)
+	ctx := context.Background()
+
+	// Each step changes the book except the third, which must not take a seq
+	books := []types.AggregatedOrderBook{aggregateBook(market)}
+	for i, price := range []float64{6, 6.5, 0, 4, 7, 5.5, 3} {
+		if price != 0 {
+			market.OrderBook.YesBids.Push(&types.Order{OrderId: string(rune('a' + i)), Price: price, Quantity: i + 1, Side: types.Yes, Action: types.BUY})
+		}
+		e.publishBook(ctx, market)
+		if price != 0 {
+			books = append(books, aggregateBook(market))
+		}
+	}
+
+	if market.Feed.Seq != 6 {
+		t.Fatalf("feed at seq %d, want 6", market.Feed.Seq)
+	}
+	if first := market.Feed.Snapshots[0].Seq; first != 2 {
+		t.Errorf("oldest snapshot at seq %d, want 2", first)
+	}
+
+	tests := []struct {
+		seq uint64
+		err error
+	}{
+		{seq: 0}, {seq: 1, err: ErrBookSeqExpired}, {seq: 2}, {seq: 3}, {seq: 4}, {seq: 5}, {seq: 6}, {seq: 7, err: ErrBookSeqAhead},
+	}
+	for _, tt := range tests {
+		snap, err := bookAt(market, tt.seq)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("seq %d: error %v, want %v", tt.seq, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		want := books[tt.seq]
+		if tt.seq == 0 {
+			want = books[len(books)-1]
+		}
+		if !reflect.DeepEqual(snap.OrderBook, want) {
+			t.Errorf("seq %d rebuilt %+v, want %+v", tt.seq, snap.OrderBook, want)
+		}
+	}
+}
@@ -1,9 +1,17 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"matching-engine/internals/config"
+	"matching-engine/internals/tracing"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
 )
 
 func (e *Engine) BroadcastMessage(channel string, message string) {
@@ -15,3 +23,217 @@ func (e *Engine) BroadcastMessage(channel string, message string) {
 	}
 
 }
+
+// marketChannel is the Redis channel a market's updates are published on.
+func marketChannel(symbol string) string {
+	return "stream:market:" + symbol
+}
+
+// userChannel is the Redis channel a user's private updates are published on.
+func userChannel(userId string) string {
+	return "stream:user:" + userId
+}
+
+// channelStream holds the updates a channel has not published yet. It is retired once
+// they are out, so idle channels hold nothing.
+type channelStream struct {
+	mu      sync.Mutex
+	channel string
+	// key and id name whose updates these are ("symbol" or "userId") in a BATCH.
+	key, id string
+	pending []map[string]interface{}
+	timer   *time.Timer
+	retired bool
+}
+
+// broadcastMarket publishes update on the market's channel.
+func (e *Engine) broadcastMarket(ctx context.Context, symbol string, update map[string]interface{}) {
+	e.broadcast(ctx, marketChannel(symbol), "symbol", symbol, update)
+}
+
+// broadcastUser publishes update on the user's private channel.
+func (e *Engine) broadcastUser(ctx context.Context, userId string, update map[string]interface{}) {
+	e.broadcast(ctx, userChannel(userId), "userId", userId, update)
+}
+
+// broadcast publishes update on channel, tagged with the trace id from ctx so stream
+// consumers can tie it back to the command that caused it. Updates within
+// Stream.Coalesce of the first one go out together when the window closes.
+func (e *Engine) broadcast(ctx context.Context, channel, key, id string, update map[string]interface{}) {
+	if traceId := tracing.TraceId(ctx); traceId != "" {
+		update["traceId"] = traceId
+	}
+	window := config.Current().Stream.Coalesce
+
+	s := e.channelStream(channel, key, id)
+	defer s.mu.Unlock()
+
+	s.pending = coalesce(s.pending, update)
+	if window <= 0 {
+		e.publishPending(s)
+		return
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(window, func() { e.flushStream(s) })
+	}
+}
+
+// channelStream returns the open stream for channel with its mu held.
+func (e *Engine) channelStream(channel, key, id string) *channelStream {
+	for {
+		e.SM.Lock()
+		s, ok := e.streams[channel]
+		if !ok {
+			s = &channelStream{channel: channel, key: key, id: id}
+			e.streams[channel] = s
+		}
+		e.SM.Unlock()
+
+		s.mu.Lock()
+		if !s.retired {
+			return s
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (e *Engine) flushStream(s *channelStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.publishPending(s)
+}
+
+// FlushBroadcasts publishes every update still waiting out its window. Shutdown calls it
+// once the markets have drained.
+func (e *Engine) FlushBroadcasts() {
+	e.SM.Lock()
+	streams := make([]*channelStream, 0, len(e.streams))
+	for _, s := range e.streams {
+		streams = append(streams, s)
+	}
+	e.SM.Unlock()
+
+	for _, s := range streams {
+		e.flushStream(s)
+	}
+}
+
+// publishPending sends what s holds, one update on its own and several as a BATCH of
+// them in order, and retires s. The caller holds s.mu, so a channel's messages leave
+// in order: a new stream for the channel can only open once this one is retired.
+func (e *Engine) publishPending(s *channelStream) {
+	if s.retired {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	defer e.retire(s)
+	if len(s.pending) == 0 {
+		return
+	}
+
+	message := s.pending[0]
+	if len(s.pending) > 1 {
+		message = map[string]interface{}{"type": "BATCH", s.key: s.id, "updates": s.pending}
+	}
+	s.pending = nil
+
+	data, err := encodeBroadcast(message, config.Current().Stream.Encoding)
+	if err != nil {
+		log.Error().Err(err).Str("channel", s.channel).Msg("failed to encode stream payload")
+		return
+	}
+	e.BroadcastMessage(s.channel, string(data))
+}
+
+func (e *Engine) retire(s *channelStream) {
+	e.SM.Lock()
+	defer e.SM.Unlock()
+	s.retired = true
+	if e.streams[s.channel] == s {
+		delete(e.streams, s.channel)
+	}
+}
+
+// coalesce adds update to pending, dropping what it makes stale: an earlier TICKER or
+// BALANCE, and every earlier book update once a BOOK_SNAPSHOT replaces the whole book.
+func coalesce(pending []map[string]interface{}, update map[string]interface{}) []map[string]interface{} {
+	var stale func(t interface{}) bool
+	switch update["type"] {
+	case "TICKER", "BALANCE":
+		kind := update["type"]
+		stale = func(t interface{}) bool { return t == kind }
+	case "BOOK_SNAPSHOT":
+		stale = func(t interface{}) bool { return t == "BOOK_DIFF" || t == "BOOK_SNAPSHOT" }
+	default:
+		return append(pending, update)
+	}
+
+	kept := pending[:0]
+	for _, p := range pending {
+		if !stale(p["type"]) {
+			kept = append(kept, p)
+		}
+	}
+	return append(kept, update)
+}
+
+// encodeBroadcast renders a message as JSON or, for "msgpack", as MessagePack with
+// the same field names and structure as the JSON.
+func encodeBroadcast(message map[string]interface{}, encoding string) ([]byte, error) {
+	data, err := json.Marshal(message)
+	if err != nil || encoding != "msgpack" {
+		return data, err
+	}
+
+	// Go through JSON so struct values keep their json field names
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, generic)
+}
+
+func appendMsgpack(b []byte, v interface{}) ([]byte, error) {
+	var err error
+	switch v := v.(type) {
+	case nil:
+		return msgp.AppendNil(b), nil
+	case bool:
+		return msgp.AppendBool(b, v), nil
+	case string:
+		return msgp.AppendString(b, v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return msgp.AppendInt64(b, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return msgp.AppendFloat64(b, f), nil
+	case []interface{}:
+		b = msgp.AppendArrayHeader(b, uint32(len(v)))
+		for _, item := range v {
+			if b, err = appendMsgpack(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = msgp.AppendMapHeader(b, uint32(len(v)))
+		for key, item := range v {
+			b = msgp.AppendString(b, key)
+			if b, err = appendMsgpack(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("cannot encode %T as msgpack", v)
+	}
+}
@@ -1,35 +1,157 @@
 package engine
 
 import (
+	"context"
+	"fmt"
+	"matching-engine/internals/config"
+	"matching-engine/internals/events"
+	"matching-engine/internals/metrics"
+	"matching-engine/internals/outbox"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/tracing"
 	"matching-engine/internals/types"
 	"sync"
+	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
 )
 
 type Engine struct {
 	User   map[string]*types.User
 	Market map[string]*types.Market
-	UM     sync.RWMutex
-	MM     sync.RWMutex
+	// UM guards every user, not just the map: matching holds it exclusively, anything
+	// else touching a user holds it shared for the whole change plus that user's Mutex.
+	UM sync.RWMutex
+	MM sync.RWMutex
+
+	Ledger *types.Ledger
+
+	// Adjustments journals every manual balance/position correction by id.
+	Adjustments map[string]*types.Adjustment
+	// AdjustmentThreshold is the notional above which an adjustment needs a second operator.
+	AdjustmentThreshold float64
+	AM                  sync.Mutex
+
+	// Withdrawals holds every payout from request to confirmation, keyed by id.
+	Withdrawals        map[string]*types.Withdrawal
+	WithdrawalCooldown time.Duration
+	WM                 sync.Mutex
+
+	// Idempotency maps user id to the responses of their keyed commands.
+	Idempotency        map[string]*types.IdempotencyBucket
+	IdempotencyTTL     time.Duration
+	IdempotencyMaxKeys int
+	IM                 sync.Mutex
+	// idempotencyInFlight holds keys whose first attempt has not answered yet. The value
+	// is true once that attempt timed out and the market's late reply will settle the key.
+	idempotencyInFlight map[string]bool
+
+	// InvariantInterval runs the invariant checker after every N commands (0 disables it).
+	InvariantInterval uint64
+	commandCount      uint64
+	touched           map[string]struct{}
+	touchedMu         sync.Mutex
+
+	// Panics holds the diagnostic for every market the supervisor halted, by symbol.
+	Panics map[string]types.MarketPanic
+	PM     sync.Mutex
+
+	// RequestTimeout bounds how long a handler waits for a market to reply.
+	RequestTimeout time.Duration
+	// InboxCapacity is the number of orders a market queues before answering MARKET_BUSY.
+	InboxCapacity int
+
+	// ready is 1 while the engine wants traffic; see SetReady.
+	ready int32
+
+	// streams holds the updates waiting to be broadcast, by channel.
+	streams map[string]*channelStream
+	SM      sync.Mutex
 
 	Redis *redis.Client
+	// Events carries everything the engine reports downstream.
+	Events events.Publisher
 }
 
 var EngineInstance *Engine
 
-func InitEngine(r *redis.Client) {
-	EngineInstance = &Engine{
-		User:   make(map[string]*types.User),
-		Market: make(map[string]*types.Market),
-		Redis:  r,
-	}
+func InitEngine(r *redis.Client, pub events.Publisher) {
+	EngineInstance = New(r, pub)
 
 	// Start background routines
 	EngineInstance.LoadLatestSnapshot()
 	EngineInstance.StartSnapshotRoutine()
 }
 
+// New returns an empty engine configured from config.Current(), with no markets running.
+func New(r *redis.Client, pub events.Publisher) *Engine {
+	cfg := config.Current()
+
+	return &Engine{
+		User:                make(map[string]*types.User),
+		Market:              make(map[string]*types.Market),
+		Ledger:              &types.Ledger{EvictedShares: make(map[string]types.StockBalance)},
+		Adjustments:         make(map[string]*types.Adjustment),
+		AdjustmentThreshold: cfg.Engine.AdjustmentThreshold,
+		Withdrawals:         make(map[string]*types.Withdrawal),
+		WithdrawalCooldown:  cfg.Withdraw.Cooldown,
+		Idempotency:         make(map[string]*types.IdempotencyBucket),
+		IdempotencyTTL:      cfg.Engine.IdempotencyTTL,
+		IdempotencyMaxKeys:  cfg.Engine.IdempotencyMaxKeys,
+		idempotencyInFlight: make(map[string]bool),
+		InvariantInterval:   cfg.Engine.InvariantInterval,
+		touched:             make(map[string]struct{}),
+		Panics:              make(map[string]types.MarketPanic),
+		RequestTimeout:      cfg.Engine.RequestTimeout,
+		InboxCapacity:       cfg.Engine.InboxCapacity,
+		streams:             make(map[string]*channelStream),
+		Redis:               r,
+		Events:              pub,
+	}
+}
+
+// Publish reports an event to the DB processor as part of the trace in ctx. The state
+// change it describes has already happened, so a failure is not returned to the caller
+// but counted and charged to the command in ctx, whose commit then fails.
+func (e *Engine) Publish(ctx context.Context, eventType types.EVENTS, payload schema.Payload) {
+	spec, ok := schema.Lookup(eventType)
+	if !ok {
+		e.publishFailed(ctx, eventType, fmt.Errorf("event type %s is not in the schema catalogue", eventType))
+		return
+	}
+
+	err := e.Events.Publish(ctx, events.Event{
+		Topic:   eventTopic(spec.Family),
+		Key:     schema.Key(payload),
+		Type:    string(eventType),
+		Version: spec.Version,
+		Data:    payload,
+		Headers: tracing.Inject(ctx),
+	})
+	if err != nil {
+		e.publishFailed(ctx, eventType, err)
+	}
+}
+
+func (e *Engine) publishFailed(ctx context.Context, eventType types.EVENTS, err error) {
+	metrics.PublishFailed(string(eventType))
+	charged := outbox.Fail(ctx, fmt.Errorf("%s: %w", eventType, err))
+	log.Error().Err(err).Str("eventType", string(eventType)).Bool("commandFailed", charged).Msg("Failed to publish event")
+}
+
+// eventTopic returns the configured topic for an event family.
+func eventTopic(family schema.Family) string {
+	topics := config.Current().Events.Topics
+	switch family {
+	case schema.FamilyWallet:
+		return topics.Wallet
+	case schema.FamilyOps:
+		return topics.Ops
+	}
+	return topics.Market
+}
+
 func (e *Engine) AddMarket(market *types.Market) {
 
 	e.MM.Lock()
@@ -37,9 +159,22 @@ func (e *Engine) AddMarket(market *types.Market) {
 
 	e.Market[market.Symbol] = market
 
+	e.openInbox(market)
 	go e.runMarket(market)
 }
 
+// StartMarkets opens the inbox of every market and starts its goroutine, for markets
+// installed by Restore or a replay.
+func (e *Engine) StartMarkets() {
+	e.MM.Lock()
+	defer e.MM.Unlock()
+
+	for _, market := range e.Market {
+		e.openInbox(market)
+		go e.runMarket(market)
+	}
+}
+
 func (e *Engine) GetMarket(symbol string) (*types.Market, bool) {
 
 	e.MM.RLock()
@@ -49,3 +184,16 @@ func (e *Engine) GetMarket(symbol string) (*types.Market, bool) {
 
 	return market, ok
 }
+
+// ReloadConfig rereads the configuration and applies its hot-reloadable keys. Fees,
+// position limits and liquidity defaults are read per order and withdrawal limits per
+// request, so they take effect from the next one.
+func (e *Engine) ReloadConfig() (config.ReloadResult, error) {
+	result, err := config.Reload()
+	if err != nil {
+		return result, err
+	}
+
+	log.Info().Strs("applied", result.Applied).Strs("ignored", result.Ignored).Msg("Configuration reloaded")
+	return result, nil
+}
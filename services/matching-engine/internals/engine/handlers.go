@@ -1,8 +1,13 @@
 package engine
 
 import (
-	"encoding/json"
-	"matching-engine/internals/services/kafka"
+	"container/heap"
+	"context"
+	"fmt"
+	"matching-engine/internals/config"
+	"matching-engine/internals/metrics"
+	"matching-engine/internals/pricing"
+	"matching-engine/internals/schema"
 	"matching-engine/internals/types"
 	"matching-engine/internals/utils"
 	"math"
@@ -11,20 +16,95 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func (e *Engine) handleOrder(msg types.MarketMessage, market *types.Market) {
+func (e *Engine) handleOrder(ctx context.Context, msg types.MarketMessage, market *types.Market) {
 	order, ok := msg.Payload.(types.Order)
 	if !ok {
 		msg.ReplyChan <- types.OrderResponse{Success: false, Message: "invalid payload"}
 		return
 	}
 
+	activities, rejection := e.placeOrder(ctx, market, &order)
+	if rejection != nil {
+		msg.ReplyChan <- *rejection
+		return
+	}
+
+	// Post trade stuff
+	e.Publish(ctx, types.ORDER_PLACED, schema.OrderPlaced{
+		OrderId: order.OrderId, MarketId: order.MarketId, Symbol: order.Symbol,
+		UserId: order.UserId, Side: string(order.Side), Action: string(order.Action),
+		Price: order.Price, OriginalQuantity: order.Quantity, FilledQuantity: order.Filled,
+		Timestamp: time.Now(), OrderType: string(order.OrderType), Role: string(order.Role),
+		FeeRate: feeRate(&order), AcceptedAt: order.Timestamp,
+	})
+
+	if len(activities) > 0 {
+		market.Trades = append(market.Trades, activities...)
+		if keep := config.Current().Trading.TradeHistory; len(market.Trades) > keep {
+			market.Trades = market.Trades[len(market.Trades)-keep:]
+		}
+		for _, act := range activities {
+			notional := float64(act.Quantity) * payoutPerShare()
+			market.Volume += notional
+			metrics.CountTrade(market.Symbol, act.MatchType, notional)
+			e.Publish(ctx, types.TRADE_EXECUTED, schema.TradeExecuted(act))
+		}
+	}
+
+	e.reprice(ctx, market, aggregateBook(market))
+
+	// Broadcast ACTIVITY (Trades) update if any trades occurred
+	if len(activities) > 0 {
+		activityPayload := map[string]interface{}{
+			"type":   "ACTIVITY",
+			"symbol": order.Symbol,
+			"trades": activities,
+		}
+		e.broadcastMarket(ctx, market.Symbol, activityPayload)
+	}
+
+	log.Info().Str("marketId", market.MarketId).Str("type", string(order.OrderType)).Int("filled", order.Filled).Msg("Order processed")
+
+	msg.ReplyChan <- types.OrderResponse{Success: true, Message: "order processed", Data: order}
+}
+
+// placeOrder reserves what the order needs and matches it. The book is held from the
+// reserve until the order rests, so the invariant checker never sees cash or shares
+// locked for an order that is not on the book yet, and released by a defer so a panic
+// in matching does not leave it held. It returns the order's trades, or the rejection
+// to send back.
+func (e *Engine) placeOrder(ctx context.Context, market *types.Market, order *types.Order) ([]types.TradeExecutedEvent, *types.OrderResponse) {
+	market.Mu.Lock()
+	defer market.Mu.Unlock()
+
+	if rejection := e.reserveOrder(order); rejection != nil {
+		return nil, rejection
+	}
+	// Before matching, so the owner hears of the order before any of its fills
+	e.notifyOrder(ctx, order, types.OrderAccepted, "")
+	e.notifyBalanceOf(ctx, order.UserId, BalanceOrderAccepted)
+
+	// Track Traders
+	if _, exists := market.Traders[order.UserId]; !exists {
+		market.Traders[order.UserId] = struct{}{}
+		market.NumberOfTraders++
+		e.Publish(ctx, types.INCREASE_TRADERS_COUNT, schema.TradersCountIncreased{MarketId: order.MarketId, Count: 1})
+	}
+
+	// Match Engine execution
+	return e.ProcessLimitOrder(ctx, market, order, order.OrderType == types.MARKET), nil
+}
+
+// reserveOrder runs the risk checks for a new order and locks the cash or shares it
+// needs. It returns the rejection to send back, or nil when the order may proceed.
+func (e *Engine) reserveOrder(order *types.Order) *types.OrderResponse {
 	isAdmin := order.Role == types.ADMIN
 	e.UM.Lock()
+	defer e.UM.Unlock()
+
 	user, exists := e.User[order.UserId]
 	if !exists {
-		e.UM.Unlock()
-		msg.ReplyChan <- types.OrderResponse{Success: false, Message: "user not found"}
-		return
+		return &types.OrderResponse{Success: false, Message: "user not found"}
 	}
 
 	user.LastActive = time.Now()
@@ -33,34 +113,32 @@ func (e *Engine) handleOrder(msg types.MarketMessage, market *types.Market) {
 		user.Balance.StockBalance = make(map[string]types.StockBalance)
 	}
 
+	// The order keeps the fee it was accepted at, whatever a later reload sets
+	trading := config.Current().ForMarket(order.Symbol)
+	order.FeeRate = &trading.Fee
+
 	// Risk Check
 	isMarketOrder := order.OrderType == types.MARKET
 	if order.Action == types.BUY {
 		if isMarketOrder {
-			order.Price = 10.0
+			order.Price = payoutPerShare()
 		}
 		totalCost := order.Price * float64(order.Quantity)
-		totalCostWithFee := totalCost * 1.0025 // Include 0.25% trading fee
+		totalCostWithFee := totalCost * (1 + trading.Fee)
 		if !isAdmin {
-			// Check Position Limit (Max 5000 shares = ₹50k exposure)
 			stock := user.Balance.StockBalance[order.Symbol]
 			currentShares := stock.Yes
 			if order.Side == types.No {
 				currentShares = stock.No
 			}
-			if currentShares+order.Quantity > 5000 {
-				e.UM.Unlock()
-				msg.ReplyChan <- types.OrderResponse{Success: false, Message: "position limit exceeded (max 5000 shares)", Data: currentShares}
-				return
+			if currentShares+order.Quantity > trading.PositionLimit {
+				return &types.OrderResponse{Success: false, Message: fmt.Sprintf("position limit exceeded (max %d shares)", trading.PositionLimit), Data: currentShares}
 			}
 
 			if user.Balance.WalletBalance.Amount < totalCostWithFee {
-				e.UM.Unlock()
-				msg.ReplyChan <- types.OrderResponse{Success: false, Message: "insufficient balance (includes 0.25% fee)", Data: user.Balance.WalletBalance.Amount}
-				return
+				return &types.OrderResponse{Success: false, Message: fmt.Sprintf("insufficient balance (includes %g%% fee)", trading.Fee*100), Data: user.Balance.WalletBalance.Amount}
 			}
-			user.Balance.WalletBalance.Amount -= totalCostWithFee
-			user.Balance.WalletBalance.Locked += totalCostWithFee
+			lockOrder(user, order)
 		}
 	} else { // SELL
 		if isMarketOrder {
@@ -73,103 +151,13 @@ func (e *Engine) handleOrder(msg types.MarketMessage, market *types.Market) {
 				availableQty = stock.No
 			}
 			if availableQty < order.Quantity {
-				e.UM.Unlock()
-				msg.ReplyChan <- types.OrderResponse{Success: false, Message: "insufficient stocks", Data: availableQty}
-				return
-			}
-			if order.Side == types.Yes {
-				stock.Yes -= order.Quantity
-			} else {
-				stock.No -= order.Quantity
+				return &types.OrderResponse{Success: false, Message: "insufficient stocks", Data: availableQty}
 			}
-			user.Balance.StockBalance[order.Symbol] = stock
-		}
-	}
-	e.UM.Unlock()
-
-	// Track Traders
-	if _, exists := market.Traders[order.UserId]; !exists {
-		market.Traders[order.UserId] = struct{}{}
-		market.NumberOfTraders++
-		kafka.ProduceEventToDBProcessor("process_db", string(types.INCREASE_TRADERS_COUNT), map[string]interface{}{"marketId": order.MarketId, "count": 1})
-	}
-
-	// Match Engine execution
-	activities := e.ProcessLimitOrder(market, &order, isMarketOrder)
-
-	// Post trade stuff
-	kafka.ProduceEventToDBProcessor("process_db", string(types.ORDER_PLACED), map[string]interface{}{
-		"orderId": order.OrderId, "marketId": order.MarketId, "symbol": order.Symbol,
-		"userId": order.UserId, "side": string(order.Side), "action": string(order.Action),
-		"price": order.Price, "originalQuantity": order.Quantity, "filledQuantity": order.Filled,
-		"timestamp": time.Now(),
-	})
-
-	if len(activities) > 0 {
-		market.Trades = append(market.Trades, activities...)
-		if len(market.Trades) > 50 {
-			market.Trades = market.Trades[len(market.Trades)-50:]
-		}
-		for _, act := range activities {
-			market.Volume += float64(act.Quantity * 10)
-			kafka.ProduceEventToDBProcessor("process_db", string(types.TRADE_EXECUTED), act)
-		}
-	}
-
-	// Broadcast Orderbook update
-	market.Mu.RLock()
-	aggOrderBook := utils.AggregateOrderBook(market.OrderBook)
-	market.Mu.RUnlock()
-	probability := utils.GetYesProbability(aggOrderBook)
-	yesPrice := math.Round(probability*10*2) / 2
-	noPrice := math.Round((1-probability)*10*2) / 2
-
-	if yesPrice != float64(market.YesPrice) || noPrice != float64(market.NoPrice) {
-		market.YesPrice = float32(yesPrice)
-		market.NoPrice = float32(noPrice)
-		kafka.ProduceEventToDBProcessor("process_db", string(types.UPDATE_STOCK_PRICE), map[string]interface{}{
-			"marketId": order.MarketId, "yesPrice": yesPrice, "noPrice": noPrice,
-		})
-	}
-
-	// Broadcast TICKER update (lightweight)
-	tickerPayload := map[string]interface{}{
-		"type":            "TICKER",
-		"symbol":          order.Symbol,
-		"yesPrice":        yesPrice,
-		"noPrice":         noPrice,
-		"volume":          market.Volume,
-		"numberOfTraders": market.NumberOfTraders,
-	}
-	if tickerData, err := json.Marshal(tickerPayload); err == nil {
-		e.BroadcastMessage("stream:data", string(tickerData))
-	}
-
-	// Broadcast ORDERBOOK update
-	orderbookPayload := map[string]interface{}{
-		"type":      "ORDERBOOK",
-		"symbol":    order.Symbol,
-		"orderbook": aggOrderBook,
-	}
-	if obData, err := json.Marshal(orderbookPayload); err == nil {
-		e.BroadcastMessage("stream:data", string(obData))
-	}
-
-	// Broadcast ACTIVITY (Trades) update if any trades occurred
-	if len(activities) > 0 {
-		activityPayload := map[string]interface{}{
-			"type":   "ACTIVITY",
-			"symbol": order.Symbol,
-			"trades": activities,
-		}
-		if actData, err := json.Marshal(activityPayload); err == nil {
-			e.BroadcastMessage("stream:data", string(actData))
+			lockOrder(user, order)
 		}
 	}
 
-	log.Info().Str("marketId", market.MarketId).Str("type", string(order.OrderType)).Int("filled", order.Filled).Msg("Order processed")
-
-	msg.ReplyChan <- types.OrderResponse{Success: true, Message: "order processed", Data: order}
+	return nil
 }
 
 func (e *Engine) GetOrderBook(symbol string) (types.AggregatedOrderBook, bool) {
@@ -181,9 +169,10 @@ func (e *Engine) GetOrderBook(symbol string) (types.AggregatedOrderBook, bool) {
 		return types.AggregatedOrderBook{}, false
 	}
 
-	replyChan := make(chan interface{})
-	market.Inbox <- types.MarketMessage{Type: types.MarketGetOrderBook, ReplyChan: replyChan}
-	resp := <-replyChan
+	resp, err := e.Ask(context.Background(), market, types.MarketGetOrderBook, nil)
+	if err != nil {
+		return types.AggregatedOrderBook{}, false
+	}
 
 	aggOrderBook, ok := resp.(types.AggregatedOrderBook)
 	if !ok {
@@ -192,54 +181,45 @@ func (e *Engine) GetOrderBook(symbol string) (types.AggregatedOrderBook, bool) {
 	return aggOrderBook, true
 }
 
-func (e *Engine) handleResolveMarket(msg types.MarketMessage, market *types.Market) {
+func (e *Engine) handleResolveMarket(ctx context.Context, msg types.MarketMessage, market *types.Market) {
 	result, ok := msg.Payload.(string)
 	if !ok {
 		msg.ReplyChan <- false
 		return
 	}
 
+	e.closeBook(ctx, market)
+
+	// Tell DB to finalize payout
+	e.Publish(ctx, types.MARKET_RESOLVED, schema.MarketResolved{
+		MarketId: market.MarketId,
+		Result:   result,
+	})
+
+	log.Info().Str("marketId", market.MarketId).Str("result", result).Msg("Market resolved and closed")
+	msg.ReplyChan <- true
+}
+
+// closeBook closes the market and releases every resting order: locked cash for bids,
+// escrowed shares for asks.
+func (e *Engine) closeBook(ctx context.Context, market *types.Market) {
 	market.Mu.Lock()
-	market.Status = types.Close
+	defer market.Mu.Unlock()
 
-	// Cancel all YES bids (BUY YES)
-	for _, order := range market.OrderBook.YesBids.OrderHeap {
-		refund := order.Price * float64(order.Quantity-order.Filled)
-		e.UM.Lock()
-		e.User[order.UserId].Balance.WalletBalance.Locked -= refund
-		e.User[order.UserId].Balance.WalletBalance.Amount += refund
-		e.UM.Unlock()
-		kafka.ProduceEventToDBProcessor("process_db", "ORDER_CANCELLED", map[string]interface{}{"userId": order.UserId, "orderId": order.OrderId, "refund": refund, "type": "INR", "marketId": market.MarketId})
-	}
-	// Cancel all NO bids (BUY NO)
-	for _, order := range market.OrderBook.NoBids.OrderHeap {
-		refund := order.Price * float64(order.Quantity-order.Filled)
-		e.UM.Lock()
-		e.User[order.UserId].Balance.WalletBalance.Locked -= refund
-		e.User[order.UserId].Balance.WalletBalance.Amount += refund
-		e.UM.Unlock()
-		kafka.ProduceEventToDBProcessor("process_db", "ORDER_CANCELLED", map[string]interface{}{"userId": order.UserId, "orderId": order.OrderId, "refund": refund, "type": "INR", "marketId": market.MarketId})
-	}
+	market.Status = types.Close
 
-	// Cancel all YES asks (SELL YES)
-	for _, order := range market.OrderBook.YesAsks.OrderHeap {
-		refund := order.Quantity - order.Filled
-		e.UM.Lock()
-		stock := e.User[order.UserId].Balance.StockBalance[market.Symbol]
-		stock.Yes += refund
-		e.User[order.UserId].Balance.StockBalance[market.Symbol] = stock
-		e.UM.Unlock()
-		kafka.ProduceEventToDBProcessor("process_db", "ORDER_CANCELLED", map[string]interface{}{"userId": order.UserId, "orderId": order.OrderId, "refund": refund, "type": "YES_STOCK", "marketId": market.MarketId})
-	}
-	// Cancel all NO asks (SELL NO)
-	for _, order := range market.OrderBook.NoAsks.OrderHeap {
-		refund := order.Quantity - order.Filled
-		e.UM.Lock()
-		stock := e.User[order.UserId].Balance.StockBalance[market.Symbol]
-		stock.No += refund
-		e.User[order.UserId].Balance.StockBalance[market.Symbol] = stock
-		e.UM.Unlock()
-		kafka.ProduceEventToDBProcessor("process_db", "ORDER_CANCELLED", map[string]interface{}{"userId": order.UserId, "orderId": order.OrderId, "refund": refund, "type": "NO_STOCK", "marketId": market.MarketId})
+	for _, h := range []types.OrderHeap{
+		market.OrderBook.YesBids.OrderHeap,
+		market.OrderBook.NoBids.OrderHeap,
+		market.OrderBook.YesAsks.OrderHeap,
+		market.OrderBook.NoAsks.OrderHeap,
+	} {
+		for _, order := range h {
+			refund, refundType := e.releaseRestingOrder(order)
+			e.Publish(ctx, types.ORDER_CANCELLED, orderCancelled(order, market.MarketId, refund, refundType, schema.CancelMarketResolved))
+			e.notifyOrder(ctx, order, types.OrderCancelled, schema.CancelMarketResolved)
+			e.notifyBalanceOf(ctx, order.UserId, BalanceOrderCancelled)
+		}
 	}
 
 	// Clear orderbook
@@ -247,20 +227,63 @@ func (e *Engine) handleResolveMarket(msg types.MarketMessage, market *types.Mark
 	market.OrderBook.NoBids.OrderHeap = make(types.OrderHeap, 0)
 	market.OrderBook.YesAsks.OrderHeap = make(types.OrderHeap, 0)
 	market.OrderBook.NoAsks.OrderHeap = make(types.OrderHeap, 0)
+}
 
-	market.Mu.Unlock()
+// orderCancelled describes a released order from what releaseOrder returned: cash for
+// a buy, shares of refundType ("YES_STOCK" or "NO_STOCK") for a sell.
+func orderCancelled(order *types.Order, marketId string, refund float64, refundType, reason string) schema.OrderCancelled {
+	event := schema.OrderCancelled{UserId: order.UserId, OrderId: order.OrderId, MarketId: marketId, Reason: reason}
+	switch refundType {
+	case "YES_STOCK":
+		event.RefundShares, event.RefundSide = int(refund), string(types.Yes)
+	case "NO_STOCK":
+		event.RefundShares, event.RefundSide = int(refund), string(types.No)
+	default:
+		event.RefundCash = refund
+	}
+	return event
+}
 
-	// Tell DB to finalize payout
-	kafka.ProduceEventToDBProcessor("process_db", "MARKET_RESOLVED", map[string]interface{}{
-		"marketId": market.MarketId,
-		"result":   result,
+// reprice estimates the market's fair value from book and its recent trades, records
+// the new prices and broadcasts them in a TICKER along with what they came from.
+func (e *Engine) reprice(ctx context.Context, market *types.Market, book types.AggregatedOrderBook) {
+	fv := pricing.Estimate(pricing.Inputs{
+		Book: book, Trades: market.Trades, Payout: payoutPerShare(), Now: time.Now(),
+		Previous: float64(market.YesPrice),
+	}, config.Current().ForMarket(market.Symbol).Pricing)
+	yesPrice := fv.YesPrice
+	noPrice := math.Round((payoutPerShare()-yesPrice)*100) / 100
+
+	if float32(yesPrice) != market.YesPrice || float32(noPrice) != market.NoPrice {
+		market.YesPrice = float32(yesPrice)
+		market.NoPrice = float32(noPrice)
+		e.Publish(ctx, types.UPDATE_STOCK_PRICE, schema.PriceUpdated{
+			MarketId: market.MarketId, YesPrice: yesPrice, NoPrice: noPrice,
+		})
+	}
+
+	// Broadcast TICKER update (lightweight)
+	e.broadcastMarket(ctx, market.Symbol, map[string]interface{}{
+		"type":            "TICKER",
+		"symbol":          market.Symbol,
+		"yesPrice":        yesPrice,
+		"noPrice":         noPrice,
+		"volume":          market.Volume,
+		"numberOfTraders": market.NumberOfTraders,
+		"fairValue":       fv,
 	})
+}
 
-	log.Info().Str("marketId", market.MarketId).Str("result", result).Msg("Market resolved and closed")
-	msg.ReplyChan <- true
+// aggregateBook returns the market's price levels under a read lock, with every side
+// [] rather than null when it is empty.
+func aggregateBook(market *types.Market) types.AggregatedOrderBook {
+	market.Mu.RLock()
+	defer market.Mu.RUnlock()
+
+	return utils.AggregateOrderBook(market.OrderBook, payoutPerShare())
 }
 
-func (e *Engine) handleCancelOrder(msg types.MarketMessage, market *types.Market) {
+func (e *Engine) handleCancelOrder(ctx context.Context, msg types.MarketMessage, market *types.Market) {
 	req, ok := msg.Payload.(types.CancelOrderPayload)
 	if !ok {
 		msg.ReplyChan <- types.OrderResponse{Success: false, Message: "invalid payload"}
@@ -271,32 +294,31 @@ func (e *Engine) handleCancelOrder(msg types.MarketMessage, market *types.Market
 	defer market.Mu.Unlock()
 
 	var foundOrder *types.Order
-	var refund float64
-	var refundType string
-
-	removeFromHeap := func(h *types.OrderHeap) *types.Order {
-		for i, order := range *h {
-			if order.OrderId == req.OrderId {
-				found := order
-				*h = append((*h)[:i], (*h)[i+1:]...)
-				return found
+
+	// heap.Remove keeps the rest of the side in price-time order
+	removeFromHeap := func(h heap.Interface, orders types.OrderHeap) *types.Order {
+		for i, order := range orders {
+			if order.OrderId == req.OrderId && order.UserId == req.UserId {
+				heap.Remove(h, i)
+				return order
 			}
 		}
 		return nil
 	}
 
-	if foundOrder = removeFromHeap(&market.OrderBook.YesBids.OrderHeap); foundOrder != nil {
-		refund = foundOrder.Price * float64(foundOrder.Quantity-foundOrder.Filled)
-		refundType = "INR"
-	} else if foundOrder = removeFromHeap(&market.OrderBook.NoBids.OrderHeap); foundOrder != nil {
-		refund = foundOrder.Price * float64(foundOrder.Quantity-foundOrder.Filled)
-		refundType = "INR"
-	} else if foundOrder = removeFromHeap(&market.OrderBook.YesAsks.OrderHeap); foundOrder != nil {
-		refund = float64(foundOrder.Quantity - foundOrder.Filled)
-		refundType = "YES_STOCK"
-	} else if foundOrder = removeFromHeap(&market.OrderBook.NoAsks.OrderHeap); foundOrder != nil {
-		refund = float64(foundOrder.Quantity - foundOrder.Filled)
-		refundType = "NO_STOCK"
+	book := market.OrderBook
+	for _, side := range []struct {
+		h      heap.Interface
+		orders types.OrderHeap
+	}{
+		{book.YesBids, book.YesBids.OrderHeap},
+		{book.NoBids, book.NoBids.OrderHeap},
+		{book.YesAsks, book.YesAsks.OrderHeap},
+		{book.NoAsks, book.NoAsks.OrderHeap},
+	} {
+		if foundOrder = removeFromHeap(side.h, side.orders); foundOrder != nil {
+			break
+		}
 	}
 
 	if foundOrder == nil {
@@ -304,60 +326,14 @@ func (e *Engine) handleCancelOrder(msg types.MarketMessage, market *types.Market
 		return
 	}
 
-	e.UM.Lock()
-	if refundType == "INR" {
-		e.User[req.UserId].Balance.WalletBalance.Locked -= refund
-		e.User[req.UserId].Balance.WalletBalance.Amount += refund
-	} else {
-		stock := e.User[req.UserId].Balance.StockBalance[req.Symbol]
-		if refundType == "YES_STOCK" {
-			stock.Yes += int(refund)
-		} else {
-			stock.No += int(refund)
-		}
-		e.User[req.UserId].Balance.StockBalance[req.Symbol] = stock
-	}
-	e.UM.Unlock()
-
-	kafka.ProduceEventToDBProcessor("process_db", "ORDER_CANCELLED", map[string]interface{}{
-		"userId": req.UserId, "orderId": req.OrderId, "refund": refund, "type": refundType, "marketId": req.MarketId,
-	})
+	// Refund the remaining lock including the fee reserved at placement
+	refund, refundType := e.releaseRestingOrder(foundOrder)
 
-	aggOrderBook := utils.AggregateOrderBook(market.OrderBook)
-	probability := utils.GetYesProbability(aggOrderBook)
-	yesPrice := math.Round(probability*10*2) / 2
-	noPrice := math.Round((1-probability)*10*2) / 2
-
-	if yesPrice != float64(market.YesPrice) || noPrice != float64(market.NoPrice) {
-		market.YesPrice = float32(yesPrice)
-		market.NoPrice = float32(noPrice)
-		kafka.ProduceEventToDBProcessor("process_db", "UPDATE_STOCK_PRICE", map[string]interface{}{
-			"marketId": req.MarketId, "yesPrice": yesPrice, "noPrice": noPrice,
-		})
-	}
+	e.Publish(ctx, types.ORDER_CANCELLED, orderCancelled(foundOrder, market.MarketId, refund, refundType, schema.CancelByUser))
+	e.notifyOrder(ctx, foundOrder, types.OrderCancelled, schema.CancelByUser)
+	e.notifyBalanceOf(ctx, foundOrder.UserId, BalanceOrderCancelled)
 
-	// Broadcast TICKER update (lightweight)
-	tickerPayload := map[string]interface{}{
-		"type":            "TICKER",
-		"symbol":          req.Symbol,
-		"yesPrice":        yesPrice,
-		"noPrice":         noPrice,
-		"volume":          market.Volume,
-		"numberOfTraders": market.NumberOfTraders,
-	}
-	if tickerData, err := json.Marshal(tickerPayload); err == nil {
-		e.BroadcastMessage("stream:data", string(tickerData))
-	}
-
-	// Broadcast ORDERBOOK update
-	orderbookPayload := map[string]interface{}{
-		"type":      "ORDERBOOK",
-		"symbol":    req.Symbol,
-		"orderbook": aggOrderBook,
-	}
-	if obData, err := json.Marshal(orderbookPayload); err == nil {
-		e.BroadcastMessage("stream:data", string(obData))
-	}
+	e.reprice(ctx, market, utils.AggregateOrderBook(market.OrderBook, payoutPerShare()))
 
 	log.Info().Str("orderId", req.OrderId).Msg("Order cancelled successfully")
 	msg.ReplyChan <- types.OrderResponse{Success: true, Message: "order cancelled"}
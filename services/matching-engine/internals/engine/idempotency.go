@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"matching-engine/internals/types"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// IdempotencyUserId pulls the user a command belongs to out of its payload, so keys
+// only have to be unique per user: userId, or id for CREATE_USER. It returns "" for
+// commands without a user, which cannot carry a key.
+func IdempotencyUserId(payload types.QueuePayload) string {
+	data, _ := payload.Data.(map[string]interface{})
+	if userId, _ := data["userId"].(string); userId != "" {
+		return userId
+	}
+	if userId, _ := data["id"].(string); payload.EventType == "CREATE_USER" {
+		return userId
+	}
+	return ""
+}
+
+// LookupIdempotent returns the stored response for a repeated idempotency key.
+// The stored response is re-addressed to the retry's ResponseId. A key with no stored
+// response is marked in flight until RememberIdempotent, and a retry arriving in the
+// meantime is told to try again rather than executing a second time. A key on a command
+// without a user is refused, since unrelated commands would share its namespace.
+func (e *Engine) LookupIdempotent(payload types.QueuePayload) (types.QueueResponse, bool) {
+	userId := IdempotencyUserId(payload)
+	if userId == "" {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    "Idempotency keys are only accepted on commands for a user",
+		}, true
+	}
+
+	e.IM.Lock()
+	defer e.IM.Unlock()
+
+	var record types.IdempotencyRecord
+	found := false
+	if bucket, ok := e.Idempotency[userId]; ok {
+		record, found = bucket.Keys[payload.IdempotencyKey]
+		found = found && time.Since(record.StoredAt) <= e.IdempotencyTTL
+	}
+
+	if !found {
+		flight := inFlightKey(payload)
+		if _, busy := e.idempotencyInFlight[flight]; busy {
+			return types.QueueResponse{
+				ResponseId: payload.ResponseId,
+				Status:     types.Error,
+				Retryable:  true,
+				Message:    "A request with this idempotency key is still in progress",
+			}, true
+		}
+		e.idempotencyInFlight[flight] = false
+		return types.QueueResponse{}, false
+	}
+
+	if record.EventType != payload.EventType {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    "Idempotency key already used for " + record.EventType,
+		}, true
+	}
+
+	response := record.Response
+	response.ResponseId = payload.ResponseId
+	return response, true
+}
+
+// RememberIdempotent stores the response for a keyed command. Retryable errors are not
+// stored so that the retry actually runs again. A key left to SettleLate stays in flight,
+// and one it has already settled keeps the market's answer.
+func (e *Engine) RememberIdempotent(payload types.QueuePayload, response types.QueueResponse) {
+	e.IM.Lock()
+	defer e.IM.Unlock()
+
+	flight := inFlightKey(payload)
+	late, inFlight := e.idempotencyInFlight[flight]
+	if !inFlight || late {
+		return
+	}
+	delete(e.idempotencyInFlight, flight)
+
+	if response.Status == types.Error && response.Retryable {
+		return
+	}
+	e.storeIdempotent(payload, response)
+}
+
+// SettleLate is for a keyed command that gave up waiting on its market. Whether the
+// market acted is unknown until it replies, so rather than storing the timeout the key
+// stays in flight and the late reply, converted by settle, becomes its response. A
+// market that never replies within IdempotencyTTL frees the key unanswered.
+func (e *Engine) SettleLate(payload types.QueuePayload, reply chan interface{}, settle func(interface{}) types.QueueResponse) {
+	if payload.IdempotencyKey == "" {
+		return
+	}
+	flight := inFlightKey(payload)
+
+	e.IM.Lock()
+	if _, inFlight := e.idempotencyInFlight[flight]; !inFlight {
+		e.IM.Unlock()
+		return
+	}
+	e.idempotencyInFlight[flight] = true
+	e.IM.Unlock()
+
+	go func() {
+		timer := time.NewTimer(e.IdempotencyTTL)
+		defer timer.Stop()
+
+		var response types.QueueResponse
+		settled := false
+		select {
+		case resp := <-reply:
+			response, settled = settle(resp), true
+		case <-timer.C:
+			log.Warn().
+				Str("eventType", payload.EventType).
+				Str("idempotencyKey", payload.IdempotencyKey).
+				Msg("Market never replied to a timed-out command, releasing its idempotency key")
+		}
+
+		e.IM.Lock()
+		defer e.IM.Unlock()
+		delete(e.idempotencyInFlight, flight)
+		if settled {
+			e.storeIdempotent(payload, response)
+		}
+	}()
+}
+
+// storeIdempotent records response under the payload's key. Caller must hold IM.
+func (e *Engine) storeIdempotent(payload types.QueuePayload, response types.QueueResponse) {
+	userId := IdempotencyUserId(payload)
+	bucket, ok := e.Idempotency[userId]
+	if !ok {
+		bucket = &types.IdempotencyBucket{Keys: make(map[string]types.IdempotencyRecord)}
+		e.Idempotency[userId] = bucket
+	}
+
+	if _, exists := bucket.Keys[payload.IdempotencyKey]; !exists {
+		bucket.Order = append(bucket.Order, payload.IdempotencyKey)
+	}
+	bucket.Keys[payload.IdempotencyKey] = types.IdempotencyRecord{
+		EventType: payload.EventType,
+		Response:  response,
+		StoredAt:  time.Now(),
+	}
+
+	// Bound each user's store by dropping the oldest keys first
+	for len(bucket.Order) > e.IdempotencyMaxKeys {
+		delete(bucket.Keys, bucket.Order[0])
+		bucket.Order = bucket.Order[1:]
+	}
+}
+
+func inFlightKey(payload types.QueuePayload) string {
+	return IdempotencyUserId(payload) + "\x00" + payload.IdempotencyKey
+}
+
+// pruneIdempotency drops expired keys and empty buckets. Caller must hold IM.
+func (e *Engine) pruneIdempotency() {
+	cutoff := time.Now().Add(-e.IdempotencyTTL)
+
+	for userId, bucket := range e.Idempotency {
+		kept := bucket.Order[:0]
+		for _, key := range bucket.Order {
+			if bucket.Keys[key].StoredAt.Before(cutoff) {
+				delete(bucket.Keys, key)
+				continue
+			}
+			kept = append(kept, key)
+		}
+		bucket.Order = kept
+
+		if len(bucket.Order) == 0 {
+			delete(e.Idempotency, userId)
+		}
+	}
+}
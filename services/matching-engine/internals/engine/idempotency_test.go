@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"fmt"
+	"matching-engine/internals/types"
+	"testing"
+	"time"
+)
+
+func keyed(userId, eventType, key, responseId string) types.QueuePayload {
+	return types.QueuePayload{
+		ResponseId:     responseId,
+		EventType:      eventType,
+		IdempotencyKey: key,
+		Data:           map[string]interface{}{"userId": userId},
+	}
+}
+
+// execute runs a keyed command the way the router does: look the key up, and store
+// the response of a first attempt.
+func execute(e *Engine, payload types.QueuePayload, response types.QueueResponse) {
+	if _, found := e.LookupIdempotent(payload); !found {
+		e.RememberIdempotent(payload, response)
+	}
+}
+
+func TestLookupIdempotent(t *testing.T) {
+	placed := types.QueueResponse{ResponseId: "r1", Status: types.Success, Message: "order processed"}
+
+	tests := []struct {
+		name     string
+		stored   types.QueueResponse
+		age      time.Duration
+		retry    types.QueuePayload
+		found    bool
+		response types.QueueResponse
+	}{
+		{
+			name:     "retry gets the first response",
+			stored:   placed,
+			retry:    keyed("alice", "CREATE_ORDER", "k1", "r2"),
+			found:    true,
+			response: types.QueueResponse{ResponseId: "r2", Status: types.Success, Message: "order processed"},
+		},
+		{
+			name:     "rejection is replayed too",
+			stored:   types.QueueResponse{ResponseId: "r1", Status: types.Error, Message: "insufficient balance"},
+			retry:    keyed("alice", "CREATE_ORDER", "k1", "r2"),
+			found:    true,
+			response: types.QueueResponse{ResponseId: "r2", Status: types.Error, Message: "insufficient balance"},
+		},
+		{
+			name:     "key reused for another command",
+			stored:   placed,
+			retry:    keyed("alice", "CANCEL_ORDER", "k1", "r2"),
+			found:    true,
+			response: types.QueueResponse{ResponseId: "r2", Status: types.Error, Message: "Idempotency key already used for CREATE_ORDER"},
+		},
+		{
+			name:   "keys are per user",
+			stored: placed,
+			retry:  keyed("bob", "CREATE_ORDER", "k1", "r2"),
+		},
+		{
+			name:     "command without a user is refused",
+			stored:   placed,
+			retry:    keyed("", "CREATE_MARKET", "k1", "r2"),
+			found:    true,
+			response: types.QueueResponse{ResponseId: "r2", Status: types.Error, Message: "Idempotency keys are only accepted on commands for a user"},
+		},
+		{
+			name:   "retryable errors are not stored",
+			stored: types.QueueResponse{ResponseId: "r1", Status: types.Error, Message: "market busy", Retryable: true},
+			retry:  keyed("alice", "CREATE_ORDER", "k1", "r2"),
+		},
+		{
+			name:   "expired key runs again",
+			stored: placed,
+			age:    2 * time.Hour,
+			retry:  keyed("alice", "CREATE_ORDER", "k1", "r2"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testEngine()
+			execute(e, keyed("alice", "CREATE_ORDER", "k1", "r1"), tt.stored)
+			if bucket, ok := e.Idempotency["alice"]; ok && tt.age != 0 {
+				record := bucket.Keys["k1"]
+				record.StoredAt = record.StoredAt.Add(-tt.age)
+				bucket.Keys["k1"] = record
+			}
+
+			response, found := e.LookupIdempotent(tt.retry)
+			if found != tt.found {
+				t.Fatalf("found %v, want %v", found, tt.found)
+			}
+			if found && (response.ResponseId != tt.response.ResponseId || response.Status != tt.response.Status || response.Message != tt.response.Message) {
+				t.Errorf("response %+v, want %+v", response, tt.response)
+			}
+		})
+	}
+}
+
+func TestIdempotencyUserId(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		data      map[string]interface{}
+		want      string
+	}{
+		{name: "user command", eventType: "PLACE_ORDER", data: map[string]interface{}{"userId": "alice"}, want: "alice"},
+		{name: "created user", eventType: "CREATE_USER", data: map[string]interface{}{"id": "alice"}, want: "alice"},
+		{name: "id of something else", eventType: "CREATE_MARKET", data: map[string]interface{}{"id": "RAIN"}, want: ""},
+		{name: "no user", eventType: "RESOLVE_MARKET", data: map[string]interface{}{"symbol": "RAIN"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := IdempotencyUserId(types.QueuePayload{EventType: tt.eventType, Data: tt.data})
+			if got != tt.want {
+				t.Errorf("user %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRememberIdempotentEvictsOldest(t *testing.T) {
+	e := testEngine()
+	e.IdempotencyMaxKeys = 3
+	ok := types.QueueResponse{Status: types.Success}
+
+	for i := 1; i <= 5; i++ {
+		execute(e, keyed("alice", "CREATE_ORDER", fmt.Sprintf("k%d", i), "r"), ok)
+	}
+	execute(e, keyed("bob", "CREATE_ORDER", "k1", "r"), ok)
+
+	for _, tt := range []struct {
+		userId, key string
+		found       bool
+	}{
+		{"alice", "k1", false},
+		{"alice", "k2", false},
+		{"alice", "k3", true},
+		{"alice", "k5", true},
+		{"bob", "k1", true},
+	} {
+		if _, found := e.LookupIdempotent(keyed(tt.userId, "CREATE_ORDER", tt.key, "r")); found != tt.found {
+			t.Errorf("%s %s found %v, want %v", tt.userId, tt.key, found, tt.found)
+		}
+	}
+	if n := len(e.Idempotency["alice"].Order); n != 3 {
+		t.Errorf("alice holds %d keys, want 3", n)
+	}
+}
+
+func TestPruneIdempotency(t *testing.T) {
+	e := testEngine()
+	ok := types.QueueResponse{Status: types.Success}
+	execute(e, keyed("alice", "CREATE_ORDER", "old", "r"), ok)
+	execute(e, keyed("alice", "CREATE_ORDER", "new", "r"), ok)
+	execute(e, keyed("bob", "CREATE_ORDER", "old", "r"), ok)
+	for _, userId := range []string{"alice", "bob"} {
+		bucket := e.Idempotency[userId]
+		record := bucket.Keys["old"]
+		record.StoredAt = record.StoredAt.Add(-2 * time.Hour)
+		bucket.Keys["old"] = record
+	}
+
+	e.pruneIdempotency()
+
+	if _, ok := e.Idempotency["bob"]; ok {
+		t.Error("bob's empty bucket was kept")
+	}
+	if order := e.Idempotency["alice"].Order; len(order) != 1 || order[0] != "new" {
+		t.Errorf("alice keeps %v, want [new]", order)
+	}
+}
+
+func TestLookupIdempotentInFlight(t *testing.T) {
+	e := testEngine()
+	first := keyed("alice", "CREATE_ORDER", "k1", "r1")
+
+	if _, found := e.LookupIdempotent(first); found {
+		t.Fatal("first attempt found a stored response")
+	}
+	response, found := e.LookupIdempotent(keyed("alice", "CREATE_ORDER", "k1", "r2"))
+	if !found || response.Status != types.Error || !response.Retryable || response.ResponseId != "r2" {
+		t.Fatalf("retry while in flight got %+v, %v; want a retryable error for r2", response, found)
+	}
+
+	e.RememberIdempotent(first, types.QueueResponse{ResponseId: "r1", Status: types.Success, Message: "order processed"})
+	response, found = e.LookupIdempotent(keyed("alice", "CREATE_ORDER", "k1", "r3"))
+	if !found || response.Status != types.Success || response.ResponseId != "r3" {
+		t.Errorf("retry after the answer got %+v, %v; want the stored success for r3", response, found)
+	}
+}
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/tracing"
+	"matching-engine/internals/types"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrMarketBusy means the market inbox was full and the message was not queued, so
+	// the caller can safely retry.
+	ErrMarketBusy = errors.New("MARKET_BUSY")
+	// ErrReplyTimeout means the market accepted the message but did not answer in time;
+	// the command may still complete.
+	ErrReplyTimeout = errors.New("market did not reply before the deadline")
+	// ErrMarketNotFound is returned for commands naming an unknown symbol.
+	ErrMarketNotFound = errors.New("market not found")
+)
+
+// priorityCapacity bounds the priority lane; cancels and halts are small and rare
+// compared with orders, so a short lane is enough to let them skip the queue.
+const priorityCapacity = 32
+
+// openInbox gives a market fresh queues sized from InboxCapacity.
+func (e *Engine) openInbox(market *types.Market) {
+	market.Inbox = make(chan types.MarketMessage, e.InboxCapacity)
+	market.Priority = make(chan types.MarketMessage, priorityCapacity)
+	market.Stats = &types.InboxStats{}
+}
+
+// Send queues a message for the market without blocking. Cancels, resolves and halts
+// go on the priority lane; everything else waits behind earlier orders. A full queue
+// returns ErrMarketBusy. The returned channel is buffered so the market goroutine
+// never blocks on a caller that has stopped waiting. The time spent queued is traced
+// as a span under ctx.
+func (e *Engine) Send(ctx context.Context, market *types.Market, msgType types.MarketMessageType, payload interface{}) (chan interface{}, error) {
+	_, wait := tracing.Start(ctx, "market.inbox_wait", trace.WithAttributes(
+		attribute.String("market.symbol", market.Symbol),
+		attribute.String("market.message", string(msgType)),
+	))
+
+	reply := make(chan interface{}, 1)
+	msg := types.MarketMessage{Type: msgType, Payload: payload, ReplyChan: reply, Ctx: ctx, Dequeued: func() { wait.End() }}
+
+	queue := market.Inbox
+	if msgType.IsPriority() {
+		queue = market.Priority
+	}
+
+	select {
+	case queue <- msg:
+	default:
+		atomic.AddUint64(&market.Stats.Rejected, 1)
+		log.Warn().
+			Str("symbol", market.Symbol).
+			Str("type", string(msgType)).
+			Int("depth", len(queue)).
+			Msg("Market inbox full, rejecting message")
+		wait.SetStatus(codes.Error, ErrMarketBusy.Error())
+		wait.End()
+		return nil, ErrMarketBusy
+	}
+
+	depth := int64(len(market.Inbox) + len(market.Priority))
+	for {
+		high := atomic.LoadInt64(&market.Stats.HighWater)
+		if depth <= high || atomic.CompareAndSwapInt64(&market.Stats.HighWater, high, depth) {
+			break
+		}
+	}
+
+	return reply, nil
+}
+
+// Await waits up to RequestTimeout for the reply to a message queued with Send.
+func (e *Engine) Await(reply chan interface{}) (interface{}, error) {
+	timer := time.NewTimer(e.RequestTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-reply:
+		return resp, nil
+	case <-timer.C:
+		return nil, ErrReplyTimeout
+	}
+}
+
+// Ask sends a message to the market goroutine and waits for its reply.
+func (e *Engine) Ask(ctx context.Context, market *types.Market, msgType types.MarketMessageType, payload interface{}) (interface{}, error) {
+	reply, err := e.Send(ctx, market, msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+	return e.Await(reply)
+}
+
+// nextMessage returns the next message for the market, always preferring the
+// priority lane. ok is false once the inbox has been closed.
+func nextMessage(market *types.Market) (msg types.MarketMessage, ok bool) {
+	select {
+	case msg = <-market.Priority:
+		return msg, true
+	default:
+	}
+
+	select {
+	case msg = <-market.Priority:
+		return msg, true
+	case msg, ok = <-market.Inbox:
+		return msg, ok
+	}
+}
+
+// InboxDepths reports queue depth and counters for every market, sorted by symbol.
+func (e *Engine) InboxDepths() []types.InboxDepth {
+	e.MM.RLock()
+	defer e.MM.RUnlock()
+
+	depths := make([]types.InboxDepth, 0, len(e.Market))
+	for symbol, market := range e.Market {
+		if market.Stats == nil {
+			continue
+		}
+		market.Mu.RLock()
+		status := market.Status
+		market.Mu.RUnlock()
+
+		depths = append(depths, types.InboxDepth{
+			Symbol:        symbol,
+			Status:        status,
+			Depth:         len(market.Inbox),
+			Capacity:      cap(market.Inbox),
+			PriorityDepth: len(market.Priority),
+			HighWater:     atomic.LoadInt64(&market.Stats.HighWater),
+			Processed:     atomic.LoadUint64(&market.Stats.Processed),
+			Rejected:      atomic.LoadUint64(&market.Stats.Rejected),
+		})
+	}
+
+	sort.Slice(depths, func(i, j int) bool { return depths[i].Symbol < depths[j].Symbol })
+	return depths
+}
+
+// HaltMarket freezes a market ahead of any queued orders.
+func (e *Engine) HaltMarket(symbol string) error {
+	market, ok := e.GetMarket(symbol)
+	if !ok {
+		return ErrMarketNotFound
+	}
+
+	_, err := e.Ask(context.Background(), market, types.MarketHalt, nil)
+	return err
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/types"
+	"testing"
+	"time"
+)
+
+func TestSendBackPressure(t *testing.T) {
+	place, cancel := types.MarketPlaceOrder, types.MarketCancelOrder
+
+	tests := []struct {
+		name      string
+		capacity  int
+		sends     []types.MarketMessageType
+		busy      []bool
+		rejected  uint64
+		highWater int64
+	}{
+		{
+			name:      "orders fit the inbox",
+			capacity:  3,
+			sends:     []types.MarketMessageType{place, place, place},
+			busy:      []bool{false, false, false},
+			highWater: 3,
+		},
+		{
+			name:      "full inbox answers busy",
+			capacity:  2,
+			sends:     []types.MarketMessageType{place, place, place, place},
+			busy:      []bool{false, false, true, true},
+			rejected:  2,
+			highWater: 2,
+		},
+		{
+			name:      "cancels skip a full inbox",
+			capacity:  1,
+			sends:     []types.MarketMessageType{place, place, cancel, types.MarketHalt},
+			busy:      []bool{false, true, false, false},
+			rejected:  1,
+			highWater: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testEngine()
+			e.InboxCapacity = tt.capacity
+			market := testMarket("RAIN")
+			e.openInbox(market)
+
+			for i, msgType := range tt.sends {
+				_, err := e.Send(context.Background(), market, msgType, nil)
+				if busy := errors.Is(err, ErrMarketBusy); busy != tt.busy[i] {
+					t.Errorf("send %d (%s): error %v, want busy %v", i, msgType, err, tt.busy[i])
+				}
+			}
+			if market.Stats.Rejected != tt.rejected || market.Stats.HighWater != tt.highWater {
+				t.Errorf("stats %+v, want %d rejected and high water %d", *market.Stats, tt.rejected, tt.highWater)
+			}
+		})
+	}
+}
+
+func TestNextMessagePrefersPriority(t *testing.T) {
+	e := testEngine()
+	e.InboxCapacity = 4
+	market := testMarket("RAIN")
+	e.openInbox(market)
+
+	for _, msgType := range []types.MarketMessageType{types.MarketPlaceOrder, types.MarketSellOrder, types.MarketCancelOrder, types.MarketHalt} {
+		if _, err := e.Send(context.Background(), market, msgType, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(market.Inbox)
+
+	want := []types.MarketMessageType{types.MarketCancelOrder, types.MarketHalt, types.MarketPlaceOrder, types.MarketSellOrder}
+	for i, msgType := range want {
+		msg, ok := nextMessage(market)
+		if !ok || msg.Type != msgType {
+			t.Fatalf("message %d is %s (ok %v), want %s", i, msg.Type, ok, msgType)
+		}
+	}
+	if _, ok := nextMessage(market); ok {
+		t.Error("closed inbox still delivered a message")
+	}
+}
+
+func TestAwait(t *testing.T) {
+	e := testEngine()
+	e.RequestTimeout = 10 * time.Millisecond
+
+	answered := make(chan interface{}, 1)
+	answered <- "done"
+	if resp, err := e.Await(answered); err != nil || resp != "done" {
+		t.Errorf("answered reply gave %v, %v", resp, err)
+	}
+	if _, err := e.Await(make(chan interface{}, 1)); !errors.Is(err, ErrReplyTimeout) {
+		t.Errorf("silent market: error %v, want %v", err, ErrReplyTimeout)
+	}
+}
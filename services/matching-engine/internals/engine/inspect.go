@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/types"
+	"sort"
+)
+
+// ErrUserNotFound is returned when inspecting a user who is not in memory.
+var ErrUserNotFound = errors.New("user not found")
+
+// InspectMarket asks the market goroutine for its state and full book, so the view is
+// never taken halfway through an order.
+func (e *Engine) InspectMarket(symbol string) (types.MarketView, error) {
+	market, ok := e.GetMarket(symbol)
+	if !ok {
+		return types.MarketView{}, ErrMarketNotFound
+	}
+
+	resp, err := e.Ask(context.Background(), market, types.MarketInspect, nil)
+	if err != nil {
+		return types.MarketView{}, err
+	}
+	return resp.(types.MarketView), nil
+}
+
+// InspectMarkets lists every market without its book, sorted by symbol. A market that
+// cannot answer is listed with the error instead of failing the whole listing.
+func (e *Engine) InspectMarkets() []types.MarketView {
+	e.MM.RLock()
+	symbols := make([]string, 0, len(e.Market))
+	for symbol := range e.Market {
+		symbols = append(symbols, symbol)
+	}
+	e.MM.RUnlock()
+	sort.Strings(symbols)
+
+	views := make([]types.MarketView, 0, len(symbols))
+	for _, symbol := range symbols {
+		view, err := e.InspectMarket(symbol)
+		if err != nil {
+			view = types.MarketView{Symbol: symbol, Error: err.Error()}
+		}
+		view.Book = nil
+		views = append(views, view)
+	}
+	return views
+}
+
+// InspectUser copies a user's account and collects their resting orders from every
+// market's goroutine.
+func (e *Engine) InspectUser(userId string) (types.UserView, error) {
+	view, ok := e.copyUser(userId)
+	if !ok {
+		return types.UserView{}, ErrUserNotFound
+	}
+
+	e.MM.RLock()
+	markets := make([]string, 0, len(e.Market))
+	for symbol := range e.Market {
+		markets = append(markets, symbol)
+	}
+	e.MM.RUnlock()
+	sort.Strings(markets)
+
+	view.OpenOrders = []types.Order{}
+	for _, symbol := range markets {
+		market, err := e.InspectMarket(symbol)
+		if err != nil {
+			return types.UserView{}, err
+		}
+		for _, side := range [][]types.Order{market.Book.YesBids, market.Book.YesAsks, market.Book.NoBids, market.Book.NoAsks} {
+			for _, order := range side {
+				if order.UserId == userId {
+					view.OpenOrders = append(view.OpenOrders, order)
+				}
+			}
+		}
+	}
+	return view, nil
+}
+
+func (e *Engine) copyUser(userId string) (types.UserView, bool) {
+	e.UM.RLock()
+	defer e.UM.RUnlock()
+
+	user, ok := e.User[userId]
+	if !ok {
+		return types.UserView{}, false
+	}
+	user.Mutex.Lock()
+	defer user.Mutex.Unlock()
+
+	view := types.UserView{
+		ID:                        user.ID,
+		Name:                      user.Name,
+		KycVerificationStatus:     user.KycVerificationStatus,
+		PaymentVerificationStatus: user.PaymentVerificationStatus,
+		Funded:                    user.Funded,
+		LastActive:                user.LastActive,
+	}
+	if user.Balance != nil {
+		view.Balance.WalletBalance = user.Balance.WalletBalance
+		view.Balance.StockBalance = make(map[string]types.StockBalance, len(user.Balance.StockBalance))
+		for symbol, stock := range user.Balance.StockBalance {
+			view.Balance.StockBalance[symbol] = stock
+		}
+	}
+	return view, true
+}
+
+// viewMarket runs on the market goroutine.
+func viewMarket(market *types.Market) types.MarketView {
+	market.Mu.RLock()
+	defer market.Mu.RUnlock()
+
+	book := snapshotBook(market.OrderBook)
+	return types.MarketView{
+		MarketId:        market.MarketId,
+		Symbol:          market.Symbol,
+		Title:           market.Title,
+		Status:          market.Status,
+		YesPrice:        market.YesPrice,
+		NoPrice:         market.NoPrice,
+		Volume:          market.Volume,
+		Collateral:      market.Collateral,
+		NumberOfTraders: market.NumberOfTraders,
+		RestingOrders:   len(book.YesBids) + len(book.YesAsks) + len(book.NoBids) + len(book.NoAsks),
+		Book:            &book,
+	}
+}
@@ -0,0 +1,296 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/types"
+	"matching-engine/internals/utils"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// invariantTolerance absorbs float drift from fee arithmetic.
+const invariantTolerance = 1e-6
+
+type supply struct {
+	yes int
+	no  int
+}
+
+// AfterCommand counts a processed command and runs the invariant checker every InvariantInterval commands.
+func (e *Engine) AfterCommand() {
+	n := atomic.AddUint64(&e.commandCount, 1)
+	if e.InvariantInterval > 0 && n%e.InvariantInterval == 0 {
+		e.RunInvariantCheck()
+	}
+}
+
+// RunInvariantCheck verifies the books and halts every market implicated in a violation.
+func (e *Engine) RunInvariantCheck() types.InvariantReport {
+	touched := e.takeTouched()
+	report := e.CheckInvariants(touched)
+
+	if len(report.Violations) == 0 {
+		log.Debug().Uint64("commands", report.Commands).Msg("Invariant check passed")
+		return report
+	}
+
+	for _, symbol := range report.HaltedMarkets {
+		if market, ok := e.GetMarket(symbol); ok {
+			market.Mu.Lock()
+			if market.Status == types.Open {
+				haltMarket(market)
+			}
+			market.Mu.Unlock()
+		}
+	}
+
+	log.Error().
+		Int("violations", len(report.Violations)).
+		Strs("haltedMarkets", report.HaltedMarkets).
+		Interface("totals", report.Totals).
+		Msg("Invariant violation detected, affected markets halted")
+
+	e.Publish(context.Background(), types.INVARIANT_VIOLATION, invariantViolation(report))
+
+	return report
+}
+
+func invariantViolation(report types.InvariantReport) schema.InvariantViolation {
+	event := schema.InvariantViolation{
+		CheckedAt:     report.CheckedAt,
+		Commands:      report.Commands,
+		Totals:        schema.InvariantTotals(report.Totals),
+		HaltedMarkets: report.HaltedMarkets,
+	}
+	for _, v := range report.Violations {
+		event.Violations = append(event.Violations, schema.Violation{
+			Check: string(v.Check), Symbol: v.Symbol, UserId: v.UserId, OrderId: v.OrderId,
+			Expected: v.Expected, Actual: v.Actual, Detail: v.Detail,
+		})
+	}
+	for _, m := range report.Markets {
+		event.Markets = append(event.Markets, schema.MarketPositions{
+			Symbol: m.Symbol, MarketId: m.MarketId, Status: string(m.Status),
+			Collateral: m.Collateral, YesSupply: m.YesSupply, NoSupply: m.NoSupply,
+		})
+	}
+	return event
+}
+
+// CheckInvariants takes a consistent view of every market and user and verifies that
+// cash is conserved, locks match resting orders, YES supply equals NO supply and
+// nothing is negative. touched lists the markets mutated since the previous check;
+// they are blamed when the global cash total does not add up.
+func (e *Engine) CheckInvariants(touched map[string]struct{}) types.InvariantReport {
+	// Lock order matches the matching path: markets before users.
+	e.MM.RLock()
+	defer e.MM.RUnlock()
+	for _, market := range e.Market {
+		market.Mu.RLock()
+		defer market.Mu.RUnlock()
+	}
+
+	e.UM.RLock()
+	defer e.UM.RUnlock()
+	for _, user := range e.User {
+		user.Mutex.Lock()
+		defer user.Mutex.Unlock()
+	}
+
+	e.Ledger.Mu.Lock()
+	defer e.Ledger.Mu.Unlock()
+
+	report := types.InvariantReport{
+		CheckedAt: time.Now(),
+		Commands:  atomic.LoadUint64(&e.commandCount),
+	}
+	affected := make(map[string]struct{})
+	flag := func(v types.InvariantViolation, symbols ...string) {
+		report.Violations = append(report.Violations, v)
+		for _, s := range symbols {
+			affected[s] = struct{}{}
+		}
+	}
+
+	// What resting orders should be holding, per user
+	lockedByUser := make(map[string]float64)
+	escrowByUser := make(map[string]map[string]supply)
+	marketsByUser := make(map[string][]string)
+	for symbol, market := range e.Market {
+		for _, h := range []types.OrderHeap{market.OrderBook.YesBids.OrderHeap, market.OrderBook.NoBids.OrderHeap} {
+			for _, order := range h {
+				lockedByUser[order.UserId] += reservedFor(order, order.Quantity-order.Filled)
+				marketsByUser[order.UserId] = append(marketsByUser[order.UserId], symbol)
+			}
+		}
+		for _, h := range []types.OrderHeap{market.OrderBook.YesAsks.OrderHeap, market.OrderBook.NoAsks.OrderHeap} {
+			for _, order := range h {
+				marketsByUser[order.UserId] = append(marketsByUser[order.UserId], symbol)
+				if order.Role == types.ADMIN {
+					continue
+				}
+				if escrowByUser[order.UserId] == nil {
+					escrowByUser[order.UserId] = make(map[string]supply)
+				}
+				escrow := escrowByUser[order.UserId][order.Symbol]
+				if order.Side == types.Yes {
+					escrow.yes += order.Quantity - order.Filled
+				} else {
+					escrow.no += order.Quantity - order.Filled
+				}
+				escrowByUser[order.UserId][order.Symbol] = escrow
+			}
+		}
+	}
+
+	supplies := make(map[string]supply)
+	for symbol, stock := range e.Ledger.EvictedShares {
+		supplies[symbol] = supply{yes: stock.Yes, no: stock.No}
+	}
+	// Admin position adjustments are not backed by matching pairs
+	for symbol, stock := range e.Ledger.AdjustedShares {
+		s := supplies[symbol]
+		s.yes -= stock.Yes
+		s.no -= stock.No
+		supplies[symbol] = s
+	}
+
+	for id, user := range e.User {
+		wallet := user.Balance.WalletBalance
+		report.Totals.Cash += wallet.Amount
+		report.Totals.Locked += wallet.Locked
+		report.Totals.Held += wallet.Held
+
+		symbols := marketsByUser[id]
+		for symbol := range user.Balance.StockBalance {
+			symbols = append(symbols, symbol)
+		}
+
+		if wallet.Amount < -invariantTolerance || wallet.Locked < -invariantTolerance || wallet.Held < -invariantTolerance {
+			flag(types.InvariantViolation{
+				Check: types.NegativeBalance, UserId: id, Actual: math.Min(wallet.Amount, math.Min(wallet.Locked, wallet.Held)),
+				Detail: fmt.Sprintf("wallet amount %.6f locked %.6f held %.6f", wallet.Amount, wallet.Locked, wallet.Held),
+			}, symbols...)
+		}
+
+		if expected := lockedByUser[id]; math.Abs(wallet.Locked-expected) > invariantTolerance {
+			flag(types.InvariantViolation{
+				Check: types.LockedMismatch, UserId: id, Expected: expected, Actual: wallet.Locked,
+				Detail: "locked cash does not match resting buy orders",
+			}, symbols...)
+		}
+
+		for symbol, stock := range user.Balance.StockBalance {
+			s := supplies[symbol]
+			s.yes += stock.Yes + stock.LockedYes
+			s.no += stock.No + stock.LockedNo
+			supplies[symbol] = s
+
+			if stock.Yes < 0 || stock.No < 0 || stock.LockedYes < 0 || stock.LockedNo < 0 {
+				flag(types.InvariantViolation{
+					Check: types.NegativeBalance, Symbol: symbol, UserId: id,
+					Detail: fmt.Sprintf("shares yes %d no %d lockedYes %d lockedNo %d", stock.Yes, stock.No, stock.LockedYes, stock.LockedNo),
+				}, symbol)
+			}
+
+			escrow := escrowByUser[id][symbol]
+			if stock.LockedYes != escrow.yes || stock.LockedNo != escrow.no {
+				flag(types.InvariantViolation{
+					Check: types.EscrowMismatch, Symbol: symbol, UserId: id,
+					Expected: float64(escrow.yes + escrow.no), Actual: float64(stock.LockedYes + stock.LockedNo),
+					Detail: "escrowed shares do not match resting sell orders",
+				}, symbol)
+			}
+		}
+	}
+
+	for symbol, market := range e.Market {
+		report.Totals.Collateral += market.Collateral
+		s := supplies[symbol]
+
+		if s.yes != s.no {
+			flag(types.InvariantViolation{
+				Check: types.SupplyMismatch, Symbol: symbol, Expected: float64(s.yes), Actual: float64(s.no),
+				Detail: "YES supply differs from NO supply",
+			}, symbol)
+		}
+
+		if backing := payoutPerShare() * float64(s.yes); math.Abs(market.Collateral-backing) > invariantTolerance {
+			flag(types.InvariantViolation{
+				Check: types.CollateralMismatch, Symbol: symbol, Expected: backing, Actual: market.Collateral,
+				Detail: "collateral does not cover outstanding YES/NO pairs",
+			}, symbol)
+		}
+	}
+
+	report.Totals.Fees = e.Ledger.Fees
+	report.Totals.Evicted = e.Ledger.EvictedCash
+	report.Totals.NetFunding = e.Ledger.NetFunding
+
+	held := report.Totals.Cash + report.Totals.Locked + report.Totals.Held + report.Totals.Collateral + report.Totals.Fees + report.Totals.Evicted
+	if math.Abs(held-report.Totals.NetFunding) > invariantTolerance*math.Max(1, math.Abs(report.Totals.NetFunding)) {
+		v := types.InvariantViolation{
+			Check: types.CashNotConserved, Expected: report.Totals.NetFunding, Actual: held,
+			Detail: "cash + locked + held + collateral + fees does not match net funding",
+		}
+		symbols := make([]string, 0, len(touched))
+		for symbol := range touched {
+			symbols = append(symbols, symbol)
+		}
+		flag(v, symbols...)
+	}
+
+	for symbol := range affected {
+		market, ok := e.Market[symbol]
+		if !ok {
+			continue
+		}
+		if market.Status == types.Open {
+			report.HaltedMarkets = append(report.HaltedMarkets, symbol)
+		}
+		report.Markets = append(report.Markets, types.MarketDiagnostic{
+			Symbol:     symbol,
+			MarketId:   market.MarketId,
+			Status:     market.Status,
+			Collateral: market.Collateral,
+			YesSupply:  supplies[symbol].yes,
+			NoSupply:   supplies[symbol].no,
+			OrderBook:  utils.AggregateOrderBook(market.OrderBook, payoutPerShare()),
+		})
+	}
+
+	return report
+}
+
+// RebaseLedger derives the ledger and market collateral from current balances.
+// It is used when restoring a snapshot that predates invariant tracking.
+func (e *Engine) RebaseLedger() {
+	e.MM.RLock()
+	defer e.MM.RUnlock()
+	e.UM.RLock()
+	defer e.UM.RUnlock()
+
+	supplies := make(map[string]int)
+	ledger := &types.Ledger{EvictedShares: make(map[string]types.StockBalance)}
+	for _, user := range e.User {
+		ledger.NetFunding += user.Balance.WalletBalance.Amount + user.Balance.WalletBalance.Locked + user.Balance.WalletBalance.Held
+		for symbol, stock := range user.Balance.StockBalance {
+			supplies[symbol] += stock.Yes + stock.LockedYes
+		}
+	}
+
+	for symbol, market := range e.Market {
+		market.Mu.Lock()
+		market.Collateral = payoutPerShare() * float64(supplies[symbol])
+		market.Mu.Unlock()
+		ledger.NetFunding += market.Collateral
+	}
+
+	e.Ledger = ledger
+	log.Warn().Float64("netFunding", ledger.NetFunding).Msg("Invariant ledger rebased from current state")
+}
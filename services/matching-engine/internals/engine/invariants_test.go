@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"matching-engine/internals/events"
+	"matching-engine/internals/types"
+	"sort"
+	"testing"
+	"time"
+)
+
+func testEngine() *Engine {
+	return &Engine{
+		User:                make(map[string]*types.User),
+		Market:              make(map[string]*types.Market),
+		Ledger:              &types.Ledger{EvictedShares: make(map[string]types.StockBalance)},
+		Adjustments:         make(map[string]*types.Adjustment),
+		AdjustmentThreshold: 1000,
+		Withdrawals:         make(map[string]*types.Withdrawal),
+		Idempotency:         make(map[string]*types.IdempotencyBucket),
+		IdempotencyTTL:      time.Hour,
+		IdempotencyMaxKeys:  1000,
+		idempotencyInFlight: make(map[string]bool),
+		streams:             make(map[string]*channelStream),
+		touched:             make(map[string]struct{}),
+		Panics:              make(map[string]types.MarketPanic),
+		Events:              events.NewRecorder(),
+		Redis:               offlineRedis(),
+	}
+}
+
+func testMarket(symbol string) *types.Market {
+	return &types.Market{
+		MarketId: symbol,
+		Symbol:   symbol,
+		Status:   types.Open,
+		Traders:  make(map[string]struct{}),
+		OrderBook: &types.OrderBook{
+			YesBids: &types.BidHeap{},
+			YesAsks: &types.AskHeap{},
+			NoBids:  &types.BidHeap{},
+			NoAsks:  &types.AskHeap{},
+		},
+	}
+}
+
+func testUser(id string, amount float64) *types.User {
+	return &types.User{
+		ID:      id,
+		Balance: &types.Balance{WalletBalance: types.WalletBalance{Amount: amount}, StockBalance: make(map[string]types.StockBalance)},
+	}
+}
+
+// balancedEngine holds two users funded with 100 each who minted 3 RAIN pairs between
+// them at 6/4, and a resting bid from alice for 2 more YES at 6.
+func balancedEngine() *Engine {
+	e := testEngine()
+	market := testMarket("RAIN")
+	market.Collateral = 30
+	e.Market["RAIN"] = market
+
+	alice, bob := testUser("alice", 100-18*(1+legacyTradingFee)), testUser("bob", 100-12*(1+legacyTradingFee))
+	alice.Balance.StockBalance["RAIN"] = types.StockBalance{Yes: 3}
+	bob.Balance.StockBalance["RAIN"] = types.StockBalance{No: 3}
+	e.User["alice"], e.User["bob"] = alice, bob
+	e.Ledger.NetFunding = 200
+	e.Ledger.Fees = 30 * legacyTradingFee
+
+	bid := &types.Order{OrderId: "o1", UserId: "alice", Symbol: "RAIN", Price: 6, Quantity: 2, Side: types.Yes, Action: types.BUY, Timestamp: time.Now()}
+	market.OrderBook.YesBids.Push(bid)
+	reserved := reservedFor(bid, 2)
+	alice.Balance.WalletBalance.Amount -= reserved
+	alice.Balance.WalletBalance.Locked += reserved
+	return e
+}
+
+func TestCheckInvariants(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(e *Engine)
+		touched []string
+		checks  []types.InvariantCheck
+		halted  []string
+	}{
+		{
+			name:   "balanced",
+			mutate: func(e *Engine) {},
+		},
+		{
+			name: "drift within tolerance",
+			mutate: func(e *Engine) {
+				e.User["alice"].Balance.WalletBalance.Locked += invariantTolerance / 2
+				e.User["alice"].Balance.WalletBalance.Amount -= invariantTolerance / 2
+			},
+		},
+		{
+			name: "locked beyond tolerance",
+			mutate: func(e *Engine) {
+				e.User["alice"].Balance.WalletBalance.Locked += 0.01
+				e.User["alice"].Balance.WalletBalance.Amount -= 0.01
+			},
+			checks: []types.InvariantCheck{types.LockedMismatch},
+			halted: []string{"RAIN"},
+		},
+		{
+			name: "escrow without a resting ask",
+			mutate: func(e *Engine) {
+				e.User["bob"].Balance.StockBalance["RAIN"] = types.StockBalance{No: 2, LockedNo: 1}
+			},
+			checks: []types.InvariantCheck{types.EscrowMismatch},
+			halted: []string{"RAIN"},
+		},
+		{
+			name: "cash created blames touched markets",
+			mutate: func(e *Engine) {
+				e.User["bob"].Balance.WalletBalance.Amount += 5
+				e.Market["SNOW"] = testMarket("SNOW")
+			},
+			touched: []string{"SNOW"},
+			checks:  []types.InvariantCheck{types.CashNotConserved},
+			halted:  []string{"SNOW"},
+		},
+		{
+			name: "unbacked share",
+			mutate: func(e *Engine) {
+				e.User["alice"].Balance.StockBalance["RAIN"] = types.StockBalance{Yes: 4}
+			},
+			checks: []types.InvariantCheck{types.SupplyMismatch, types.CollateralMismatch},
+			halted: []string{"RAIN"},
+		},
+		{
+			name: "negative shares",
+			mutate: func(e *Engine) {
+				e.User["alice"].Balance.StockBalance["RAIN"] = types.StockBalance{Yes: 4}
+				e.User["bob"].Balance.StockBalance["RAIN"] = types.StockBalance{Yes: -1, No: 3}
+			},
+			checks: []types.InvariantCheck{types.NegativeBalance},
+			halted: []string{"RAIN"},
+		},
+		{
+			name: "closed market is not halted",
+			mutate: func(e *Engine) {
+				e.Market["RAIN"].Status = types.Close
+				e.User["alice"].Balance.StockBalance["RAIN"] = types.StockBalance{Yes: 4}
+			},
+			checks: []types.InvariantCheck{types.SupplyMismatch, types.CollateralMismatch},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := balancedEngine()
+			tt.mutate(e)
+			touched := make(map[string]struct{})
+			for _, symbol := range tt.touched {
+				touched[symbol] = struct{}{}
+			}
+
+			report := e.CheckInvariants(touched)
+
+			var checks []types.InvariantCheck
+			for _, v := range report.Violations {
+				checks = append(checks, v.Check)
+			}
+			if !sameChecks(checks, tt.checks) {
+				t.Errorf("violations %+v, want checks %v", report.Violations, tt.checks)
+			}
+			if !sameSymbols(report.HaltedMarkets, tt.halted) {
+				t.Errorf("halted %v, want %v", report.HaltedMarkets, tt.halted)
+			}
+		})
+	}
+}
+
+func TestRunInvariantCheckHaltsMarkets(t *testing.T) {
+	e := balancedEngine()
+	e.Market["SNOW"] = testMarket("SNOW")
+	e.User["bob"].Balance.StockBalance["RAIN"] = types.StockBalance{No: 2, LockedNo: 1}
+
+	e.RunInvariantCheck()
+
+	if status := e.Market["RAIN"].Status; status != types.Halted {
+		t.Errorf("RAIN is %s, want %s", status, types.Halted)
+	}
+	if status := e.Market["SNOW"].Status; status != types.Open {
+		t.Errorf("SNOW is %s, want %s", status, types.Open)
+	}
+}
+
+func sameChecks(got, want []types.InvariantCheck) bool {
+	g, w := make([]string, len(got)), make([]string, len(want))
+	for i := range got {
+		g[i] = string(got[i])
+	}
+	for i := range want {
+		w[i] = string(want[i])
+	}
+	return sameSymbols(g, w)
+}
+
+func sameSymbols(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	got, want = append([]string(nil), got...), append([]string(nil), want...)
+	sort.Strings(got)
+	sort.Strings(want)
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
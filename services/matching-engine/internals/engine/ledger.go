@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"matching-engine/internals/config"
+	"matching-engine/internals/types"
+
+	"github.com/rs/zerolog/log"
+)
+
+// legacyTradingFee is what orders resting in snapshots taken before fees were
+// configurable were charged.
+const legacyTradingFee = 0.0025
+
+// payoutPerShare is the collateral backing one YES/NO pair. It is fixed at startup.
+func payoutPerShare() float64 {
+	return config.Current().Trading.PayoutPerShare
+}
+
+// feeRate is the fee charged on an order's fills, fixed when the order was accepted so
+// the cash it locked always covers them.
+func feeRate(order *types.Order) float64 {
+	if order.FeeRate == nil {
+		return legacyTradingFee
+	}
+	return *order.FeeRate
+}
+
+// RecordFunding books cash that entered (positive) or left (negative) the engine.
+func (e *Engine) RecordFunding(delta float64) {
+	e.Ledger.Mu.Lock()
+	defer e.Ledger.Mu.Unlock()
+
+	e.Ledger.NetFunding += delta
+}
+
+func (e *Engine) recordFee(fee float64) {
+	e.Ledger.Mu.Lock()
+	defer e.Ledger.Mu.Unlock()
+
+	e.Ledger.Fees += fee
+}
+
+// recordEviction moves a purged user's balances into the ledger so totals still add up.
+// Caller must hold UM.
+func (e *Engine) recordEviction(user *types.User) {
+	e.Ledger.Mu.Lock()
+	defer e.Ledger.Mu.Unlock()
+
+	e.Ledger.EvictedCash += user.Balance.WalletBalance.Amount + user.Balance.WalletBalance.Locked + user.Balance.WalletBalance.Held
+	if e.Ledger.EvictedShares == nil {
+		e.Ledger.EvictedShares = make(map[string]types.StockBalance)
+	}
+	for symbol, stock := range user.Balance.StockBalance {
+		evicted := e.Ledger.EvictedShares[symbol]
+		evicted.Yes += stock.Yes + stock.LockedYes
+		evicted.No += stock.No + stock.LockedNo
+		e.Ledger.EvictedShares[symbol] = evicted
+	}
+}
+
+// recordShareAdjustment books shares an operator created or removed by hand.
+func (e *Engine) recordShareAdjustment(symbol string, side types.Side, qty int) {
+	e.Ledger.Mu.Lock()
+	defer e.Ledger.Mu.Unlock()
+
+	if e.Ledger.AdjustedShares == nil {
+		e.Ledger.AdjustedShares = make(map[string]types.StockBalance)
+	}
+	adjusted := e.Ledger.AdjustedShares[symbol]
+	if side == types.Yes {
+		adjusted.Yes += qty
+	} else {
+		adjusted.No += qty
+	}
+	e.Ledger.AdjustedShares[symbol] = adjusted
+}
+
+// MarkTouched remembers that a market was mutated since the last invariant check.
+func (e *Engine) MarkTouched(symbol string) {
+	e.touchedMu.Lock()
+	defer e.touchedMu.Unlock()
+
+	e.touched[symbol] = struct{}{}
+}
+
+func (e *Engine) takeTouched() map[string]struct{} {
+	e.touchedMu.Lock()
+	defer e.touchedMu.Unlock()
+
+	touched := e.touched
+	e.touched = make(map[string]struct{})
+	return touched
+}
+
+// reservedFor is the cash a resting buy order holds in Locked for qty shares.
+func reservedFor(order *types.Order, qty int) float64 {
+	if order.Role == types.ADMIN {
+		return 0
+	}
+	return order.Price * float64(qty) * (1 + feeRate(order))
+}
+
+// lockOrder sets aside what a new order needs: cash including the fee for a bid, and
+// shares in escrow until it fills or is cancelled for an ask. Admin orders lock nothing.
+// Caller must hold UM.
+func lockOrder(user *types.User, order *types.Order) {
+	if order.Role == types.ADMIN {
+		return
+	}
+
+	if order.Action == types.BUY {
+		reserved := reservedFor(order, order.Quantity)
+		user.Balance.WalletBalance.Amount -= reserved
+		user.Balance.WalletBalance.Locked += reserved
+		return
+	}
+
+	stock := user.Balance.StockBalance[order.Symbol]
+	if order.Side == types.Yes {
+		stock.Yes -= order.Quantity
+		stock.LockedYes += order.Quantity
+	} else {
+		stock.No -= order.Quantity
+		stock.LockedNo += order.Quantity
+	}
+	user.Balance.StockBalance[order.Symbol] = stock
+}
+
+// debitBuyer charges a buy fill against the funds its order locked at placement
+// and returns any price improvement to the wallet. Caller must hold UM.
+func (e *Engine) debitBuyer(buyer *types.User, order *types.Order, qty int, price float64) {
+	cost := price * float64(qty)
+	fee := cost * feeRate(order)
+
+	if order.Role == types.ADMIN {
+		buyer.Balance.WalletBalance.Amount -= cost + fee
+	} else {
+		reserved := reservedFor(order, qty)
+		buyer.Balance.WalletBalance.Locked -= reserved
+		buyer.Balance.WalletBalance.Amount += reserved - cost - fee
+	}
+
+	e.recordFee(fee)
+}
+
+// creditSeller pays out a sell fill and burns the shares escrowed by the order.
+// Caller must hold UM.
+func (e *Engine) creditSeller(seller *types.User, order *types.Order, qty int, price float64) {
+	proceeds := price * float64(qty)
+	fee := proceeds * feeRate(order)
+
+	seller.Balance.WalletBalance.Amount += proceeds - fee
+	e.recordFee(fee)
+
+	stock := seller.Balance.StockBalance[order.Symbol]
+	isAdmin := order.Role == types.ADMIN
+	switch {
+	case order.Side == types.Yes && isAdmin:
+		stock.Yes -= qty
+	case order.Side == types.Yes:
+		stock.LockedYes -= qty
+	case isAdmin:
+		stock.No -= qty
+	default:
+		stock.LockedNo -= qty
+	}
+	seller.Balance.StockBalance[order.Symbol] = stock
+}
+
+// releaseRestingOrder refunds an order for its owner, taking UM for the duration.
+func (e *Engine) releaseRestingOrder(order *types.Order) (float64, string) {
+	e.UM.Lock()
+	defer e.UM.Unlock()
+
+	user, ok := e.User[order.UserId]
+	if !ok {
+		// Eviction moved the owner's whole balance into the ledger, this hold included,
+		// so it is released there already and only the refund is reported
+		log.Warn().Str("orderId", order.OrderId).Str("userId", order.UserId).Msg("Released a resting order whose owner is not in memory")
+		return e.releaseOrder(&types.User{Balance: &types.Balance{}}, order)
+	}
+	return e.releaseOrder(user, order)
+}
+
+// userName returns a user's display name, or "" if they are not in memory.
+func (e *Engine) userName(userId string) string {
+	e.UM.RLock()
+	defer e.UM.RUnlock()
+
+	if user, ok := e.User[userId]; ok {
+		return user.Name
+	}
+	return ""
+}
+
+// releaseOrder hands back whatever a resting order still holds: cash for bids,
+// escrowed shares for asks. Caller must hold UM.
+func (e *Engine) releaseOrder(user *types.User, order *types.Order) (float64, string) {
+	remaining := order.Quantity - order.Filled
+
+	if order.Action == types.BUY {
+		refund := reservedFor(order, remaining)
+		user.Balance.WalletBalance.Locked -= refund
+		user.Balance.WalletBalance.Amount += refund
+		return refund, "INR"
+	}
+
+	if user.Balance.StockBalance == nil {
+		user.Balance.StockBalance = make(map[string]types.StockBalance)
+	}
+	stock := user.Balance.StockBalance[order.Symbol]
+	refundType := "YES_STOCK"
+	if order.Role != types.ADMIN {
+		if order.Side == types.Yes {
+			stock.LockedYes -= remaining
+			stock.Yes += remaining
+		} else {
+			stock.LockedNo -= remaining
+			stock.No += remaining
+		}
+	}
+	if order.Side == types.No {
+		refundType = "NO_STOCK"
+	}
+	user.Balance.StockBalance[order.Symbol] = stock
+	return float64(remaining), refundType
+}
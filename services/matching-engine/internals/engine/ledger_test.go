@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"matching-engine/internals/types"
+	"testing"
+)
+
+func TestReleaseRestingOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		order      types.Order
+		owner      bool
+		shares     types.StockBalance
+		refund     float64
+		refundType string
+		wantWallet types.WalletBalance
+		wantStock  types.StockBalance
+	}{
+		{
+			name:       "bid returns its cash",
+			order:      types.Order{Action: types.BUY, Side: types.Yes, Price: 6, Quantity: 2},
+			owner:      true,
+			refund:     12 * (1 + legacyTradingFee),
+			refundType: "INR",
+			wantWallet: types.WalletBalance{Amount: 100},
+		},
+		{
+			name:       "ask returns its escrow",
+			order:      types.Order{Action: types.SELL, Side: types.No, Price: 4, Quantity: 3},
+			owner:      true,
+			shares:     types.StockBalance{No: 3},
+			refund:     3,
+			refundType: "NO_STOCK",
+			wantWallet: types.WalletBalance{Amount: 100},
+			wantStock:  types.StockBalance{No: 3},
+		},
+		{
+			name:       "missing owner is released into the ledger",
+			order:      types.Order{Action: types.BUY, Side: types.Yes, Price: 6, Quantity: 2},
+			refund:     12 * (1 + legacyTradingFee),
+			refundType: "INR",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testEngine()
+			order := tt.order
+			order.OrderId, order.UserId, order.Symbol = "o1", "alice", "RAIN"
+			user := testUser("alice", 100)
+			user.Balance.StockBalance["RAIN"] = tt.shares
+			lockOrder(user, &order)
+			if tt.owner {
+				e.User["alice"] = user
+			}
+			evicted, funding := e.Ledger.EvictedCash, e.Ledger.NetFunding
+
+			refund, refundType := e.releaseRestingOrder(&order)
+			if refund != tt.refund || refundType != tt.refundType {
+				t.Errorf("refund %v %s, want %v %s", refund, refundType, tt.refund, tt.refundType)
+			}
+			if e.Ledger.EvictedCash != evicted || e.Ledger.NetFunding != funding {
+				t.Errorf("ledger moved to evicted %v funding %v", e.Ledger.EvictedCash, e.Ledger.NetFunding)
+			}
+			if !tt.owner {
+				return
+			}
+			if user.Balance.WalletBalance != tt.wantWallet {
+				t.Errorf("wallet %+v, want %+v", user.Balance.WalletBalance, tt.wantWallet)
+			}
+			if stock := user.Balance.StockBalance["RAIN"]; stock != tt.wantStock {
+				t.Errorf("stock %+v, want %+v", stock, tt.wantStock)
+			}
+		})
+	}
+}
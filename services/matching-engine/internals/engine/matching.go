@@ -2,16 +2,26 @@ package engine
 
 import (
 	"container/heap"
+	"context"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/tracing"
 	"matching-engine/internals/types"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ProcessLimitOrder matches a LIMIT or MARKET order against the orderbook using synthetic matching.
-func (e *Engine) ProcessLimitOrder(market *types.Market, order *types.Order, isMarketOrder bool) []types.TradeExecutedEvent {
-	var trades []types.TradeExecutedEvent
+// The caller holds market.Mu.
+func (e *Engine) ProcessLimitOrder(ctx context.Context, market *types.Market, order *types.Order, isMarketOrder bool) []types.TradeExecutedEvent {
+	ctx, span := tracing.Start(ctx, "matching.process_limit_order", trace.WithAttributes(
+		attribute.String("market.symbol", market.Symbol),
+		attribute.String("order.id", order.OrderId),
+	))
+	defer span.End()
 
-	market.Mu.Lock()
-	defer market.Mu.Unlock()
+	var trades []types.TradeExecutedEvent
 
 	for order.Filled < order.Quantity {
 		var bestStandard *types.Order
@@ -62,7 +72,7 @@ func (e *Engine) ProcessLimitOrder(market *types.Market, order *types.Order, isM
 			matchOrder = bestSynthetic
 			isSynthetic = true
 		} else {
-			synthPrice := 10.0 - bestSynthetic.Price
+			synthPrice := payoutPerShare() - bestSynthetic.Price
 			if order.Action == types.BUY {
 				if bestStandard.Price < synthPrice {
 					matchOrder = bestStandard
@@ -100,7 +110,7 @@ func (e *Engine) ProcessLimitOrder(market *types.Market, order *types.Order, isM
 
 		matchPrice := matchOrder.Price
 		if isSynthetic {
-			matchPrice = 10.0 - matchOrder.Price
+			matchPrice = payoutPerShare() - matchOrder.Price
 		}
 
 		if order.Action == types.BUY && order.Price < matchPrice {
@@ -111,6 +121,10 @@ func (e *Engine) ProcessLimitOrder(market *types.Market, order *types.Order, isM
 
 		if matchOrder.UserId == order.UserId {
 			popOrderFromHeap(market, matchOrder)
+			refund, refundType := e.releaseRestingOrder(matchOrder)
+			e.Publish(ctx, types.ORDER_CANCELLED, orderCancelled(matchOrder, market.MarketId, refund, refundType, schema.CancelSelfTrade))
+			e.notifyOrder(ctx, matchOrder, types.OrderCancelled, schema.CancelSelfTrade)
+			e.notifyBalanceOf(ctx, matchOrder.UserId, BalanceOrderCancelled)
 			continue
 		}
 
@@ -137,7 +151,12 @@ func (e *Engine) ProcessLimitOrder(market *types.Market, order *types.Order, isM
 			}
 		}
 
-		e.settleTradeBalances(order, matchOrder, tradeQty, matchPrice, matchType)
+		e.settleTradeBalances(ctx, market, order, matchOrder, tradeQty, matchPrice, matchType)
+		makerPrice := addFillNotional(order, matchOrder, tradeQty, matchPrice, matchType)
+		e.notifyFill(ctx, order, tradeQty, matchPrice)
+		e.notifyFill(ctx, matchOrder, tradeQty, makerPrice)
+		e.notifyBalanceOf(ctx, order.UserId, BalanceTrade)
+		e.notifyBalanceOf(ctx, matchOrder.UserId, BalanceTrade)
 
 		var makerId, takerId, makerOrderId, takerOrderId string
 		takerId = order.UserId
@@ -145,18 +164,7 @@ func (e *Engine) ProcessLimitOrder(market *types.Market, order *types.Order, isM
 		makerId = matchOrder.UserId
 		makerOrderId = matchOrder.OrderId
 
-		e.UM.Lock()
-		takerAcc := e.User[takerId]
-		makerAcc := e.User[makerId]
-		e.UM.Unlock()
-
-		var takerName, makerName string
-		if takerAcc != nil {
-			takerName = takerAcc.Name
-		}
-		if makerAcc != nil {
-			makerName = makerAcc.Name
-		}
+		takerName, makerName := e.userName(takerId), e.userName(makerId)
 
 		trades = append(trades, types.TradeExecutedEvent{
 			MarketId:     market.MarketId,
@@ -183,16 +191,14 @@ func (e *Engine) ProcessLimitOrder(market *types.Market, order *types.Order, isM
 		pushOrderToHeap(market, order)
 	}
 
-	// Refund unfilled portion for market buy orders
-	if isMarketOrder && order.Filled < order.Quantity && order.Action == types.BUY {
-		e.UM.Lock()
-		u := e.User[order.UserId]
-		refund := order.Price * float64(order.Quantity-order.Filled)
-		u.Balance.WalletBalance.Locked -= refund
-		u.Balance.WalletBalance.Amount += refund
-		e.UM.Unlock()
+	// Refund unfilled portion for market orders (cash for buys, escrowed shares for sells)
+	if isMarketOrder && order.Filled < order.Quantity {
+		e.releaseRestingOrder(order)
+		e.notifyOrder(ctx, order, types.OrderExpired, "")
+		e.notifyBalanceOf(ctx, order.UserId, BalanceOrderExpired)
 	}
 
+	span.SetAttributes(attribute.Int("order.filled", order.Filled), attribute.Int("trades", len(trades)))
 	return trades
 }
 
@@ -220,7 +226,28 @@ func pushOrderToHeap(market *types.Market, order *types.Order) {
 	}
 }
 
-func (e *Engine) settleTradeBalances(order, matchOrder *types.Order, qty int, executionPrice float64, matchType string) {
+// addFillNotional adds a fill of qty to both orders' FilledNotional, the taker's at
+// price and the maker's at its own outcome's price, which it returns.
+func addFillNotional(taker, maker *types.Order, qty int, price float64, matchType string) float64 {
+	makerPrice := price
+	if matchType != "STANDARD" {
+		makerPrice = payoutPerShare() - price
+	}
+	taker.FilledNotional += price * float64(qty)
+	maker.FilledNotional += makerPrice * float64(qty)
+	return makerPrice
+}
+
+// settleTradeBalances moves cash, shares and collateral for a single fill.
+// executionPrice is quoted on the taker's side; the maker of a MINT or MERGE
+// trades the opposite outcome at payoutPerShare - executionPrice.
+func (e *Engine) settleTradeBalances(ctx context.Context, market *types.Market, order, matchOrder *types.Order, qty int, executionPrice float64, matchType string) {
+	_, span := tracing.Start(ctx, "matching.settle", trace.WithAttributes(
+		attribute.String("trade.match_type", matchType),
+		attribute.Int("trade.quantity", qty),
+	))
+	defer span.End()
+
 	e.UM.Lock()
 	defer e.UM.Unlock()
 
@@ -235,88 +262,51 @@ func (e *Engine) settleTradeBalances(order, matchOrder *types.Order, qty int, ex
 		u2.Balance.StockBalance = make(map[string]types.StockBalance)
 	}
 
+	makerPrice := executionPrice
+	if matchType != "STANDARD" {
+		makerPrice = payoutPerShare() - executionPrice
+	}
+
 	switch matchType {
 	case "STANDARD":
-		var buyer, seller *types.User
-		var buyerSide types.Side
-		if order.Action == types.BUY {
-			buyer, seller = u1, u2
-			buyerSide = order.Side
-		} else {
+		buyOrder, sellOrder := order, matchOrder
+		buyer, seller := u1, u2
+		if order.Action == types.SELL {
+			buyOrder, sellOrder = matchOrder, order
 			buyer, seller = u2, u1
-			buyerSide = matchOrder.Side
 		}
 
 		buyerStock := buyer.Balance.StockBalance[order.Symbol]
-		sellerStock := seller.Balance.StockBalance[order.Symbol]
-
-		if buyerSide == types.Yes {
+		if buyOrder.Side == types.Yes {
 			buyerStock.Yes += qty
-			sellerStock.Yes -= qty
 		} else {
 			buyerStock.No += qty
-			sellerStock.No -= qty
 		}
-
 		buyer.Balance.StockBalance[order.Symbol] = buyerStock
-		seller.Balance.StockBalance[order.Symbol] = sellerStock
-
-		tradeValue := executionPrice * float64(qty)
-		buyerFee := tradeValue * 0.0025
-		sellerFee := tradeValue * 0.0025
 
-		buyer.Balance.WalletBalance.Locked -= tradeValue
-		buyer.Balance.WalletBalance.Amount -= buyerFee
-		seller.Balance.WalletBalance.Amount += (tradeValue - sellerFee)
+		e.debitBuyer(buyer, buyOrder, qty, executionPrice)
+		e.creditSeller(seller, sellOrder, qty, executionPrice)
 
 	case "MINT":
-		var yesBuyer, noBuyer *types.User
-		if order.Side == types.Yes {
-			yesBuyer, noBuyer = u1, u2
-		} else {
-			yesBuyer, noBuyer = u2, u1
+		for _, leg := range []struct {
+			user  *types.User
+			order *types.Order
+			price float64
+		}{{u1, order, executionPrice}, {u2, matchOrder, makerPrice}} {
+			stock := leg.user.Balance.StockBalance[order.Symbol]
+			if leg.order.Side == types.Yes {
+				stock.Yes += qty
+			} else {
+				stock.No += qty
+			}
+			leg.user.Balance.StockBalance[order.Symbol] = stock
+			e.debitBuyer(leg.user, leg.order, qty, leg.price)
 		}
-
-		yStock := yesBuyer.Balance.StockBalance[order.Symbol]
-		yStock.Yes += qty
-		yesBuyer.Balance.StockBalance[order.Symbol] = yStock
-
-		yesTradeValue := executionPrice * float64(qty)
-		yesFee := yesTradeValue * 0.0025
-		yesBuyer.Balance.WalletBalance.Locked -= yesTradeValue
-		yesBuyer.Balance.WalletBalance.Amount -= yesFee
-
-		nStock := noBuyer.Balance.StockBalance[order.Symbol]
-		nStock.No += qty
-		noBuyer.Balance.StockBalance[order.Symbol] = nStock
-
-		noTradeValue := (10.0 - executionPrice) * float64(qty)
-		noFee := noTradeValue * 0.0025
-		noBuyer.Balance.WalletBalance.Locked -= noTradeValue
-		noBuyer.Balance.WalletBalance.Amount -= noFee
+		market.Collateral += payoutPerShare() * float64(qty)
 
 	case "MERGE":
-		var yesSeller, noSeller *types.User
-		if order.Side == types.Yes {
-			yesSeller, noSeller = u1, u2
-		} else {
-			yesSeller, noSeller = u2, u1
-		}
-
-		yStock := yesSeller.Balance.StockBalance[order.Symbol]
-		yStock.Yes -= qty
-		yesSeller.Balance.StockBalance[order.Symbol] = yStock
-
-		yesTradeValue := executionPrice * float64(qty)
-		yesFee := yesTradeValue * 0.0025
-		yesSeller.Balance.WalletBalance.Amount += (yesTradeValue - yesFee)
-
-		nStock := noSeller.Balance.StockBalance[order.Symbol]
-		nStock.No -= qty
-		noSeller.Balance.StockBalance[order.Symbol] = nStock
-
-		noTradeValue := (10.0 - executionPrice) * float64(qty)
-		noFee := noTradeValue * 0.0025
-		noSeller.Balance.WalletBalance.Amount += (noTradeValue - noFee)
+		e.creditSeller(u1, order, qty, executionPrice)
+		e.creditSeller(u2, matchOrder, qty, makerPrice)
+		market.Collateral -= payoutPerShare() * float64(qty)
 	}
 }
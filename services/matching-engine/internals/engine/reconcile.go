@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/types"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// BalanceExportKey is where processor-service writes the Postgres balance export by default.
+const BalanceExportKey = "engine:balance_export"
+
+// ReconciliationOperator is the requester of the adjustments reconciliation proposes, so
+// any operator can approve them.
+const ReconciliationOperator = "reconciliation"
+
+// reconcileTolerance matches the two decimal places Postgres keeps for wallets.
+const reconcileTolerance = 0.005
+
+// LoadBalanceExport reads a balance export from "redis:<key>" or "file:<path>".
+// A bare value is treated as a file path.
+func (e *Engine) LoadBalanceExport(ctx context.Context, source string) (*types.BalanceExport, error) {
+	var raw []byte
+	var err error
+
+	switch {
+	case strings.HasPrefix(source, "redis:"):
+		key := strings.TrimPrefix(source, "redis:")
+		if key == "" {
+			key = BalanceExportKey
+		}
+		raw, err = e.Redis.Get(ctx, key).Bytes()
+	default:
+		raw, err = os.ReadFile(strings.TrimPrefix(source, "file:"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read balance export from %s: %w", source, err)
+	}
+
+	var export types.BalanceExport
+	if err := json.Unmarshal(raw, &export); err != nil {
+		return nil, fmt.Errorf("decode balance export from %s: %w", source, err)
+	}
+	return &export, nil
+}
+
+// Reconcile compares the Postgres balance export against engine memory and reports every
+// wallet, lock and position that disagrees. With emitAdjustments set, each wallet or
+// position discrepancy becomes an adjustment that brings the engine to the ledger value,
+// requested by ReconciliationOperator, and is announced as ADJUSTMENT_PROPOSED. Whatever
+// its size it waits for APPROVE_ADJUSTMENT, which refuses it if the engine value has moved
+// since. Locks follow resting orders and are only reported.
+func (e *Engine) Reconcile(ctx context.Context, export *types.BalanceExport, source string, emitAdjustments bool) types.ReconcileReport {
+	report := types.ReconcileReport{
+		ReconciliationId: uuid.New().String(),
+		Source:           source,
+		ExportedAt:       export.GeneratedAt,
+		ReconciledAt:     time.Now(),
+	}
+
+	// The DB keys positions by market id, the engine by symbol
+	e.MM.RLock()
+	symbolById := make(map[string]string, len(e.Market))
+	for symbol, market := range e.Market {
+		symbolById[market.MarketId] = symbol
+	}
+	e.MM.RUnlock()
+
+	e.UM.RLock()
+	seen := make(map[string]struct{}, len(export.Users))
+	for _, row := range export.Users {
+		seen[row.UserId] = struct{}{}
+
+		user, exists := e.User[row.UserId]
+		if !exists {
+			report.Discrepancies = append(report.Discrepancies, types.Discrepancy{
+				UserId: row.UserId, Field: types.FieldMissingInEngine, Ledger: row.Balance + row.Locked,
+			})
+			continue
+		}
+
+		report.UsersChecked++
+		user.Mutex.Lock()
+		report.Discrepancies = append(report.Discrepancies, compareUser(user, row, symbolById)...)
+		user.Mutex.Unlock()
+	}
+
+	for id, user := range e.User {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		report.Discrepancies = append(report.Discrepancies, types.Discrepancy{
+			UserId: id, Field: types.FieldMissingInExport,
+			Engine: user.Balance.WalletBalance.Amount + user.Balance.WalletBalance.Locked,
+		})
+	}
+	// RequestAdjustment takes the users lock itself
+	e.UM.RUnlock()
+
+	if emitAdjustments {
+		for i := range report.Discrepancies {
+			if e.proposeAdjustment(ctx, report.ReconciliationId, &report.Discrepancies[i]) {
+				report.AdjustmentsSent++
+			}
+		}
+	}
+
+	log.Info().
+		Str("reconciliationId", report.ReconciliationId).
+		Str("source", source).
+		Int("usersChecked", report.UsersChecked).
+		Int("discrepancies", len(report.Discrepancies)).
+		Int("adjustmentsSent", report.AdjustmentsSent).
+		Msg("Balance reconciliation finished")
+
+	return report
+}
+
+// proposeAdjustment requests the adjustment that corrects d, if its field can be
+// adjusted, and records the outcome on d.
+func (e *Engine) proposeAdjustment(ctx context.Context, reconciliationId string, d *types.Discrepancy) bool {
+	expected := d.Engine
+	adj := types.Adjustment{
+		UserId:      d.UserId,
+		Delta:       d.Ledger - d.Engine,
+		ReasonCode:  types.ReasonReconciliation,
+		Note:        fmt.Sprintf("reconciliation %s: %s engine %v, ledger %v", reconciliationId, d.Field, d.Engine, d.Ledger),
+		RequestedBy: ReconciliationOperator,
+		Expected:    &expected,
+	}
+	switch d.Field {
+	case types.FieldWallet:
+		adj.Kind = types.BalanceAdjustment
+		adj.Delta = math.Round(adj.Delta*100) / 100
+	case types.FieldYes, types.FieldNo:
+		adj.Kind, adj.Symbol, adj.Side = types.PositionAdjustment, d.Symbol, types.Yes
+		if d.Field == types.FieldNo {
+			adj.Side = types.No
+		}
+	default:
+		return false
+	}
+
+	adj, err := e.RequestAdjustment(ctx, adj)
+	if err != nil {
+		d.Error = err.Error()
+		log.Warn().Err(err).Str("userId", d.UserId).Str("field", string(d.Field)).Msg("Reconciliation adjustment not requested")
+		return false
+	}
+	d.AdjustmentId, d.Approval = adj.AdjustmentId, string(adj.Status)
+
+	e.Publish(ctx, types.ADJUSTMENT_PROPOSED, schema.AdjustmentProposed{
+		ReconciliationId: reconciliationId,
+		UserId:           d.UserId,
+		Symbol:           d.Symbol,
+		Field:            string(d.Field),
+		Delta:            adj.Delta,
+		EngineValue:      d.Engine,
+		LedgerValue:      d.Ledger,
+		AdjustmentId:     adj.AdjustmentId,
+	})
+	return true
+}
+
+// compareUser diffs one user. Caller must hold the user's mutex.
+func compareUser(user *types.User, row types.UserBalanceExport, symbolById map[string]string) []types.Discrepancy {
+	var out []types.Discrepancy
+	diff := func(symbol string, field types.DiscrepancyField, engineValue, ledgerValue float64) {
+		if math.Abs(engineValue-ledgerValue) > reconcileTolerance {
+			out = append(out, types.Discrepancy{
+				UserId: user.ID, Symbol: symbol, Field: field,
+				Engine: engineValue, Ledger: ledgerValue, Delta: engineValue - ledgerValue,
+			})
+		}
+	}
+
+	diff("", types.FieldWallet, user.Balance.WalletBalance.Amount, row.Balance)
+	diff("", types.FieldLocked, user.Balance.WalletBalance.Locked, row.Locked)
+
+	positions := make(map[string]types.PositionExport, len(row.Positions))
+	for _, p := range row.Positions {
+		symbol := p.Symbol
+		if symbol == "" {
+			symbol = symbolById[p.MarketId]
+		}
+		if symbol == "" {
+			symbol = p.MarketId
+		}
+		positions[symbol] = p
+	}
+
+	symbols := make([]string, 0, len(positions)+len(user.Balance.StockBalance))
+	for symbol := range positions {
+		symbols = append(symbols, symbol)
+	}
+	for symbol := range user.Balance.StockBalance {
+		if _, ok := positions[symbol]; !ok {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
+		stock := user.Balance.StockBalance[symbol]
+		p := positions[symbol]
+		diff(symbol, types.FieldYes, float64(stock.Yes), float64(p.YesQuantity))
+		diff(symbol, types.FieldNo, float64(stock.No), float64(p.NoQuantity))
+		diff(symbol, types.FieldLockedYes, float64(stock.LockedYes), float64(p.YesLocked))
+		diff(symbol, types.FieldLockedNo, float64(stock.LockedNo), float64(p.NoLocked))
+	}
+
+	return out
+}
@@ -0,0 +1,671 @@
+package engine
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"matching-engine/internals/config"
+	"matching-engine/internals/events"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/types"
+	"math"
+	"sort"
+)
+
+// Replayer rebuilds engine state from the event history, one event at a time in seq
+// order. It applies what each event reports rather than re-running the command, so a
+// fill lands exactly as it was matched. Idempotency keys are not rebuilt and users keep
+// the activity time of their last order.
+//
+// Apply must not run beside anything else using the engine: markets are installed
+// without their goroutines, see StartMarkets.
+type Replayer struct {
+	e   *Engine
+	ctx context.Context
+
+	// markets indexes e.Market by market id, which is what most events carry
+	markets map[string]*types.Market
+	// orders holds every order still resting, plus takers whose fills are still to come
+	orders map[string]*types.Order
+	// unsettled counts the shares of a taker's fills not yet seen as TRADE_EXECUTED
+	unsettled map[string]int
+	// parked holds events waiting for the ORDER_PLACED of the order they name. Engines
+	// that numbered events when their command committed, after the market had moved
+	// on, could number a fill ahead of the placement of its maker.
+	parked map[string][]events.Event
+	// late collects parked events that failed once they could be applied
+	late []types.ReplayError
+}
+
+// NewReplayer starts from whatever the engine holds, empty or restored from a snapshot.
+func (e *Engine) NewReplayer() *Replayer {
+	r := &Replayer{
+		e:         e,
+		ctx:       context.Background(),
+		markets:   make(map[string]*types.Market),
+		orders:    make(map[string]*types.Order),
+		unsettled: make(map[string]int),
+		parked:    make(map[string][]events.Event),
+	}
+	for _, market := range e.Market {
+		r.markets[market.MarketId] = market
+		for _, orders := range bookSides(market.OrderBook) {
+			for _, order := range orders {
+				r.orders[order.OrderId] = order
+			}
+		}
+	}
+	return r
+}
+
+// Apply changes state the way the command that raised event did. An error means the
+// event did not fit the state rebuilt so far; it may have been applied in part. An
+// event naming an order not placed yet is held back until it is, see Finish.
+func (r *Replayer) Apply(event events.Event) error {
+	if orderId := r.awaiting(event); orderId != "" {
+		r.parked[orderId] = append(r.parked[orderId], event)
+		return nil
+	}
+
+	err := r.apply(event)
+	if p, ok := event.Data.(schema.OrderPlaced); ok && err == nil {
+		held := r.parked[p.OrderId]
+		delete(r.parked, p.OrderId)
+		for _, event := range held {
+			if err := r.Apply(event); err != nil {
+				r.late = append(r.late, types.ReplayError{Seq: event.Seq, Type: event.Type, Error: err.Error()})
+			}
+		}
+	}
+	return err
+}
+
+// Finish reports the held back events that failed once applied, and those whose order
+// never turned up, in seq order.
+func (r *Replayer) Finish() []types.ReplayError {
+	failed := append([]types.ReplayError(nil), r.late...)
+	for orderId, held := range r.parked {
+		for _, event := range held {
+			failed = append(failed, types.ReplayError{
+				Seq: event.Seq, Type: event.Type, Error: fmt.Sprintf("order %s not found", orderId),
+			})
+		}
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Seq < failed[j].Seq })
+	return failed
+}
+
+// awaiting returns the order event names that the replay does not hold yet.
+func (r *Replayer) awaiting(event events.Event) string {
+	var ids []string
+	switch p := event.Data.(type) {
+	case schema.TradeExecuted:
+		ids = []string{p.TakerOrderId, p.MakerOrderId}
+	case schema.OrderCancelled:
+		ids = []string{p.OrderId}
+	}
+	for _, id := range ids {
+		if _, ok := r.orders[id]; !ok {
+			return id
+		}
+	}
+	return ""
+}
+
+func (r *Replayer) apply(event events.Event) error {
+	switch p := event.Data.(type) {
+	case schema.MarketCreated:
+		return r.marketCreated(p)
+	case schema.OrderPlaced:
+		return r.orderPlaced(p)
+	case schema.TradeExecuted:
+		return r.tradeExecuted(p)
+	case schema.OrderCancelled:
+		return r.orderCancelled(p)
+	case schema.PriceUpdated:
+		if p.MarketId == "" {
+			// Published by user cancels before they carried the market; the next
+			// order on the book sets the price again
+			return nil
+		}
+		return r.withMarket(p.MarketId, func(market *types.Market) {
+			market.YesPrice, market.NoPrice = float32(p.YesPrice), float32(p.NoPrice)
+		})
+	case schema.TradersCountIncreased:
+		return r.withMarket(p.MarketId, func(market *types.Market) {
+			market.NumberOfTraders += int16(p.Count)
+		})
+	case schema.MarketResolved:
+		return r.withMarket(p.MarketId, func(market *types.Market) {
+			market.Status = types.Close
+		})
+	case schema.MarketHalted:
+		return r.withMarket(p.MarketId, haltMarket)
+	case schema.MarketRestarted:
+		return r.withMarket(p.MarketId, reopenMarket)
+	case schema.InvariantViolation:
+		for _, symbol := range p.HaltedMarkets {
+			if market, ok := r.e.Market[symbol]; ok && market.Status == types.Open {
+				haltMarket(market)
+			}
+		}
+		return nil
+
+	case schema.UserCreated:
+		return r.userCreated(p)
+	case schema.UserVerified:
+		return r.withUser(p.UserId, func(user *types.User) {
+			user.KycVerificationStatus = types.KycStatus(p.KycStatus)
+			user.PaymentVerificationStatus = types.PaymentStatus(p.PaymentStatus)
+		})
+	case schema.BalanceInitialized:
+		return r.withUser(p.UserId, func(user *types.User) {
+			wallet := &user.Balance.WalletBalance
+			previous := wallet.Amount + wallet.Locked
+			wallet.Amount, wallet.Locked = p.Amount, p.Locked
+			user.Funded = p.Amount != 0 || p.Locked != 0
+			r.e.RecordFunding(p.Amount + p.Locked - previous)
+		})
+	case schema.FundsDeposited:
+		return r.withUser(p.UserId, func(user *types.User) {
+			user.Balance.WalletBalance.Amount += p.Amount
+			user.Funded = true
+			if p.Source == schema.DepositSource {
+				user.LastDepositAt = p.Timestamp
+			}
+			r.e.RecordFunding(p.Amount)
+		})
+	case schema.SharesSplit:
+		return r.splitOrMerge(p.UserId, p.Symbol, p.Quantity, -p.Cost)
+	case schema.SharesMerged:
+		return r.splitOrMerge(p.UserId, p.Symbol, -p.Quantity, p.Refund)
+	case schema.Withdrawal:
+		return r.withdrawal(types.EVENTS(event.Type), p)
+	case schema.Adjustment:
+		return r.adjustment(p)
+	case schema.AdjustmentProposed:
+		// Only a proposal; an approved one arrives as ADJUSTMENT
+		return nil
+
+	case json.RawMessage:
+		return fmt.Errorf("schema version %d of %s cannot be replayed", event.Version, event.Type)
+	}
+	return fmt.Errorf("event type %s cannot be replayed", event.Type)
+}
+
+func (r *Replayer) marketCreated(p schema.MarketCreated) error {
+	market := &types.Market{
+		MarketId:        p.MarketId,
+		Title:           p.Title,
+		Symbol:          p.Symbol,
+		YesPrice:        float32(p.YesPrice),
+		NoPrice:         float32(p.NoPrice),
+		Thumbnail:       p.Thumbnail,
+		CategoryId:      p.CategoryId,
+		NumberOfTraders: int16(p.NumberOfTraders),
+		Traders:         make(map[string]struct{}),
+		Status:          types.Open,
+		OrderBook:       types.NewOrderBook(),
+		Overview: types.Overview{
+			SourceOfTruth: p.SourceOfTruth,
+			StartDate:     p.StartDate,
+			EndDate:       p.EndDate,
+			EOS:           p.EOS,
+			Rules:         p.Rules,
+		},
+	}
+	r.e.Market[market.Symbol] = market
+	r.markets[market.MarketId] = market
+	return nil
+}
+
+// orderPlaced locks what the order needed and rests what is left of it. Its own fills
+// follow as TRADE_EXECUTED, so it is booked as already filled and only settled then. A
+// market order is booked for what it filled, since the rest was released at once.
+func (r *Replayer) orderPlaced(p schema.OrderPlaced) error {
+	market, ok := r.markets[p.MarketId]
+	if !ok {
+		return fmt.Errorf("market %s not found", p.MarketId)
+	}
+	user, ok := r.e.User[p.UserId]
+	if !ok {
+		return fmt.Errorf("user %s not found", p.UserId)
+	}
+
+	fee := p.FeeRate
+	order := &types.Order{
+		OrderId:   p.OrderId,
+		UserId:    p.UserId,
+		MarketId:  p.MarketId,
+		Symbol:    p.Symbol,
+		Role:      types.Role(p.Role),
+		Price:     p.Price,
+		Quantity:  p.OriginalQuantity,
+		Side:      types.Side(p.Side),
+		Action:    types.Action(p.Action),
+		OrderType: types.OrderType(p.OrderType),
+		Timestamp: p.AcceptedAt,
+		FeeRate:   &fee,
+	}
+	if order.OrderType == types.MARKET {
+		order.Quantity = p.FilledQuantity
+	}
+
+	if user.Balance.StockBalance == nil {
+		user.Balance.StockBalance = make(map[string]types.StockBalance)
+	}
+	lockOrder(user, order)
+	user.LastActive = p.AcceptedAt
+	market.Traders[order.UserId] = struct{}{}
+
+	order.Filled = p.FilledQuantity
+	if order.Filled < order.Quantity {
+		pushOrderToHeap(market, order)
+	}
+	r.orders[order.OrderId] = order
+	r.unsettled[order.OrderId] = order.Filled
+	r.forget(order)
+	return nil
+}
+
+func (r *Replayer) tradeExecuted(p schema.TradeExecuted) error {
+	market, ok := r.markets[p.MarketId]
+	if !ok {
+		return fmt.Errorf("market %s not found", p.MarketId)
+	}
+	taker, ok := r.orders[p.TakerOrderId]
+	if !ok {
+		return fmt.Errorf("taker order %s not found", p.TakerOrderId)
+	}
+	maker, ok := r.orders[p.MakerOrderId]
+	if !ok {
+		return fmt.Errorf("maker order %s not found", p.MakerOrderId)
+	}
+	if _, ok := r.e.User[maker.UserId]; !ok {
+		return fmt.Errorf("user %s not found", maker.UserId)
+	}
+
+	r.e.settleTradeBalances(r.ctx, market, taker, maker, p.Quantity, p.Price, p.MatchType)
+	addFillNotional(taker, maker, p.Quantity, p.Price, p.MatchType)
+
+	maker.Filled += p.Quantity
+	if maker.Filled >= maker.Quantity {
+		removeFromBook(market, maker)
+		r.forget(maker)
+	}
+	r.unsettled[taker.OrderId] -= p.Quantity
+	r.forget(taker)
+
+	market.Trades = append(market.Trades, types.TradeExecutedEvent(p))
+	if keep := config.Current().Trading.TradeHistory; len(market.Trades) > keep {
+		market.Trades = market.Trades[len(market.Trades)-keep:]
+	}
+	market.Volume += float64(p.Quantity) * payoutPerShare()
+	return nil
+}
+
+// orderCancelled finds the market through the order, as user cancels used to be
+// published without a market id.
+func (r *Replayer) orderCancelled(p schema.OrderCancelled) error {
+	order, ok := r.orders[p.OrderId]
+	if !ok {
+		return fmt.Errorf("resting order %s not found", p.OrderId)
+	}
+	market, ok := r.markets[order.MarketId]
+	if !ok {
+		return fmt.Errorf("market %s not found", order.MarketId)
+	}
+	if !removeFromBook(market, order) {
+		return fmt.Errorf("resting order %s not found", p.OrderId)
+	}
+	if _, ok := r.e.User[order.UserId]; !ok {
+		return fmt.Errorf("user %s not found", order.UserId)
+	}
+
+	refund, refundType := r.e.releaseRestingOrder(order)
+	order.Filled = order.Quantity
+	r.forget(order)
+
+	replayed := orderCancelled(order, market.MarketId, refund, refundType, p.Reason)
+	if math.Abs(replayed.RefundCash-p.RefundCash) > invariantTolerance || replayed.RefundShares != p.RefundShares {
+		return fmt.Errorf("order %s released %.6f cash and %d shares, the event says %.6f and %d",
+			p.OrderId, replayed.RefundCash, replayed.RefundShares, p.RefundCash, p.RefundShares)
+	}
+	return nil
+}
+
+// forget drops an order that neither rests nor waits for its own fills any more.
+func (r *Replayer) forget(order *types.Order) {
+	if order.Filled < order.Quantity || r.unsettled[order.OrderId] > 0 {
+		return
+	}
+	delete(r.orders, order.OrderId)
+	delete(r.unsettled, order.OrderId)
+}
+
+// userCreated adds an empty account. A user the replay already holds was evicted by
+// the live engine since, so its balances move to the ledger as they did there.
+func (r *Replayer) userCreated(p schema.UserCreated) error {
+	var err error
+	if old, ok := r.e.User[p.UserId]; ok {
+		resting := make(map[string]struct{})
+		for _, market := range r.e.Market {
+			addRestingOwners(resting, market)
+		}
+		if hasOpenExposure(old, resting) {
+			err = fmt.Errorf("user %s was created again while holding orders or a withdrawal", p.UserId)
+		}
+		r.e.recordEviction(old)
+	}
+
+	r.e.User[p.UserId] = &types.User{
+		ID:                        p.UserId,
+		Name:                      p.Name,
+		Phone:                     p.Phone,
+		KycVerificationStatus:     types.KycStatus(p.KycStatus),
+		PaymentVerificationStatus: types.PaymentStatus(p.PaymentStatus),
+		Balance: &types.Balance{
+			StockBalance: make(map[string]types.StockBalance),
+		},
+	}
+	return err
+}
+
+// splitOrMerge turns cash into pairs (positive qty) or pairs back into cash.
+func (r *Replayer) splitOrMerge(userId, symbol string, qty int, cash float64) error {
+	market, ok := r.e.Market[symbol]
+	if !ok {
+		return fmt.Errorf("market %s not found", symbol)
+	}
+	return r.withUser(userId, func(user *types.User) {
+		if user.Balance.StockBalance == nil {
+			user.Balance.StockBalance = make(map[string]types.StockBalance)
+		}
+		stock := user.Balance.StockBalance[symbol]
+		stock.Yes += qty
+		stock.No += qty
+		user.Balance.StockBalance[symbol] = stock
+		user.Balance.WalletBalance.Amount += cash
+		market.Collateral -= cash
+	})
+}
+
+func (r *Replayer) withdrawal(eventType types.EVENTS, p schema.Withdrawal) error {
+	user, ok := r.e.User[p.UserId]
+	if !ok {
+		return fmt.Errorf("user %s not found", p.UserId)
+	}
+	wallet := &user.Balance.WalletBalance
+
+	if eventType == types.WITHDRAWAL_REQUESTED {
+		wallet.Amount -= p.Amount
+		wallet.Held += p.Amount
+		r.e.Withdrawals[p.WithdrawalId] = &types.Withdrawal{
+			WithdrawalId: p.WithdrawalId, UserId: p.UserId, Amount: p.Amount,
+			Status: types.WithdrawalHeld, RequestedAt: p.RequestedAt,
+		}
+		return nil
+	}
+
+	w, ok := r.e.Withdrawals[p.WithdrawalId]
+	if !ok || w.Status != types.WithdrawalHeld {
+		return fmt.Errorf("withdrawal %s is not on hold", p.WithdrawalId)
+	}
+	wallet.Held -= w.Amount
+	if eventType == types.WITHDRAWAL_CONFIRMED {
+		r.e.RecordFunding(-w.Amount)
+	} else {
+		wallet.Amount += w.Amount
+	}
+	w.Status, w.Reason, w.ResolvedAt = types.WithdrawalStatus(p.Status), p.Reason, p.ResolvedAt
+	return nil
+}
+
+// adjustment journals the correction and applies it the first time it is reported
+// APPLIED, which is either when it was requested or when it was approved.
+func (r *Replayer) adjustment(p schema.Adjustment) error {
+	adj := &types.Adjustment{
+		AdjustmentId: p.AdjustmentId, Kind: types.AdjustmentKind(p.Kind), UserId: p.UserId,
+		Symbol: p.Symbol, Side: types.Side(p.Side), Delta: p.Delta,
+		ReasonCode: types.ReasonCode(p.ReasonCode), Note: p.Note,
+		RequestedBy: p.RequestedBy, ApprovedBy: p.ApprovedBy,
+		RequestedAt: p.RequestedAt, ResolvedAt: p.ResolvedAt,
+	}
+
+	var err error
+	previous, seen := r.e.Adjustments[p.AdjustmentId]
+	if p.Status == string(types.AdjustmentApplied) && (!seen || previous.Status != types.AdjustmentApplied) {
+		err = r.e.applyAdjustment(adj)
+	}
+	adj.Status, adj.ResolvedAt = types.AdjustmentStatus(p.Status), p.ResolvedAt
+	r.e.Adjustments[p.AdjustmentId] = adj
+	return err
+}
+
+func (r *Replayer) withMarket(marketId string, fn func(*types.Market)) error {
+	market, ok := r.markets[marketId]
+	if !ok {
+		return fmt.Errorf("market %s not found", marketId)
+	}
+	fn(market)
+	return nil
+}
+
+func (r *Replayer) withUser(userId string, fn func(*types.User)) error {
+	user, ok := r.e.User[userId]
+	if !ok {
+		return fmt.Errorf("user %s not found", userId)
+	}
+	fn(user)
+	return nil
+}
+
+// RestingOrders counts the orders on every book.
+func (r *Replayer) RestingOrders() int {
+	n := 0
+	for _, market := range r.e.Market {
+		for _, orders := range bookSides(market.OrderBook) {
+			n += len(orders)
+		}
+	}
+	return n
+}
+
+// removeFromBook takes an order off its side of the book, keeping the heap ordered.
+func removeFromBook(market *types.Market, order *types.Order) bool {
+	book := market.OrderBook
+	var h heap.Interface
+	var orders types.OrderHeap
+	switch {
+	case order.Side == types.Yes && order.Action == types.BUY:
+		h, orders = book.YesBids, book.YesBids.OrderHeap
+	case order.Side == types.Yes:
+		h, orders = book.YesAsks, book.YesAsks.OrderHeap
+	case order.Action == types.BUY:
+		h, orders = book.NoBids, book.NoBids.OrderHeap
+	default:
+		h, orders = book.NoAsks, book.NoAsks.OrderHeap
+	}
+	for i, resting := range orders {
+		if resting.OrderId == order.OrderId {
+			heap.Remove(h, i)
+			return true
+		}
+	}
+	return false
+}
+
+func bookSides(book *types.OrderBook) []types.OrderHeap {
+	if book == nil {
+		return nil
+	}
+	var sides []types.OrderHeap
+	if book.YesBids != nil {
+		sides = append(sides, book.YesBids.OrderHeap)
+	}
+	if book.YesAsks != nil {
+		sides = append(sides, book.YesAsks.OrderHeap)
+	}
+	if book.NoBids != nil {
+		sides = append(sides, book.NoBids.OrderHeap)
+	}
+	if book.NoAsks != nil {
+		sides = append(sides, book.NoAsks.OrderHeap)
+	}
+	return sides
+}
+
+// CompareSnapshot lists where the engine's wallets, positions, markets and resting
+// orders disagree with snap. A user only the engine holds counts as evicted when
+// nothing of theirs is at stake, since the live engine drops idle users from memory.
+func (e *Engine) CompareSnapshot(snap *SnapshotData) (mismatches []types.RecoveryMismatch, checked, evicted int) {
+	differ := func(a, b float64) bool { return math.Abs(a-b) > invariantTolerance }
+
+	for _, userId := range sortedKeys(snap.Users) {
+		want := snap.Users[userId]
+		got, ok := e.User[userId]
+		if !ok {
+			mismatches = append(mismatches, types.RecoveryMismatch{UserId: userId, Field: types.FieldMissingInReplay})
+			continue
+		}
+		checked++
+
+		gotWallet, wantWallet := walletOf(got), walletOf(want)
+		for _, f := range []struct {
+			field     types.DiscrepancyField
+			got, want float64
+		}{
+			{types.FieldWallet, gotWallet.Amount, wantWallet.Amount},
+			{types.FieldLocked, gotWallet.Locked, wantWallet.Locked},
+			{types.FieldHeld, gotWallet.Held, wantWallet.Held},
+		} {
+			if differ(f.got, f.want) {
+				mismatches = append(mismatches, types.RecoveryMismatch{UserId: userId, Field: f.field, Replayed: f.got, Snapshot: f.want})
+			}
+		}
+
+		gotStocks, wantStocks := stocksOf(got), stocksOf(want)
+		symbols := make(map[string]types.StockBalance, len(gotStocks)+len(wantStocks))
+		for symbol := range gotStocks {
+			symbols[symbol] = types.StockBalance{}
+		}
+		for symbol := range wantStocks {
+			symbols[symbol] = types.StockBalance{}
+		}
+		for _, symbol := range sortedKeys(symbols) {
+			g, w := gotStocks[symbol], wantStocks[symbol]
+			for _, f := range []struct {
+				field     types.DiscrepancyField
+				got, want int
+			}{
+				{types.FieldYes, g.Yes, w.Yes},
+				{types.FieldNo, g.No, w.No},
+				{types.FieldLockedYes, g.LockedYes, w.LockedYes},
+				{types.FieldLockedNo, g.LockedNo, w.LockedNo},
+			} {
+				if f.got != f.want {
+					mismatches = append(mismatches, types.RecoveryMismatch{UserId: userId, Symbol: symbol, Field: f.field, Replayed: float64(f.got), Snapshot: float64(f.want)})
+				}
+			}
+		}
+	}
+
+	resting := make(map[string]struct{})
+	for _, market := range e.Market {
+		addRestingOwners(resting, market)
+	}
+	for _, userId := range sortedKeys(e.User) {
+		if _, ok := snap.Users[userId]; ok {
+			continue
+		}
+		if hasOpenExposure(e.User[userId], resting) {
+			mismatches = append(mismatches, types.RecoveryMismatch{UserId: userId, Field: types.FieldMissingInSnapshot, Detail: "holds orders or a withdrawal"})
+			continue
+		}
+		evicted++
+	}
+
+	for _, symbol := range sortedKeys(snap.Markets) {
+		want := snap.Markets[symbol]
+		got, ok := e.Market[symbol]
+		if !ok {
+			mismatches = append(mismatches, types.RecoveryMismatch{Symbol: symbol, Field: types.FieldMissingInReplay})
+			continue
+		}
+		if got.Status != want.Status {
+			mismatches = append(mismatches, types.RecoveryMismatch{Symbol: symbol, Field: types.FieldStatus, Detail: fmt.Sprintf("replayed %s, snapshot %s", got.Status, want.Status)})
+		}
+		if differ(got.Collateral, want.Collateral) {
+			mismatches = append(mismatches, types.RecoveryMismatch{Symbol: symbol, Field: types.FieldCollateral, Replayed: got.Collateral, Snapshot: want.Collateral})
+		}
+		mismatches = append(mismatches, compareBooks(symbol, got.OrderBook, want.OrderBook)...)
+	}
+	for _, symbol := range sortedKeys(e.Market) {
+		if _, ok := snap.Markets[symbol]; !ok {
+			mismatches = append(mismatches, types.RecoveryMismatch{Symbol: symbol, Field: types.FieldMissingInSnapshot})
+		}
+	}
+
+	return mismatches, checked, evicted
+}
+
+// compareBooks matches resting orders by id and compares what is left of each.
+func compareBooks(symbol string, got, want *types.OrderBook) []types.RecoveryMismatch {
+	remaining := func(book *types.OrderBook) map[string]*types.Order {
+		orders := make(map[string]*types.Order)
+		for _, side := range bookSides(book) {
+			for _, order := range side {
+				orders[order.OrderId] = order
+			}
+		}
+		return orders
+	}
+	gotOrders, wantOrders := remaining(got), remaining(want)
+
+	var mismatches []types.RecoveryMismatch
+	for _, id := range sortedKeys(wantOrders) {
+		w := wantOrders[id]
+		g, ok := gotOrders[id]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, types.RecoveryMismatch{Symbol: symbol, OrderId: id, UserId: w.UserId, Field: types.FieldMissingInReplay, Snapshot: float64(w.Quantity - w.Filled)})
+		case g.Quantity-g.Filled != w.Quantity-w.Filled || g.Price != w.Price || g.Side != w.Side || g.Action != w.Action:
+			mismatches = append(mismatches, types.RecoveryMismatch{
+				Symbol: symbol, OrderId: id, UserId: w.UserId, Field: types.FieldRestingOrder,
+				Replayed: float64(g.Quantity - g.Filled), Snapshot: float64(w.Quantity - w.Filled),
+				Detail: fmt.Sprintf("replayed %s %s at %g, snapshot %s %s at %g", g.Action, g.Side, g.Price, w.Action, w.Side, w.Price),
+			})
+		}
+	}
+	for _, id := range sortedKeys(gotOrders) {
+		if _, ok := wantOrders[id]; !ok {
+			g := gotOrders[id]
+			mismatches = append(mismatches, types.RecoveryMismatch{Symbol: symbol, OrderId: id, UserId: g.UserId, Field: types.FieldMissingInSnapshot, Replayed: float64(g.Quantity - g.Filled)})
+		}
+	}
+	return mismatches
+}
+
+func walletOf(user *types.User) types.WalletBalance {
+	if user.Balance == nil {
+		return types.WalletBalance{}
+	}
+	return user.Balance.WalletBalance
+}
+
+func stocksOf(user *types.User) map[string]types.StockBalance {
+	if user.Balance == nil {
+		return nil
+	}
+	return user.Balance.StockBalance
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
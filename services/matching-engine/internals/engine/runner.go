@@ -1,47 +1,162 @@
 package engine
 
 import (
+	"context"
+	"fmt"
+	"matching-engine/internals/metrics"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/tracing"
 	"matching-engine/internals/types"
-	"matching-engine/internals/utils"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (e *Engine) runMarket(market *types.Market) {
 	log.Info().Str("marketId", market.MarketId).Msg("Started market goroutine")
+	market.Feed = newBookFeed(market)
 
-	for msg := range market.Inbox {
-		switch msg.Type {
+	for {
+		msg, ok := nextMessage(market)
+		if !ok {
+			return
+		}
+		atomic.AddUint64(&market.Stats.Processed, 1)
+		if msg.Dequeued != nil {
+			msg.Dequeued()
+		}
 
-		case types.MarketPlaceOrder:
-			if market.Status == types.Close {
-				msg.ReplyChan <- types.OrderResponse{Success: false, Message: "market is closed"}
-				continue
-			}
-			e.handleOrder(msg, market)
+		e.process(market, msg)
+		if !msg.Type.IsReadOnly() {
+			observeBook(market)
+			e.publishBook(msg.Ctx, market)
+		}
+	}
+}
+
+// observeBook publishes resting orders and the top of each outcome's book.
+func observeBook(market *types.Market) {
+	market.Mu.RLock()
+	defer market.Mu.RUnlock()
+
+	book := market.OrderBook
+	if book == nil {
+		return
+	}
+	top := func(h types.OrderHeap) float64 {
+		if len(h) == 0 || h[0] == nil {
+			return 0
+		}
+		return h[0].Price
+	}
+	resting := book.YesBids.Len() + book.YesAsks.Len() + book.NoBids.Len() + book.NoAsks.Len()
+	metrics.ObserveBook(market.Symbol, resting,
+		metrics.Quote{Outcome: "yes", Bid: top(book.YesBids.OrderHeap), Ask: top(book.YesAsks.OrderHeap)},
+		metrics.Quote{Outcome: "no", Bid: top(book.NoBids.OrderHeap), Ask: top(book.NoAsks.OrderHeap)},
+	)
+}
+
+// process handles one message. A panic is recovered here so it halts this market
+// instead of the whole engine; the goroutine keeps serving the halted market.
+func (e *Engine) process(market *types.Market, msg types.MarketMessage) {
+	parent := msg.Ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, span := tracing.Start(parent, "market.process "+string(msg.Type), trace.WithAttributes(
+		attribute.String("market.symbol", market.Symbol),
+	))
+	defer span.End()
+
+	defer func() {
+		if r := recover(); r != nil {
+			span.SetStatus(codes.Error, fmt.Sprint(r))
+			e.quarantineMarket(market, msg, r)
+		}
+	}()
+
+	switch msg.Type {
+	case types.MarketHalt:
+		market.Mu.Lock()
+		halted := market.Status == types.Open
+		if halted {
+			haltMarket(market)
+		}
+		market.Mu.Unlock()
+		log.Warn().Str("symbol", market.Symbol).Msg("Market halted")
+		if halted {
+			e.Publish(ctx, types.MARKET_HALTED, schema.MarketHalted{
+				Symbol: market.Symbol, MarketId: market.MarketId, At: time.Now(), Reason: schema.HaltOperator,
+			})
+		}
+		msg.ReplyChan <- true
+		return
 
-		case types.MarketGetOrderBook:
-			market.Mu.RLock()
-			aggOrderBook := utils.AggregateOrderBook(market.OrderBook)
-			market.Mu.RUnlock()
+	case types.MarketDrain:
+		msg.ReplyChan <- true
+		return
 
-			msg.ReplyChan <- aggOrderBook
+	case types.MarketRestart:
+		operatorId, _ := msg.Payload.(string)
+		msg.ReplyChan <- e.restartMarket(ctx, market, operatorId)
+		return
+	}
 
-		case types.MarketSellOrder:
-			if market.Status == types.Close {
-				msg.ReplyChan <- types.OrderResponse{Success: false, Message: "market is closed"}
-				continue
+	if !msg.Type.IsReadOnly() {
+		// A halted market is frozen until an operator has looked at it
+		if market.Status == types.Halted {
+			if msg.Type == types.MarketResolveMarket {
+				msg.ReplyChan <- false
+			} else {
+				msg.ReplyChan <- types.OrderResponse{Success: false, Message: "market is halted"}
 			}
-			e.handleOrder(msg, market)
+			return
+		}
+		e.MarkTouched(market.Symbol)
+	}
 
-		case types.MarketResolveMarket:
-			e.handleResolveMarket(msg, market)
+	switch msg.Type {
 
-		case types.MarketCancelOrder:
-			e.handleCancelOrder(msg, market)
+	case types.MarketPlaceOrder:
+		if market.Status == types.Close {
+			msg.ReplyChan <- types.OrderResponse{Success: false, Message: "market is closed"}
+			return
+		}
+		e.handleOrder(ctx, msg, market)
 
-		default:
-			log.Error().Str("marketId", market.MarketId).Msg("Unknown message type")
+	case types.MarketGetOrderBook:
+		msg.ReplyChan <- aggregateBook(market)
+
+	case types.MarketInspect:
+		msg.ReplyChan <- viewMarket(market)
+
+	case types.MarketBookAt:
+		seq, _ := msg.Payload.(uint64)
+		snapshot, err := bookAt(market, seq)
+		if err != nil {
+			msg.ReplyChan <- err
+			return
+		}
+		msg.ReplyChan <- snapshot
+
+	case types.MarketSellOrder:
+		if market.Status == types.Close {
+			msg.ReplyChan <- types.OrderResponse{Success: false, Message: "market is closed"}
+			return
 		}
+		e.handleOrder(ctx, msg, market)
+
+	case types.MarketResolveMarket:
+		e.handleResolveMarket(ctx, msg, market)
+
+	case types.MarketCancelOrder:
+		e.handleCancelOrder(ctx, msg, market)
+
+	default:
+		log.Error().Str("marketId", market.MarketId).Msg("Unknown message type")
 	}
 }
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"context"
+	"matching-engine/internals/types"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SetReady flips the readiness flag orchestrators poll before sending traffic.
+func (e *Engine) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&e.ready, v)
+}
+
+// Ready reports whether the engine is accepting traffic.
+func (e *Engine) Ready() bool {
+	return atomic.LoadInt32(&e.ready) == 1
+}
+
+// DrainInboxes waits until every market has handled the messages already queued. It
+// queues a drain marker behind them on each inbox, blocking for space rather than
+// answering MARKET_BUSY, and returns ctx's error if a market does not get there in time.
+func (e *Engine) DrainInboxes(ctx context.Context) error {
+	e.MM.RLock()
+	markets := make([]*types.Market, 0, len(e.Market))
+	for _, market := range e.Market {
+		markets = append(markets, market)
+	}
+	e.MM.RUnlock()
+
+	replies := make([]chan interface{}, 0, len(markets))
+	for _, market := range markets {
+		reply := make(chan interface{}, 1)
+		select {
+		case market.Inbox <- types.MarketMessage{Type: types.MarketDrain, ReplyChan: reply}:
+			replies = append(replies, reply)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for _, reply := range replies {
+		select {
+		case <-reply:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	log.Info().Int("markets", len(markets)).Msg("Market inboxes drained")
+	return nil
+}
@@ -3,29 +3,47 @@ package engine
 import (
 	"bytes"
 	"compress/gzip"
+	"container/heap"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/rs/zerolog/log"
 
+	"matching-engine/internals/config"
+	"matching-engine/internals/events"
+	"matching-engine/internals/metrics"
 	"matching-engine/internals/types"
 )
 
+// snapshotKey is where the Redis store keeps the latest snapshot.
+const snapshotKey = "engine_snapshot:latest"
+
 type SnapshotData struct {
-	Timestamp time.Time                `json:"timestamp"`
-	Users     map[string]*types.User   `json:"users"`
-	Markets   map[string]*types.Market `json:"markets"`
+	Timestamp time.Time `json:"timestamp"`
+	// EventSeq is the seq of the last event numbered when the snapshot was started.
+	EventSeq uint64 `json:"eventSeq,omitempty"`
+	// Drained is set when nothing was in flight, so the state is exactly what the events
+	// up to EventSeq describe. Periodic snapshots are taken while trading goes on and
+	// are not drained.
+	Drained     bool                                `json:"drained,omitempty"`
+	Users       map[string]*types.User              `json:"users"`
+	Markets     map[string]*types.Market            `json:"markets"`
+	Ledger      *types.Ledger                       `json:"ledger"`
+	Adjustments map[string]*types.Adjustment        `json:"adjustments"`
+	Withdrawals map[string]*types.Withdrawal        `json:"withdrawals"`
+	Idempotency map[string]*types.IdempotencyBucket `json:"idempotency"`
 }
 
 func (e *Engine) StartSnapshotRoutine() {
-	// Runs every 10 minutes as requested
-	ticker := time.NewTicker(10 * time.Minute)
+	ticker := time.NewTicker(config.Current().Snapshot.Interval)
 	go func() {
 		for {
 			<-ticker.C
@@ -34,18 +52,69 @@ func (e *Engine) StartSnapshotRoutine() {
 	}()
 }
 
-func (e *Engine) PerformSnapshot() {
+// PerformSnapshot evicts idle users and saves engine state to the configured store.
+// A disabled or unconfigured store is not an error.
+//
+// Trading carries on meanwhile: EventSeq is read first, then markets, journals and users
+// are each copied under their own lock, so the snapshot can hold commands numbered
+// after EventSeq and miss parts of others. Recovery therefore does not replay on top of
+// it; see PerformDrainedSnapshot.
+func (e *Engine) PerformSnapshot() error {
+	return e.performSnapshot(false)
+}
+
+// PerformDrainedSnapshot is PerformSnapshot for callers that have stopped intake and
+// let every market drain, as shutdown does, or whose engine is not running at all. The
+// snapshot is marked Drained.
+func (e *Engine) PerformDrainedSnapshot() error {
+	return e.performSnapshot(true)
+}
+
+func (e *Engine) performSnapshot(drained bool) error {
 	log.Info().Msg("Starting state snapshot and memory eviction routine...")
+	start := time.Now()
+
+	// Read first, so every event up to it is already reflected in what is serialized
+	eventSeq := e.eventSeq()
+
+	// Markets are serialized before taking UM to keep the markets-then-users lock order
+	e.MM.RLock()
+	marketsRaw := make(map[string]json.RawMessage)
+	resting := make(map[string]struct{})
+	for k, m := range e.Market {
+		m.Mu.RLock()
+		mBytes, _ := json.Marshal(m)
+		addRestingOwners(resting, m)
+		m.Mu.RUnlock()
+		marketsRaw[k] = mBytes
+	}
+	e.MM.RUnlock()
+
+	// Journals have their own locks and are serialized before UM as well
+	e.pruneWithdrawals()
+	e.AM.Lock()
+	adjustmentsRaw, _ := json.Marshal(e.Adjustments)
+	e.AM.Unlock()
+	e.WM.Lock()
+	withdrawalsRaw, _ := json.Marshal(e.Withdrawals)
+	e.WM.Unlock()
+	e.IM.Lock()
+	e.pruneIdempotency()
+	idempotencyRaw, _ := json.Marshal(e.Idempotency)
+	e.IM.Unlock()
 
 	e.UM.Lock()
 
-	// 1. Evict inactive users (> 7 days)
-	evictionThreshold := time.Now().Add(-7 * 24 * time.Hour)
+	// 1. Evict users idle longer than snapshot.evictAfter
+	evictionThreshold := time.Now().Add(-config.Current().Snapshot.EvictAfter)
 	evictedCount := 0
 
 	for userId, user := range e.User {
 		// If LastActive is zero, it might be a new user or pre-existing without activity
-		if !user.LastActive.IsZero() && user.LastActive.Before(evictionThreshold) {
+		// Users with a payout in flight or orders resting on a book stay resident so the
+		// confirmation, a cancel or a resolution can still find them
+		if !user.LastActive.IsZero() && user.LastActive.Before(evictionThreshold) && !hasOpenExposure(user, resting) {
+			e.recordEviction(user)
 			delete(e.User, userId)
 			evictedCount++
 		}
@@ -53,54 +122,58 @@ func (e *Engine) PerformSnapshot() {
 
 	log.Info().Int("evicted_users", evictedCount).Msg("Purged inactive users from engine RAM")
 
-	e.MM.RLock()
-	marketsRaw := make(map[string]json.RawMessage)
-	for k, m := range e.Market {
-		m.Mu.RLock()
-		mBytes, _ := json.Marshal(m)
-		m.Mu.RUnlock()
-		marketsRaw[k] = mBytes
-	}
-	e.MM.RUnlock()
-
 	// 2. Serialize State
 	data := struct {
-		Timestamp time.Time                  `json:"timestamp"`
-		Users     map[string]*types.User     `json:"users"`
-		Markets   map[string]json.RawMessage `json:"markets"`
+		Timestamp   time.Time                  `json:"timestamp"`
+		EventSeq    uint64                     `json:"eventSeq,omitempty"`
+		Drained     bool                       `json:"drained,omitempty"`
+		Users       map[string]*types.User     `json:"users"`
+		Markets     map[string]json.RawMessage `json:"markets"`
+		Ledger      *types.Ledger              `json:"ledger"`
+		Adjustments json.RawMessage            `json:"adjustments"`
+		Withdrawals json.RawMessage            `json:"withdrawals"`
+		Idempotency json.RawMessage            `json:"idempotency"`
 	}{
-		Timestamp: time.Now(),
-		Users:     e.User,
-		Markets:   marketsRaw,
+		Timestamp:   time.Now(),
+		EventSeq:    eventSeq,
+		Drained:     drained,
+		Users:       e.User,
+		Markets:     marketsRaw,
+		Ledger:      e.Ledger,
+		Adjustments: adjustmentsRaw,
+		Withdrawals: withdrawalsRaw,
+		Idempotency: idempotencyRaw,
 	}
 
+	e.Ledger.Mu.Lock()
 	jsonData, err := json.Marshal(data)
+	e.Ledger.Mu.Unlock()
 	e.UM.Unlock() // Unlock after serialization to unblock trading
 
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to serialize engine state for snapshot")
-		return
+		return err
 	}
 
-	snapshotEnabled := os.Getenv("SNAPSHOT_ENABLED")
-	if snapshotEnabled != "true" {
+	defer func() { metrics.ObserveSnapshot(time.Since(start), len(jsonData)) }()
+
+	cfg := config.Current().Snapshot
+	if !cfg.Enabled {
 		log.Warn().Msg("SNAPSHOT_ENABLED is not true, skipping snapshot generation.")
-		return
+		return nil
 	}
 
-	snapshotStore := os.Getenv("SNAPSHOT_STORE")
-
-	if snapshotStore == "redis" {
+	if cfg.Store == "redis" {
 		log.Info().Msg("SNAPSHOT_STORE is redis, saving to Redis...")
 		ctx := context.Background()
 		// Save to Redis with 7 days TTL (7 * 24 * 60 * 60 seconds)
-		err := e.Redis.Set(ctx, "engine_snapshot:latest", jsonData, 7*24*time.Hour).Err()
+		err := e.Redis.Set(ctx, snapshotKey, jsonData, 7*24*time.Hour).Err()
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to save snapshot to Redis")
-		} else {
-			log.Info().Msg("Engine state snapshot successfully saved to Redis")
+			return err
 		}
-		return
+		log.Info().Msg("Engine state snapshot successfully saved to Redis")
+		return nil
 	}
 
 	// 3. Compress for S3
@@ -108,29 +181,29 @@ func (e *Engine) PerformSnapshot() {
 	gz := gzip.NewWriter(&b)
 	if _, err := gz.Write(jsonData); err != nil {
 		log.Error().Err(err).Msg("Failed to compress engine state")
-		return
+		return err
 	}
 	if err := gz.Close(); err != nil {
 		log.Error().Err(err).Msg("Failed to close gzip writer")
-		return
+		return err
 	}
 
 	compressedData := b.Bytes()
 
 	// 4. Upload to S3/R2
-	bucketName := os.Getenv("S3_SNAPSHOT_BUCKET")
+	bucketName := cfg.Bucket
 	if bucketName == "" {
 		log.Warn().Msg("S3_SNAPSHOT_BUCKET env var not set, skipping S3 upload. Snapshot generated in memory.")
-		return
+		return nil
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to load AWS config")
-		return
+		return err
 	}
 
-	client := s3.NewFromConfig(cfg)
+	client := s3.NewFromConfig(awsCfg)
 	filename := fmt.Sprintf("engine_snapshot_%d.json.gz", time.Now().Unix())
 
 	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
@@ -141,63 +214,37 @@ func (e *Engine) PerformSnapshot() {
 
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to upload snapshot to S3")
-		return
+		return err
 	}
 
 	log.Info().Str("filename", filename).Msg("Engine state snapshot successfully uploaded to S3")
+	return nil
 }
 
 // LoadLatestSnapshot fetches the latest snapshot and populates the engine.
 func (e *Engine) LoadLatestSnapshot() {
-	snapshotEnabled := os.Getenv("SNAPSHOT_ENABLED")
-	if snapshotEnabled != "true" {
+	cfg := config.Current().Snapshot
+	if !cfg.Enabled {
 		log.Info().Msg("SNAPSHOT_ENABLED not true, skipping snapshot restore on startup")
 		return
 	}
 
-	snapshotStore := os.Getenv("SNAPSHOT_STORE")
-
-	if snapshotStore == "redis" {
+	if cfg.Store == "redis" {
 		log.Info().Msg("Attempting to load snapshot from Redis...")
-		ctx := context.Background()
-		jsonData, err := e.Redis.Get(ctx, "engine_snapshot:latest").Bytes()
+		data, err := e.ReadLatestSnapshot(context.Background())
 		if err != nil {
 			log.Info().Err(err).Msg("No snapshot found in Redis or failed to read")
 			return
 		}
 
-		var data SnapshotData
-		if err := json.Unmarshal(jsonData, &data); err != nil {
-			log.Error().Err(err).Msg("Failed to unmarshal snapshot from Redis")
-			return
-		}
-
-		e.UM.Lock()
-		e.User = data.Users
-		e.UM.Unlock()
-
-		e.MM.Lock()
-		e.Market = data.Markets
-		if e.Market == nil {
-			e.Market = make(map[string]*types.Market)
-		}
-		// Re-initialize channels and start goroutines for each market
-		for key, market := range e.Market {
-			if market == nil {
-				log.Warn().Str("market_key", key).Msg("Found nil market in snapshot, skipping")
-				delete(e.Market, key)
-				continue
-			}
-			market.Inbox = make(chan types.MarketMessage, 100)
-			go e.runMarket(market)
-		}
-		e.MM.Unlock()
+		e.Restore(data)
+		e.StartMarkets()
 
-		log.Info().Time("snapshot_timestamp", data.Timestamp).Int("users_loaded", len(data.Users)).Int("markets_loaded", len(e.Market)).Msg("Successfully restored snapshot from Redis")
+		log.Info().Time("snapshot_timestamp", data.Timestamp).Uint64("eventSeq", data.EventSeq).Bool("drained", data.Drained).Int("users_loaded", len(data.Users)).Int("markets_loaded", len(e.Market)).Msg("Successfully restored snapshot from Redis")
 		return
 	}
 
-	bucketName := os.Getenv("S3_SNAPSHOT_BUCKET")
+	bucketName := cfg.Bucket
 	if bucketName == "" {
 		log.Info().Msg("S3_SNAPSHOT_BUCKET not set, skipping S3 snapshot restore on startup")
 		return
@@ -205,3 +252,158 @@ func (e *Engine) LoadLatestSnapshot() {
 
 	log.Info().Msg("Snapshot restoration logic initialized (ready for S3 sync)")
 }
+
+// ReadLatestSnapshot fetches the snapshot the Redis store holds, whether or not
+// snapshots are enabled.
+func (e *Engine) ReadLatestSnapshot(ctx context.Context) (*SnapshotData, error) {
+	jsonData, err := e.Redis.Get(ctx, snapshotKey).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var data SnapshotData
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot: %w", err)
+	}
+	return &data, nil
+}
+
+// ReadSnapshotFile reads a snapshot from disk, either plain JSON or gzipped as it is
+// uploaded to S3.
+func ReadSnapshotFile(path string) (*SnapshotData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var data SnapshotData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot %s: %w", path, err)
+	}
+	return &data, nil
+}
+
+// Restore replaces the engine's state with a snapshot's. Markets are installed but not
+// started, see StartMarkets.
+func (e *Engine) Restore(data *SnapshotData) {
+	e.UM.Lock()
+	e.User = data.Users
+	if e.User == nil {
+		e.User = make(map[string]*types.User)
+	}
+	e.UM.Unlock()
+
+	e.MM.Lock()
+	e.Market = data.Markets
+	if e.Market == nil {
+		e.Market = make(map[string]*types.Market)
+	}
+	for key, market := range e.Market {
+		if market == nil {
+			log.Warn().Str("market_key", key).Msg("Found nil market in snapshot, skipping")
+			delete(e.Market, key)
+			continue
+		}
+		restoreHeapOrder(market.OrderBook)
+	}
+	e.MM.Unlock()
+
+	// Snapshots taken before the ledger existed carry no baseline, so start one from the restored state
+	if data.Ledger != nil {
+		e.Ledger = data.Ledger
+	} else {
+		e.RebaseLedger()
+	}
+
+	if data.Adjustments != nil {
+		e.AM.Lock()
+		e.Adjustments = data.Adjustments
+		e.AM.Unlock()
+	}
+
+	if data.Withdrawals != nil {
+		e.WM.Lock()
+		e.Withdrawals = data.Withdrawals
+		e.WM.Unlock()
+	}
+
+	if data.Idempotency != nil {
+		e.IM.Lock()
+		e.Idempotency = data.Idempotency
+		e.IM.Unlock()
+	}
+
+	// Without a journal the outbox would number events from 1 again
+	if seq, ok := e.Events.(events.Sequencer); ok {
+		seq.Resume(data.EventSeq)
+	}
+}
+
+// eventSeq returns the seq of the last event committed, or 0 if the publisher does not
+// number events.
+func (e *Engine) eventSeq() uint64 {
+	if seq, ok := e.Events.(events.Sequencer); ok {
+		return seq.Seq()
+	}
+	return 0
+}
+
+// hasOpenExposure reports whether the user still has cash or shares tied up in resting
+// orders or a pending withdrawal, or is among resting, the owners of orders on a book.
+// ADMIN orders lock nothing, so only resting finds them.
+func hasOpenExposure(user *types.User, resting map[string]struct{}) bool {
+	if _, ok := resting[user.ID]; ok {
+		return true
+	}
+	wallet := user.Balance.WalletBalance
+	if wallet.Held != 0 || wallet.Locked != 0 {
+		return true
+	}
+	for _, stock := range user.Balance.StockBalance {
+		if stock.LockedYes != 0 || stock.LockedNo != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// addRestingOwners adds the owner of every order on market's book to owners. Caller
+// must hold market.Mu.
+func addRestingOwners(owners map[string]struct{}, market *types.Market) {
+	for _, orders := range bookSides(market.OrderBook) {
+		for _, order := range orders {
+			owners[order.UserId] = struct{}{}
+		}
+	}
+}
+
+// restoreHeapOrder re-heapifies every side of a restored book. Books saved while cancels
+// spliced orders out of the slice can be out of heap order.
+func restoreHeapOrder(book *types.OrderBook) {
+	if book == nil {
+		return
+	}
+	if book.YesBids != nil {
+		heap.Init(book.YesBids)
+	}
+	if book.YesAsks != nil {
+		heap.Init(book.YesAsks)
+	}
+	if book.NoBids != nil {
+		heap.Init(book.NoBids)
+	}
+	if book.NoAsks != nil {
+		heap.Init(book.NoAsks)
+	}
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"matching-engine/internals/config"
+	"matching-engine/internals/types"
+	"testing"
+	"time"
+)
+
+func TestPerformSnapshotEviction(t *testing.T) {
+	previous := *config.Current()
+	cfg := previous
+	cfg.Snapshot.Enabled, cfg.Snapshot.EvictAfter = false, time.Hour
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+
+	tests := []struct {
+		name    string
+		active  time.Duration
+		setup   func(e *Engine, u *types.User)
+		evicted bool
+	}{
+		{name: "idle with nothing at stake", active: 2 * time.Hour, setup: func(e *Engine, u *types.User) {}, evicted: true},
+		{name: "recently active", active: time.Minute, setup: func(e *Engine, u *types.User) {}},
+		{
+			name: "idle with cash locked", active: 2 * time.Hour,
+			setup: func(e *Engine, u *types.User) { u.Balance.WalletBalance.Locked = 5 },
+		},
+		{
+			name: "idle with a withdrawal held", active: 2 * time.Hour,
+			setup: func(e *Engine, u *types.User) { u.Balance.WalletBalance.Held = 5 },
+		},
+		{
+			name: "idle with shares in escrow", active: 2 * time.Hour,
+			setup: func(e *Engine, u *types.User) { u.Balance.StockBalance["RAIN"] = types.StockBalance{LockedYes: 1} },
+		},
+		{
+			name: "idle ADMIN with an order resting", active: 2 * time.Hour,
+			setup: func(e *Engine, u *types.User) {
+				e.Market["RAIN"].OrderBook.YesAsks.Push(&types.Order{OrderId: "o1", UserId: u.ID, Role: types.ADMIN, Price: 6, Quantity: 10, Side: types.Yes, Action: types.SELL})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testEngine()
+			e.Market["RAIN"] = testMarket("RAIN")
+			user := testUser("alice", 100)
+			user.LastActive = time.Now().Add(-tt.active)
+			e.User["alice"] = user
+			tt.setup(e, user)
+
+			if err := e.PerformSnapshot(); err != nil {
+				t.Fatal(err)
+			}
+			if _, resident := e.User["alice"]; resident == tt.evicted {
+				t.Errorf("resident %v, want evicted %v", resident, tt.evicted)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/types"
+	"runtime/debug"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrMarketNotHalted is returned when restarting a market that is not halted.
+	ErrMarketNotHalted = errors.New("market is not halted")
+	// ErrBookInvalid means the book failed verification and the market stays halted.
+	ErrBookInvalid = errors.New("order book failed verification")
+)
+
+// restartResult is what the market goroutine replies to a restart request.
+type restartResult struct {
+	violations []types.InvariantViolation
+	err        error
+}
+
+// quarantineMarket runs after a panic in the market goroutine. It halts the market,
+// keeps a copy of its book for the operator, raises an alert and then fails the message
+// that was being handled.
+func (e *Engine) quarantineMarket(market *types.Market, msg types.MarketMessage, r interface{}) {
+	stack := string(debug.Stack())
+
+	market.Mu.Lock()
+	haltMarket(market)
+	book := snapshotBook(market.OrderBook)
+	market.Mu.Unlock()
+
+	diag := types.MarketPanic{
+		Symbol:      market.Symbol,
+		MarketId:    market.MarketId,
+		Panic:       fmt.Sprint(r),
+		Stack:       stack,
+		MessageType: msg.Type,
+		Payload:     msg.Payload,
+		At:          time.Now(),
+		Book:        book,
+	}
+
+	e.PM.Lock()
+	e.Panics[market.Symbol] = diag
+	e.PM.Unlock()
+
+	log.Error().
+		Str("symbol", market.Symbol).
+		Str("messageType", string(msg.Type)).
+		Interface("panic", r).
+		Str("stack", stack).
+		Msg("Market goroutine panicked, market halted")
+
+	e.Publish(context.Background(), types.MARKET_HALTED, marketHalted(diag))
+
+	// The handler may have replied before it panicked; never block on a full channel
+	var reply interface{} = types.OrderResponse{Success: false, Message: "market halted after internal error"}
+	if msg.Type == types.MarketResolveMarket || msg.Type == types.MarketHalt {
+		reply = false
+	} else if msg.Type == types.MarketRestart {
+		reply = restartResult{err: fmt.Errorf("restart panicked: %v", r)}
+	}
+	select {
+	case msg.ReplyChan <- reply:
+	default:
+	}
+}
+
+// haltMarket freezes market, remembering the status a restart returns it to, so a
+// market that panicked while resolving stays closed. Caller must hold market.Mu.
+func haltMarket(market *types.Market) {
+	if market.Status == types.Halted {
+		return
+	}
+	market.HaltedFrom = market.Status
+	market.Status = types.Halted
+}
+
+// reopenMarket undoes haltMarket. Markets halted before HaltedFrom was kept reopen.
+// Caller must hold market.Mu.
+func reopenMarket(market *types.Market) {
+	market.Status = market.HaltedFrom
+	if market.Status == "" {
+		market.Status = types.Open
+	}
+	market.HaltedFrom = ""
+}
+
+// MarketPanic returns the diagnostic captured when the market last panicked.
+func (e *Engine) MarketPanic(symbol string) (types.MarketPanic, bool) {
+	e.PM.Lock()
+	defer e.PM.Unlock()
+
+	diag, ok := e.Panics[symbol]
+	return diag, ok
+}
+
+// RestartMarket reopens a halted market once its book and balances verify. On failure
+// the violations found are returned alongside ErrBookInvalid and the market stays halted.
+func (e *Engine) RestartMarket(ctx context.Context, symbol, operatorId string) ([]types.InvariantViolation, error) {
+	if operatorId == "" {
+		return nil, ErrMissingOperator
+	}
+	market, ok := e.GetMarket(symbol)
+	if !ok {
+		return nil, ErrMarketNotFound
+	}
+
+	resp, err := e.Ask(ctx, market, types.MarketRestart, operatorId)
+	if err != nil {
+		return nil, err
+	}
+	result := resp.(restartResult)
+	return result.violations, result.err
+}
+
+// restartMarket runs on the market goroutine, so nothing else touches the book while
+// it is verified.
+func (e *Engine) restartMarket(ctx context.Context, market *types.Market, operatorId string) restartResult {
+	market.Mu.RLock()
+	status := market.Status
+	market.Mu.RUnlock()
+	if status != types.Halted {
+		return restartResult{err: ErrMarketNotHalted}
+	}
+
+	violations := e.verifyBook(market)
+
+	// Balances must agree with the book too; any global cash drift counts against this market
+	report := e.CheckInvariants(map[string]struct{}{market.Symbol: {}})
+	for _, diag := range report.Markets {
+		if diag.Symbol != market.Symbol {
+			continue
+		}
+		// Violations without a symbol (locked cash, global cash) were blamed on this market
+		for _, v := range report.Violations {
+			if v.Symbol == market.Symbol || v.Symbol == "" {
+				violations = append(violations, v)
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		log.Warn().
+			Str("symbol", market.Symbol).
+			Str("operatorId", operatorId).
+			Int("violations", len(violations)).
+			Msg("Market restart refused, book failed verification")
+		return restartResult{violations: violations, err: ErrBookInvalid}
+	}
+
+	market.Mu.Lock()
+	reopenMarket(market)
+	market.Mu.Unlock()
+
+	e.PM.Lock()
+	delete(e.Panics, market.Symbol)
+	e.PM.Unlock()
+
+	log.Info().Str("symbol", market.Symbol).Str("operatorId", operatorId).Msg("Market restarted")
+	e.Publish(ctx, types.MARKET_RESTARTED, schema.MarketRestarted{
+		MarketId: market.MarketId, Symbol: market.Symbol, OperatorId: operatorId, Timestamp: time.Now(),
+	})
+
+	return restartResult{}
+}
+
+// verifyBook checks the structure of a market's book: every order sits on the heap for
+// its side and action, quantities and prices are in range, ids are unique, the heaps
+// are ordered and every resting order belongs to a user in memory.
+func (e *Engine) verifyBook(market *types.Market) []types.InvariantViolation {
+	market.Mu.RLock()
+	defer market.Mu.RUnlock()
+	e.UM.RLock()
+	defer e.UM.RUnlock()
+
+	var violations []types.InvariantViolation
+	flag := func(order *types.Order, detail string) {
+		v := types.InvariantViolation{Check: types.BookCorrupt, Symbol: market.Symbol, Detail: detail}
+		if order != nil {
+			v.UserId = order.UserId
+			v.OrderId = order.OrderId
+		}
+		violations = append(violations, v)
+	}
+
+	book := market.OrderBook
+	if book == nil || book.YesBids == nil || book.YesAsks == nil || book.NoBids == nil || book.NoAsks == nil {
+		flag(nil, "order book is missing a side")
+		return violations
+	}
+
+	seen := make(map[string]struct{})
+	for _, side := range []struct {
+		name   string
+		side   types.Side
+		action types.Action
+		orders types.OrderHeap
+		less   func(i, j int) bool
+	}{
+		{"yesBids", types.Yes, types.BUY, book.YesBids.OrderHeap, book.YesBids.Less},
+		{"yesAsks", types.Yes, types.SELL, book.YesAsks.OrderHeap, book.YesAsks.Less},
+		{"noBids", types.No, types.BUY, book.NoBids.OrderHeap, book.NoBids.Less},
+		{"noAsks", types.No, types.SELL, book.NoAsks.OrderHeap, book.NoAsks.Less},
+	} {
+		for i, order := range side.orders {
+			if order == nil {
+				flag(nil, fmt.Sprintf("%s[%d] is nil", side.name, i))
+				continue
+			}
+			if order.Side != side.side || order.Action != side.action {
+				flag(order, fmt.Sprintf("%s %s order resting in %s", order.Action, order.Side, side.name))
+			}
+			if order.Symbol != market.Symbol {
+				flag(order, fmt.Sprintf("order for %s resting in this book", order.Symbol))
+			}
+			if order.Quantity <= 0 || order.Filled < 0 || order.Filled >= order.Quantity {
+				flag(order, fmt.Sprintf("filled %d of %d is not a resting quantity", order.Filled, order.Quantity))
+			}
+			if order.Price <= 0 || order.Price >= payoutPerShare() {
+				flag(order, fmt.Sprintf("price %.2f outside (0, %.0f)", order.Price, payoutPerShare()))
+			}
+			if _, dup := seen[order.OrderId]; dup {
+				flag(order, "order id appears more than once")
+			}
+			seen[order.OrderId] = struct{}{}
+			if _, ok := e.User[order.UserId]; !ok {
+				flag(order, "owner is not in memory")
+			}
+			if i > 0 && side.orders[(i-1)/2] != nil && side.less(i, (i-1)/2) {
+				flag(order, fmt.Sprintf("%s is out of heap order at %d", side.name, i))
+			}
+		}
+	}
+
+	return violations
+}
+
+// snapshotBook copies the resting orders so the diagnostic survives later changes.
+func snapshotBook(book *types.OrderBook) types.BookSnapshot {
+	var snap types.BookSnapshot
+	if book == nil {
+		return snap
+	}
+	copyHeap := func(h types.OrderHeap) []types.Order {
+		orders := make([]types.Order, 0, len(h))
+		for _, order := range h {
+			if order != nil {
+				orders = append(orders, *order)
+			}
+		}
+		return orders
+	}
+	if book.YesBids != nil {
+		snap.YesBids = copyHeap(book.YesBids.OrderHeap)
+	}
+	if book.YesAsks != nil {
+		snap.YesAsks = copyHeap(book.YesAsks.OrderHeap)
+	}
+	if book.NoBids != nil {
+		snap.NoBids = copyHeap(book.NoBids.OrderHeap)
+	}
+	if book.NoAsks != nil {
+		snap.NoAsks = copyHeap(book.NoAsks.OrderHeap)
+	}
+	return snap
+}
+
+// marketHalted flattens a panic diagnostic for the event stream. The payload is kept
+// as JSON since its shape depends on the message type.
+func marketHalted(diag types.MarketPanic) schema.MarketHalted {
+	payload, err := json.Marshal(diag.Payload)
+	if err != nil {
+		payload = []byte(fmt.Sprintf("%q", fmt.Sprint(diag.Payload)))
+	}
+
+	event := schema.MarketHalted{
+		Symbol:      diag.Symbol,
+		MarketId:    diag.MarketId,
+		Panic:       diag.Panic,
+		Stack:       diag.Stack,
+		MessageType: string(diag.MessageType),
+		Payload:     string(payload),
+		At:          diag.At,
+		Reason:      schema.HaltPanic,
+	}
+	for _, orders := range [][]types.Order{diag.Book.YesBids, diag.Book.YesAsks, diag.Book.NoBids, diag.Book.NoAsks} {
+		for _, order := range orders {
+			event.Book = append(event.Book, schema.BookOrder{
+				OrderId: order.OrderId, UserId: order.UserId, Side: string(order.Side), Action: string(order.Action),
+				Price: order.Price, Quantity: order.Quantity, Filled: order.Filled, Timestamp: order.Timestamp,
+			})
+		}
+	}
+	return event
+}
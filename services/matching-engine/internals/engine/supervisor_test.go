@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/types"
+	"testing"
+)
+
+func TestRestartMarketRestoresStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status types.MarketStatus
+		halt   func(e *Engine, market *types.Market)
+		want   types.MarketStatus
+	}{
+		{
+			name:   "panic while open",
+			status: types.Open,
+			halt: func(e *Engine, market *types.Market) {
+				e.quarantineMarket(market, types.MarketMessage{Type: types.MarketPlaceOrder}, "boom")
+			},
+			want: types.Open,
+		},
+		{
+			name:   "panic while resolving",
+			status: types.Close,
+			halt: func(e *Engine, market *types.Market) {
+				e.quarantineMarket(market, types.MarketMessage{Type: types.MarketResolveMarket}, "boom")
+			},
+			want: types.Close,
+		},
+		{
+			name:   "halted by the invariant checker",
+			status: types.Open,
+			halt: func(e *Engine, market *types.Market) {
+				e.User["bob"].Balance.StockBalance["RAIN"] = types.StockBalance{No: 2, LockedNo: 1}
+				e.RunInvariantCheck()
+				e.User["bob"].Balance.StockBalance["RAIN"] = types.StockBalance{No: 3}
+			},
+			want: types.Open,
+		},
+		{
+			name:   "halted before the status was kept",
+			status: types.Halted,
+			halt:   func(e *Engine, market *types.Market) {},
+			want:   types.Open,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := balancedEngine()
+			market := e.Market["RAIN"]
+			market.Status = tt.status
+			tt.halt(e, market)
+			if market.Status != types.Halted {
+				t.Fatalf("market is %s after halting, want %s", market.Status, types.Halted)
+			}
+
+			result := e.restartMarket(context.Background(), market, "ops-1")
+			if result.err != nil {
+				t.Fatalf("restart: %v %+v", result.err, result.violations)
+			}
+			if market.Status != tt.want {
+				t.Errorf("market is %s after restart, want %s", market.Status, tt.want)
+			}
+			if again := e.restartMarket(context.Background(), market, "ops-1"); !errors.Is(again.err, ErrMarketNotHalted) {
+				t.Errorf("second restart: error %v, want %v", again.err, ErrMarketNotHalted)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"context"
+	"matching-engine/internals/types"
+	"time"
+)
+
+// Why a BALANCE update was sent.
+const (
+	BalanceOrderAccepted  = "ORDER_ACCEPTED"
+	BalanceTrade          = "TRADE"
+	BalanceOrderCancelled = "ORDER_CANCELLED"
+	BalanceOrderExpired   = "ORDER_EXPIRED"
+	BalanceDeposit        = "DEPOSIT"
+	BalanceWithdrawal     = "WITHDRAWAL"
+	BalanceSplitMerge     = "SPLIT_MERGE"
+)
+
+// notifyOrder sends an ORDER_UPDATE with the order as it stands to its owner.
+func (e *Engine) notifyOrder(ctx context.Context, order *types.Order, status types.OrderStatus, reason string) {
+	e.broadcastUser(ctx, order.UserId, map[string]interface{}{
+		"type":   "ORDER_UPDATE",
+		"userId": order.UserId,
+		"order":  orderUpdate(order, status, reason),
+	})
+}
+
+// notifyFill sends the order's owner the fill of qty at price, on the order's own outcome.
+func (e *Engine) notifyFill(ctx context.Context, order *types.Order, qty int, price float64) {
+	status := types.OrderPartiallyFilled
+	if order.Filled >= order.Quantity {
+		status = types.OrderFilled
+	}
+	update := orderUpdate(order, status, "")
+	update.LastQuantity, update.LastPrice = qty, price
+	e.broadcastUser(ctx, order.UserId, map[string]interface{}{
+		"type":   "ORDER_UPDATE",
+		"userId": order.UserId,
+		"order":  update,
+	})
+}
+
+func orderUpdate(order *types.Order, status types.OrderStatus, reason string) types.OrderUpdate {
+	update := types.OrderUpdate{
+		OrderId: order.OrderId, MarketId: order.MarketId, Symbol: order.Symbol,
+		Side: order.Side, Action: order.Action, OrderType: order.OrderType,
+		Status: status, Price: order.Price, Quantity: order.Quantity, Filled: order.Filled,
+		Remaining: order.Quantity - order.Filled, Reason: reason, Timestamp: time.Now(),
+	}
+	if order.Filled > 0 {
+		update.AvgPrice = order.FilledNotional / float64(order.Filled)
+	}
+	return update
+}
+
+// BalanceViewOf copies the user's balances for a BALANCE update. The caller holds the
+// user's Mutex or UM exclusively.
+func BalanceViewOf(user *types.User) types.BalanceView {
+	view := types.BalanceView{
+		Cash:      user.Balance.WalletBalance.Amount,
+		Locked:    user.Balance.WalletBalance.Locked,
+		Held:      user.Balance.WalletBalance.Held,
+		Positions: make(map[string]types.Position, len(user.Balance.StockBalance)),
+	}
+	for symbol, stock := range user.Balance.StockBalance {
+		view.Positions[symbol] = types.Position(stock)
+	}
+	return view
+}
+
+// NotifyBalance sends a BALANCE update with a view taken by BalanceViewOf. Callers
+// take the view under their locks and send it after releasing them.
+func (e *Engine) NotifyBalance(ctx context.Context, userId string, view types.BalanceView, reason string) {
+	e.broadcastUser(ctx, userId, map[string]interface{}{
+		"type":    "BALANCE",
+		"userId":  userId,
+		"reason":  reason,
+		"balance": view,
+	})
+}
+
+// notifyBalanceOf is NotifyBalance for callers holding no user locks.
+func (e *Engine) notifyBalanceOf(ctx context.Context, userId, reason string) {
+	e.UM.RLock()
+	user, ok := e.User[userId]
+	if !ok {
+		e.UM.RUnlock()
+		return
+	}
+	user.Mutex.Lock()
+	view := BalanceViewOf(user)
+	user.Mutex.Unlock()
+	e.UM.RUnlock()
+	e.NotifyBalance(ctx, userId, view, reason)
+}
@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/config"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/types"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrWithdrawalUserNotFound = errors.New("user not found")
+	ErrWithdrawalNotVerified  = errors.New("kyc and payment method is not verified")
+	ErrWithdrawalCooldown     = errors.New("withdrawals are paused after a recent deposit")
+	ErrWithdrawalDailyLimit   = errors.New("daily withdrawal limit exceeded")
+	ErrWithdrawalMonthlyLimit = errors.New("monthly withdrawal limit exceeded")
+	ErrWithdrawalInsufficient = errors.New("insufficient balance for withdrawal")
+	ErrWithdrawalNotFound     = errors.New("withdrawal not found")
+	ErrWithdrawalNotHeld      = errors.New("withdrawal is no longer on hold")
+)
+
+// withdrawalRetention is how long resolved withdrawals are kept for the monthly limit.
+const withdrawalRetention = 31 * 24 * time.Hour
+
+// RequestWithdrawal moves funds from the spendable wallet into a withdrawal hold.
+// The money only leaves the engine once ConfirmWithdrawal is called by the payout system.
+func (e *Engine) RequestWithdrawal(ctx context.Context, userId string, amount float64) (types.Withdrawal, error) {
+	withdrawal, view, err := e.holdWithdrawal(userId, amount)
+	if err != nil {
+		return types.Withdrawal{}, err
+	}
+
+	// Nothing can resolve the withdrawal before its id is out, so publishing after the
+	// locks are released keeps the request ahead of its resolution.
+	e.NotifyBalance(ctx, userId, view, BalanceWithdrawal)
+	e.Publish(ctx, types.WITHDRAWAL_REQUESTED, withdrawalEvent(&withdrawal))
+
+	log.Info().
+		Str("userId", userId).
+		Str("withdrawalId", withdrawal.WithdrawalId).
+		Float64("amount", amount).
+		Msg("Withdrawal placed on hold")
+
+	return withdrawal, nil
+}
+
+// holdWithdrawal checks the limits and moves the amount into the hold, returning the
+// withdrawal and the user's balances as they are afterwards.
+func (e *Engine) holdWithdrawal(userId string, amount float64) (types.Withdrawal, types.BalanceView, error) {
+	e.WM.Lock()
+	defer e.WM.Unlock()
+
+	e.UM.RLock()
+	defer e.UM.RUnlock()
+	user, exists := e.User[userId]
+	if !exists {
+		return types.Withdrawal{}, types.BalanceView{}, ErrWithdrawalUserNotFound
+	}
+
+	user.Mutex.Lock()
+	defer user.Mutex.Unlock()
+
+	limit, allowed := config.Current().Withdraw.Limits[user.KycVerificationStatus]
+	if !allowed || user.PaymentVerificationStatus != types.PAYMENT_VERIFIED {
+		return types.Withdrawal{}, types.BalanceView{}, ErrWithdrawalNotVerified
+	}
+
+	now := time.Now()
+	if !user.LastDepositAt.IsZero() && now.Sub(user.LastDepositAt) < e.WithdrawalCooldown {
+		return types.Withdrawal{}, types.BalanceView{}, ErrWithdrawalCooldown
+	}
+
+	daily, monthly := e.withdrawnSince(userId, now)
+	if daily+amount > limit.Daily {
+		return types.Withdrawal{}, types.BalanceView{}, ErrWithdrawalDailyLimit
+	}
+	if monthly+amount > limit.Monthly {
+		return types.Withdrawal{}, types.BalanceView{}, ErrWithdrawalMonthlyLimit
+	}
+
+	if user.Balance.WalletBalance.Amount < amount {
+		return types.Withdrawal{}, types.BalanceView{}, ErrWithdrawalInsufficient
+	}
+
+	user.Balance.WalletBalance.Amount -= amount
+	user.Balance.WalletBalance.Held += amount
+
+	withdrawal := &types.Withdrawal{
+		WithdrawalId: uuid.New().String(),
+		UserId:       userId,
+		Amount:       amount,
+		Status:       types.WithdrawalHeld,
+		RequestedAt:  now,
+	}
+	e.Withdrawals[withdrawal.WithdrawalId] = withdrawal
+
+	return *withdrawal, BalanceViewOf(user), nil
+}
+
+// ConfirmWithdrawal releases the hold once the payout succeeded.
+func (e *Engine) ConfirmWithdrawal(ctx context.Context, withdrawalId string) (types.Withdrawal, error) {
+	return e.resolveWithdrawal(ctx, withdrawalId, "", types.WithdrawalConfirmed, "")
+}
+
+// FailWithdrawal returns held funds to the wallet when the payout failed.
+func (e *Engine) FailWithdrawal(ctx context.Context, withdrawalId, reason string) (types.Withdrawal, error) {
+	return e.resolveWithdrawal(ctx, withdrawalId, "", types.WithdrawalFailed, reason)
+}
+
+// CancelWithdrawal lets the owner take back a withdrawal that has not been paid out yet.
+func (e *Engine) CancelWithdrawal(ctx context.Context, withdrawalId, userId string) (types.Withdrawal, error) {
+	return e.resolveWithdrawal(ctx, withdrawalId, userId, types.WithdrawalCancelled, "cancelled by user")
+}
+
+func (e *Engine) resolveWithdrawal(ctx context.Context, withdrawalId, owner string, status types.WithdrawalStatus, reason string) (types.Withdrawal, error) {
+	withdrawal, view, err := e.settleWithdrawal(withdrawalId, owner, status, reason)
+	if err != nil {
+		return withdrawal, err
+	}
+
+	event := types.WITHDRAWAL_CONFIRMED
+	switch status {
+	case types.WithdrawalFailed:
+		event = types.WITHDRAWAL_FAILED
+	case types.WithdrawalCancelled:
+		event = types.WITHDRAWAL_CANCELLED
+	}
+	e.NotifyBalance(ctx, withdrawal.UserId, view, BalanceWithdrawal)
+	e.Publish(ctx, event, withdrawalEvent(&withdrawal))
+
+	log.Info().
+		Str("userId", withdrawal.UserId).
+		Str("withdrawalId", withdrawalId).
+		Str("status", string(status)).
+		Float64("amount", withdrawal.Amount).
+		Msg("Withdrawal resolved")
+
+	return withdrawal, nil
+}
+
+// settleWithdrawal moves a held withdrawal into status, returning it and the owner's
+// balances as they are afterwards.
+func (e *Engine) settleWithdrawal(withdrawalId, owner string, status types.WithdrawalStatus, reason string) (types.Withdrawal, types.BalanceView, error) {
+	e.WM.Lock()
+	defer e.WM.Unlock()
+
+	withdrawal, ok := e.Withdrawals[withdrawalId]
+	if !ok || (owner != "" && withdrawal.UserId != owner) {
+		return types.Withdrawal{}, types.BalanceView{}, ErrWithdrawalNotFound
+	}
+	if withdrawal.Status != types.WithdrawalHeld {
+		return *withdrawal, types.BalanceView{}, ErrWithdrawalNotHeld
+	}
+
+	e.UM.RLock()
+	defer e.UM.RUnlock()
+	user, exists := e.User[withdrawal.UserId]
+	if !exists {
+		return *withdrawal, types.BalanceView{}, ErrWithdrawalUserNotFound
+	}
+
+	user.Mutex.Lock()
+	defer user.Mutex.Unlock()
+	user.Balance.WalletBalance.Held -= withdrawal.Amount
+	if status == types.WithdrawalConfirmed {
+		e.RecordFunding(-withdrawal.Amount)
+	} else {
+		user.Balance.WalletBalance.Amount += withdrawal.Amount
+	}
+
+	withdrawal.Status = status
+	withdrawal.Reason = reason
+	withdrawal.ResolvedAt = time.Now()
+
+	return *withdrawal, BalanceViewOf(user), nil
+}
+
+// withdrawnSince sums held and confirmed withdrawals over the last 24 hours and 30 days.
+// Caller must hold WM.
+func (e *Engine) withdrawnSince(userId string, now time.Time) (daily, monthly float64) {
+	dayAgo := now.Add(-24 * time.Hour)
+	monthAgo := now.Add(-30 * 24 * time.Hour)
+
+	for _, w := range e.Withdrawals {
+		if w.UserId != userId || w.RequestedAt.Before(monthAgo) {
+			continue
+		}
+		if w.Status != types.WithdrawalHeld && w.Status != types.WithdrawalConfirmed {
+			continue
+		}
+		monthly += w.Amount
+		if w.RequestedAt.After(dayAgo) {
+			daily += w.Amount
+		}
+	}
+	return daily, monthly
+}
+
+// pruneWithdrawals drops resolved withdrawals that no longer count towards any limit.
+func (e *Engine) pruneWithdrawals() {
+	e.WM.Lock()
+	defer e.WM.Unlock()
+
+	cutoff := time.Now().Add(-withdrawalRetention)
+	for id, w := range e.Withdrawals {
+		if w.Status != types.WithdrawalHeld && w.RequestedAt.Before(cutoff) {
+			delete(e.Withdrawals, id)
+		}
+	}
+}
+
+func withdrawalEvent(w *types.Withdrawal) schema.Withdrawal {
+	return schema.Withdrawal{
+		WithdrawalId: w.WithdrawalId, UserId: w.UserId, Amount: w.Amount, Status: string(w.Status),
+		Reason: w.Reason, RequestedAt: w.RequestedAt, ResolvedAt: w.ResolvedAt,
+	}
+}
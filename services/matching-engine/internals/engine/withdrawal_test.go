@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/config"
+	"matching-engine/internals/types"
+	"testing"
+	"time"
+)
+
+// withWithdrawLimits runs a test with the withdrawal limits given, restoring the config after.
+func withWithdrawLimits(t *testing.T, limits map[types.KycStatus]types.WithdrawalLimit) {
+	previous := *config.Current()
+	cfg := previous
+	cfg.Withdraw.Limits = limits
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+}
+
+func TestRequestWithdrawalLimits(t *testing.T) {
+	withWithdrawLimits(t, map[types.KycStatus]types.WithdrawalLimit{
+		types.KYC_VERIFIED: {Daily: 1000, Monthly: 3000},
+		types.KYC_PENDING:  {Daily: 100, Monthly: 100},
+	})
+	now := time.Now()
+	past := func(status types.WithdrawalStatus, amount float64, ago time.Duration) *types.Withdrawal {
+		return &types.Withdrawal{UserId: "alice", Amount: amount, Status: status, RequestedAt: now.Add(-ago)}
+	}
+
+	tests := []struct {
+		name     string
+		kyc      types.KycStatus
+		deposit  time.Duration
+		wallet   float64
+		history  []*types.Withdrawal
+		amount   float64
+		err      error
+		wantHeld float64
+	}{
+		{name: "within both limits", kyc: types.KYC_VERIFIED, amount: 1000, wantHeld: 1000},
+		{name: "over the daily limit", kyc: types.KYC_VERIFIED, amount: 1000.01, err: ErrWithdrawalDailyLimit},
+		{
+			name: "held and confirmed count towards the day", kyc: types.KYC_VERIFIED, amount: 300,
+			history: []*types.Withdrawal{past(types.WithdrawalHeld, 400, time.Hour), past(types.WithdrawalConfirmed, 400, 23*time.Hour)},
+			err:     ErrWithdrawalDailyLimit,
+		},
+		{
+			name: "failed and cancelled do not count", kyc: types.KYC_VERIFIED, amount: 1000, wantHeld: 1000,
+			history: []*types.Withdrawal{past(types.WithdrawalFailed, 900, time.Hour), past(types.WithdrawalCancelled, 900, time.Hour)},
+		},
+		{
+			name: "the day rolls over", kyc: types.KYC_VERIFIED, amount: 1000, wantHeld: 1000,
+			history: []*types.Withdrawal{past(types.WithdrawalConfirmed, 1000, 25*time.Hour)},
+		},
+		{
+			name: "over the monthly limit", kyc: types.KYC_VERIFIED, amount: 600,
+			history: []*types.Withdrawal{past(types.WithdrawalConfirmed, 1000, 2*24*time.Hour), past(types.WithdrawalConfirmed, 1000, 10*24*time.Hour), past(types.WithdrawalConfirmed, 500, 29*24*time.Hour)},
+			err:     ErrWithdrawalMonthlyLimit,
+		},
+		{
+			name: "the month rolls over", kyc: types.KYC_VERIFIED, amount: 600, wantHeld: 600,
+			history: []*types.Withdrawal{past(types.WithdrawalConfirmed, 1000, 2*24*time.Hour), past(types.WithdrawalConfirmed, 1000, 10*24*time.Hour), past(types.WithdrawalConfirmed, 500, 31*24*time.Hour)},
+		},
+		{name: "kyc pending within its own limit", kyc: types.KYC_PENDING, amount: 100, wantHeld: 100},
+		{name: "kyc pending over its own limit", kyc: types.KYC_PENDING, amount: 100.01, err: ErrWithdrawalDailyLimit},
+		{name: "kyc rejected has no limits", kyc: types.KYC_REJECTED, amount: 10, err: ErrWithdrawalNotVerified},
+		{name: "recent deposit", kyc: types.KYC_VERIFIED, deposit: time.Hour, amount: 10, err: ErrWithdrawalCooldown},
+		{name: "deposit before the cooldown", kyc: types.KYC_VERIFIED, deposit: 25 * time.Hour, amount: 10, wantHeld: 10},
+		{name: "more than the wallet", kyc: types.KYC_VERIFIED, wallet: 500, amount: 500.01, err: ErrWithdrawalInsufficient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testEngine()
+			e.WithdrawalCooldown = 24 * time.Hour
+			funds := 1000.0
+			if tt.wallet != 0 {
+				funds = tt.wallet
+			}
+			user := testUser("alice", funds)
+			user.KycVerificationStatus = tt.kyc
+			user.PaymentVerificationStatus = types.PAYMENT_VERIFIED
+			if tt.deposit != 0 {
+				user.LastDepositAt = now.Add(-tt.deposit)
+			}
+			e.User["alice"] = user
+			for i, w := range tt.history {
+				w.WithdrawalId = string(rune('a' + i))
+				e.Withdrawals[w.WithdrawalId] = w
+			}
+
+			_, err := e.RequestWithdrawal(context.Background(), "alice", tt.amount)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			wallet := user.Balance.WalletBalance
+			if wallet.Held != tt.wantHeld || wallet.Amount != funds-tt.wantHeld {
+				t.Errorf("wallet %+v, want %v held", wallet, tt.wantHeld)
+			}
+		})
+	}
+}
+
+func TestResolveWithdrawal(t *testing.T) {
+	withWithdrawLimits(t, map[types.KycStatus]types.WithdrawalLimit{types.KYC_VERIFIED: {Daily: 1000, Monthly: 3000}})
+	tests := []struct {
+		name        string
+		resolve     func(e *Engine, id string) (types.Withdrawal, error)
+		status      types.WithdrawalStatus
+		wantAmount  float64
+		wantFunding float64
+	}{
+		{
+			name: "confirmed payout leaves the engine",
+			resolve: func(e *Engine, id string) (types.Withdrawal, error) {
+				return e.ConfirmWithdrawal(context.Background(), id)
+			},
+			status:      types.WithdrawalConfirmed,
+			wantAmount:  600,
+			wantFunding: 600,
+		},
+		{
+			name: "failed payout returns to the wallet",
+			resolve: func(e *Engine, id string) (types.Withdrawal, error) {
+				return e.FailWithdrawal(context.Background(), id, "bank rejected")
+			},
+			status:      types.WithdrawalFailed,
+			wantAmount:  1000,
+			wantFunding: 1000,
+		},
+		{
+			name: "owner cancels",
+			resolve: func(e *Engine, id string) (types.Withdrawal, error) {
+				return e.CancelWithdrawal(context.Background(), id, "alice")
+			},
+			status:      types.WithdrawalCancelled,
+			wantAmount:  1000,
+			wantFunding: 1000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testEngine()
+			user := testUser("alice", 1000)
+			user.KycVerificationStatus, user.PaymentVerificationStatus = types.KYC_VERIFIED, types.PAYMENT_VERIFIED
+			e.User["alice"] = user
+			e.Ledger.NetFunding = 1000
+
+			held, err := e.RequestWithdrawal(context.Background(), "alice", 400)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.CancelWithdrawal(context.Background(), held.WithdrawalId, "bob"); !errors.Is(err, ErrWithdrawalNotFound) {
+				t.Errorf("cancel by another user: error %v, want %v", err, ErrWithdrawalNotFound)
+			}
+
+			w, err := tt.resolve(e, held.WithdrawalId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.Status != tt.status {
+				t.Errorf("status %s, want %s", w.Status, tt.status)
+			}
+			if wallet := user.Balance.WalletBalance; wallet.Amount != tt.wantAmount || wallet.Held != 0 {
+				t.Errorf("wallet %+v, want amount %v and nothing held", wallet, tt.wantAmount)
+			}
+			if e.Ledger.NetFunding != tt.wantFunding {
+				t.Errorf("net funding %v, want %v", e.Ledger.NetFunding, tt.wantFunding)
+			}
+			if _, err := e.ConfirmWithdrawal(context.Background(), held.WithdrawalId); !errors.Is(err, ErrWithdrawalNotHeld) {
+				t.Errorf("resolving twice: error %v, want %v", err, ErrWithdrawalNotHeld)
+			}
+		})
+	}
+}
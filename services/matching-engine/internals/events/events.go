@@ -0,0 +1,170 @@
+// Package events defines how the engine hands its events to downstream consumers. The
+// engine only sees Publisher; Kafka, Redis Streams and an in-memory recorder implement
+// it, and Spool wraps any of them with a local file for events they could not take.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/types"
+	"strconv"
+	"time"
+)
+
+// ErrNotConnected is returned by a publisher that has no usable connection.
+var ErrNotConnected = errors.New("event publisher not connected")
+
+// Event is one message for a topic: a payload from the schema catalogue plus the W3C
+// trace headers of the command that produced it. Seq is assigned by the outbox and
+// increases by one per event, so consumers can drop duplicates and notice gaps. Events
+// with the same Key keep their order on the way to consumers.
+type Event struct {
+	Seq     uint64            `json:"seq"`
+	Topic   string            `json:"topic"`
+	Key     string            `json:"key,omitempty"`
+	Type    string            `json:"type"`
+	Version int               `json:"schemaVersion"`
+	Data    interface{}       `json:"data"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// UnmarshalJSON restores Data as its typed payload, so an event read back from the
+// journal or the spool encodes exactly like a fresh one.
+func (e *Event) UnmarshalJSON(raw []byte) error {
+	type plain Event
+	var decoded struct {
+		plain
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return err
+	}
+	data, err := schema.Decode(types.EVENTS(decoded.Type), decoded.Version, decoded.Data)
+	if err != nil {
+		return err
+	}
+	*e = Event(decoded.plain)
+	e.Data = data
+	return nil
+}
+
+// Encode renders an event for the wire. A JSON body is the {"seq", "type",
+// "schemaVersion", "data"} envelope and a Protobuf body is the payload message alone;
+// either way the headers say what the body is, next to the trace headers.
+func Encode(event Event, enc schema.Encoding) ([]byte, map[string]string, error) {
+	data, enc, err := schema.Marshal(enc, event.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := data
+	if enc == schema.JSON {
+		body, err = json.Marshal(struct {
+			Seq     uint64          `json:"seq"`
+			Type    string          `json:"type"`
+			Version int             `json:"schemaVersion"`
+			Data    json.RawMessage `json:"data"`
+		}{event.Seq, event.Type, event.Version, data})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	headers := make(map[string]string, len(event.Headers)+4)
+	for key, value := range event.Headers {
+		headers[key] = value
+	}
+	headers[schema.HeaderEventType] = event.Type
+	headers[schema.HeaderSchemaVersion] = strconv.Itoa(event.Version)
+	headers[schema.HeaderContentType] = enc.ContentType()
+	headers[schema.HeaderSeq] = strconv.FormatUint(event.Seq, 10)
+	return body, headers, nil
+}
+
+// Decode reads back an event Encode produced, from its body and headers. Topic and Key
+// are left for the caller, who knows where the message came from.
+func Decode(body []byte, headers map[string]string) (Event, error) {
+	var event Event
+	if headers[schema.HeaderContentType] != schema.Protobuf.ContentType() {
+		err := json.Unmarshal(body, &event)
+		event.Headers = traceHeaders(headers)
+		return event, err
+	}
+
+	seq, err := strconv.ParseUint(headers[schema.HeaderSeq], 10, 64)
+	if err != nil {
+		return event, fmt.Errorf("seq header: %w", err)
+	}
+	version, err := strconv.Atoi(headers[schema.HeaderSchemaVersion])
+	if err != nil {
+		return event, fmt.Errorf("schemaVersion header: %w", err)
+	}
+	eventType := headers[schema.HeaderEventType]
+	data, err := schema.Unmarshal(schema.Protobuf, types.EVENTS(eventType), version, body)
+	if err != nil {
+		return event, err
+	}
+	return Event{Seq: seq, Type: eventType, Version: version, Data: data, Headers: traceHeaders(headers)}, nil
+}
+
+// traceHeaders drops the headers Encode derives from the event itself.
+func traceHeaders(headers map[string]string) map[string]string {
+	var out map[string]string
+	for key, value := range headers {
+		switch key {
+		case schema.HeaderEventType, schema.HeaderSchemaVersion, schema.HeaderContentType, schema.HeaderSeq:
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[key] = value
+	}
+	return out
+}
+
+// Publisher delivers events. Publish returns an error when the event was not accepted;
+// nil means the transport has it, not necessarily that the broker acknowledged it.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+	// Ping reports whether the broker is reachable right now.
+	Ping(ctx context.Context) error
+	// Close waits up to timeout for accepted events to be delivered and returns how many
+	// were lost.
+	Close(timeout time.Duration) int
+}
+
+// AsyncPublisher is a Publisher that only learns of a failed delivery after Publish has
+// returned. The callback receives every such event, including those still queued when
+// Close gives up.
+type AsyncPublisher interface {
+	Publisher
+	OnUndelivered(func(Event))
+}
+
+// Settler is implemented by publishers that accept events before the broker has them.
+// Unsettled returns how many accepted events have neither been acknowledged nor
+// reported undelivered yet.
+type Settler interface {
+	Unsettled() int
+}
+
+// Sequencer is implemented by publishers that number events. Seq is the last number
+// handed out; Resume makes numbering continue after seq if it has not got that far,
+// so a process without a journal does not reuse the numbers of the one before it.
+type Sequencer interface {
+	Seq() uint64
+	Resume(seq uint64)
+}
+
+// Unsettled returns how many events pub has accepted but not yet settled. Publishers
+// that are not a Settler settle every event before Publish returns.
+func Unsettled(pub Publisher) int {
+	if s, ok := pub.(Settler); ok {
+		return s.Unsettled()
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Recorder keeps published events in memory, for tests and for running the engine
+// without a broker. Setting Err makes Publish and Ping fail with it.
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+	Err    error
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Publish(ctx context.Context, event Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Err != nil {
+		return r.Err
+	}
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *Recorder) Ping(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.Err
+}
+
+func (r *Recorder) Close(timeout time.Duration) int {
+	return 0
+}
+
+// Events returns a copy of everything published so far, in order.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Event(nil), r.events...)
+}
+
+// Reset forgets the recorded events.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = nil
+}
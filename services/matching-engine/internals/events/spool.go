@@ -0,0 +1,284 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"matching-engine/internals/metrics"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Spool wraps a Publisher with a local file. An event the publisher refuses, or later
+// reports undelivered, is appended to the file instead of being lost. While the file
+// holds anything, new events queue behind it so they keep their order; every retry
+// interval the spool pings the broker and, once it answers, replays the file. A key
+// with an event reported undelivered keeps going through the file until the publisher
+// has nothing in flight, so a late report cannot put it behind a newer event.
+type Spool struct {
+	inner Publisher
+	path  string
+	retry time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	pending int64 // events in the file; written under mu, read atomically by Pending
+	lost    int
+	// failed holds the keys of events reported undelivered since the publisher last
+	// had nothing in flight.
+	failed map[string]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSpool opens (or creates) the spool file at path and starts the drain loop. Events
+// left over from a previous run are replayed as soon as the broker is reachable.
+func NewSpool(inner Publisher, path string, retry time.Duration) (*Spool, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open event spool: %w", err)
+	}
+
+	s := &Spool{
+		inner:  inner,
+		path:   path,
+		retry:  retry,
+		file:   file,
+		failed: make(map[string]struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	lines, err := countLines(path)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("read event spool: %w", err)
+	}
+	s.setPending(int64(lines))
+	if lines > 0 {
+		log.Warn().Int("events", lines).Str("path", path).Msg("Event spool holds undelivered events from a previous run")
+	}
+
+	if async, ok := inner.(AsyncPublisher); ok {
+		async.OnUndelivered(s.undelivered)
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Publish hands the event to the wrapped publisher, or spools it if that fails, older
+// events are still waiting or its key has failed. It only returns an error if the event
+// could not be spooled either. It holds mu throughout, so a spooled event cannot be
+// overtaken by one published after it.
+func (s *Spool) Publish(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending > 0 || s.keyFailed(event.Key) {
+		return s.appendLocked(event)
+	}
+
+	err := s.inner.Publish(ctx, event)
+	if err == nil {
+		return nil
+	}
+	log.Warn().Err(err).Str("eventType", event.Type).Msg("Event not accepted, spooling it")
+	if spoolErr := s.appendLocked(event); spoolErr != nil {
+		return errors.Join(err, spoolErr)
+	}
+	return nil
+}
+
+// keyFailed reports whether an event with key was reported undelivered while others may
+// still be in flight. Caller must hold mu.
+func (s *Spool) keyFailed(key string) bool {
+	if len(s.failed) > 0 && Unsettled(s.inner) == 0 {
+		clear(s.failed)
+	}
+	_, ok := s.failed[key]
+	return ok
+}
+
+func (s *Spool) Ping(ctx context.Context) error {
+	return s.inner.Ping(ctx)
+}
+
+// Unsettled counts only what the wrapped publisher has in flight; a spooled event is
+// settled once it is on disk.
+func (s *Spool) Unsettled() int {
+	return Unsettled(s.inner)
+}
+
+// Pending returns how many events are waiting in the spool file.
+func (s *Spool) Pending() int {
+	return int(atomic.LoadInt64(&s.pending))
+}
+
+// Close stops the drain loop and closes the wrapped publisher; whatever it could not
+// deliver lands in the file for the next run. It returns the events that were neither
+// delivered nor spooled.
+func (s *Spool) Close(timeout time.Duration) int {
+	close(s.stop)
+	<-s.done
+
+	lost := s.inner.Close(timeout)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := s.Pending(); n > 0 {
+		log.Warn().Int("events", n).Str("path", s.path).Msg("Closing with events still spooled, they are sent on the next start")
+	}
+	if err := s.file.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close event spool")
+	}
+	return lost + s.lost
+}
+
+func (s *Spool) undelivered(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failed[event.Key] = struct{}{}
+	if err := s.appendLocked(event); err != nil {
+		log.Error().Err(err).Str("eventType", event.Type).Msg("Failed to spool undelivered event, event lost")
+	}
+}
+
+// appendLocked writes event to the end of the file. Caller must hold mu.
+func (s *Spool) appendLocked(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		s.lost++
+		return fmt.Errorf("encode spooled event: %w", err)
+	}
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		s.lost++
+		return fmt.Errorf("write event spool: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		s.lost++
+		return fmt.Errorf("sync event spool: %w", err)
+	}
+	s.setPending(s.pending + 1)
+	return nil
+}
+
+func (s *Spool) setPending(n int64) {
+	atomic.StoreInt64(&s.pending, n)
+	metrics.SpoolDepth(int(n))
+}
+
+func (s *Spool) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.retry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		if s.Pending() == 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.retry)
+		err := s.inner.Ping(ctx)
+		cancel()
+		if err != nil {
+			log.Debug().Err(err).Int("pending", s.Pending()).Msg("Event broker still unreachable")
+			continue
+		}
+		if err := s.drain(); err != nil {
+			log.Error().Err(err).Msg("Failed to drain event spool")
+		}
+	}
+}
+
+// drain replays the file in order and keeps whatever the publisher refuses. New events
+// wait on mu meanwhile, so none can slip in ahead of the backlog.
+func (s *Spool) drain() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var rest bytes.Buffer
+	sent, dropped := 0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if rest.Len() > 0 {
+			rest.Write(line)
+			rest.WriteByte('\n')
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			// A torn last line from a crash mid-write; nothing to recover from it
+			log.Error().Err(err).Str("line", string(line)).Msg("Dropping unreadable spooled event")
+			dropped++
+			continue
+		}
+		if err := s.inner.Publish(context.Background(), event); err != nil {
+			log.Warn().Err(err).Msg("Broker refused spooled event, keeping the rest for later")
+			rest.Write(line)
+			rest.WriteByte('\n')
+			continue
+		}
+		sent++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Swap in what is left; the append handle must follow the new file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, rest.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = file
+
+	lines, err := countLines(s.path)
+	if err != nil {
+		return err
+	}
+	s.setPending(int64(lines))
+
+	log.Info().Int("sent", sent).Int("dropped", dropped).Int("pending", lines).Msg("Drained event spool")
+	return nil
+}
+
+func countLines(path string) (int, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Count(raw, []byte{'\n'}), nil
+}
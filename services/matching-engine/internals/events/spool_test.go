@@ -0,0 +1,113 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// asyncRecorder is a Recorder that can report events undelivered after accepting them.
+type asyncRecorder struct {
+	*Recorder
+	inFlight    int
+	undelivered func(Event)
+}
+
+func (a *asyncRecorder) OnUndelivered(fn func(Event)) { a.undelivered = fn }
+func (a *asyncRecorder) Unsettled() int               { return a.inFlight }
+
+func TestSpoolKeepsKeyOrder(t *testing.T) {
+	event := func(seq uint64, key string) Event { return Event{Seq: seq, Key: key, Type: "TEST"} }
+	refused := errors.New("broker down")
+
+	tests := []struct {
+		name string
+		// run publishes through s and returns the seqs the broker should hold and how
+		// many should be left in the file
+		run func(t *testing.T, s *Spool, inner *asyncRecorder) ([]uint64, int)
+	}{
+		{
+			name: "refused event holds back the ones after it",
+			run: func(t *testing.T, s *Spool, inner *asyncRecorder) ([]uint64, int) {
+				inner.Err = refused
+				s.Publish(context.Background(), event(1, "a"))
+				inner.Err = nil
+				s.Publish(context.Background(), event(2, "b"))
+				return nil, 2
+			},
+		},
+		{
+			name: "drain sends the backlog in order",
+			run: func(t *testing.T, s *Spool, inner *asyncRecorder) ([]uint64, int) {
+				inner.Err = refused
+				s.Publish(context.Background(), event(1, "a"))
+				inner.Err = nil
+				s.Publish(context.Background(), event(2, "a"))
+				if err := s.drain(); err != nil {
+					t.Fatal(err)
+				}
+				s.Publish(context.Background(), event(3, "a"))
+				return []uint64{1, 2, 3}, 0
+			},
+		},
+		{
+			name: "failed key stays spooled while events are in flight, others go direct",
+			run: func(t *testing.T, s *Spool, inner *asyncRecorder) ([]uint64, int) {
+				s.Publish(context.Background(), event(1, "a"))
+				inner.inFlight = 1
+				inner.undelivered(event(1, "a"))
+				if err := s.drain(); err != nil {
+					t.Fatal(err)
+				}
+				s.Publish(context.Background(), event(2, "b"))
+				s.Publish(context.Background(), event(3, "a"))
+				return []uint64{1, 1, 2}, 1
+			},
+		},
+		{
+			name: "failed key goes direct once nothing is in flight",
+			run: func(t *testing.T, s *Spool, inner *asyncRecorder) ([]uint64, int) {
+				s.Publish(context.Background(), event(1, "a"))
+				inner.inFlight = 1
+				inner.undelivered(event(1, "a"))
+				if err := s.drain(); err != nil {
+					t.Fatal(err)
+				}
+				inner.inFlight = 0
+				s.Publish(context.Background(), event(2, "a"))
+				return []uint64{1, 1, 2}, 0
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &asyncRecorder{Recorder: NewRecorder()}
+			s, err := NewSpool(inner, filepath.Join(t.TempDir(), "spool"), time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close(time.Second)
+
+			want, pending := tt.run(t, s, inner)
+
+			var got []uint64
+			for _, e := range inner.Events() {
+				got = append(got, e.Seq)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("broker holds seqs %v, want %v", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("broker holds seqs %v, want %v", got, want)
+				}
+			}
+			if s.Pending() != pending {
+				t.Errorf("%d events spooled, want %d", s.Pending(), pending)
+			}
+		})
+	}
+}
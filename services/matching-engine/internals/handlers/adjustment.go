@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"matching-engine/internals/engine"
+	"matching-engine/internals/types"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog/log"
+)
+
+type AdjustBalanceDataRequest struct {
+	UserId     string  `mapstructure:"userId"`
+	Delta      float64 `mapstructure:"delta"`
+	ReasonCode string  `mapstructure:"reasonCode"`
+	Note       string  `mapstructure:"note"`
+	OperatorId string  `mapstructure:"operatorId"`
+}
+
+type AdjustPositionDataRequest struct {
+	UserId     string `mapstructure:"userId"`
+	Symbol     string `mapstructure:"symbol"`
+	Side       string `mapstructure:"side"`
+	Delta      int    `mapstructure:"delta"`
+	ReasonCode string `mapstructure:"reasonCode"`
+	Note       string `mapstructure:"note"`
+	OperatorId string `mapstructure:"operatorId"`
+}
+
+type ApproveAdjustmentDataRequest struct {
+	AdjustmentId string `mapstructure:"adjustmentId"`
+	OperatorId   string `mapstructure:"operatorId"`
+}
+
+// AdjustBalance credits or debits a wallet by a signed delta on behalf of an operator.
+func AdjustBalance(payload types.QueuePayload) types.QueueResponse {
+	var data AdjustBalanceDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    "failed to validate payload data " + err.Error(),
+		}
+	}
+
+	adj, err := engine.EngineInstance.RequestAdjustment(payload.Context(), types.Adjustment{
+		Kind:        types.BalanceAdjustment,
+		UserId:      data.UserId,
+		Delta:       data.Delta,
+		ReasonCode:  types.ReasonCode(data.ReasonCode),
+		Note:        data.Note,
+		RequestedBy: data.OperatorId,
+	})
+
+	return adjustmentResponse(payload, adj, err)
+}
+
+// AdjustPosition adds or removes YES/NO shares on behalf of an operator.
+func AdjustPosition(payload types.QueuePayload) types.QueueResponse {
+	var data AdjustPositionDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    "failed to validate payload data " + err.Error(),
+		}
+	}
+
+	adj, err := engine.EngineInstance.RequestAdjustment(payload.Context(), types.Adjustment{
+		Kind:        types.PositionAdjustment,
+		UserId:      data.UserId,
+		Symbol:      data.Symbol,
+		Side:        types.Side(data.Side),
+		Delta:       float64(data.Delta),
+		ReasonCode:  types.ReasonCode(data.ReasonCode),
+		Note:        data.Note,
+		RequestedBy: data.OperatorId,
+	})
+
+	return adjustmentResponse(payload, adj, err)
+}
+
+// ApproveAdjustment is the checker step for adjustments above the approval threshold.
+func ApproveAdjustment(payload types.QueuePayload) types.QueueResponse {
+	var data ApproveAdjustmentDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    "failed to validate payload data " + err.Error(),
+		}
+	}
+
+	adj, err := engine.EngineInstance.ApproveAdjustment(payload.Context(), data.AdjustmentId, data.OperatorId)
+
+	return adjustmentResponse(payload, adj, err)
+}
+
+// RejectAdjustment discards a pending adjustment.
+func RejectAdjustment(payload types.QueuePayload) types.QueueResponse {
+	var data ApproveAdjustmentDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    "failed to validate payload data " + err.Error(),
+		}
+	}
+
+	adj, err := engine.EngineInstance.RejectAdjustment(payload.Context(), data.AdjustmentId, data.OperatorId)
+
+	return adjustmentResponse(payload, adj, err)
+}
+
+func adjustmentResponse(payload types.QueuePayload, adj types.Adjustment, err error) types.QueueResponse {
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("adjustmentId", adj.AdjustmentId).
+			Str("userId", adj.UserId).
+			Msg("Adjustment refused")
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    err.Error(),
+			Data:       adj,
+		}
+	}
+
+	message := "Adjustment applied"
+	switch adj.Status {
+	case types.AdjustmentPending:
+		message = "Adjustment awaiting approval"
+	case types.AdjustmentRejected:
+		message = "Adjustment rejected"
+	}
+
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Success,
+		Message:    message,
+		Data:       adj,
+	}
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"matching-engine/internals/engine"
+	"matching-engine/internals/schema"
 	"matching-engine/internals/types"
+	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog/log"
@@ -33,9 +37,9 @@ func InitBalance(payload types.QueuePayload) types.QueueResponse {
 
 	engine.EngineInstance.UM.RLock()
 	user, exists := engine.EngineInstance.User[data.UserId]
-	engine.EngineInstance.UM.RUnlock()
 
 	if !exists {
+		engine.EngineInstance.UM.RUnlock()
 		log.Error().
 			Str("userId", data.UserId).
 			Msg("User not found in InitBalance handler")
@@ -50,8 +54,32 @@ func InitBalance(payload types.QueuePayload) types.QueueResponse {
 	user.Mutex.Lock()
 	defer user.Mutex.Unlock()
 
+	// Once an account holds money, corrections must go through ADJUST_BALANCE
+	if hasBeenFunded(user) {
+		log.Warn().
+			Str("userId", data.UserId).
+			Msg("InitBalance refused for an already funded account")
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    "Balance already initialized. Use a balance adjustment instead",
+		}
+	}
+
+	previous := user.Balance.WalletBalance.Amount + user.Balance.WalletBalance.Locked
+
 	user.Balance.WalletBalance.Amount = data.Amount
 	user.Balance.WalletBalance.Locked = data.Locked
+	user.Funded = data.Amount != 0 || data.Locked != 0
+
+	engine.EngineInstance.RecordFunding(data.Amount + data.Locked - previous)
+
+	engine.EngineInstance.Publish(payload.Context(), types.BALANCE_INITIALIZED, schema.BalanceInitialized{
+		UserId: user.ID,
+		Amount: data.Amount,
+		Locked: data.Locked,
+	})
 
 	log.Info().
 		Str("userId", data.UserId).
@@ -66,6 +94,19 @@ func InitBalance(payload types.QueuePayload) types.QueueResponse {
 	}
 }
 
+// hasBeenFunded also covers users restored from snapshots taken before Funded was tracked.
+func hasBeenFunded(user *types.User) bool {
+	if user.Funded || user.Balance.WalletBalance.Amount != 0 || user.Balance.WalletBalance.Locked != 0 {
+		return true
+	}
+	for _, stock := range user.Balance.StockBalance {
+		if stock.Yes != 0 || stock.No != 0 || stock.LockedYes != 0 || stock.LockedNo != 0 {
+			return true
+		}
+	}
+	return false
+}
+
 type GetBalanceDataRequest struct {
 	UserId string `mapstructure:"userId" json:"userId"`
 }
@@ -89,9 +130,9 @@ func GetBalance(payload types.QueuePayload) types.QueueResponse {
 
 	engine.EngineInstance.UM.RLock()
 	user, exists := engine.EngineInstance.User[data.UserId]
-	engine.EngineInstance.UM.RUnlock()
 
 	if !exists {
+		engine.EngineInstance.UM.RUnlock()
 		log.Error().
 			Str("userId", data.UserId).
 			Msg("User not found in GetBalance handler")
@@ -103,14 +144,19 @@ func GetBalance(payload types.QueuePayload) types.QueueResponse {
 		}
 	}
 
+	user.Mutex.Lock()
+	wallet := user.Balance.WalletBalance
+	user.Mutex.Unlock()
+
 	return types.QueueResponse{
 		ResponseId: payload.ResponseId,
 		Status:     types.Success,
 		Message:    "Balance fetched successfully",
 		Data: map[string]interface{}{
 			"userId": user.ID,
-			"amount": user.Balance.WalletBalance.Amount,
-			"locked": user.Balance.WalletBalance.Locked,
+			"amount": wallet.Amount,
+			"locked": wallet.Locked,
+			"held":   wallet.Held,
 		},
 	}
 
@@ -151,9 +197,9 @@ func Deposit(payload types.QueuePayload) types.QueueResponse {
 
 	engine.EngineInstance.UM.RLock()
 	user, exists := engine.EngineInstance.User[data.UserId]
-	engine.EngineInstance.UM.RUnlock()
 
 	if !exists {
+		engine.EngineInstance.UM.RUnlock()
 		log.Error().
 			Str("userId", data.UserId).
 			Msg("User not found in Deposit handler")
@@ -166,14 +212,27 @@ func Deposit(payload types.QueuePayload) types.QueueResponse {
 	}
 
 	user.Mutex.Lock()
-	defer user.Mutex.Unlock()
-
 	user.Balance.WalletBalance.Amount += data.Amount
+	user.Funded = true
+	user.LastDepositAt = time.Now()
+	engine.EngineInstance.RecordFunding(data.Amount)
+	view := engine.BalanceViewOf(user)
+	depositedAt := user.LastDepositAt
+	user.Mutex.Unlock()
+	engine.EngineInstance.UM.RUnlock()
+
+	engine.EngineInstance.NotifyBalance(payload.Context(), data.UserId, view, engine.BalanceDeposit)
+	engine.EngineInstance.Publish(payload.Context(), types.FUNDS_DEPOSITED, schema.FundsDeposited{
+		UserId:    data.UserId,
+		Amount:    data.Amount,
+		Source:    schema.DepositSource,
+		Timestamp: depositedAt,
+	})
 
 	log.Info().
 		Str("userId", data.UserId).
 		Float64("amount", data.Amount).
-		Float64("newBalance", user.Balance.WalletBalance.Amount).
+		Float64("newBalance", view.Cash).
 		Msg("Deposit processed")
 
 	return types.QueueResponse{
@@ -189,8 +248,8 @@ func Deposit(payload types.QueuePayload) types.QueueResponse {
 
 }
 
-// Withdraw handles user withdrawal requests and updates balance safely.
-// if Kyc and Payment method is verified only then user can verify.
+// Withdraw places the requested amount on hold until the payout system confirms it.
+// if Kyc and Payment method is verified only then user can withdraw.
 
 type WithdrawDataRequest struct {
 	UserId string  `mapstructure:"userId" json:"userId"`
@@ -223,11 +282,10 @@ func Withdraw(payload types.QueuePayload) types.QueueResponse {
 		}
 	}
 
-	engine.EngineInstance.UM.RLock()
-	user, exists := engine.EngineInstance.User[data.UserId]
-	engine.EngineInstance.UM.RUnlock()
+	withdrawal, err := engine.EngineInstance.RequestWithdrawal(payload.Context(), data.UserId, data.Amount)
 
-	if !exists {
+	switch {
+	case errors.Is(err, engine.ErrWithdrawalUserNotFound):
 		log.Error().
 			Str("userId", data.UserId).
 			Msg("User not found in Withdraw handler")
@@ -237,51 +295,97 @@ func Withdraw(payload types.QueuePayload) types.QueueResponse {
 			Retryable:  false,
 			Message:    "User not found. Please contact support team",
 		}
-	}
 
-	user.Mutex.Lock()
-	defer user.Mutex.Unlock()
-
-	if user.KycVerificationStatus == types.KYC_VERIFIED && user.PaymentVerificationStatus == types.PAYMENT_VERIFIED {
-
-		if user.Balance.WalletBalance.Amount < data.Amount {
-			return types.QueueResponse{
-				ResponseId: payload.ResponseId,
-				Status:     types.Error,
-				Retryable:  false,
-				Message:    "Insufficient balance for withdrawal",
-				Data: map[string]interface{}{
-					"userId":  user.ID,
-					"balance": user.Balance.WalletBalance.Amount,
-				},
-			}
+	case errors.Is(err, engine.ErrWithdrawalNotVerified):
+		log.Warn().Msg("User is not verified")
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    "Kyc and payment method is not verified. Please verify to withdraw money",
 		}
 
-		user.Balance.WalletBalance.Amount -= data.Amount
-
-		log.Info().
+	case err != nil:
+		log.Warn().
+			Err(err).
 			Str("userId", data.UserId).
 			Float64("amount", data.Amount).
-			Float64("remainingBalance", user.Balance.WalletBalance.Amount).
-			Msg("Withdrawal successful")
+			Msg("Withdrawal refused")
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    err.Error(),
+		}
+	}
+
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Success,
+		Message:    "Withdrawal requested",
+		Data:       withdrawal,
+	}
+}
+
+type WithdrawalUpdateDataRequest struct {
+	WithdrawalId string `mapstructure:"withdrawalId" json:"withdrawalId"`
+	UserId       string `mapstructure:"userId" json:"userId"`
+	Reason       string `mapstructure:"reason" json:"reason"`
+}
+
+// WithdrawConfirmed is sent by the payout system once the money has left.
+func WithdrawConfirmed(payload types.QueuePayload) types.QueueResponse {
+	return updateWithdrawal(payload, func(data WithdrawalUpdateDataRequest) (types.Withdrawal, error) {
+		return engine.EngineInstance.ConfirmWithdrawal(payload.Context(), data.WithdrawalId)
+	})
+}
 
+// WithdrawFailed is sent by the payout system when the transfer bounced.
+func WithdrawFailed(payload types.QueuePayload) types.QueueResponse {
+	return updateWithdrawal(payload, func(data WithdrawalUpdateDataRequest) (types.Withdrawal, error) {
+		return engine.EngineInstance.FailWithdrawal(payload.Context(), data.WithdrawalId, data.Reason)
+	})
+}
+
+// CancelWithdrawal lets a user take back a withdrawal that is still on hold.
+func CancelWithdrawal(payload types.QueuePayload) types.QueueResponse {
+	return updateWithdrawal(payload, func(data WithdrawalUpdateDataRequest) (types.Withdrawal, error) {
+		return engine.EngineInstance.CancelWithdrawal(payload.Context(), data.WithdrawalId, data.UserId)
+	})
+}
+
+func updateWithdrawal(payload types.QueuePayload, apply func(WithdrawalUpdateDataRequest) (types.Withdrawal, error)) types.QueueResponse {
+
+	var data WithdrawalUpdateDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
 		return types.QueueResponse{
 			ResponseId: payload.ResponseId,
-			Status:     types.Success,
-			Message:    "Withdrawal successful",
-			Data: map[string]interface{}{
-				"userId":           user.ID,
-				"withdrawnAmount":  data.Amount,
-				"remainingBalance": user.Balance.WalletBalance.Amount,
-			},
+			Status:     types.Error,
+			Retryable:  true,
+			Message:    "Invalid request data for withdrawal update",
 		}
-	} else {
-		log.Warn().Msg("User is not verified")
+	}
+
+	withdrawal, err := apply(data)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("withdrawalId", data.WithdrawalId).
+			Msg("Withdrawal update refused")
 		return types.QueueResponse{
 			ResponseId: payload.ResponseId,
 			Status:     types.Error,
 			Retryable:  false,
-			Message:    "Kyc and payment method is not verified. Please verify to withdraw money",
+			Message:    err.Error(),
+			Data:       withdrawal,
 		}
 	}
+
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Success,
+		Message:    "Withdrawal " + strings.ToLower(string(withdrawal.Status)),
+		Data:       withdrawal,
+	}
 }
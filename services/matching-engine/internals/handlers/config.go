@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"matching-engine/internals/engine"
+	"matching-engine/internals/types"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog/log"
+)
+
+type ReloadConfigDataRequest struct {
+	OperatorId string `mapstructure:"operatorId"`
+}
+
+// ReloadConfig rereads the config file and environment and applies fees, limits and
+// other hot-reloadable keys. Keys that need a restart are reported as ignored.
+func ReloadConfig(payload types.QueuePayload) types.QueueResponse {
+
+	var data ReloadConfigDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "Invalid format",
+		}
+	}
+
+	result, err := engine.EngineInstance.ReloadConfig()
+	if err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "Configuration rejected: " + err.Error(),
+		}
+	}
+
+	log.Warn().Str("operatorId", data.OperatorId).Strs("applied", result.Applied).Msg("Configuration reloaded by operator")
+
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Success,
+		Message:    "Configuration reloaded",
+		Data:       result,
+	}
+}
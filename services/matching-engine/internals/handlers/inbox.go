@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"matching-engine/internals/engine"
+	"matching-engine/internals/types"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// GetInboxStats reports queue depth, high-water mark and rejections for every market.
+func GetInboxStats(payload types.QueuePayload) types.QueueResponse {
+
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Success,
+		Message:    "Inbox stats fetched",
+		Data:       engine.EngineInstance.InboxDepths(),
+	}
+}
+
+type HaltMarketDataRequest struct {
+	Symbol string `mapstructure:"symbol"`
+}
+
+// HaltMarket freezes a market on its priority lane, ahead of any orders already queued.
+func HaltMarket(payload types.QueuePayload) types.QueueResponse {
+
+	var data HaltMarketDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "Invalid format",
+		}
+	}
+
+	err := engine.EngineInstance.HaltMarket(data.Symbol)
+
+	if errors.Is(err, engine.ErrMarketNotFound) {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "Market not found",
+		}
+	}
+
+	if err != nil {
+		return inboxErrorResponse(payload, err)
+	}
+
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Success,
+		Message:    "Market halted",
+	}
+}
+
+type RestartMarketDataRequest struct {
+	Symbol     string `mapstructure:"symbol"`
+	OperatorId string `mapstructure:"operatorId"`
+}
+
+// RestartMarket reopens a halted market after verifying its book. When verification
+// fails the market stays halted and the violations are returned.
+func RestartMarket(payload types.QueuePayload) types.QueueResponse {
+
+	var data RestartMarketDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "Invalid format",
+		}
+	}
+
+	violations, err := engine.EngineInstance.RestartMarket(payload.Context(), data.Symbol, data.OperatorId)
+
+	switch {
+	case err == nil:
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Success,
+			Message:    "Market restarted",
+		}
+	case errors.Is(err, engine.ErrMarketBusy), errors.Is(err, engine.ErrReplyTimeout):
+		return inboxErrorResponse(payload, err)
+	default:
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    err.Error(),
+			Data:       violations,
+		}
+	}
+}
+
+type GetMarketDiagnosticDataRequest struct {
+	Symbol string `mapstructure:"symbol"`
+}
+
+// GetMarketDiagnostic returns what the supervisor captured when the market panicked.
+func GetMarketDiagnostic(payload types.QueuePayload) types.QueueResponse {
+
+	var data GetMarketDiagnosticDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "Invalid format",
+		}
+	}
+
+	diag, ok := engine.EngineInstance.MarketPanic(data.Symbol)
+	if !ok {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "No diagnostic recorded for this market",
+		}
+	}
+
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Success,
+		Message:    "Market diagnostic fetched",
+		Data:       diag,
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"matching-engine/internals/engine"
+	"matching-engine/internals/types"
+)
+
+// CheckInvariants runs the conservation checker on demand and returns its report.
+func CheckInvariants(payload types.QueuePayload) types.QueueResponse {
+
+	report := engine.EngineInstance.RunInvariantCheck()
+
+	if len(report.Violations) > 0 {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "Invariant violations detected",
+			Data:       report,
+		}
+	}
+
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Success,
+		Message:    "All invariants hold",
+		Data:       report,
+	}
+}
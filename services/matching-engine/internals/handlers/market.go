@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"matching-engine/internals/config"
 	"matching-engine/internals/engine"
+	"matching-engine/internals/schema"
 	"matching-engine/internals/types"
 	"matching-engine/internals/utils"
 	"time"
@@ -83,13 +86,7 @@ func CreateMarket(payload types.QueuePayload) types.QueueResponse {
 		Traders:         make(map[string]struct{}),
 		Volume:          0,
 		Status:          types.Open,
-		Inbox:           make(chan types.MarketMessage, 100),
-		OrderBook: &types.OrderBook{
-			YesBids: &types.BidHeap{OrderHeap: make(types.OrderHeap, 0)},
-			YesAsks: &types.AskHeap{OrderHeap: make(types.OrderHeap, 0)},
-			NoBids:  &types.BidHeap{OrderHeap: make(types.OrderHeap, 0)},
-			NoAsks:  &types.AskHeap{OrderHeap: make(types.OrderHeap, 0)},
-		},
+		OrderBook:       types.NewOrderBook(),
 		Overview: types.Overview{
 			SourceOfTruth: data.SourceOfTruth,
 			StartDate:     startTime,
@@ -101,6 +98,22 @@ func CreateMarket(payload types.QueuePayload) types.QueueResponse {
 
 	engine.EngineInstance.AddMarket(market)
 
+	engine.EngineInstance.Publish(payload.Context(), types.MARKET_CREATED, schema.MarketCreated{
+		MarketId:        market.MarketId,
+		Symbol:          market.Symbol,
+		Title:           market.Title,
+		YesPrice:        float64(market.YesPrice),
+		NoPrice:         float64(market.NoPrice),
+		Thumbnail:       market.Thumbnail,
+		CategoryId:      market.CategoryId,
+		NumberOfTraders: int(market.NumberOfTraders),
+		SourceOfTruth:   market.Overview.SourceOfTruth,
+		StartDate:       market.Overview.StartDate,
+		EndDate:         market.Overview.EndDate,
+		EOS:             market.Overview.EOS,
+		Rules:           market.Overview.Rules,
+	})
+
 	log.Info().
 		Str("marketId", data.ID).
 		Msg("Market created and added to engine")
@@ -145,8 +158,8 @@ func GetMarketDetails(payload types.QueuePayload) types.QueueResponse {
 
 	log.Info().
 		Str("marketId", market.MarketId).
-		Int("YesOrders", len(orderBook.Yes)).
-		Int("NoOrders", len(orderBook.No)).
+		Int("YesBids", len(orderBook.Yes.Bids)).
+		Int("YesAsks", len(orderBook.Yes.Asks)).
 		Msg("Fetched order book")
 
 	return types.QueueResponse{
@@ -243,66 +256,65 @@ func AddLiquidity(payload types.QueuePayload) types.QueueResponse {
 	// Default levels if none provided
 	levels := data.Levels
 	if len(levels) == 0 {
-		levels = []LiquidityLevel{
-			{Price: 3.0, Quantity: 10},
-			{Price: 4.0, Quantity: 25},
-			{Price: 5.0, Quantity: 50},
-			{Price: 6.0, Quantity: 25},
-			{Price: 7.0, Quantity: 10},
+		for _, level := range config.Current().ForMarket(data.Symbol).DefaultLiquidity {
+			levels = append(levels, LiquidityLevel{Price: level.Price, Quantity: level.Quantity})
 		}
 	}
 
 	// Place BUY YES and BUY NO at each price level.
 	// When a real user buys YES at price P, it can MINT-match with a BUY NO at (10-P).
 	// This creates proper two-sided liquidity without needing SELL orders.
-	totalOrders := 0
+	// All orders are queued before any reply is awaited so seeding costs one round trip, not one per order.
+	var replies []chan interface{}
+	var sendErr error
 	for _, level := range levels {
-		replyYes := make(chan interface{}, 1)
-		replyNo := make(chan interface{}, 1)
-
-		yesOrder := types.Order{
-			OrderId:   utils.GenerateOrderID(),
-			UserId:    data.UserId,
-			MarketId:  data.MarketId,
-			Symbol:    data.Symbol,
-			Side:      types.Yes,
-			Price:     level.Price,
-			Role:      types.ADMIN,
-			Quantity:  level.Quantity,
-			Action:    types.BUY,
-			OrderType: types.LIMIT,
-			Timestamp: time.Now().UTC(),
+		for _, side := range []types.Side{types.Yes, types.No} {
+			order := types.Order{
+				OrderId:   utils.GenerateOrderID(),
+				UserId:    data.UserId,
+				MarketId:  data.MarketId,
+				Symbol:    data.Symbol,
+				Side:      side,
+				Price:     level.Price,
+				Role:      types.ADMIN,
+				Quantity:  level.Quantity,
+				Action:    types.BUY,
+				OrderType: types.LIMIT,
+				Timestamp: time.Now().UTC(),
+			}
+
+			reply, err := engine.EngineInstance.Send(payload.Context(), market, types.MarketPlaceOrder, order)
+			if err != nil {
+				sendErr = err
+				break
+			}
+			replies = append(replies, reply)
 		}
-
-		noOrder := types.Order{
-			OrderId:   utils.GenerateOrderID(),
-			UserId:    data.UserId,
-			MarketId:  data.MarketId,
-			Symbol:    data.Symbol,
-			Side:      types.No,
-			Price:     level.Price,
-			Role:      types.ADMIN,
-			Quantity:  level.Quantity,
-			Action:    types.BUY,
-			OrderType: types.LIMIT,
-			Timestamp: time.Now().UTC(),
+		if sendErr != nil {
+			break
 		}
+	}
 
-		market.Inbox <- types.MarketMessage{
-			Type:      types.MarketPlaceOrder,
-			Payload:   yesOrder,
-			ReplyChan: replyYes,
-		}
-		<-replyYes
+	if payload.Release != nil {
+		payload.Release()
+	}
 
-		market.Inbox <- types.MarketMessage{
-			Type:      types.MarketPlaceOrder,
-			Payload:   noOrder,
-			ReplyChan: replyNo,
+	totalOrders := 0
+	for _, reply := range replies {
+		if _, err := engine.EngineInstance.Await(reply); err != nil {
+			sendErr = err
+			continue
 		}
-		<-replyNo
+		totalOrders++
+	}
 
-		totalOrders += 2
+	if sendErr != nil {
+		log.Warn().Err(sendErr).Str("symbol", data.Symbol).Int("totalOrders", totalOrders).Msg("Liquidity seeding incomplete")
+		resp := inboxErrorResponse(payload, sendErr)
+		// Once any order was queued a blind retry would double the levels
+		resp.Retryable = resp.Retryable && len(replies) == 0
+		resp.Message = fmt.Sprintf("Liquidity seeding incomplete after %d orders: %s", totalOrders, sendErr.Error())
+		return resp
 	}
 
 	log.Info().
@@ -335,9 +347,7 @@ func ResolveMarket(payload types.QueuePayload) types.QueueResponse {
 		}
 	}
 
-	engine.EngineInstance.MM.RLock()
 	market, ok := engine.EngineInstance.GetMarket(data.Symbol)
-	engine.EngineInstance.MM.RUnlock()
 
 	if !ok {
 		return types.QueueResponse{
@@ -347,14 +357,11 @@ func ResolveMarket(payload types.QueuePayload) types.QueueResponse {
 		}
 	}
 
-	replyChan := make(chan interface{})
-	market.Inbox <- types.MarketMessage{
-		Type:      types.MarketResolveMarket,
-		Payload:   data.Result,
-		ReplyChan: replyChan,
-	}
+	return askMarket(payload, market, types.MarketResolveMarket, data.Result, resolveResponse)
+}
 
-	resp := <-replyChan
+// resolveResponse converts a market's reply to RESOLVE_MARKET.
+func resolveResponse(payload types.QueuePayload, resp interface{}) types.QueueResponse {
 	if respBool, ok := resp.(bool); ok && respBool {
 		return types.QueueResponse{
 			ResponseId: payload.ResponseId,
@@ -369,3 +376,49 @@ func ResolveMarket(payload types.QueuePayload) types.QueueResponse {
 		Message:    "Failed to resolve market",
 	}
 }
+
+type GetBookSnapshotDataRequest struct {
+	Symbol string `mapstructure:"symbol"`
+	Seq    uint64 `mapstructure:"seq"`
+}
+
+// GetBookSnapshot returns the market's L2 book as of a BOOK_DIFF seq, or the current
+// book when no seq is given, so a subscriber that saw a gap can resync.
+func GetBookSnapshot(payload types.QueuePayload) types.QueueResponse {
+
+	var data GetBookSnapshotDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "Invalid format",
+		}
+	}
+
+	snapshot, err := engine.EngineInstance.BookAt(payload.Context(), data.Symbol, data.Seq)
+
+	switch {
+	case err == nil:
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Success,
+			Message:    "Book snapshot fetched",
+			Data:       snapshot,
+		}
+	case errors.Is(err, engine.ErrMarketNotFound):
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    "Market not found",
+		}
+	case errors.Is(err, engine.ErrMarketBusy), errors.Is(err, engine.ErrReplyTimeout):
+		return inboxErrorResponse(payload, err)
+	default:
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Message:    err.Error(),
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"matching-engine/internals/engine"
 	"matching-engine/internals/types"
 	"matching-engine/internals/utils"
@@ -57,8 +58,6 @@ func BuyOrder(payload types.QueuePayload) types.QueueResponse {
 		}
 	}
 
-	replyChannel := make(chan interface{})
-
 	orderId := data.OrderId
 	if orderId == "" {
 		orderId = utils.GenerateOrderID()
@@ -77,36 +76,7 @@ func BuyOrder(payload types.QueuePayload) types.QueueResponse {
 		Timestamp: time.Now().UTC(),
 	}
 
-	market.Inbox <- types.MarketMessage{
-		Type:      types.MarketPlaceOrder,
-		Payload:   order,
-		ReplyChan: replyChannel,
-	}
-
-	rawResp := <-replyChannel
-
-	placeOrderResp, ok := rawResp.(types.OrderResponse)
-
-	if !ok {
-		return types.QueueResponse{
-			ResponseId: payload.ResponseId,
-			Status:     types.Error,
-			Message:    "Invalid response from market, having internal issues.",
-		}
-	}
-
-	status := types.Error
-
-	if placeOrderResp.Success {
-		status = types.Success
-	}
-
-	return types.QueueResponse{
-		ResponseId: payload.ResponseId,
-		Status:     status,
-		Message:    placeOrderResp.Message,
-		Data:       placeOrderResp.Data,
-	}
+	return askMarket(payload, market, types.MarketPlaceOrder, order, orderResponse)
 
 }
 
@@ -154,8 +124,6 @@ func SellOrder(payload types.QueuePayload) types.QueueResponse {
 		}
 	}
 
-	replyChannel := make(chan interface{})
-
 	orderId := data.OrderId
 	if orderId == "" {
 		orderId = utils.GenerateOrderID()
@@ -174,36 +142,7 @@ func SellOrder(payload types.QueuePayload) types.QueueResponse {
 		Timestamp: time.Now().UTC(),
 	}
 
-	market.Inbox <- types.MarketMessage{
-		Type:      types.MarketSellOrder,
-		Payload:   order,
-		ReplyChan: replyChannel,
-	}
-
-	rawResp := <-replyChannel
-
-	placeOrderResp, ok := rawResp.(types.OrderResponse)
-
-	if !ok {
-		return types.QueueResponse{
-			ResponseId: payload.ResponseId,
-			Status:     types.Error,
-			Message:    "Invalid response from market, having internal issues.",
-		}
-	}
-
-	status := types.Error
-
-	if placeOrderResp.Success {
-		status = types.Success
-	}
-
-	return types.QueueResponse{
-		ResponseId: payload.ResponseId,
-		Status:     status,
-		Message:    placeOrderResp.Message,
-		Data:       placeOrderResp.Data,
-	}
+	return askMarket(payload, market, types.MarketSellOrder, order, orderResponse)
 
 }
 
@@ -238,15 +177,37 @@ func CancelOrder(payload types.QueuePayload) types.QueueResponse {
 		}
 	}
 
-	replyChannel := make(chan interface{})
+	return askMarket(payload, market, types.MarketCancelOrder, data, orderResponse)
+}
+
+// askMarket queues a message on the market and, once it is queued, lets the dispatcher
+// start the next command for this market before waiting for the reply, which convert
+// turns into the command's response. When the reply is late the outcome is unknown, so
+// a keyed command's response is left for the reply to settle.
+func askMarket(payload types.QueuePayload, market *types.Market, msgType types.MarketMessageType, msg interface{}, convert func(types.QueuePayload, interface{}) types.QueueResponse) types.QueueResponse {
+	reply, err := engine.EngineInstance.Send(payload.Context(), market, msgType, msg)
+	if err != nil {
+		return inboxErrorResponse(payload, err)
+	}
+
+	if payload.Release != nil {
+		payload.Release()
+	}
 
-	market.Inbox <- types.MarketMessage{
-		Type:      types.MarketCancelOrder,
-		Payload:   data,
-		ReplyChan: replyChannel,
+	rawResp, err := engine.EngineInstance.Await(reply)
+	if errors.Is(err, engine.ErrReplyTimeout) {
+		engine.EngineInstance.SettleLate(payload, reply, func(rawResp interface{}) types.QueueResponse {
+			return convert(payload, rawResp)
+		})
 	}
+	if err != nil {
+		return inboxErrorResponse(payload, err)
+	}
+	return convert(payload, rawResp)
+}
 
-	rawResp := <-replyChannel
+// orderResponse converts a market's reply to an order or cancel.
+func orderResponse(payload types.QueuePayload, rawResp interface{}) types.QueueResponse {
 	resp, ok := rawResp.(types.OrderResponse)
 
 	if !ok {
@@ -266,5 +227,17 @@ func CancelOrder(payload types.QueuePayload) types.QueueResponse {
 		ResponseId: payload.ResponseId,
 		Status:     status,
 		Message:    resp.Message,
+		Data:       resp.Data,
+	}
+}
+
+// inboxErrorResponse reports a market that did not accept or answer in time. Only a
+// message that never reached the market is safe to retry blindly.
+func inboxErrorResponse(payload types.QueuePayload, err error) types.QueueResponse {
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Error,
+		Retryable:  errors.Is(err, engine.ErrMarketBusy),
+		Message:    err.Error(),
 	}
 }
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"matching-engine/internals/engine"
+	"matching-engine/internals/types"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog/log"
+)
+
+type ReconcileBalancesDataRequest struct {
+	Source          string `mapstructure:"source"`
+	EmitAdjustments bool   `mapstructure:"emitAdjustments"`
+}
+
+// ReconcileBalances compares the processor-service balance export with engine memory.
+func ReconcileBalances(payload types.QueuePayload) types.QueueResponse {
+	var data ReconcileBalancesDataRequest
+
+	if err := mapstructure.Decode(payload.Data, &data); err != nil {
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  false,
+			Message:    "failed to validate payload data " + err.Error(),
+		}
+	}
+
+	if data.Source == "" {
+		data.Source = "redis:" + engine.BalanceExportKey
+	}
+
+	export, err := engine.EngineInstance.LoadBalanceExport(context.Background(), data.Source)
+	if err != nil {
+		log.Error().Err(err).Str("source", data.Source).Msg("Failed to load balance export")
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     types.Error,
+			Retryable:  true,
+			Message:    "Failed to load balance export",
+		}
+	}
+
+	report := engine.EngineInstance.Reconcile(payload.Context(), export, data.Source, data.EmitAdjustments)
+
+	return types.QueueResponse{
+		ResponseId: payload.ResponseId,
+		Status:     types.Success,
+		Message:    "Reconciliation completed",
+		Data:       report,
+	}
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"matching-engine/internals/engine"
+	"matching-engine/internals/schema"
 	"matching-engine/internals/types"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog/log"
@@ -30,10 +32,9 @@ func AddReferralBonus(payload types.QueuePayload) types.QueueResponse {
 	}
 
 	engine.EngineInstance.UM.Lock()
-	defer engine.EngineInstance.UM.Unlock()
-
 	user, exists := engine.EngineInstance.User[data.UserId]
 	if !exists {
+		engine.EngineInstance.UM.Unlock()
 		log.Error().
 			Str("userId", data.UserId).
 			Msg("User not found in engine")
@@ -46,6 +47,18 @@ func AddReferralBonus(payload types.QueuePayload) types.QueueResponse {
 	}
 
 	user.Balance.WalletBalance.Amount += data.Amount
+	user.Funded = true
+	engine.EngineInstance.RecordFunding(data.Amount)
+	view := engine.BalanceViewOf(user)
+	engine.EngineInstance.UM.Unlock()
+
+	engine.EngineInstance.NotifyBalance(payload.Context(), data.UserId, view, engine.BalanceDeposit)
+	engine.EngineInstance.Publish(payload.Context(), types.FUNDS_DEPOSITED, schema.FundsDeposited{
+		UserId:    data.UserId,
+		Amount:    data.Amount,
+		Source:    schema.ReferralSource,
+		Timestamp: time.Now(),
+	})
 
 	log.Info().
 		Str("userId", data.UserId).
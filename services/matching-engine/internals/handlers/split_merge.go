@@ -1,8 +1,9 @@
 package handlers
 
 import (
+	"matching-engine/internals/config"
 	"matching-engine/internals/engine"
-	"matching-engine/internals/services/kafka"
+	"matching-engine/internals/schema"
 	"matching-engine/internals/types"
 
 	"github.com/mitchellh/mapstructure"
@@ -53,7 +54,11 @@ func SplitShares(payload types.QueuePayload) types.QueueResponse {
 		}
 	}
 
-	totalCost := float64(data.Quantity * 10)
+	totalCost := float64(data.Quantity) * config.Current().Trading.PayoutPerShare
+
+	// Market lock first to keep the same order as the matching path
+	market.Mu.Lock()
+	defer market.Mu.Unlock()
 
 	engine.EngineInstance.UM.Lock()
 	user, exists := engine.EngineInstance.User[data.UserId]
@@ -87,15 +92,21 @@ func SplitShares(payload types.QueuePayload) types.QueueResponse {
 	stock.No += data.Quantity
 	user.Balance.StockBalance[data.Symbol] = stock
 
+	// Each YES/NO pair is backed by the full payout
+	market.Collateral += totalCost
+
+	view := engine.BalanceViewOf(user)
 	engine.EngineInstance.UM.Unlock()
+	engine.EngineInstance.NotifyBalance(payload.Context(), data.UserId, view, engine.BalanceSplitMerge)
+	engine.EngineInstance.MarkTouched(data.Symbol)
 
 	// Notify DB processor to update postgres
-	kafka.ProduceEventToDBProcessor("process_db", "SHARES_SPLIT", map[string]interface{}{
-		"userId":   data.UserId,
-		"marketId": data.MarketId,
-		"symbol":   data.Symbol,
-		"quantity": data.Quantity,
-		"cost":     totalCost,
+	engine.EngineInstance.Publish(payload.Context(), types.SHARES_SPLIT, schema.SharesSplit{
+		UserId:   data.UserId,
+		MarketId: data.MarketId,
+		Symbol:   data.Symbol,
+		Quantity: data.Quantity,
+		Cost:     totalCost,
 	})
 
 	return types.QueueResponse{
@@ -143,7 +154,10 @@ func MergeShares(payload types.QueuePayload) types.QueueResponse {
 		}
 	}
 
-	totalRefund := float64(data.Quantity * 10)
+	totalRefund := float64(data.Quantity) * config.Current().Trading.PayoutPerShare
+
+	market.Mu.Lock()
+	defer market.Mu.Unlock()
 
 	engine.EngineInstance.UM.Lock()
 	user, exists := engine.EngineInstance.User[data.UserId]
@@ -182,16 +196,20 @@ func MergeShares(payload types.QueuePayload) types.QueueResponse {
 
 	// Add balance
 	user.Balance.WalletBalance.Amount += totalRefund
+	market.Collateral -= totalRefund
 
+	view := engine.BalanceViewOf(user)
 	engine.EngineInstance.UM.Unlock()
+	engine.EngineInstance.NotifyBalance(payload.Context(), data.UserId, view, engine.BalanceSplitMerge)
+	engine.EngineInstance.MarkTouched(data.Symbol)
 
 	// Notify DB processor to update postgres
-	kafka.ProduceEventToDBProcessor("process_db", "SHARES_MERGED", map[string]interface{}{
-		"userId":   data.UserId,
-		"marketId": data.MarketId,
-		"symbol":   data.Symbol,
-		"quantity": data.Quantity,
-		"refund":   totalRefund,
+	engine.EngineInstance.Publish(payload.Context(), types.SHARES_MERGED, schema.SharesMerged{
+		UserId:   data.UserId,
+		MarketId: data.MarketId,
+		Symbol:   data.Symbol,
+		Quantity: data.Quantity,
+		Refund:   totalRefund,
 	})
 
 	return types.QueueResponse{
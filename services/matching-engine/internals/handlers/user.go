@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"matching-engine/internals/engine"
+	"matching-engine/internals/schema"
 	"matching-engine/internals/types"
 
 	"github.com/mitchellh/mapstructure"
@@ -75,6 +76,14 @@ func CreateUser(payload types.QueuePayload) types.QueueResponse {
 
 	engine.EngineInstance.User[data.ID] = user
 
+	engine.EngineInstance.Publish(payload.Context(), types.USER_CREATED, schema.UserCreated{
+		UserId:        user.ID,
+		Name:          user.Name,
+		Phone:         user.Phone,
+		KycStatus:     string(user.KycVerificationStatus),
+		PaymentStatus: string(user.PaymentVerificationStatus),
+	})
+
 	log.Info().
 		Str("id", data.ID).
 		Msg("User added to engine memory")
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"matching-engine/internals/engine"
+	"matching-engine/internals/schema"
 	"matching-engine/internals/types"
 
 	"github.com/mitchellh/mapstructure"
@@ -62,6 +63,12 @@ func UpdateVerificationStatus(payload types.QueuePayload) types.QueueResponse {
 		user.PaymentVerificationStatus = types.PAYMENT_NOT_VERIFIED
 	}
 
+	engine.EngineInstance.Publish(payload.Context(), types.USER_VERIFIED, schema.UserVerified{
+		UserId:        user.ID,
+		KycStatus:     string(user.KycVerificationStatus),
+		PaymentStatus: string(user.PaymentVerificationStatus),
+	})
+
 	log.Info().
 		Str("userId", data.UserId).
 		Msg("Updated user verification statuses")
@@ -0,0 +1,148 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"matching-engine/internals/events"
+	"matching-engine/internals/types"
+)
+
+// Entry is one executed command together with the response that was sent back and the
+// events it produced. Events raised outside any command get an entry of their own with
+// no source id.
+type Entry struct {
+	Seq      uint64              `json:"seq"`
+	SourceId string              `json:"sourceId"`
+	Payload  types.QueuePayload  `json:"payload"`
+	Response types.QueueResponse `json:"response"`
+	Events   []events.Event      `json:"events,omitempty"`
+	At       time.Time           `json:"at"`
+}
+
+// Journal is an append-only JSON-lines log of executed commands. Intake only
+// acknowledges a message once its entry is on disk.
+type Journal struct {
+	path string
+	file *os.File
+	mu   sync.Mutex
+	seq  uint64
+	// seen maps intake message ids to their response so redelivered messages are not re-executed.
+	seen map[string]types.QueueResponse
+}
+
+// Open opens (or creates) the journal at path and indexes the entries already in it.
+func Open(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+
+	j := &Journal{path: path, file: file, seen: make(map[string]types.QueueResponse)}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn final line from a crash mid-write is skipped
+			continue
+		}
+		j.seq = entry.Seq
+		if entry.SourceId != "" {
+			j.seen[entry.SourceId] = entry.Response
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+
+	return j, nil
+}
+
+// Append writes an entry and syncs it to disk, returning its sequence number. The
+// response is remembered for Lookup even when the write fails, since the command has
+// run either way and a redelivery must not execute it again.
+func (j *Journal) Append(sourceId string, payload types.QueuePayload, response types.QueueResponse, evs []events.Event) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if sourceId != "" {
+		j.seen[sourceId] = response
+	}
+
+	entry := Entry{
+		Seq:      j.seq + 1,
+		SourceId: sourceId,
+		Payload:  payload,
+		Response: response,
+		Events:   evs,
+		At:       time.Now(),
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, fmt.Errorf("encode journal entry: %w", err)
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return 0, fmt.Errorf("write journal entry: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return 0, fmt.Errorf("sync journal: %w", err)
+	}
+
+	j.seq = entry.Seq
+	return entry.Seq, nil
+}
+
+// Lookup returns the journaled response for an intake message id, if it was already executed.
+func (j *Journal) Lookup(sourceId string) (types.QueueResponse, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	response, ok := j.seen[sourceId]
+	return response, ok
+}
+
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Scan calls fn for every readable entry, oldest first.
+func (j *Journal) Scan(fn func(Entry) error) error {
+	file, err := os.Open(j.path)
+	if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
@@ -0,0 +1,224 @@
+// Package metrics defines the engine's Prometheus metrics. Everything registers on the
+// default registry, which the admin server exposes at /metrics.
+package metrics
+
+import (
+	"matching-engine/internals/types"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "engine"
+
+var (
+	commands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Commands routed, by event type and response status.",
+	}, []string{"event_type", "status"})
+
+	commandLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "Time from reading a command off the intake to delivering its reply.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"event_type"})
+
+	restingOrders = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "market_resting_orders",
+		Help:      "Orders resting on the book.",
+	}, []string{"symbol"})
+
+	bestBid = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "market_best_bid",
+		Help:      "Highest resting bid per outcome; absent when that side is empty.",
+	}, []string{"symbol", "outcome"})
+
+	bestAsk = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "market_best_ask",
+		Help:      "Lowest resting ask per outcome; absent when that side is empty.",
+	}, []string{"symbol", "outcome"})
+
+	spread = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "market_spread",
+		Help:      "Best ask minus best bid per outcome; absent unless both sides are quoted.",
+	}, []string{"symbol", "outcome"})
+
+	trades = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "trades_total",
+		Help:      "Executed trades by match type.",
+	}, []string{"symbol", "match_type"})
+
+	volume = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "volume_total",
+		Help:      "Traded notional, counted the way market volume is (quantity times payout).",
+	}, []string{"symbol"})
+
+	kafkaErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_produce_errors_total",
+		Help:      "Kafka events that failed to enqueue or were reported undelivered.",
+	}, []string{"topic", "stage"})
+
+	publishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_publish_failures_total",
+		Help:      "Events the engine raised that the outbox did not take.",
+	}, []string{"event_type"})
+
+	spoolDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_spool_depth",
+		Help:      "Events waiting in the local spool for the broker to come back.",
+	})
+
+	outboxBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_outbox_backlog",
+		Help:      "Journaled events not yet handed to the publisher.",
+	})
+
+	outboxShipped = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_outbox_shipped_seq",
+		Help:      "Sequence number of the last event handed to the publisher.",
+	})
+
+	snapshotDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "snapshot_duration_seconds",
+		Help:      "Time taken by PerformSnapshot, including the upload.",
+		Buckets:   prometheus.ExponentialBuckets(.01, 2, 12),
+	})
+
+	snapshotSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "snapshot_size_bytes",
+		Help:      "Serialized size of the last snapshot before compression.",
+	})
+)
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// CountCommand counts one routed command.
+func CountCommand(eventType string, status types.Status) {
+	commands.WithLabelValues(eventType, string(status)).Inc()
+}
+
+// ObserveCommand records how long a command took from dequeue to reply.
+func ObserveCommand(eventType string, since time.Time) {
+	commandLatency.WithLabelValues(eventType).Observe(time.Since(since).Seconds())
+}
+
+// Quote is the top of one outcome's book; a zero price means that side is empty.
+type Quote struct {
+	Outcome string
+	Bid     float64
+	Ask     float64
+}
+
+// ObserveBook records resting orders and the top of book for a market.
+func ObserveBook(symbol string, resting int, quotes ...Quote) {
+	restingOrders.WithLabelValues(symbol).Set(float64(resting))
+
+	for _, q := range quotes {
+		setOrDelete(bestBid, q.Bid, symbol, q.Outcome)
+		setOrDelete(bestAsk, q.Ask, symbol, q.Outcome)
+		if q.Bid > 0 && q.Ask > 0 {
+			spread.WithLabelValues(symbol, q.Outcome).Set(q.Ask - q.Bid)
+		} else {
+			spread.DeleteLabelValues(symbol, q.Outcome)
+		}
+	}
+}
+
+func setOrDelete(g *prometheus.GaugeVec, v float64, labels ...string) {
+	if v > 0 {
+		g.WithLabelValues(labels...).Set(v)
+		return
+	}
+	g.DeleteLabelValues(labels...)
+}
+
+// CountTrade records one execution.
+func CountTrade(symbol, matchType string, notional float64) {
+	trades.WithLabelValues(symbol, matchType).Inc()
+	volume.WithLabelValues(symbol).Add(notional)
+}
+
+// KafkaError counts a failed produce. stage is "enqueue" when Produce refused the event
+// and "delivery" when the broker reported it undelivered.
+func KafkaError(topic, stage string) {
+	kafkaErrors.WithLabelValues(topic, stage).Inc()
+}
+
+// PublishFailed counts an event the engine raised but could not hand to the outbox.
+func PublishFailed(eventType string) {
+	publishFailures.WithLabelValues(eventType).Inc()
+}
+
+// SpoolDepth records how many events are waiting in the spool file.
+func SpoolDepth(n int) {
+	spoolDepth.Set(float64(n))
+}
+
+// OutboxShipped records the outbox backlog and the last sequence number shipped.
+func OutboxShipped(backlog int, seq uint64) {
+	outboxBacklog.Set(float64(backlog))
+	outboxShipped.Set(float64(seq))
+}
+
+// ObserveSnapshot records one snapshot run.
+func ObserveSnapshot(took time.Duration, size int) {
+	snapshotDuration.Observe(took.Seconds())
+	snapshotSize.Set(float64(size))
+}
+
+// inboxCollector reads queue depth at scrape time, so idle markets report accurately.
+type inboxCollector struct {
+	depths    func() []types.InboxDepth
+	depth     *prometheus.Desc
+	capacity  *prometheus.Desc
+	highWater *prometheus.Desc
+	rejected  *prometheus.Desc
+}
+
+// RegisterInboxes exposes the per-market inbox figures returned by depths.
+func RegisterInboxes(depths func() []types.InboxDepth) {
+	prometheus.MustRegister(&inboxCollector{
+		depths:    depths,
+		depth:     prometheus.NewDesc(namespace+"_market_inbox_depth", "Messages waiting in the market inbox, priority lane included.", []string{"symbol"}, nil),
+		capacity:  prometheus.NewDesc(namespace+"_market_inbox_capacity", "Orders the market inbox holds before MARKET_BUSY.", []string{"symbol"}, nil),
+		highWater: prometheus.NewDesc(namespace+"_market_inbox_high_water", "Deepest the market inbox has been.", []string{"symbol"}, nil),
+		rejected:  prometheus.NewDesc(namespace+"_market_inbox_rejected_total", "Messages refused with MARKET_BUSY.", []string{"symbol"}, nil),
+	})
+}
+
+func (c *inboxCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.depth
+	ch <- c.capacity
+	ch <- c.highWater
+	ch <- c.rejected
+}
+
+func (c *inboxCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, d := range c.depths() {
+		ch <- prometheus.MustNewConstMetric(c.depth, prometheus.GaugeValue, float64(d.Depth+d.PriorityDepth), d.Symbol)
+		ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(d.Capacity), d.Symbol)
+		ch <- prometheus.MustNewConstMetric(c.highWater, prometheus.GaugeValue, float64(d.HighWater), d.Symbol)
+		ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(d.Rejected), d.Symbol)
+	}
+}
@@ -0,0 +1,73 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/events"
+	"sync"
+)
+
+type batchKey struct{}
+
+// Batch collects the events one command produces until Commit writes them with its
+// journal entry. A market goroutine can still be publishing after the handler gave up
+// waiting for it; events that arrive once the batch is sealed are recorded on their own.
+// Events the command failed to raise are collected too, and fail its Commit.
+type Batch struct {
+	mu     sync.Mutex
+	events []events.Event
+	failed []error
+	sealed bool
+}
+
+// WithBatch returns a context whose events are collected into b.
+func WithBatch(ctx context.Context, b *Batch) context.Context {
+	if b == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, batchKey{}, b)
+}
+
+func batchFrom(ctx context.Context) *Batch {
+	b, _ := ctx.Value(batchKey{}).(*Batch)
+	return b
+}
+
+// Fail records that the command in ctx lost an event, and reports false when no batch
+// is collecting that command's events any more.
+func Fail(ctx context.Context, err error) bool {
+	b := batchFrom(ctx)
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.sealed {
+		return false
+	}
+	b.failed = append(b.failed, err)
+	return true
+}
+
+func (b *Batch) add(event events.Event) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.sealed {
+		return false
+	}
+	b.events = append(b.events, event)
+	return true
+}
+
+func (b *Batch) seal() ([]events.Event, error) {
+	if b == nil {
+		return nil, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sealed = true
+	return b.events, errors.Join(b.failed...)
+}
@@ -0,0 +1,392 @@
+// Package outbox makes the events a command produces part of its journal entry. They
+// are numbered as they are raised, written to disk together with the command, then
+// shipped to the publisher in the background, so a broker outage delays events but never loses them
+// or lets them disagree with engine state.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"matching-engine/internals/events"
+	"matching-engine/internals/journal"
+	"matching-engine/internals/metrics"
+	"matching-engine/internals/tracing"
+	"matching-engine/internals/types"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxBackoff   = 10 * time.Second
+	cursorPeriod = time.Second
+)
+
+// Outbox numbers events, journals them and ships them to pub in sequence order. Events
+// are numbered when raised, which is the order the markets changed state in, but their
+// commands can commit in any order, so an event is only queued for shipping once every
+// lower seq has been committed. The seq of the last event the publisher settled is kept
+// next to the journal; on start everything after it is shipped again, so delivery is at
+// least once and consumers dedupe on seq.
+type Outbox struct {
+	pub     events.Publisher
+	journal *journal.Journal
+	cursor  string
+
+	mu    sync.Mutex
+	seq   uint64
+	queue []events.Event
+	// queued is the seq of the last event queued; pending holds the committed events
+	// after it that wait for a lower seq to commit.
+	queued  uint64
+	pending map[uint64]events.Event
+
+	shipped   uint64
+	savedAt   time.Time
+	savedSeq  uint64
+	closed    bool
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New starts shipping to pub. With a journal, events it holds past the shipped cursor
+// are queued again first; without one, events only live in memory until shipped and
+// numbering restarts with the process.
+func New(pub events.Publisher, j *journal.Journal) (*Outbox, error) {
+	o := &Outbox{
+		pub:     pub,
+		journal: j,
+		pending: make(map[uint64]events.Event),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if j == nil {
+		log.Warn().Msg("No command journal, events are not durable and their seq restarts at 1")
+	} else {
+		o.cursor = j.Path() + ".shipped"
+		shipped, err := readCursor(o.cursor)
+		if err != nil {
+			return nil, err
+		}
+		o.shipped, o.savedSeq, o.seq = shipped, shipped, shipped
+
+		err = j.Scan(func(entry journal.Entry) error {
+			for _, event := range entry.Events {
+				if event.Seq > o.seq {
+					o.seq = event.Seq
+				}
+				if event.Seq > shipped {
+					o.queue = append(o.queue, event)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("replay outbox: %w", err)
+		}
+		// Entries are journaled in commit order, which is not quite seq order
+		sort.Slice(o.queue, func(i, k int) bool { return o.queue[i].Seq < o.queue[k].Seq })
+		if len(o.queue) > 0 {
+			log.Warn().Int("events", len(o.queue)).Uint64("shipped", shipped).Msg("Shipping journaled events left over from a previous run")
+		}
+	}
+	o.queued = o.seq
+	metrics.OutboxShipped(len(o.queue), o.shipped)
+
+	go o.run()
+	return o, nil
+}
+
+// Begin starts collecting the events of one command.
+func (o *Outbox) Begin() *Batch {
+	return &Batch{}
+}
+
+// Commit journals the command with the events collected in batch and queues them for
+// shipping. The events are queued even if the journal write fails, since the state they
+// describe has already changed. The error, which also reports events the command failed
+// to raise, is for the caller to treat the command as not fully recorded.
+func (o *Outbox) Commit(sourceId string, payload types.QueuePayload, response types.QueueResponse, batch *Batch) error {
+	evs, failed := batch.seal()
+	if failed != nil {
+		failed = fmt.Errorf("command lost events: %w", failed)
+	}
+	return errors.Join(failed, o.commit(sourceId, payload, response, evs))
+}
+
+// Publish numbers the event and adds it to the batch in ctx, or journals it on its own
+// when it was not raised by a command.
+func (o *Outbox) Publish(ctx context.Context, event events.Event) error {
+	o.mu.Lock()
+	o.seq++
+	event.Seq = o.seq
+	o.mu.Unlock()
+
+	if b := batchFrom(ctx); b != nil && b.add(event) {
+		return nil
+	}
+	return o.commit("", types.QueuePayload{}, types.QueueResponse{}, []events.Event{event})
+}
+
+func (o *Outbox) commit(sourceId string, payload types.QueuePayload, response types.QueueResponse, evs []events.Event) error {
+	if len(evs) == 0 && (o.journal == nil || sourceId == "") {
+		return nil
+	}
+
+	var err error
+
+	o.mu.Lock()
+	if o.journal != nil {
+		_, err = o.journal.Append(sourceId, payload, response, evs)
+	}
+	for _, event := range evs {
+		o.pending[event.Seq] = event
+	}
+	released := false
+	for {
+		event, ok := o.pending[o.queued+1]
+		if !ok {
+			break
+		}
+		delete(o.pending, event.Seq)
+		o.queue = append(o.queue, event)
+		o.queued, released = event.Seq, true
+	}
+	backlog := len(o.queue)
+	o.mu.Unlock()
+
+	if released {
+		metrics.OutboxShipped(backlog, o.Shipped())
+		select {
+		case o.wake <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+func (o *Outbox) Ping(ctx context.Context) error {
+	return o.pub.Ping(ctx)
+}
+
+// Backlog returns how many events are waiting to be shipped.
+func (o *Outbox) Backlog() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.queue)
+}
+
+// Shipped returns the seq of the last event handed to the publisher.
+func (o *Outbox) Shipped() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.shipped
+}
+
+// Seq returns the seq of the last event numbered.
+func (o *Outbox) Seq() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.seq
+}
+
+// Resume continues numbering after seq, typically the one recorded in the snapshot the
+// engine restored. It never moves numbering back, and is meant for startup: events still
+// waiting on a lower seq would never be queued across the skipped numbers.
+func (o *Outbox) Resume(seq uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if seq > o.seq {
+		log.Info().Uint64("from", o.seq).Uint64("to", seq).Msg("Event numbering resumed from snapshot")
+		if o.queued == o.seq {
+			o.queued = seq
+		}
+		o.seq = seq
+	}
+}
+
+// Close ships what is queued, saves the cursor and closes the publisher, all within
+// timeout. Unshipped events count as lost only without a journal; with one they are
+// shipped on the next start.
+func (o *Outbox) Close(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+
+	o.closeOnce.Do(func() { close(o.stop) })
+	<-o.done
+
+	// Last pass for whatever was committed after the loop stopped
+	left := 0
+	if evs := o.take(); len(evs) > 0 {
+		sent := o.ship(evs)
+		left = len(evs) - sent
+		o.requeue(evs[sent:])
+	}
+	// Events still waiting on a lower seq are not shipped either
+	o.mu.Lock()
+	left += len(o.pending)
+	o.mu.Unlock()
+
+	// Once the publisher is closed every shipped event is delivered, spooled or counted
+	// lost; with losses the cursor stays put so the next start ships them again
+	lost := o.pub.Close(time.Until(deadline))
+	if lost == 0 {
+		o.closed = true
+		o.saveCursor(true)
+	} else if o.journal != nil {
+		log.Warn().Int("events", lost).Uint64("cursor", o.savedSeq).Msg("Publisher lost events, they are shipped again on the next start")
+		lost = 0
+	}
+	if left > 0 {
+		if o.journal == nil {
+			log.Error().Int("events", left).Msg("Outbox closed with unshipped events, events lost")
+			return lost + left
+		}
+		log.Warn().Int("events", left).Uint64("shipped", o.Shipped()).Msg("Outbox closed with unshipped events, they are shipped on the next start")
+	}
+	return lost
+}
+
+func (o *Outbox) run() {
+	defer close(o.done)
+
+	failures := 0
+	for {
+		evs := o.take()
+		if len(evs) == 0 {
+			o.saveCursor(true)
+			// Wake up now and then to save the cursor once the publisher has settled
+			select {
+			case <-o.stop:
+				return
+			case <-o.wake:
+			case <-time.After(cursorPeriod):
+			}
+			continue
+		}
+
+		sent := o.ship(evs)
+		o.saveCursor(false)
+		if sent == len(evs) {
+			failures = 0
+			continue
+		}
+
+		o.requeue(evs[sent:])
+		failures++
+		select {
+		case <-o.stop:
+			return
+		case <-time.After(backoff(failures)):
+		}
+	}
+}
+
+func (o *Outbox) take() []events.Event {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	evs := o.queue
+	o.queue = nil
+	return evs
+}
+
+// requeue puts unshipped events back in front of anything committed meanwhile.
+func (o *Outbox) requeue(evs []events.Event) {
+	if len(evs) == 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.queue = append(evs[:len(evs):len(evs)], o.queue...)
+}
+
+// ship publishes evs in order and stops at the first one the publisher refuses. It
+// returns how many were shipped.
+func (o *Outbox) ship(evs []events.Event) int {
+	for i, event := range evs {
+		// The produce span joins the trace of the command that raised the event
+		ctx := tracing.Extract(context.Background(), event.Headers)
+		if err := o.pub.Publish(ctx, event); err != nil {
+			log.Warn().Err(err).Uint64("seq", event.Seq).Str("eventType", event.Type).Msg("Publisher refused event, retrying")
+			return i
+		}
+
+		o.mu.Lock()
+		o.shipped = event.Seq
+		backlog := len(o.queue) + len(evs) - i - 1
+		o.mu.Unlock()
+		metrics.OutboxShipped(backlog, event.Seq)
+	}
+	return len(evs)
+}
+
+// saveCursor writes the shipped seq at most once per cursorPeriod unless forced, and
+// only while the publisher has nothing unsettled: an event it accepted but could still
+// lose must be shipped again after a crash. A cursor that lags only means a few events
+// are shipped twice.
+func (o *Outbox) saveCursor(force bool) {
+	if o.cursor == "" {
+		return
+	}
+	shipped := o.Shipped()
+	if shipped == o.savedSeq || (!force && time.Since(o.savedAt) < cursorPeriod) {
+		return
+	}
+	// Read shipped first: a drained publisher then covers every event up to it
+	if !o.closed && events.Unsettled(o.pub) > 0 {
+		return
+	}
+
+	tmp := o.cursor + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(shipped, 10)+"\n"), 0o644); err != nil {
+		log.Error().Err(err).Msg("Failed to save outbox cursor")
+		return
+	}
+	if err := os.Rename(tmp, o.cursor); err != nil {
+		log.Error().Err(err).Msg("Failed to save outbox cursor")
+		return
+	}
+	o.savedSeq, o.savedAt = shipped, time.Now()
+}
+
+func readCursor(path string) (uint64, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read outbox cursor: %w", err)
+	}
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse outbox cursor %s: %w", path, err)
+	}
+	return seq, nil
+}
+
+// backoff waits 100ms doubled per consecutive failure, capped at maxBackoff.
+func backoff(failures int) time.Duration {
+	delay := 100 * time.Millisecond
+	for i := 1; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
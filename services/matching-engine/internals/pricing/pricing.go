@@ -0,0 +1,175 @@
+// Package pricing estimates a market's fair YES price from its book and recent trades.
+// The strategy is chosen per market through config.Pricing.
+package pricing
+
+import (
+	"math"
+	"time"
+
+	"matching-engine/internals/config"
+	"matching-engine/internals/types"
+)
+
+// Strategy names as set in config, and the sources reported when none of them could price.
+const (
+	Mid      = "mid"
+	Last     = "last"
+	VWAP     = "vwap"
+	Depth    = "depth"
+	Previous = "previous"
+	Default  = "default"
+)
+
+// Inputs is what a market is priced from.
+type Inputs struct {
+	// Book includes implied levels, so its YES side covers orders on both outcomes.
+	Book types.AggregatedOrderBook
+	// Trades are the market's recent trades, oldest first.
+	Trades []types.TradeExecutedEvent
+	Payout float64
+	Now    time.Time
+	// Previous is the market's YES price before this estimate.
+	Previous float64
+}
+
+// Estimator prices a market's YES outcome. It records the figures it used in fv and
+// reports false when it had nothing to price from.
+type Estimator interface {
+	Estimate(in Inputs, fv *types.FairValue) (float64, bool)
+}
+
+// New returns the estimator for p.Strategy.
+func New(p config.Pricing) Estimator {
+	switch p.Strategy {
+	case Last:
+		return lastTrade{}
+	case VWAP:
+		return vwap{window: p.VWAPWindow}
+	case Depth:
+		return depthMid{reach: float64(p.DepthTicks) * p.TickSize}
+	default:
+		return mid{}
+	}
+}
+
+// Estimate prices a market with p's strategy. The mid and the last trade are always
+// reported and stand in, in that order, when the strategy has nothing to price from;
+// failing those the previous price is kept, or half the payout for a new market. The
+// price is rounded to a hundredth.
+func Estimate(in Inputs, p config.Pricing) types.FairValue {
+	fv := types.FairValue{Strategy: p.Strategy, Source: p.Strategy}
+	price, ok := New(p).Estimate(in, &fv)
+
+	for _, fallback := range []struct {
+		source string
+		est    Estimator
+	}{
+		{Mid, mid{}},
+		{Last, lastTrade{}},
+	} {
+		if fallbackPrice, found := fallback.est.Estimate(in, &fv); found && !ok {
+			price, ok, fv.Source = fallbackPrice, true, fallback.source
+		}
+	}
+
+	if !ok {
+		price, fv.Source = in.Previous, Previous
+		if price <= 0 || price >= in.Payout {
+			price, fv.Source = in.Payout/2, Default
+		}
+	}
+	fv.YesPrice = math.Round(price*100) / 100
+	return fv
+}
+
+// mid is halfway between the best YES bid and ask.
+type mid struct{}
+
+func (mid) Estimate(in Inputs, fv *types.FairValue) (float64, bool) {
+	bids, asks := in.Book.Yes.Bids, in.Book.Yes.Asks
+	if len(bids) > 0 {
+		fv.BestBid = bids[0].Price
+	}
+	if len(asks) > 0 {
+		fv.BestAsk = asks[0].Price
+	}
+	if len(bids) == 0 || len(asks) == 0 {
+		return 0, false
+	}
+	return (fv.BestBid + fv.BestAsk) / 2, true
+}
+
+// lastTrade is the price of the most recent trade.
+type lastTrade struct{}
+
+func (lastTrade) Estimate(in Inputs, fv *types.FairValue) (float64, bool) {
+	if len(in.Trades) == 0 {
+		return 0, false
+	}
+	fv.LastTrade = yesPrice(in.Trades[len(in.Trades)-1], in.Payout)
+	return fv.LastTrade, true
+}
+
+// vwap averages the trades within window by quantity.
+type vwap struct {
+	window time.Duration
+}
+
+func (v vwap) Estimate(in Inputs, fv *types.FairValue) (float64, bool) {
+	since := in.Now.Add(-v.window)
+	notional, quantity := 0.0, 0
+	for _, t := range in.Trades {
+		if t.Timestamp.Before(since) {
+			continue
+		}
+		notional += yesPrice(t, in.Payout) * float64(t.Quantity)
+		quantity += t.Quantity
+	}
+	if quantity == 0 {
+		return 0, false
+	}
+	fv.VWAP, fv.VWAPVolume = notional/float64(quantity), quantity
+	return fv.VWAP, true
+}
+
+// depthMid averages each side over the levels within reach of its best price, then
+// weighs each side's average by the other side's quantity, so the price leans toward
+// the thinner side the way it is more likely to move.
+type depthMid struct {
+	reach float64
+}
+
+func (d depthMid) Estimate(in Inputs, fv *types.FairValue) (float64, bool) {
+	bid, bidQty := depthWithin(in.Book.Yes.Bids, d.reach)
+	ask, askQty := depthWithin(in.Book.Yes.Asks, d.reach)
+	fv.DepthBid, fv.DepthBidQuantity = bid, bidQty
+	fv.DepthAsk, fv.DepthAskQuantity = ask, askQty
+	if bidQty == 0 || askQty == 0 {
+		return 0, false
+	}
+	return (bid*float64(askQty) + ask*float64(bidQty)) / float64(bidQty+askQty), true
+}
+
+// depthWithin averages the levels no further than reach from the first, best one.
+func depthWithin(levels []types.BookLevel, reach float64) (float64, int) {
+	notional, quantity := 0.0, 0
+	for _, l := range levels {
+		if math.Abs(l.Price-levels[0].Price) > reach+1e-9 {
+			break
+		}
+		notional += l.Price * float64(l.Quantity)
+		quantity += l.Quantity
+	}
+	if quantity == 0 {
+		return 0, 0
+	}
+	return notional / float64(quantity), quantity
+}
+
+// yesPrice is a trade's price on the YES outcome; trades are quoted on the taker's.
+func yesPrice(t types.TradeExecutedEvent, payout float64) float64 {
+	if t.StockType == string(types.No) {
+		return payout - t.Price
+	}
+	return t.Price
+}
@@ -0,0 +1,81 @@
+package pricing
+
+import (
+	"matching-engine/internals/config"
+	"matching-engine/internals/types"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func levels(pairs ...float64) []types.BookLevel {
+	out := []types.BookLevel{}
+	for i := 0; i < len(pairs); i += 2 {
+		out = append(out, types.BookLevel{Price: pairs[i], Quantity: int(pairs[i+1]), Direct: int(pairs[i+1])})
+	}
+	return out
+}
+
+func yesBook(bids, asks []types.BookLevel) types.AggregatedOrderBook {
+	return types.AggregatedOrderBook{Yes: types.OutcomeBook{Bids: bids, Asks: asks}}
+}
+
+func trade(side types.Side, price float64, qty int, ago time.Duration) types.TradeExecutedEvent {
+	return types.TradeExecutedEvent{StockType: string(side), Price: price, Quantity: qty, Timestamp: now.Add(-ago)}
+}
+
+func TestEstimate(t *testing.T) {
+	pricing := func(strategy string) config.Pricing {
+		return config.Pricing{Strategy: strategy, VWAPWindow: 5 * time.Minute, DepthTicks: 2, TickSize: 0.5}
+	}
+	book := yesBook(levels(6, 2, 5.5, 6, 4, 10), levels(7, 4, 8, 1))
+	trades := []types.TradeExecutedEvent{
+		trade(types.Yes, 5, 10, 10*time.Minute),
+		trade(types.Yes, 6, 2, 2*time.Minute),
+		trade(types.No, 3.5, 2, time.Minute),
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		in       Inputs
+		price    float64
+		source   string
+	}{
+		{name: "mid", strategy: Mid, in: Inputs{Book: book, Trades: trades}, price: 6.5, source: Mid},
+		{name: "last trade on NO is quoted on YES", strategy: Last, in: Inputs{Book: book, Trades: trades}, price: 6.5, source: Last},
+		{name: "vwap skips trades outside the window", strategy: VWAP, in: Inputs{Book: book, Trades: trades}, price: 6.25, source: VWAP},
+		// within two ticks: bids 6x2 and 5.5x6 average 5.625 over 8, asks 7x4 and 8x1 average 7.2 over 5
+		{name: "depth leans to the thinner side", strategy: Depth, in: Inputs{Book: book}, price: 6.59, source: Depth},
+		{name: "one-sided book falls back to the last trade", strategy: Mid, in: Inputs{Book: yesBook(levels(6, 2), levels()), Trades: trades}, price: 6.5, source: Last},
+		{name: "no trades in the window falls back to mid", strategy: VWAP, in: Inputs{Book: book, Trades: trades[:1]}, price: 6.5, source: Mid},
+		{name: "nothing to price keeps the previous price", strategy: Depth, in: Inputs{Previous: 4.25}, price: 4.25, source: Previous},
+		{name: "new market starts at half the payout", strategy: Mid, in: Inputs{}, price: 5, source: Default},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.in.Payout, tt.in.Now = 10, now
+			fv := Estimate(tt.in, pricing(tt.strategy))
+			if fv.YesPrice != tt.price || fv.Source != tt.source || fv.Strategy != tt.strategy {
+				t.Errorf("priced %v from %s (strategy %s), want %v from %s", fv.YesPrice, fv.Source, fv.Strategy, tt.price, tt.source)
+			}
+		})
+	}
+}
+
+func TestEstimateReportsInputs(t *testing.T) {
+	in := Inputs{
+		Book:   yesBook(levels(6, 2, 5.5, 6), levels(7, 4)),
+		Trades: []types.TradeExecutedEvent{trade(types.Yes, 6, 3, time.Minute)},
+		Payout: 10,
+		Now:    now,
+	}
+	fv := Estimate(in, config.Pricing{Strategy: VWAP, VWAPWindow: 5 * time.Minute, DepthTicks: 2, TickSize: 0.5})
+
+	want := types.FairValue{Strategy: VWAP, Source: VWAP, YesPrice: 6, BestBid: 6, BestAsk: 7, LastTrade: 6, VWAP: 6, VWAPVolume: 3}
+	if fv != want {
+		t.Errorf("fair value %+v, want %+v", fv, want)
+	}
+}
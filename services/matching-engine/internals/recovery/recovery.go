@@ -0,0 +1,187 @@
+// Package recovery rebuilds engine state from the event history alone, for when no
+// usable snapshot is left. Events are read from a Source, put back in seq order and
+// replayed into a fresh engine, and the result is checked against a snapshot.
+package recovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"matching-engine/internals/engine"
+	"matching-engine/internals/events"
+	"matching-engine/internals/types"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Source reads a history of engine events. Events may come in any order and more than
+// once; Run sorts them by seq and drops duplicates.
+type Source interface {
+	Read(ctx context.Context, fn func(events.Event) error) error
+}
+
+// FileSource reads one JSON event per line, the format the event spool writes, so a
+// history can be replayed without a broker.
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Read(ctx context.Context, fn func(events.Event) error) error {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var event events.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("%s:%d: %w", s.Path, line, err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+type Options struct {
+	Source Source
+	// Name identifies the source in the report.
+	Name string
+	// Base is the snapshot to start from; events up to its seq are skipped. It must be
+	// drained, since a periodic snapshot does not line up with its seq. Nil starts from an
+	// empty engine, which needs the history from seq 1.
+	Base *engine.SnapshotData
+	// Verify is the snapshot the state is compared with once replay reaches its seq,
+	// or at the end for snapshots that carry no seq. Nil skips verification.
+	Verify *engine.SnapshotData
+}
+
+// ErrBaseNotDrained is returned for a base snapshot taken while the engine was trading.
+var ErrBaseNotDrained = errors.New("base snapshot was not taken drained")
+
+// Run replays the history from opts.Source into e, which must be new and not running,
+// and reports what it found. Numbering of events e publishes afterwards continues from
+// the last seq replayed. An error means the base is unusable or the history could not be
+// read at all.
+func Run(ctx context.Context, e *engine.Engine, opts Options) (types.RecoveryReport, error) {
+	if opts.Base != nil && !opts.Base.Drained {
+		return types.RecoveryReport{}, ErrBaseNotDrained
+	}
+
+	report := types.RecoveryReport{
+		Source:     opts.Name,
+		StartedAt:  time.Now(),
+		Gaps:       []types.SeqGap{},
+		Errors:     []types.ReplayError{},
+		Mismatches: []types.RecoveryMismatch{},
+	}
+
+	if opts.Base != nil {
+		e.Restore(opts.Base)
+		report.BaseSeq = opts.Base.EventSeq
+	}
+
+	history := make(map[uint64]events.Event)
+	err := opts.Source.Read(ctx, func(event events.Event) error {
+		report.Read++
+		if event.Seq <= report.BaseSeq {
+			return nil
+		}
+		if _, dup := history[event.Seq]; dup {
+			report.Duplicates++
+			return nil
+		}
+		history[event.Seq] = event
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("read %s: %w", opts.Name, err)
+	}
+
+	seqs := make([]uint64, 0, len(history))
+	for seq := range history {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	next := report.BaseSeq + 1
+	for _, seq := range seqs {
+		if seq > next {
+			report.Gaps = append(report.Gaps, types.SeqGap{From: next, To: seq - 1})
+		}
+		next = seq + 1
+	}
+	if len(seqs) > 0 {
+		report.FirstSeq, report.LastSeq = seqs[0], seqs[len(seqs)-1]
+	}
+	if len(report.Gaps) > 0 {
+		log.Warn().Int("gaps", len(report.Gaps)).Interface("first", report.Gaps[0]).Msg("Event history has gaps, state after them is incomplete")
+	}
+
+	verified := opts.Verify == nil
+	if opts.Verify != nil && opts.Verify.EventSeq != 0 && opts.Verify.EventSeq < report.BaseSeq {
+		log.Warn().Uint64("snapshotSeq", opts.Verify.EventSeq).Uint64("baseSeq", report.BaseSeq).Msg("Snapshot is older than the base, not verifying")
+		verified = true
+	}
+	verify := func() {
+		verified = true
+		mismatches, checked, evicted := e.CompareSnapshot(opts.Verify)
+		report.Verified = true
+		report.SnapshotAt, report.SnapshotSeq = opts.Verify.Timestamp, opts.Verify.EventSeq
+		report.SnapshotDrained = opts.Verify.Drained
+		report.UsersChecked, report.Evicted = checked, evicted
+		report.Mismatches = append(report.Mismatches, mismatches...)
+		log.Info().Uint64("snapshotSeq", opts.Verify.EventSeq).Int("mismatches", len(mismatches)).Bool("drained", opts.Verify.Drained).Msg("Replayed state compared with snapshot")
+	}
+
+	replayer := e.NewReplayer()
+	for _, seq := range seqs {
+		if !verified && opts.Verify.EventSeq != 0 && seq > opts.Verify.EventSeq {
+			verify()
+		}
+		event := history[seq]
+		if err := replayer.Apply(event); err != nil {
+			report.Errors = append(report.Errors, types.ReplayError{Seq: seq, Type: event.Type, Error: err.Error()})
+		}
+	}
+	if !verified {
+		verify()
+	}
+	report.Errors = append(report.Errors, replayer.Finish()...)
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Seq < report.Errors[j].Seq })
+	report.Applied = len(seqs) - len(report.Errors)
+
+	if seq, ok := e.Events.(events.Sequencer); ok {
+		seq.Resume(report.LastSeq)
+	}
+
+	report.Users, report.Markets, report.RestingOrders = len(e.User), len(e.Market), replayer.RestingOrders()
+	report.FinishedAt = time.Now()
+
+	log.Info().
+		Int("applied", report.Applied).
+		Int("errors", len(report.Errors)).
+		Int("gaps", len(report.Gaps)).
+		Int("mismatches", len(report.Mismatches)).
+		Uint64("lastSeq", report.LastSeq).
+		Msg("Event history replayed")
+
+	return report, nil
+}
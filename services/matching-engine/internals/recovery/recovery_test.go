@@ -0,0 +1,122 @@
+package recovery_test
+
+import (
+	"context"
+	"errors"
+	"matching-engine/internals/engine"
+	"matching-engine/internals/events"
+	"matching-engine/internals/recovery"
+	"matching-engine/internals/types"
+	"testing"
+)
+
+// testdata/history.jsonl holds two funded users and a MINT fill between them, numbered
+// ahead of the placements of both its taker and its maker, then a sell that is
+// cancelled. The file is out of seq order and repeats one event.
+// testdata/snapshot.json is the state the live engine held after the last of them.
+func TestRunRebuildsSnapshot(t *testing.T) {
+	snap, err := engine.ReadSnapshotFile("testdata/snapshot.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := engine.New(nil, events.NewRecorder())
+	report, err := recovery.Run(context.Background(), e, recovery.Options{
+		Source: recovery.FileSource{Path: "testdata/history.jsonl"},
+		Name:   "history.jsonl",
+		Verify: snap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Errors) != 0 {
+		t.Errorf("replay errors: %+v", report.Errors)
+	}
+	if len(report.Gaps) != 0 {
+		t.Errorf("gaps: %+v", report.Gaps)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Errorf("mismatches with the snapshot: %+v", report.Mismatches)
+	}
+	if !report.Verified || report.UsersChecked != 2 {
+		t.Errorf("verified %v with %d users checked, want 2", report.Verified, report.UsersChecked)
+	}
+	if report.Read != 11 || report.Duplicates != 1 || report.Applied != 10 {
+		t.Errorf("read %d, duplicates %d, applied %d; want 11, 1 and 10", report.Read, report.Duplicates, report.Applied)
+	}
+	if report.FirstSeq != 1 || report.LastSeq != 10 {
+		t.Errorf("seqs %d to %d, want 1 to 10", report.FirstSeq, report.LastSeq)
+	}
+	if report.RestingOrders != 1 {
+		t.Errorf("%d resting orders, want 1", report.RestingOrders)
+	}
+}
+
+// A replay that disagrees with the snapshot is reported, not passed over. Only a drained
+// snapshot fails the recovery, since a periodic one can catch a command halfway.
+func TestRunReportsMismatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		drained   bool
+		wantClean bool
+	}{
+		{name: "drained snapshot", drained: true, wantClean: false},
+		{name: "periodic snapshot", drained: false, wantClean: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap, err := engine.ReadSnapshotFile("testdata/snapshot.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			snap.Drained = tt.drained
+			snap.Users["bob"].Balance.StockBalance["RAIN"] = types.StockBalance{No: 3, LockedNo: 1}
+
+			e := engine.New(nil, events.NewRecorder())
+			report, err := recovery.Run(context.Background(), e, recovery.Options{
+				Source: recovery.FileSource{Path: "testdata/history.jsonl"},
+				Name:   "history.jsonl",
+				Verify: snap,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := []types.RecoveryMismatch{
+				{UserId: "bob", Symbol: "RAIN", Field: types.FieldNo, Replayed: 4, Snapshot: 3},
+				{UserId: "bob", Symbol: "RAIN", Field: types.FieldLockedNo, Replayed: 0, Snapshot: 1},
+			}
+			if len(report.Mismatches) != len(want) {
+				t.Fatalf("mismatches %+v, want %+v", report.Mismatches, want)
+			}
+			for i := range want {
+				if report.Mismatches[i] != want[i] {
+					t.Errorf("mismatch %d is %+v, want %+v", i, report.Mismatches[i], want[i])
+				}
+			}
+			if report.Clean() != tt.wantClean {
+				t.Errorf("clean %v, want %v", report.Clean(), tt.wantClean)
+			}
+		})
+	}
+}
+
+// Replaying on top of a periodic snapshot could apply a command twice or skip part of it.
+func TestRunRejectsPeriodicBase(t *testing.T) {
+	base, err := engine.ReadSnapshotFile("testdata/snapshot.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.Drained = false
+
+	_, err = recovery.Run(context.Background(), engine.New(nil, events.NewRecorder()), recovery.Options{
+		Source: recovery.FileSource{Path: "testdata/history.jsonl"},
+		Name:   "history.jsonl",
+		Base:   base,
+	})
+	if !errors.Is(err, recovery.ErrBaseNotDrained) {
+		t.Errorf("error %v, want %v", err, recovery.ErrBaseNotDrained)
+	}
+}
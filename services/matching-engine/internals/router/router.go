@@ -1,70 +1,139 @@
 package router
 
 import (
+	"matching-engine/internals/engine"
 	"matching-engine/internals/handlers"
+	"matching-engine/internals/metrics"
 	"matching-engine/internals/types"
 
 	"github.com/rs/zerolog/log"
 )
 
+// RouteEvent dispatches a command, short-circuiting retries that carry an idempotency key
+// already seen for the same user.
 func RouteEvent(payload types.QueuePayload) types.QueueResponse {
 
-	switch payload.EventType {
-
-	case "CREATE_USER":
-		return handlers.CreateUser(payload)
-
-	case "INIT_BALANCE":
-		return handlers.InitBalance(payload)
-
-	case "REFERRAL_CREDIT":
-		return handlers.AddReferralBonus(payload)
+	if payload.IdempotencyKey == "" {
+		return route(payload)
+	}
 
-	case "VERIFICATION_STATUS_UPDATE":
-		return handlers.UpdateVerificationStatus(payload)
+	if response, ok := engine.EngineInstance.LookupIdempotent(payload); ok {
+		log.Info().
+			Str("eventType", payload.EventType).
+			Str("idempotencyKey", payload.IdempotencyKey).
+			Msg("Duplicate command, returning original response")
+		return response
+	}
 
-	case "GET_BALANCE":
-		return handlers.GetBalance(payload)
+	response := route(payload)
+	engine.EngineInstance.RememberIdempotent(payload, response)
 
-	case "DEPOSIT_BALANCE":
-		return handlers.Deposit(payload)
+	return response
+}
 
-	case "WITHDRAW_BALANCE":
-		return handlers.Withdraw(payload)
+// routes maps each queue event type to its handler.
+var routes = map[string]func(types.QueuePayload) types.QueueResponse{
+	"CREATE_USER":                handlers.CreateUser,
+	"INIT_BALANCE":               handlers.InitBalance,
+	"REFERRAL_CREDIT":            handlers.AddReferralBonus,
+	"VERIFICATION_STATUS_UPDATE": handlers.UpdateVerificationStatus,
+	"GET_BALANCE":                handlers.GetBalance,
+	"DEPOSIT_BALANCE":            handlers.Deposit,
+	"WITHDRAW_BALANCE":           handlers.Withdraw,
+	"WITHDRAW_CONFIRMED":         handlers.WithdrawConfirmed,
+	"WITHDRAW_FAILED":            handlers.WithdrawFailed,
+	"CANCEL_WITHDRAWAL":          handlers.CancelWithdrawal,
+	"CREATE_MARKET":              handlers.CreateMarket,
+	"ADD_LIQUIDITY":              handlers.AddLiquidity,
+	"GET_MARKET_WITH_SYMBOL":     handlers.GetMarketDetails,
+	"GET_BOOK_SNAPSHOT":          handlers.GetBookSnapshot,
+	"RESOLVE_MARKET":             handlers.ResolveMarket,
+	"PLACE_ORDER":                handlers.BuyOrder,
+	"SELL_ORDER":                 handlers.SellOrder,
+	"CANCEL_ORDER":               handlers.CancelOrder,
+	"SPLIT_SHARES":               handlers.SplitShares,
+	"MERGE_SHARES":               handlers.MergeShares,
+	"CHECK_INVARIANTS":           handlers.CheckInvariants,
+	"RECONCILE_BALANCES":         handlers.ReconcileBalances,
+	"ADJUST_BALANCE":             handlers.AdjustBalance,
+	"ADJUST_POSITION":            handlers.AdjustPosition,
+	"APPROVE_ADJUSTMENT":         handlers.ApproveAdjustment,
+	"REJECT_ADJUSTMENT":          handlers.RejectAdjustment,
+	"GET_INBOX_STATS":            handlers.GetInboxStats,
+	"HALT_MARKET":                handlers.HaltMarket,
+	"RESTART_MARKET":             handlers.RestartMarket,
+	"GET_MARKET_DIAGNOSTIC":      handlers.GetMarketDiagnostic,
+	"RELOAD_CONFIG":              handlers.ReloadConfig,
+}
 
-	case "CREATE_MARKET":
-		return handlers.CreateMarket(payload)
+// IsRoutable reports whether the engine has a handler for eventType.
+func IsRoutable(eventType string) bool {
+	_, ok := routes[eventType]
+	return ok
+}
 
-	case "ADD_LIQUIDITY":
-		return handlers.AddLiquidity(payload)
+func route(payload types.QueuePayload) types.QueueResponse {
 
-	case "GET_MARKET_WITH_SYMBOL":
-		return handlers.GetMarketDetails(payload)
+	handler, ok := routes[payload.EventType]
+	if !ok {
+		log.Warn().Str("eventType", payload.EventType).Msg("Unhandled event type")
+		// Unknown types share one label so bad input cannot grow the metric set
+		metrics.CountCommand("unknown", types.Error)
+		return types.QueueResponse{
+			ResponseId: payload.ResponseId,
+			Status:     "error",
+			Message:    "Unhandled event type: " + payload.EventType,
+		}
+	}
 
-	case "RESOLVE_MARKET":
-		return handlers.ResolveMarket(payload)
+	response := handler(payload)
+	metrics.CountCommand(payload.EventType, response.Status)
 
-	case "PLACE_ORDER":
-		return handlers.BuyOrder(payload)
+	return response
+}
 
-	case "SELL_ORDER":
-		return handlers.SellOrder(payload)
+// marketScoped lists the events that are serialized per market symbol. Every other
+// event is serialized per user when it names one.
+var marketScoped = map[string]bool{
+	"CREATE_MARKET":          true,
+	"ADD_LIQUIDITY":          true,
+	"GET_MARKET_WITH_SYMBOL": true,
+	"GET_BOOK_SNAPSHOT":      true,
+	"PLACE_ORDER":            true,
+	"SELL_ORDER":             true,
+	"SPLIT_SHARES":           true,
+	"MERGE_SHARES":           true,
+}
 
-	case "CANCEL_ORDER":
-		return handlers.CancelOrder(payload)
+// priorityScoped events bypass the market's order stream so they can reach its
+// priority lane while new orders are still queued.
+var priorityScoped = map[string]bool{
+	"CANCEL_ORDER":   true,
+	"RESOLVE_MARKET": true,
+	"HALT_MARKET":    true,
+	"RESTART_MARKET": true,
+}
 
-	case "SPLIT_SHARES":
-		return handlers.SplitShares(payload)
+// OrderingKey names the stream of commands payload must stay ordered with:
+// "market:<symbol>" for trading, "priority:<symbol>" for cancels and halts,
+// "user:<id>" for wallet operations and "admin" for engine-wide commands.
+func OrderingKey(payload types.QueuePayload) string {
+	data, _ := payload.Data.(map[string]interface{})
+	symbol, _ := data["symbol"].(string)
 
-	case "MERGE_SHARES":
-		return handlers.MergeShares(payload)
+	if priorityScoped[payload.EventType] && symbol != "" {
+		return "priority:" + symbol
+	}
+	if marketScoped[payload.EventType] && symbol != "" {
+		return "market:" + symbol
+	}
 
-	default:
-		log.Warn().Str("eventType", payload.EventType).Msg("Unhandled event type")
-		return types.QueueResponse{
-			ResponseId: payload.ResponseId,
-			Status:     "error",
-			Message:    "Unhandled event type: " + payload.EventType,
-		}
+	if userId, _ := data["userId"].(string); userId != "" {
+		return "user:" + userId
+	}
+	if userId, _ := data["id"].(string); userId != "" && payload.EventType == "CREATE_USER" {
+		return "user:" + userId
 	}
+
+	return "admin"
 }
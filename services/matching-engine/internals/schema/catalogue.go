@@ -0,0 +1,368 @@
+package schema
+
+import (
+	"matching-engine/internals/types"
+	"time"
+)
+
+// Catalogue lists every event type in the order the docs present them. Field numbers
+// are permanent: a removed field's number is never reused.
+var Catalogue = []Spec{
+	{
+		Type: types.MARKET_CREATED, Version: 1, Family: FamilyMarket, payload: MarketCreated{},
+		Doc: "A market was listed and opened for trading.",
+	},
+	{
+		Type: types.ORDER_PLACED, Version: 1, Family: FamilyMarket, payload: OrderPlaced{},
+		Doc: "An order was accepted and has been matched as far as the book allowed. Trades it took part in follow as TRADE_EXECUTED.",
+	},
+	{
+		Type: types.TRADE_EXECUTED, Version: 1, Family: FamilyMarket, payload: TradeExecuted{},
+		Doc: "One fill between a resting maker order and the incoming taker order.",
+	},
+	{
+		Type: types.ORDER_CANCELLED, Version: 2, Family: FamilyMarket, payload: OrderCancelled{},
+		Doc: "A resting order left the book without filling, and what it had locked was released.",
+		Changes: []string{
+			"v1: `refund` held cash for buy orders and a share count for sell orders, with `type` set to `INR`, `YES_STOCK` or `NO_STOCK`.",
+			"v2: `refund` and `type` are replaced by `refundCash`, `refundShares` and `refundSide`; `reason` added.",
+		},
+	},
+	{
+		Type: types.UPDATE_STOCK_PRICE, Version: 1, Family: FamilyMarket, payload: PriceUpdated{},
+		Doc: "The displayed YES and NO prices of a market moved after an order or cancel.",
+	},
+	{
+		Type: types.INCREASE_TRADERS_COUNT, Version: 1, Family: FamilyMarket, payload: TradersCountIncreased{},
+		Doc: "A user placed their first order in a market.",
+	},
+	{
+		Type: types.MARKET_RESOLVED, Version: 1, Family: FamilyMarket, payload: MarketResolved{},
+		Doc: "A market was resolved and closed. Its resting orders are cancelled first, each with an ORDER_CANCELLED.",
+	},
+	{
+		Type: types.USER_CREATED, Version: 1, Family: FamilyWallet, payload: UserCreated{},
+		Doc: "A user account was created in the engine with an empty wallet.",
+	},
+	{
+		Type: types.USER_VERIFIED, Version: 1, Family: FamilyWallet, payload: UserVerified{},
+		Doc: "A user's KYC or payment verification status changed.",
+	},
+	{
+		Type: types.BALANCE_INITIALIZED, Version: 1, Family: FamilyWallet, payload: BalanceInitialized{},
+		Doc: "An unfunded wallet was set to an opening balance, replacing whatever it held.",
+	},
+	{
+		Type: types.FUNDS_DEPOSITED, Version: 1, Family: FamilyWallet, payload: FundsDeposited{},
+		Doc: "Cash entered a wallet from a deposit or a referral bonus.",
+	},
+	{
+		Type: types.SHARES_SPLIT, Version: 1, Family: FamilyWallet, payload: SharesSplit{},
+		Doc: "A user turned cash into equal numbers of YES and NO shares.",
+	},
+	{
+		Type: types.SHARES_MERGED, Version: 1, Family: FamilyWallet, payload: SharesMerged{},
+		Doc: "A user turned equal numbers of YES and NO shares back into cash.",
+	},
+	{
+		Type: types.WITHDRAWAL_REQUESTED, Version: 1, Family: FamilyWallet, payload: Withdrawal{},
+		Doc: "Funds moved from the wallet into a withdrawal hold, waiting for the payout system.",
+	},
+	{
+		Type: types.WITHDRAWAL_CONFIRMED, Version: 1, Family: FamilyWallet, payload: Withdrawal{},
+		Doc: "The payout went through and the held funds left the engine.",
+	},
+	{
+		Type: types.WITHDRAWAL_FAILED, Version: 1, Family: FamilyWallet, payload: Withdrawal{},
+		Doc: "The payout failed and the held funds went back to the wallet.",
+	},
+	{
+		Type: types.WITHDRAWAL_CANCELLED, Version: 1, Family: FamilyWallet, payload: Withdrawal{},
+		Doc: "The user took back a withdrawal before it was paid out.",
+	},
+	{
+		Type: types.ADJUSTMENT, Version: 1, Family: FamilyWallet, payload: Adjustment{},
+		Doc: "A manual correction to a wallet or position was requested, applied or rejected.",
+	},
+	{
+		Type: types.ADJUSTMENT_PROPOSED, Version: 1, Family: FamilyWallet, payload: AdjustmentProposed{},
+		Doc: "Reconciliation found the engine and the ledger export disagreeing and requested the adjustment, sent as ADJUSTMENT and always pending approval, that brings the engine to the ledger value.",
+		Changes: []string{
+			"v1: split out of ADJUSTMENT, which used to carry these proposals with a different set of fields.",
+		},
+	},
+	{
+		Type: types.INVARIANT_VIOLATION, Version: 1, Family: FamilyOps, payload: InvariantViolation{},
+		Doc: "The invariant checker found state that should be impossible. Affected markets are halted.",
+	},
+	{
+		Type: types.MARKET_HALTED, Version: 1, Family: FamilyMarket, payload: MarketHalted{},
+		Doc: "A market was halted until an operator restarts it, either because its goroutine panicked or on an operator's HALT_MARKET.",
+	},
+	{
+		Type: types.MARKET_RESTARTED, Version: 1, Family: FamilyMarket, payload: MarketRestarted{},
+		Doc: "An operator reopened a halted market after its book verified.",
+	},
+}
+
+type OrderPlaced struct {
+	OrderId          string    `json:"orderId" proto:"1"`
+	MarketId         string    `json:"marketId" proto:"2" key:"true"`
+	Symbol           string    `json:"symbol" proto:"3"`
+	UserId           string    `json:"userId" proto:"4"`
+	Side             string    `json:"side" proto:"5" doc:"YES or NO"`
+	Action           string    `json:"action" proto:"6" doc:"BUY or SELL"`
+	Price            float64   `json:"price" proto:"7" doc:"Limit price per share"`
+	OriginalQuantity int       `json:"originalQuantity" proto:"8"`
+	FilledQuantity   int       `json:"filledQuantity" proto:"9" doc:"Shares filled before the order came to rest or was done"`
+	Timestamp        time.Time `json:"timestamp" proto:"10"`
+	OrderType        string    `json:"orderType" proto:"11" doc:"LIMIT, or MARKET for an order that never rests; its unfilled part is released at once"`
+	Role             string    `json:"role,omitempty" proto:"12" doc:"USER, or ADMIN for liquidity orders, which lock nothing"`
+	FeeRate          float64   `json:"feeRate" proto:"13" doc:"Fee charged on the order's fills, fixed when it was accepted"`
+	AcceptedAt       time.Time `json:"acceptedAt" proto:"14" doc:"When the order was accepted; its time priority on the book"`
+}
+
+type TradeExecuted struct {
+	MarketId     string    `json:"marketId" proto:"1" key:"true"`
+	MakerId      string    `json:"makerId" proto:"2"`
+	TakerId      string    `json:"takerId" proto:"3"`
+	MakerName    string    `json:"makerName" proto:"4"`
+	TakerName    string    `json:"takerName" proto:"5"`
+	MakerOrderId string    `json:"makerOrderId" proto:"6"`
+	TakerOrderId string    `json:"takerOrderId" proto:"7"`
+	StockType    string    `json:"stockType" proto:"8" doc:"Outcome the taker traded, YES or NO"`
+	TakerAction  string    `json:"takerAction" proto:"9" doc:"BUY or SELL"`
+	Price        float64   `json:"price" proto:"10" doc:"Price per share paid by the taker"`
+	Quantity     int       `json:"quantity" proto:"11"`
+	Timestamp    time.Time `json:"timestamp" proto:"12"`
+	MatchType    string    `json:"matchType" proto:"13" doc:"STANDARD, MINT (two buys create a pair) or MERGE (two sells redeem a pair)"`
+}
+
+type OrderCancelled struct {
+	UserId       string  `json:"userId" proto:"1"`
+	OrderId      string  `json:"orderId" proto:"2"`
+	MarketId     string  `json:"marketId" proto:"3" key:"true"`
+	RefundCash   float64 `json:"refundCash" proto:"4" doc:"Cash unlocked for a buy order, fee reservation included"`
+	RefundShares int     `json:"refundShares" proto:"5" doc:"Shares unlocked for a sell order"`
+	RefundSide   string  `json:"refundSide" proto:"6" doc:"Outcome of refundShares, YES or NO; empty for buy orders"`
+	Reason       string  `json:"reason" proto:"7" doc:"USER, SELF_TRADE or MARKET_RESOLVED"`
+}
+
+// Reasons an order is cancelled.
+const (
+	CancelByUser         = "USER"
+	CancelSelfTrade      = "SELF_TRADE"
+	CancelMarketResolved = "MARKET_RESOLVED"
+)
+
+type PriceUpdated struct {
+	MarketId string  `json:"marketId" proto:"1" key:"true"`
+	YesPrice float64 `json:"yesPrice" proto:"2"`
+	NoPrice  float64 `json:"noPrice" proto:"3"`
+}
+
+type TradersCountIncreased struct {
+	MarketId string `json:"marketId" proto:"1" key:"true"`
+	Count    int    `json:"count" proto:"2" doc:"Traders added, always 1"`
+}
+
+type MarketResolved struct {
+	MarketId string `json:"marketId" proto:"1" key:"true"`
+	Result   string `json:"result" proto:"2" doc:"Winning outcome, YES or NO"`
+}
+
+type MarketCreated struct {
+	MarketId        string    `json:"marketId" proto:"1" key:"true"`
+	Symbol          string    `json:"symbol" proto:"2"`
+	Title           string    `json:"title" proto:"3"`
+	YesPrice        float64   `json:"yesPrice" proto:"4"`
+	NoPrice         float64   `json:"noPrice" proto:"5"`
+	Thumbnail       string    `json:"thumbnail" proto:"6"`
+	CategoryId      string    `json:"categoryId" proto:"7"`
+	NumberOfTraders int       `json:"numberOfTraders" proto:"8" doc:"Traders carried over from before the market was listed in the engine"`
+	SourceOfTruth   string    `json:"sourceOfTruth" proto:"9"`
+	StartDate       time.Time `json:"startDate" proto:"10"`
+	EndDate         time.Time `json:"endDate" proto:"11"`
+	EOS             string    `json:"eos" proto:"12"`
+	Rules           string    `json:"rules" proto:"13"`
+}
+
+type UserCreated struct {
+	UserId        string `json:"userId" proto:"1" key:"true"`
+	Name          string `json:"name" proto:"2"`
+	Phone         string `json:"phone" proto:"3"`
+	KycStatus     string `json:"kycStatus" proto:"4"`
+	PaymentStatus string `json:"paymentStatus" proto:"5"`
+}
+
+type UserVerified struct {
+	UserId        string `json:"userId" proto:"1" key:"true"`
+	KycStatus     string `json:"kycStatus" proto:"2" doc:"VERIFIED or NOT_VERIFIED"`
+	PaymentStatus string `json:"paymentStatus" proto:"3" doc:"VERIFIED or NOT_VERIFIED"`
+}
+
+type BalanceInitialized struct {
+	UserId string  `json:"userId" proto:"1" key:"true"`
+	Amount float64 `json:"amount" proto:"2"`
+	Locked float64 `json:"locked" proto:"3"`
+}
+
+type FundsDeposited struct {
+	UserId    string    `json:"userId" proto:"1" key:"true"`
+	Amount    float64   `json:"amount" proto:"2"`
+	Source    string    `json:"source" proto:"3" doc:"DEPOSIT or REFERRAL"`
+	Timestamp time.Time `json:"timestamp" proto:"4"`
+}
+
+// Sources of FundsDeposited.
+const (
+	DepositSource  = "DEPOSIT"
+	ReferralSource = "REFERRAL"
+)
+
+// Reasons of MarketHalted.
+const (
+	HaltPanic    = "PANIC"
+	HaltOperator = "OPERATOR"
+)
+
+type SharesSplit struct {
+	UserId   string  `json:"userId" proto:"1" key:"true"`
+	MarketId string  `json:"marketId" proto:"2"`
+	Symbol   string  `json:"symbol" proto:"3"`
+	Quantity int     `json:"quantity" proto:"4" doc:"Pairs created"`
+	Cost     float64 `json:"cost" proto:"5" doc:"Cash taken from the wallet"`
+}
+
+type SharesMerged struct {
+	UserId   string  `json:"userId" proto:"1" key:"true"`
+	MarketId string  `json:"marketId" proto:"2"`
+	Symbol   string  `json:"symbol" proto:"3"`
+	Quantity int     `json:"quantity" proto:"4" doc:"Pairs redeemed"`
+	Refund   float64 `json:"refund" proto:"5" doc:"Cash paid into the wallet"`
+}
+
+type Withdrawal struct {
+	WithdrawalId string    `json:"withdrawalId" proto:"1"`
+	UserId       string    `json:"userId" proto:"2" key:"true"`
+	Amount       float64   `json:"amount" proto:"3"`
+	Status       string    `json:"status" proto:"4" doc:"HELD, CONFIRMED, FAILED or CANCELLED"`
+	Reason       string    `json:"reason,omitempty" proto:"5" doc:"Why the payout failed"`
+	RequestedAt  time.Time `json:"requestedAt" proto:"6"`
+	ResolvedAt   time.Time `json:"resolvedAt,omitempty" proto:"7"`
+}
+
+type Adjustment struct {
+	AdjustmentId string    `json:"adjustmentId" proto:"1"`
+	Kind         string    `json:"kind" proto:"2" doc:"BALANCE or POSITION"`
+	UserId       string    `json:"userId" proto:"3" key:"true"`
+	Symbol       string    `json:"symbol,omitempty" proto:"4" doc:"Market of a POSITION adjustment"`
+	Side         string    `json:"side,omitempty" proto:"5" doc:"Outcome of a POSITION adjustment"`
+	Delta        float64   `json:"delta" proto:"6" doc:"Cash for BALANCE, shares for POSITION"`
+	ReasonCode   string    `json:"reasonCode" proto:"7"`
+	Note         string    `json:"note" proto:"8"`
+	RequestedBy  string    `json:"requestedBy" proto:"9"`
+	ApprovedBy   string    `json:"approvedBy,omitempty" proto:"10"`
+	Status       string    `json:"status" proto:"11" doc:"PENDING_APPROVAL, APPLIED or REJECTED"`
+	RequestedAt  time.Time `json:"requestedAt" proto:"12"`
+	ResolvedAt   time.Time `json:"resolvedAt,omitempty" proto:"13"`
+}
+
+type AdjustmentProposed struct {
+	ReconciliationId string  `json:"reconciliationId" proto:"1"`
+	UserId           string  `json:"userId" proto:"2" key:"true"`
+	Symbol           string  `json:"symbol,omitempty" proto:"3"`
+	Field            string  `json:"field" proto:"4" doc:"WALLET, YES or NO"`
+	Delta            float64 `json:"delta" proto:"5" doc:"Ledger value minus engine value"`
+	EngineValue      float64 `json:"engineValue" proto:"6"`
+	LedgerValue      float64 `json:"ledgerValue" proto:"7"`
+	AdjustmentId     string  `json:"adjustmentId,omitempty" proto:"8" doc:"The adjustment requested to correct it"`
+}
+
+type InvariantViolation struct {
+	CheckedAt     time.Time         `json:"checkedAt" proto:"1"`
+	Commands      uint64            `json:"commands" proto:"2" doc:"Commands executed when the check ran"`
+	Totals        InvariantTotals   `json:"totals" proto:"3"`
+	Violations    []Violation       `json:"violations" proto:"4"`
+	HaltedMarkets []string          `json:"haltedMarkets" proto:"5"`
+	Markets       []MarketPositions `json:"markets,omitempty" proto:"6" doc:"Markets blamed for a global cash mismatch"`
+}
+
+type InvariantTotals struct {
+	Cash       float64 `json:"cash" proto:"1"`
+	Locked     float64 `json:"locked" proto:"2"`
+	Held       float64 `json:"held" proto:"3"`
+	Collateral float64 `json:"collateral" proto:"4"`
+	Fees       float64 `json:"fees" proto:"5"`
+	Evicted    float64 `json:"evicted" proto:"6"`
+	NetFunding float64 `json:"netFunding" proto:"7"`
+}
+
+type Violation struct {
+	Check    string  `json:"check" proto:"1"`
+	Symbol   string  `json:"symbol,omitempty" proto:"2"`
+	UserId   string  `json:"userId,omitempty" proto:"3"`
+	OrderId  string  `json:"orderId,omitempty" proto:"4"`
+	Expected float64 `json:"expected" proto:"5"`
+	Actual   float64 `json:"actual" proto:"6"`
+	Detail   string  `json:"detail" proto:"7"`
+}
+
+type MarketPositions struct {
+	Symbol     string  `json:"symbol" proto:"1"`
+	MarketId   string  `json:"marketId" proto:"2"`
+	Status     string  `json:"status" proto:"3"`
+	Collateral float64 `json:"collateral" proto:"4"`
+	YesSupply  int     `json:"yesSupply" proto:"5"`
+	NoSupply   int     `json:"noSupply" proto:"6"`
+}
+
+type MarketHalted struct {
+	Symbol      string      `json:"symbol" proto:"1"`
+	MarketId    string      `json:"marketId" proto:"2" key:"true"`
+	Panic       string      `json:"panic" proto:"3"`
+	Stack       string      `json:"stack" proto:"4"`
+	MessageType string      `json:"messageType" proto:"5" doc:"Market message being handled when it panicked"`
+	Payload     string      `json:"payload" proto:"6" doc:"That message's payload as JSON"`
+	At          time.Time   `json:"at" proto:"7"`
+	Book        []BookOrder `json:"book" proto:"8" doc:"Every resting order at the time, in heap order"`
+	Reason      string      `json:"reason" proto:"9" doc:"PANIC, or OPERATOR for HALT_MARKET, which leaves the diagnostic fields empty"`
+}
+
+type BookOrder struct {
+	OrderId   string    `json:"orderId" proto:"1"`
+	UserId    string    `json:"userId" proto:"2"`
+	Side      string    `json:"side" proto:"3"`
+	Action    string    `json:"action" proto:"4"`
+	Price     float64   `json:"price" proto:"5"`
+	Quantity  int       `json:"quantity" proto:"6"`
+	Filled    int       `json:"filled" proto:"7"`
+	Timestamp time.Time `json:"timestamp" proto:"8"`
+}
+
+type MarketRestarted struct {
+	MarketId   string    `json:"marketId" proto:"1" key:"true"`
+	Symbol     string    `json:"symbol" proto:"2"`
+	OperatorId string    `json:"operatorId" proto:"3"`
+	Timestamp  time.Time `json:"timestamp" proto:"4"`
+}
+
+func (MarketCreated) isPayload()         {}
+func (UserCreated) isPayload()           {}
+func (UserVerified) isPayload()          {}
+func (BalanceInitialized) isPayload()    {}
+func (FundsDeposited) isPayload()        {}
+func (OrderPlaced) isPayload()           {}
+func (TradeExecuted) isPayload()         {}
+func (OrderCancelled) isPayload()        {}
+func (PriceUpdated) isPayload()          {}
+func (TradersCountIncreased) isPayload() {}
+func (MarketResolved) isPayload()        {}
+func (SharesSplit) isPayload()           {}
+func (SharesMerged) isPayload()          {}
+func (Withdrawal) isPayload()            {}
+func (Adjustment) isPayload()            {}
+func (AdjustmentProposed) isPayload()    {}
+func (InvariantViolation) isPayload()    {}
+func (MarketHalted) isPayload()          {}
+func (MarketRestarted) isPayload()       {}
@@ -0,0 +1,173 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// ProtoFile renders the catalogue as a proto3 file, one message per payload type and
+// per nested struct.
+func ProtoFile() []byte {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by cmd/eventdoc from internals/schema; DO NOT EDIT.\n\n")
+	b.WriteString("syntax = \"proto3\";\n\npackage matching_engine.events;\n\n")
+	b.WriteString("import \"google/protobuf/timestamp.proto\";\n")
+
+	for _, t := range messageTypes() {
+		b.WriteString("\n")
+		for _, spec := range Catalogue {
+			if reflect.TypeOf(spec.payload) == t {
+				fmt.Fprintf(&b, "// %s, schema version %d, %s. %s\n", spec.Type, spec.Version, routing(spec), spec.Doc)
+			}
+		}
+		fmt.Fprintf(&b, "message %s {\n", t.Name())
+		fields, _ := protoFields(t)
+		for _, f := range fields {
+			sf := t.Field(f.index)
+			if doc := sf.Tag.Get("doc"); doc != "" {
+				fmt.Fprintf(&b, "  // %s\n", doc)
+			}
+			fmt.Fprintf(&b, "  %s %s = %d;\n", protoType(sf.Type), snakeCase(jsonName(sf)), f.number)
+		}
+		b.WriteString("}\n")
+	}
+	return b.Bytes()
+}
+
+// Markdown renders the catalogue as reference documentation for consumers.
+func Markdown() []byte {
+	var b bytes.Buffer
+	b.WriteString("<!-- Code generated by cmd/eventdoc from internals/schema; DO NOT EDIT. -->\n\n")
+	b.WriteString("# Engine events\n\n")
+	b.WriteString("Every event carries the headers `eventType`, `schemaVersion`, `contentType` and `seq`. ")
+	b.WriteString("With `contentType: application/json` the body is `{\"seq\", \"type\", \"schemaVersion\", \"data\"}` with `data` as below; ")
+	b.WriteString("with `application/x-protobuf` the body is the event's message from [events.proto](../internals/schema/events.proto). ")
+	b.WriteString("A schema version only changes when a field changes meaning or is removed, so consumers should reject versions they do not know.\n\n")
+	b.WriteString("Each family of events goes to its own topic. Events of the `market` and `wallet` families carry a message key, ")
+	b.WriteString("so every event for one market, or for one user, lands on the same partition in the order it happened. ")
+	b.WriteString("Order across keys is not guaranteed; use `seq` for that.\n\n")
+
+	b.WriteString("| Event | Version | Family | Key | Message |\n| --- | --- | --- | --- | --- |\n")
+	for _, spec := range Catalogue {
+		key := "none"
+		if field := spec.KeyField(); field != "" {
+			key = "`" + field + "`"
+		}
+		fmt.Fprintf(&b, "| [`%s`](#%s) | %d | `%s` | %s | `%s` |\n", spec.Type, strings.ToLower(string(spec.Type)), spec.Version, spec.Family, key, spec.Message())
+	}
+
+	for _, spec := range Catalogue {
+		fmt.Fprintf(&b, "\n## %s\n\n%s\n\n", spec.Type, spec.Doc)
+		fmt.Fprintf(&b, "Schema version %d, message `%s`, %s.\n\n", spec.Version, spec.Message(), routing(spec))
+		writeFieldTable(&b, reflect.TypeOf(spec.payload))
+		if len(spec.Changes) > 0 {
+			b.WriteString("\nChanges:\n\n")
+			for _, change := range spec.Changes {
+				fmt.Fprintf(&b, "- %s\n", change)
+			}
+		}
+	}
+
+	var nested []reflect.Type
+	for _, t := range messageTypes() {
+		if _, top := topLevel()[t]; !top {
+			nested = append(nested, t)
+		}
+	}
+	if len(nested) > 0 {
+		b.WriteString("\n## Nested messages\n")
+		for _, t := range nested {
+			fmt.Fprintf(&b, "\n### %s\n\n", t.Name())
+			writeFieldTable(&b, t)
+		}
+	}
+	return b.Bytes()
+}
+
+// routing describes where events of a type are published.
+func routing(spec Spec) string {
+	if field := spec.KeyField(); field != "" {
+		return fmt.Sprintf("%s family keyed by %s", spec.Family, field)
+	}
+	return fmt.Sprintf("%s family, unkeyed", spec.Family)
+}
+
+func writeFieldTable(b *bytes.Buffer, t reflect.Type) {
+	b.WriteString("| Field | Proto | Type | |\n| --- | --- | --- | --- |\n")
+	fields, _ := protoFields(t)
+	for _, f := range fields {
+		sf := t.Field(f.index)
+		fmt.Fprintf(b, "| `%s` | %d | `%s` | %s |\n", jsonName(sf), f.number, protoType(sf.Type), sf.Tag.Get("doc"))
+	}
+}
+
+func topLevel() map[reflect.Type]struct{} {
+	top := map[reflect.Type]struct{}{}
+	for _, spec := range Catalogue {
+		top[reflect.TypeOf(spec.payload)] = struct{}{}
+	}
+	return top
+}
+
+// messageTypes returns every payload type in catalogue order, each followed by the
+// nested structs it introduces, without duplicates.
+func messageTypes() []reflect.Type {
+	var out []reflect.Type
+	seen := map[reflect.Type]bool{}
+	var visit func(t reflect.Type)
+	visit = func(t reflect.Type) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		out = append(out, t)
+		for i := 0; i < t.NumField(); i++ {
+			ft := t.Field(i).Type
+			if ft.Kind() == reflect.Slice {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				visit(ft)
+			}
+		}
+	}
+	for _, spec := range Catalogue {
+		visit(reflect.TypeOf(spec.payload))
+	}
+	return out
+}
+
+func protoType(t reflect.Type) string {
+	if t.Kind() == reflect.Slice {
+		return "repeated " + protoType(t.Elem())
+	}
+	if name, err := scalarType(t); err == nil {
+		return name
+	}
+	return t.Name()
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,370 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Payloads are encoded and decoded by reflection from their proto tags, following
+// proto3 rules: zero scalars are omitted, numeric lists are packed, time.Time is a
+// google.protobuf.Timestamp and nested structs are messages. Go int maps to int64.
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type protoField struct {
+	index  int
+	number protowire.Number
+}
+
+func protoFields(t reflect.Type) ([]protoField, error) {
+	var fields []protoField
+	seen := map[protowire.Number]string{}
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("proto")
+		if tag == "" {
+			return nil, fmt.Errorf("%s.%s has no proto tag", t.Name(), t.Field(i).Name)
+		}
+		n, err := strconv.Atoi(tag)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%s.%s has invalid proto tag %q", t.Name(), t.Field(i).Name, tag)
+		}
+		number := protowire.Number(n)
+		if other, dup := seen[number]; dup {
+			return nil, fmt.Errorf("%s.%s reuses field %d of %s", t.Name(), t.Field(i).Name, n, other)
+		}
+		seen[number] = t.Field(i).Name
+		fields = append(fields, protoField{index: i, number: number})
+	}
+	return fields, nil
+}
+
+// checkMessage verifies a payload type can be encoded, so a bad tag fails at startup
+// rather than on the first publish.
+func checkMessage(t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("%s is not a struct", t)
+	}
+	fields, err := protoFields(t)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		ft := t.Field(f.index).Type
+		if ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		if _, err := scalarType(ft); err == nil {
+			continue
+		}
+		if ft.Kind() != reflect.Struct {
+			return fmt.Errorf("%s.%s has unsupported type %s", t.Name(), t.Field(f.index).Name, ft)
+		}
+		if err := checkMessage(ft); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalProto(payload Payload) ([]byte, error) {
+	return appendMessage(nil, reflect.ValueOf(payload))
+}
+
+func appendMessage(b []byte, v reflect.Value) ([]byte, error) {
+	fields, err := protoFields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if fv.Kind() == reflect.Slice {
+			if b, err = appendRepeated(b, f.number, fv); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if fv.IsZero() || (fv.Type() == timeType && fv.Interface().(time.Time).IsZero()) {
+			continue
+		}
+		if b, err = appendValue(b, f.number, fv); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendRepeated(b []byte, num protowire.Number, v reflect.Value) ([]byte, error) {
+	if v.Len() == 0 {
+		return b, nil
+	}
+
+	var err error
+	if packable(v.Type().Elem()) {
+		var packed []byte
+		for i := 0; i < v.Len(); i++ {
+			packed = appendScalar(packed, v.Index(i))
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, packed), nil
+	}
+	for i := 0; i < v.Len(); i++ {
+		if b, err = appendValue(b, num, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendValue(b []byte, num protowire.Number, v reflect.Value) ([]byte, error) {
+	switch {
+	case v.Type() == timeType:
+		t := v.Interface().(time.Time)
+		var ts []byte
+		if s := t.Unix(); s != 0 {
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(s))
+		}
+		if n := t.Nanosecond(); n != 0 {
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(n))
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, ts), nil
+
+	case v.Kind() == reflect.String:
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendString(b, v.String()), nil
+
+	case v.Kind() == reflect.Struct:
+		inner, err := appendMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, inner), nil
+
+	case v.Kind() == reflect.Float64:
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		return appendScalar(b, v), nil
+
+	case packable(v.Type()):
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return appendScalar(b, v), nil
+	}
+	return nil, fmt.Errorf("cannot encode %s", v.Type())
+}
+
+// appendScalar writes a numeric or bool value without its tag.
+func appendScalar(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Float64:
+		return protowire.AppendFixed64(b, math.Float64bits(v.Float()))
+	case reflect.Bool:
+		return protowire.AppendVarint(b, protowire.EncodeBool(v.Bool()))
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return protowire.AppendVarint(b, uint64(v.Int()))
+	default:
+		return protowire.AppendVarint(b, v.Uint())
+	}
+}
+
+func packable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Float64, reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// scalarType returns the .proto type of a Go field type that is not a message.
+func scalarType(t reflect.Type) (string, error) {
+	if t == timeType {
+		return "google.protobuf.Timestamp", nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "bool", nil
+	case reflect.Float64:
+		return "double", nil
+	case reflect.Int, reflect.Int64:
+		return "int64", nil
+	case reflect.Int32:
+		return "int32", nil
+	case reflect.Uint32:
+		return "uint32", nil
+	case reflect.Uint64:
+		return "uint64", nil
+	}
+	return "", fmt.Errorf("%s is not a scalar", t)
+}
+
+func unmarshalProto(t reflect.Type, b []byte) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	fields, err := protoFields(t)
+	if err != nil {
+		return v, err
+	}
+	byNumber := make(map[protowire.Number]int, len(fields))
+	for _, f := range fields {
+		byNumber[f.number] = f.index
+	}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return v, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		index, known := byNumber[num]
+		if !known {
+			// Fields added after this build are skipped
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return v, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		fv := v.Field(index)
+		if fv.Kind() == reflect.Slice && typ == protowire.BytesType && packable(fv.Type().Elem()) {
+			packed, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return v, protowire.ParseError(n)
+			}
+			b = b[n:]
+			for len(packed) > 0 {
+				elem := reflect.New(fv.Type().Elem()).Elem()
+				m, err := consumeValue(elem, scalarWireType(elem.Kind()), packed)
+				if err != nil {
+					return v, err
+				}
+				packed = packed[m:]
+				fv.Set(reflect.Append(fv, elem))
+			}
+			continue
+		}
+
+		target := fv
+		if fv.Kind() == reflect.Slice {
+			target = reflect.New(fv.Type().Elem()).Elem()
+		}
+		m, err := consumeValue(target, typ, b)
+		if err != nil {
+			return v, fmt.Errorf("%s.%s: %w", t.Name(), t.Field(index).Name, err)
+		}
+		b = b[m:]
+		if fv.Kind() == reflect.Slice {
+			fv.Set(reflect.Append(fv, target))
+		}
+	}
+	return v, nil
+}
+
+// consumeValue decodes one value of wire type typ from b into v and returns the bytes
+// it used.
+func consumeValue(v reflect.Value, typ protowire.Type, b []byte) (int, error) {
+	if want := wireType(v.Type()); typ != want {
+		return 0, fmt.Errorf("wire type %d, want %d", typ, want)
+	}
+
+	switch {
+	case v.Type() == timeType || v.Kind() == reflect.Struct || v.Kind() == reflect.String:
+		raw, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		switch {
+		case v.Type() == timeType:
+			t, err := consumeTimestamp(raw)
+			if err != nil {
+				return 0, err
+			}
+			v.Set(reflect.ValueOf(t))
+		case v.Kind() == reflect.Struct:
+			inner, err := unmarshalProto(v.Type(), raw)
+			if err != nil {
+				return 0, err
+			}
+			v.Set(inner)
+		default:
+			v.SetString(string(raw))
+		}
+		return n, nil
+
+	case v.Kind() == reflect.Float64:
+		bits, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		v.SetFloat(math.Float64frombits(bits))
+		return n, nil
+	}
+
+	x, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(protowire.DecodeBool(x))
+	case reflect.Int, reflect.Int64:
+		v.SetInt(int64(x))
+	case reflect.Int32:
+		v.SetInt(int64(int32(x)))
+	default:
+		v.SetUint(x)
+	}
+	return n, nil
+}
+
+func consumeTimestamp(b []byte) (time.Time, error) {
+	var seconds, nanos int64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.VarintType || (num != 1 && num != 2) {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return time.Time{}, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		x, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 1 {
+			seconds = int64(x)
+		} else {
+			nanos = int64(int32(x))
+		}
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
+
+// wireType is how a field of type t is written, packed lists aside.
+func wireType(t reflect.Type) protowire.Type {
+	if t == timeType || t.Kind() == reflect.Struct || t.Kind() == reflect.String {
+		return protowire.BytesType
+	}
+	return scalarWireType(t.Kind())
+}
+
+func scalarWireType(kind reflect.Kind) protowire.Type {
+	if kind == reflect.Float64 {
+		return protowire.Fixed64Type
+	}
+	return protowire.VarintType
+}
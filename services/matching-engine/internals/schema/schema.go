@@ -0,0 +1,189 @@
+// Package schema is the catalogue of events the engine publishes. Every event type has
+// one typed payload and a schema version that is bumped whenever a field changes
+// meaning or goes away; adding a field keeps the version. Payloads encode as JSON or
+// Protobuf, and the catalogue also generates events.proto and docs/events.md.
+package schema
+
+//go:generate go run ../../cmd/eventdoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"matching-engine/internals/types"
+	"reflect"
+)
+
+// Payload is implemented by every event body in the catalogue.
+type Payload interface {
+	isPayload()
+}
+
+// Encoding is how event bodies are serialized on the wire.
+type Encoding string
+
+const (
+	JSON     Encoding = "json"
+	Protobuf Encoding = "protobuf"
+)
+
+// ContentType is sent in the contentType header so consumers can pick a decoder.
+func (enc Encoding) ContentType() string {
+	if enc == Protobuf {
+		return "application/x-protobuf"
+	}
+	return "application/json"
+}
+
+// Header names every published event carries next to the trace headers.
+const (
+	HeaderEventType     = "eventType"
+	HeaderSchemaVersion = "schemaVersion"
+	HeaderContentType   = "contentType"
+	HeaderSeq           = "seq"
+)
+
+// Family groups event types that share a topic and a partition key. Events with the
+// same key land on the same partition, so consumers see them in the order they happened.
+type Family string
+
+const (
+	// FamilyMarket events change a market's book and are keyed by marketId.
+	FamilyMarket Family = "market"
+	// FamilyWallet events change a user's cash or holdings outside the book and are
+	// keyed by userId.
+	FamilyWallet Family = "wallet"
+	// FamilyOps events are operator alerts and have no key.
+	FamilyOps Family = "ops"
+)
+
+// Spec describes one event type.
+type Spec struct {
+	Type    types.EVENTS
+	Version int
+	Family  Family
+	Doc     string
+	// Changes lists what each version changed, oldest first.
+	Changes []string
+	payload Payload
+}
+
+// Message is the name of the payload's Protobuf message.
+func (s Spec) Message() string {
+	return reflect.TypeOf(s.payload).Name()
+}
+
+// KeyField is the JSON name of the payload field events of this type are keyed by, or
+// "" for unkeyed events.
+func (s Spec) KeyField() string {
+	if i, ok := keyIndex[reflect.TypeOf(s.payload)]; ok {
+		return jsonName(reflect.TypeOf(s.payload).Field(i))
+	}
+	return ""
+}
+
+var (
+	byType = map[types.EVENTS]Spec{}
+	// keyIndex holds the field tagged key:"true" of each keyed payload type.
+	keyIndex = map[reflect.Type]int{}
+)
+
+func init() {
+	for _, spec := range Catalogue {
+		if _, dup := byType[spec.Type]; dup {
+			panic("schema: duplicate event type " + spec.Type)
+		}
+		t := reflect.TypeOf(spec.payload)
+		if err := checkMessage(t); err != nil {
+			panic(fmt.Sprintf("schema: %s: %v", spec.Type, err))
+		}
+		if err := checkKey(spec.Family, t); err != nil {
+			panic(fmt.Sprintf("schema: %s: %v", spec.Type, err))
+		}
+		byType[spec.Type] = spec
+	}
+}
+
+// checkKey verifies that market and wallet payloads have exactly one string key field
+// and ops payloads have none.
+func checkKey(family Family, t reflect.Type) error {
+	var keys []int
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("key") == "true" {
+			keys = append(keys, i)
+		}
+	}
+
+	switch family {
+	case FamilyMarket, FamilyWallet:
+		if len(keys) != 1 || t.Field(keys[0]).Type.Kind() != reflect.String {
+			return fmt.Errorf("%s events need exactly one string field tagged key", family)
+		}
+		keyIndex[t] = keys[0]
+	case FamilyOps:
+		if len(keys) != 0 {
+			return fmt.Errorf("ops events are not keyed")
+		}
+	default:
+		return fmt.Errorf("unknown family %q", family)
+	}
+	return nil
+}
+
+// Key returns the partition key of a payload: its marketId or userId, or "" for
+// payloads without a key field.
+func Key(payload Payload) string {
+	i, ok := keyIndex[reflect.TypeOf(payload)]
+	if !ok {
+		return ""
+	}
+	return reflect.ValueOf(payload).Field(i).String()
+}
+
+// Lookup returns the spec for an event type.
+func Lookup(eventType types.EVENTS) (Spec, bool) {
+	spec, ok := byType[eventType]
+	return spec, ok
+}
+
+// Decode rebuilds the typed payload of a journaled or spooled event. Data written
+// under another schema version, or for an unknown type, is returned as raw JSON.
+func Decode(eventType types.EVENTS, version int, data json.RawMessage) (interface{}, error) {
+	spec, ok := byType[eventType]
+	if !ok || spec.Version != version {
+		return data, nil
+	}
+	payload := reflect.New(reflect.TypeOf(spec.payload))
+	if err := json.Unmarshal(data, payload.Interface()); err != nil {
+		return nil, fmt.Errorf("decode %s v%d: %w", eventType, version, err)
+	}
+	return payload.Elem().Interface(), nil
+}
+
+// Unmarshal rebuilds the typed payload of an event read back from the wire. A JSON body
+// under another schema version, or for an unknown type, is returned as raw JSON; a
+// Protobuf body can only be read with the schema it was written with.
+func Unmarshal(enc Encoding, eventType types.EVENTS, version int, body []byte) (interface{}, error) {
+	if enc != Protobuf {
+		return Decode(eventType, version, body)
+	}
+	spec, ok := byType[eventType]
+	if !ok || spec.Version != version {
+		return nil, fmt.Errorf("no schema for %s v%d", eventType, version)
+	}
+	payload, err := unmarshalProto(reflect.TypeOf(spec.payload), body)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s v%d: %w", eventType, version, err)
+	}
+	return payload.Interface(), nil
+}
+
+// Marshal encodes a payload. Anything that is not a catalogue payload, such as raw
+// JSON from an older version, is always encoded as JSON.
+func Marshal(enc Encoding, data interface{}) ([]byte, Encoding, error) {
+	if payload, ok := data.(Payload); ok && enc == Protobuf {
+		body, err := marshalProto(payload)
+		return body, Protobuf, err
+	}
+	body, err := json.Marshal(data)
+	return body, JSON, err
+}
@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"matching-engine/internals/config"
+	"matching-engine/internals/events"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/rs/zerolog/log"
+)
+
+const historyTimeout = 10 * time.Second
+
+// History reads the event topics from a starting point up to the end they had when the
+// read began. It assigns partitions directly, so it never joins a consumer group or
+// commits offsets.
+type History struct {
+	brokers string
+	topics  []string
+	offset  int64
+	since   time.Time
+}
+
+// NewHistory reads topics from offset in every partition, or from the first message at
+// or after since when it is set. An offset below what a partition still retains starts
+// at its oldest message.
+func NewHistory(cfg config.Kafka, topics []string, offset int64, since time.Time) *History {
+	return &History{brokers: cfg.Brokers, topics: topics, offset: offset, since: since}
+}
+
+func (h *History) Read(ctx context.Context, fn func(events.Event) error) error {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":    h.brokers,
+		"group.id":             "matching-engine-recovery",
+		"enable.auto.commit":   false,
+		"enable.partition.eof": true,
+		"auto.offset.reset":    "earliest",
+	})
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	assignment, ends, err := h.plan(consumer)
+	if err != nil {
+		return err
+	}
+	if len(assignment) == 0 {
+		log.Warn().Strs("topics", h.topics).Msg("No events to read from Kafka")
+		return nil
+	}
+	if err := consumer.Assign(assignment); err != nil {
+		return fmt.Errorf("assign partitions: %w", err)
+	}
+	log.Info().Int("partitions", len(assignment)).Strs("topics", h.topics).Msg("Reading event history from Kafka")
+
+	for len(ends) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch ev := consumer.Poll(500).(type) {
+		case *kafka.Message:
+			tp := partitionOf(ev.TopicPartition)
+			headers := make(map[string]string, len(ev.Headers))
+			for _, header := range ev.Headers {
+				headers[header.Key] = string(header.Value)
+			}
+			event, err := events.Decode(ev.Value, headers)
+			if err != nil {
+				return fmt.Errorf("decode %s offset %d: %w", tp, ev.TopicPartition.Offset, err)
+			}
+			event.Topic, event.Key = *ev.TopicPartition.Topic, string(ev.Key)
+			if err := fn(event); err != nil {
+				return err
+			}
+			if int64(ev.TopicPartition.Offset) >= ends[tp]-1 {
+				delete(ends, tp)
+			}
+		case kafka.PartitionEOF:
+			delete(ends, partitionOf(kafka.TopicPartition(ev)))
+		case kafka.Error:
+			if ev.IsFatal() {
+				return ev
+			}
+			log.Warn().Err(ev).Msg("Kafka consumer error while reading history")
+		}
+	}
+	return nil
+}
+
+// plan picks the starting offset of every partition that has something to read, and
+// the high watermark it is read up to.
+func (h *History) plan(consumer *kafka.Consumer) ([]kafka.TopicPartition, map[string]int64, error) {
+	var assignment []kafka.TopicPartition
+	ends := make(map[string]int64)
+	timeout := int(historyTimeout.Milliseconds())
+
+	for _, topic := range h.topics {
+		metadata, err := consumer.GetMetadata(&topic, false, timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("metadata for %s: %w", topic, err)
+		}
+		for _, partition := range metadata.Topics[topic].Partitions {
+			low, high, err := consumer.QueryWatermarkOffsets(topic, partition.ID, timeout)
+			if err != nil {
+				return nil, nil, fmt.Errorf("watermarks of %s[%d]: %w", topic, partition.ID, err)
+			}
+
+			start := h.offset
+			if !h.since.IsZero() {
+				found, err := consumer.OffsetsForTimes([]kafka.TopicPartition{
+					{Topic: &topic, Partition: partition.ID, Offset: kafka.Offset(h.since.UnixMilli())},
+				}, timeout)
+				if err != nil {
+					return nil, nil, fmt.Errorf("offset for time in %s[%d]: %w", topic, partition.ID, err)
+				}
+				start = int64(found[0].Offset)
+				if start < 0 {
+					// Nothing at or after since
+					start = high
+				}
+			}
+			if start < low {
+				start = low
+			}
+			if start >= high {
+				continue
+			}
+
+			tp := kafka.TopicPartition{Topic: &topic, Partition: partition.ID, Offset: kafka.Offset(start)}
+			assignment = append(assignment, tp)
+			ends[partitionOf(tp)] = high
+		}
+	}
+	return assignment, ends, nil
+}
+
+func partitionOf(tp kafka.TopicPartition) string {
+	return fmt.Sprintf("%s[%d]", *tp.Topic, tp.Partition)
+}
@@ -1,78 +1,189 @@
 package kafka
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
+	"matching-engine/internals/config"
+	"matching-engine/internals/events"
+	"matching-engine/internals/metrics"
+	"matching-engine/internals/schema"
+	"matching-engine/internals/tracing"
 	"sync"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	producerInstance *kafka.Producer
-	once             sync.Once
-)
+// Publisher produces engine events to Kafka. Produce only queues a message, so a failed
+// delivery is reported later through the OnUndelivered callback.
+type Publisher struct {
+	producer *kafka.Producer
+	encoding schema.Encoding
+	done     chan struct{}
 
-type Event struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+	mu          sync.Mutex
+	undelivered func(events.Event)
 }
 
-func InitProducer() {
-	once.Do(func() {
-		producer, err := kafka.NewProducer(&kafka.ConfigMap{
-			"bootstrap.servers": "localhost",
-		})
+// NewPublisher connects a producer to the configured brokers and starts reading its
+// delivery reports. Message bodies are written in encoding.
+func NewPublisher(cfg config.Kafka, encoding schema.Encoding) (*Publisher, error) {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":                     cfg.Brokers,
+		"acks":                                  cfg.Acks,
+		"enable.idempotence":                    cfg.Idempotent,
+		"max.in.flight.requests.per.connection": cfg.MaxInFlight,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to create Kafka producer")
-		}
-		producerInstance = producer
-
-		go func() {
-			for e := range producerInstance.Events() {
-				switch ev := e.(type) {
-				case *kafka.Message:
-					if ev.TopicPartition.Error != nil {
-						log.Error().Err(ev.TopicPartition.Error).Msg("Kafka delivery failed")
-					} else {
-						log.Debug().Msgf("Delivered to %v", ev.TopicPartition)
-					}
-				}
+	p := &Publisher{producer: producer, encoding: encoding, done: make(chan struct{})}
+	go p.deliveryReports()
+
+	log.Info().Str("acks", cfg.Acks).Bool("idempotent", cfg.Idempotent).Msg("📡 Kafka Producer connected")
+	return p, nil
+}
+
+func (p *Publisher) deliveryReports() {
+	defer close(p.done)
+
+	for e := range p.producer.Events() {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			if ev.TopicPartition.Error == nil {
+				log.Debug().Msgf("Delivered to %v", ev.TopicPartition)
+				continue
 			}
-		}()
+			log.Error().Err(ev.TopicPartition.Error).Msg("Kafka delivery failed")
+			metrics.KafkaError(*ev.TopicPartition.Topic, "delivery")
 
-		log.Info().Msg("📡 Kafka Producer connected")
-	})
+			event, ok := ev.Opaque.(events.Event)
+			p.mu.Lock()
+			fn := p.undelivered
+			p.mu.Unlock()
+			if ok && fn != nil {
+				fn(event)
+			}
+		case kafka.Error:
+			log.Warn().Err(ev).Bool("allBrokersDown", ev.Code() == kafka.ErrAllBrokersDown).Msg("Kafka producer error")
+		}
+	}
 }
 
-func ProduceEventToDBProcessor(topic, eventType string, data interface{}) error {
-	if producerInstance == nil {
-		log.Error().Msg("Producer not initialized")
-		return nil
+// OnUndelivered registers fn for events Kafka reports as undelivered.
+func (p *Publisher) OnUndelivered(fn func(events.Event)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.undelivered = fn
+}
+
+// Unsettled returns the messages queued, in flight or awaiting their delivery report.
+func (p *Publisher) Unsettled() int {
+	return p.producer.Len()
+}
+
+// Publish queues an event as part of the trace in ctx. The W3C trace headers go on the
+// Kafka message so consumers can continue the trace. Keyed events are partitioned by
+// their key; unkeyed ones go to any partition.
+func (p *Publisher) Publish(ctx context.Context, event events.Event) error {
+	ctx, span := tracing.Start(ctx, "kafka.produce "+event.Type, trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("messaging.destination.name", event.Topic),
+		attribute.String("messaging.kafka.message.key", event.Key),
+		attribute.String("event.type", event.Type),
+	))
+	defer span.End()
+
+	fail := func(err error) error {
+		metrics.KafkaError(event.Topic, "enqueue")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
 	}
 
-	event := Event{
-		Type: eventType,
-		Data: data,
+	// Replayed events have no live span, so they keep the headers they were created with
+	if headers := tracing.Inject(ctx); headers != nil {
+		event.Headers = headers
 	}
-	bytes, err := json.Marshal(event)
+
+	body, headers, err := events.Encode(event, p.encoding)
 	if err != nil {
-		return err
+		return fail(fmt.Errorf("encode %s: %w", event.Type, err))
 	}
 
-	return producerInstance.Produce(&kafka.Message{
+	err = p.producer.Produce(&kafka.Message{
 		TopicPartition: kafka.TopicPartition{
-			Topic:     &topic,
+			Topic:     &event.Topic,
 			Partition: kafka.PartitionAny,
 		},
-		Value: bytes,
+		Key:     messageKey(event.Key),
+		Value:   body,
+		Headers: kafkaHeaders(headers),
+		Opaque:  event,
 	}, nil)
+	if err != nil {
+		return fail(fmt.Errorf("produce %s: %w", event.Type, err))
+	}
+	return nil
+}
+
+func messageKey(key string) []byte {
+	if key == "" {
+		return nil
+	}
+	return []byte(key)
+}
+
+func kafkaHeaders(carrier map[string]string) []kafka.Header {
+	var headers []kafka.Header
+	for key, value := range carrier {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+	return headers
+}
+
+// Ping asks the cluster for its broker list, which fails while no broker is reachable.
+func (p *Publisher) Ping(ctx context.Context) error {
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	_, err := p.producer.GetMetadata(nil, false, int(timeout.Milliseconds()))
+	return err
 }
 
-func CloseProducer() {
-	if producerInstance != nil {
-		producerInstance.Flush(5000)
-		producerInstance.Close()
+// Close waits up to timeout for queued events to be delivered. Anything still queued
+// is purged, which reports it to the OnUndelivered callback; without one, the purged
+// events are lost and counted in the return value.
+func (p *Publisher) Close(timeout time.Duration) int {
+	remaining := p.producer.Flush(int(timeout.Milliseconds()))
+	if remaining > 0 {
+		if err := p.producer.Purge(kafka.PurgeQueue | kafka.PurgeInFlight); err != nil {
+			log.Error().Err(err).Msg("Failed to purge Kafka producer queue")
+		}
+		// Serve the delivery reports the purge generated
+		p.producer.Flush(1000)
+	}
+	p.producer.Close()
+	<-p.done
+
+	p.mu.Lock()
+	handedOff := p.undelivered != nil
+	p.mu.Unlock()
+
+	if remaining == 0 {
+		return 0
+	}
+	if handedOff {
+		log.Warn().Int("undelivered", remaining).Msg("Kafka producer closed, undelivered events handed back for spooling")
+		return 0
 	}
+	log.Error().Int("undelivered", remaining).Msg("Kafka producer closed with undelivered events")
+	return remaining
 }
@@ -2,7 +2,7 @@ package redis
 
 import (
 	"context"
-	"os"
+	"matching-engine/internals/config"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
@@ -10,7 +10,7 @@ import (
 
 func ConnectRedis() *redis.Client {
 
-	url := os.Getenv("REDIS_URL")
+	url := config.Current().Redis.URL
 
 	option, err := redis.ParseURL(url)
 
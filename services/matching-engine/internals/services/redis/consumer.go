@@ -3,27 +3,78 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"matching-engine/internals/config"
+	"matching-engine/internals/dispatcher"
+	"matching-engine/internals/engine"
+	"matching-engine/internals/journal"
+	"matching-engine/internals/metrics"
+	"matching-engine/internals/outbox"
 	"matching-engine/internals/router"
+	"matching-engine/internals/tracing"
 	"matching-engine/internals/types"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func Consumer(ctx context.Context, client *redis.Client) {
+const (
+	// QueueKey is the legacy list consumed with BRPOP when INTAKE_MODE=list.
+	QueueKey = "engine:queue"
 
-	log.Info().Msg("Consumer started and ready to consume messages")
+	maxBackoff = 10 * time.Second
+)
+
+// Consumer reads commands from Redis until ctx is cancelled, then waits for every
+// command it already read to finish and be answered. Stream intake is the default;
+// INTAKE_MODE=list keeps the old BRPOP queue for producers not yet migrated. The events
+// each command produces are committed through box.
+func Consumer(ctx context.Context, client *redis.Client, j *journal.Journal, box *outbox.Outbox) {
+
+	d := dispatcher.FromConfig()
+	// Commands already handed to a worker are finished before returning
+	defer d.Close()
+
+	if config.Current().Intake.Mode == "list" {
+		listConsumer(ctx, client, d, box)
+		return
+	}
+
+	NewStreamConsumer(client, j, box, d).Run(ctx)
+
+}
+
+func listConsumer(ctx context.Context, client *redis.Client, d *dispatcher.Dispatcher, box *outbox.Outbox) {
+
+	log.Info().Str("queue", QueueKey).Msg("Consumer started and ready to consume messages")
+
+	responder := NewResponder(client)
+
+	var failures int
 
 	for {
 
-		result, err := client.BRPop(ctx, 5*time.Minute, "engine:queue").Result()
+		result, err := client.BRPop(ctx, 5*time.Minute, QueueKey).Result()
 
-		if err != nil {
-			log.Warn().Err(err).Msg("Failed to consume from queue")
+		if err == redis.Nil {
 			continue
+		}
 
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Warn().Err(err).Int("failures", failures).Msg("Failed to consume from queue")
+			if !sleepBackoff(ctx, failures) {
+				return
+			}
+			continue
 		}
+		failures = 0
 
 		if len(result) != 2 {
 			log.Warn().Msg("invalid BRPop result length")
@@ -39,31 +90,96 @@ func Consumer(ctx context.Context, client *redis.Client) {
 			continue
 		}
 
-		log.Info().
-			Str("eventType", data.EventType).
-			Str("responseId", data.ResponseId).
-			Interface("data", data.Data).
-			Msg("Successfully parsed queue payload")
+		// A popped command is already off the queue, so it runs even during shutdown
+		work := context.WithoutCancel(ctx)
+		data.ReceivedAt = time.Now()
+		d.Submit(work, router.OrderingKey(data),
+			func(release func()) {
+				data.Release = release
+				batch := box.Begin()
+				response := execute(data, batch)
+				if err := box.Commit("", data, response, batch); err != nil {
+					log.Error().Err(err).Str("responseId", data.ResponseId).Msg("Failed to record command events")
+				}
+				responder.Send(work, response)
+				metrics.ObserveCommand(data.EventType, data.ReceivedAt)
+			},
+			func() {
+				responder.Send(work, expired(data))
+				metrics.ObserveCommand(data.EventType, data.ReceivedAt)
+			},
+		)
 
-		response := router.RouteEvent(data)
+	}
 
-		responseJSON, err := json.Marshal(response)
+}
 
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to marshal response")
-			continue
-		}
+// execute routes one command and counts it towards the invariant check interval. The
+// command's span continues the producer's trace from data.TraceContext and starts when
+// the command was read, so it includes the wait for a dispatcher worker. Events the
+// command publishes are collected in batch.
+func execute(data types.QueuePayload, batch *outbox.Batch) types.QueueResponse {
+	parent := outbox.WithBatch(tracing.Extract(context.Background(), data.TraceContext), batch)
+	ctx, span := tracing.Start(parent, "route "+data.EventType,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(data.ReceivedAt),
+		trace.WithAttributes(
+			attribute.String("event.type", data.EventType),
+			attribute.String("response.id", data.ResponseId),
+		),
+	)
+	defer span.End()
+	data.Ctx = ctx
+
+	log.Info().
+		Str("eventType", data.EventType).
+		Str("responseId", data.ResponseId).
+		Interface("data", data.Data).
+		Msg("Successfully parsed queue payload")
+
+	response := router.RouteEvent(data)
+	span.SetAttributes(attribute.String("response.status", string(response.Status)))
+	if response.Status == types.Error {
+		span.SetStatus(codes.Error, response.Message)
+	}
 
-		responseKey := "engine:response:" + response.ResponseId
+	engine.EngineInstance.AfterCommand()
 
-		err = client.Publish(ctx, responseKey, responseJSON).Err()
+	return response
+}
 
-		if err != nil {
-			log.Error().Err(err).Str("responseId", response.ResponseId).Msg("Failed to send response to api")
-		} else {
-			log.Info().Str("responseId", response.ResponseId).Msg("Response send to api successfully")
-		}
+// expired answers a command that waited too long for a dispatcher worker. It was never
+// executed, so the caller may retry it.
+func expired(data types.QueuePayload) types.QueueResponse {
+
+	log.Warn().
+		Str("eventType", data.EventType).
+		Str("responseId", data.ResponseId).
+		Msg("Command expired before dispatch")
 
+	return types.QueueResponse{
+		ResponseId: data.ResponseId,
+		Status:     types.Error,
+		Retryable:  true,
+		Message:    "engine is busy, request timed out",
 	}
+}
 
+// sleepBackoff waits 100ms doubled per consecutive failure, capped at maxBackoff.
+// It returns false if ctx was cancelled while waiting.
+func sleepBackoff(ctx context.Context, failures int) bool {
+	delay := 100 * time.Millisecond
+	for i := 1; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
 }
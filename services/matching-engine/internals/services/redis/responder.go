@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"matching-engine/internals/config"
+	"matching-engine/internals/types"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ResponseChannelPrefix is the pub/sub channel the waiting API instance subscribes to.
+	ResponseChannelPrefix = "engine:response:"
+	// ResultKeyPrefix holds a copy of each reply so it can be fetched after a missed notification.
+	ResultKeyPrefix = "engine:result:"
+)
+
+// Responder delivers command replies to the API. In durable mode (the default) each
+// reply is stored under ResultKeyPrefix+responseId with a short TTL and then published;
+// RESPONSE_MODE=pubsub only publishes, as the engine did originally.
+type Responder struct {
+	client  *redis.Client
+	durable bool
+	ttl     time.Duration
+	retries int
+}
+
+// NewResponder takes its mode, TTL and retry count from the response config.
+func NewResponder(client *redis.Client) *Responder {
+	cfg := config.Current().Response
+	return &Responder{
+		client:  client,
+		durable: cfg.Mode != "pubsub",
+		ttl:     cfg.TTL,
+		retries: cfg.Retries,
+	}
+}
+
+// Send stores and publishes a reply, retrying with backoff when Redis is unavailable.
+// It reports whether the reply was delivered.
+func (r *Responder) Send(ctx context.Context, response types.QueueResponse) bool {
+
+	responseJSON, err := json.Marshal(response)
+
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal response")
+		return false
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = r.deliver(ctx, response.ResponseId, responseJSON)
+		if err == nil {
+			log.Info().Str("responseId", response.ResponseId).Msg("Response send to api successfully")
+			return true
+		}
+
+		if attempt >= r.retries || !sleepBackoff(ctx, attempt+1) {
+			break
+		}
+		log.Warn().Err(err).Str("responseId", response.ResponseId).Int("attempt", attempt+1).Msg("Retrying response delivery")
+	}
+
+	log.Error().Err(err).Str("responseId", response.ResponseId).Msg("Failed to send response to api")
+	return false
+}
+
+func (r *Responder) deliver(ctx context.Context, responseId string, responseJSON []byte) error {
+	if !r.durable {
+		return r.client.Publish(ctx, ResponseChannelPrefix+responseId, responseJSON).Err()
+	}
+
+	// The key is written before the notify so a subscriber woken by it can always read it back
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, ResultKeyPrefix+responseId, responseJSON, r.ttl)
+		pipe.Publish(ctx, ResponseChannelPrefix+responseId, responseJSON)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,358 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"matching-engine/internals/config"
+	"matching-engine/internals/dispatcher"
+	"matching-engine/internals/journal"
+	"matching-engine/internals/metrics"
+	"matching-engine/internals/outbox"
+	"matching-engine/internals/router"
+	"matching-engine/internals/types"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// StreamKey is the command stream producers XADD to, as a "payload" field holding the QueuePayload JSON.
+	StreamKey = "engine:stream"
+	// DeadLetterKey receives entries that could not be decoded or routed, and executed
+	// ones whose journal entry or events could not be recorded.
+	DeadLetterKey = "engine:stream:dlq"
+	// ConsumerGroup is the consumer group every engine instance reads through.
+	ConsumerGroup = "engine"
+
+	streamBatch   = 10
+	streamBlock   = 5 * time.Second
+	deadLetterCap = 100000
+)
+
+// StreamConsumer reads commands from a Redis Stream consumer group. An entry is only
+// acknowledged once its command has been executed and journaled, so anything in
+// flight during a crash is still pending and is picked up again on restart.
+type StreamConsumer struct {
+	client    *redis.Client
+	journal   *journal.Journal
+	outbox    *outbox.Outbox
+	responder *Responder
+	dispatch  *dispatcher.Dispatcher
+	stream    string
+	group     string
+	consumer  string
+	claimIdle time.Duration
+
+	// inFlight holds the ids of entries handed to the dispatcher and not finished yet.
+	// They stay pending and go idle while queued, so XAUTOCLAIM hands them back to us.
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+// NewStreamConsumer takes its stream, group and consumer names from the intake config.
+// A nil journal acknowledges entries as soon as they are executed. The outbox must
+// journal to j as well, since finish commits through it.
+func NewStreamConsumer(client *redis.Client, j *journal.Journal, box *outbox.Outbox, d *dispatcher.Dispatcher) *StreamConsumer {
+	cfg := config.Current().Intake
+	c := &StreamConsumer{
+		client:    client,
+		journal:   j,
+		outbox:    box,
+		responder: NewResponder(client),
+		dispatch:  d,
+		stream:    cfg.Stream,
+		group:     cfg.Group,
+		consumer:  cfg.Consumer,
+		claimIdle: cfg.ClaimIdle,
+		inFlight:  make(map[string]struct{}),
+	}
+
+	if c.consumer == "" {
+		c.consumer, _ = os.Hostname()
+	}
+	if c.consumer == "" {
+		c.consumer = "engine-1"
+	}
+
+	return c
+}
+
+// Run creates the consumer group if needed, recovers pending entries and then
+// consumes new entries until ctx is cancelled.
+func (c *StreamConsumer) Run(ctx context.Context) {
+
+	if c.journal == nil {
+		log.Warn().Msg("JOURNAL_PATH not set, stream entries are acknowledged without a journal")
+	}
+
+	var failures int
+	for {
+		err := c.client.XGroupCreateMkStream(ctx, c.stream, c.group, "0").Err()
+		if err == nil || strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			break
+		}
+		failures++
+		log.Warn().Err(err).Int("failures", failures).Msg("Failed to create stream consumer group")
+		if !sleepBackoff(ctx, failures) {
+			return
+		}
+	}
+
+	log.Info().
+		Str("stream", c.stream).
+		Str("group", c.group).
+		Str("consumer", c.consumer).
+		Msg("Consumer started and ready to consume messages")
+
+	if err := c.recoverPending(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to recover pending stream entries")
+	}
+	lastClaim := time.Now()
+
+	failures = 0
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(lastClaim) >= c.claimIdle {
+			if err := c.claimStale(ctx); err != nil {
+				log.Warn().Err(err).Msg("Failed to claim stale stream entries")
+			}
+			lastClaim = time.Now()
+		}
+
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.consumer,
+			Streams:  []string{c.stream, ">"},
+			Count:    streamBatch,
+			Block:    streamBlock,
+		}).Result()
+
+		if err == redis.Nil {
+			continue
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Warn().Err(err).Int("failures", failures).Msg("Failed to consume from stream")
+			if !sleepBackoff(ctx, failures) {
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				c.handle(ctx, msg)
+			}
+		}
+	}
+}
+
+// recoverPending replays entries this consumer read but never acknowledged, then takes
+// over entries abandoned by other consumers.
+func (c *StreamConsumer) recoverPending(ctx context.Context) error {
+	recovered := 0
+	start := "0"
+	for {
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.consumer,
+			Streams:  []string{c.stream, start},
+			Count:    streamBatch,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("read pending entries: %w", err)
+		}
+
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			break
+		}
+		for _, msg := range streams[0].Messages {
+			c.handle(ctx, msg)
+			start = msg.ID
+			recovered++
+		}
+	}
+
+	if recovered > 0 {
+		log.Info().Int("entries", recovered).Msg("Recovered pending stream entries")
+	}
+
+	return c.claimStale(ctx)
+}
+
+// claimStale takes over entries another consumer has held for longer than claimIdle.
+// It also returns this consumer's own entries still waiting on the dispatcher, which
+// handle skips.
+func (c *StreamConsumer) claimStale(ctx context.Context) error {
+	start := "0-0"
+	for {
+		msgs, next, err := c.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.stream,
+			Group:    c.group,
+			Consumer: c.consumer,
+			MinIdle:  c.claimIdle,
+			Start:    start,
+			Count:    streamBatch,
+		}).Result()
+		if err != nil {
+			return fmt.Errorf("claim stale entries: %w", err)
+		}
+
+		for _, msg := range msgs {
+			log.Warn().Str("streamId", msg.ID).Msg("Claimed stale stream entry")
+			c.handle(ctx, msg)
+		}
+
+		if next == "0-0" || next == "" {
+			return nil
+		}
+		start = next
+	}
+}
+
+// handle decodes one stream entry and hands it to the dispatcher. Entries already in
+// the journal are answered from it instead of being executed again, and entries still
+// with the dispatcher are left to it. Once read, an entry is seen through to its ack
+// even if intake is shutting down.
+func (c *StreamConsumer) handle(ctx context.Context, msg redis.XMessage) {
+	ctx = context.WithoutCancel(ctx)
+
+	if !c.track(msg.ID) {
+		log.Debug().Str("streamId", msg.ID).Msg("Stream entry is already being processed")
+		return
+	}
+	submitted := false
+	defer func() {
+		if !submitted {
+			c.untrack(msg.ID)
+		}
+	}()
+
+	raw, ok := msg.Values["payload"].(string)
+	if !ok {
+		c.deadLetter(ctx, msg, "", errors.New("missing payload field"))
+		return
+	}
+
+	var data types.QueuePayload
+
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		log.Error().Err(err).Str("payload", raw).Msg("Failed to unmarshal payload")
+		c.deadLetter(ctx, msg, raw, err)
+		return
+	}
+
+	if !router.IsRoutable(data.EventType) {
+		c.deadLetter(ctx, msg, raw, fmt.Errorf("unhandled event type: %s", data.EventType))
+		c.responder.Send(ctx, types.QueueResponse{
+			ResponseId: data.ResponseId,
+			Status:     types.Error,
+			Message:    "Unhandled event type: " + data.EventType,
+		})
+		return
+	}
+
+	if c.journal != nil {
+		if response, ok := c.journal.Lookup(msg.ID); ok {
+			log.Info().Str("streamId", msg.ID).Str("responseId", response.ResponseId).Msg("Stream entry already journaled, resending response")
+			c.responder.Send(ctx, response)
+			c.ack(ctx, msg.ID)
+			return
+		}
+	}
+
+	data.ReceivedAt = time.Now()
+	submitted = c.dispatch.Submit(ctx, router.OrderingKey(data),
+		func(release func()) {
+			data.Release = release
+			batch := c.outbox.Begin()
+			c.finish(ctx, msg.ID, data, execute(data, batch), batch)
+		},
+		func() { c.finish(ctx, msg.ID, data, expired(data), nil) },
+	)
+
+}
+
+// track marks id in flight, reporting false if it already was.
+func (c *StreamConsumer) track(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, busy := c.inFlight[id]; busy {
+		return false
+	}
+	c.inFlight[id] = struct{}{}
+	return true
+}
+
+func (c *StreamConsumer) untrack(id string) {
+	c.mu.Lock()
+	delete(c.inFlight, id)
+	c.mu.Unlock()
+}
+
+// finish journals a response together with the command's events, delivers it and
+// acknowledges the entry.
+func (c *StreamConsumer) finish(ctx context.Context, id string, data types.QueuePayload, response types.QueueResponse, batch *outbox.Batch) {
+	defer c.untrack(id)
+
+	if err := c.outbox.Commit(id, data, response, batch); err != nil {
+		// The command has run, so it must not be executed again: the entry goes to the
+		// dead-letter stream for an operator, and should that fail a re-claim finds it
+		// in the journal's memory and only resends the response
+		log.Error().Err(err).Str("streamId", id).Msg("Failed to record executed command")
+		c.responder.Send(ctx, response)
+		metrics.ObserveCommand(data.EventType, data.ReceivedAt)
+		raw, _ := json.Marshal(data)
+		c.deadLetter(ctx, redis.XMessage{ID: id}, string(raw), fmt.Errorf("executed but not fully recorded: %w", err))
+		return
+	}
+
+	// Replying before the ack means a crash in between resends the journaled reply on restart
+	c.responder.Send(ctx, response)
+	metrics.ObserveCommand(data.EventType, data.ReceivedAt)
+	c.ack(ctx, id)
+
+}
+
+func (c *StreamConsumer) ack(ctx context.Context, id string) {
+	if err := c.client.XAck(ctx, c.stream, c.group, id).Err(); err != nil {
+		log.Error().Err(err).Str("streamId", id).Msg("Failed to acknowledge stream entry")
+	}
+}
+
+// deadLetter copies an entry to the dead-letter stream and acknowledges it so it is not redelivered.
+func (c *StreamConsumer) deadLetter(ctx context.Context, msg redis.XMessage, payload string, reason error) {
+	err := c.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: DeadLetterKey,
+		MaxLen: deadLetterCap,
+		Approx: true,
+		Values: map[string]interface{}{
+			"streamId": msg.ID,
+			"payload":  payload,
+			"error":    reason.Error(),
+			"consumer": c.consumer,
+		},
+	}).Err()
+	if err != nil {
+		// Leave it pending; it will be retried on the next claim
+		log.Error().Err(err).Str("streamId", msg.ID).Msg("Failed to write dead-letter entry")
+		return
+	}
+
+	log.Warn().Str("streamId", msg.ID).Str("reason", reason.Error()).Msg("Stream entry moved to dead-letter stream")
+	c.ack(ctx, msg.ID)
+}
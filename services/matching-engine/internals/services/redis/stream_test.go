@@ -0,0 +1,20 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// XAUTOCLAIM hands back entries this consumer still has queued on the dispatcher. The
+// consumer has no client, so handling the entry at all would panic.
+func TestHandleSkipsEntryInFlight(t *testing.T) {
+	c := &StreamConsumer{inFlight: map[string]struct{}{"1-0": {}}}
+
+	c.handle(context.Background(), redis.XMessage{ID: "1-0"})
+
+	if _, ok := c.inFlight["1-0"]; !ok {
+		t.Error("skipping the entry dropped it from the in-flight set")
+	}
+}
@@ -0,0 +1,95 @@
+// Package tracing wires the engine into OpenTelemetry. Trace context arrives with each
+// queue payload as W3C headers and leaves on Kafka message headers and stream payloads.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rs/zerolog/log"
+)
+
+const tracerName = "matching-engine"
+
+// Init installs the exporter chosen by OTEL_TRACES_EXPORTER: "otlp" sends to the
+// collector named by the standard OTEL_EXPORTER_OTLP_* variables, "stdout" prints spans
+// for local runs, and anything else keeps the no-op tracer. Context is propagated either
+// way, so trace ids from callers still reach Kafka and the stream. The returned func
+// flushes buffered spans.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch kind := os.Getenv("OTEL_TRACES_EXPORTER"); kind {
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		log.Info().Msg("OTEL_TRACES_EXPORTER not set, tracing spans are not exported")
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create trace exporter: %w", err)
+	}
+
+	service := os.Getenv("SERVICE_NAME")
+	if service == "" {
+		service = tracerName
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", service),
+			attribute.String("service.version", os.Getenv("VERSION")),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	log.Info().Str("exporter", os.Getenv("OTEL_TRACES_EXPORTER")).Msg("Tracing initialized")
+	return provider.Shutdown, nil
+}
+
+// Start opens a span on the engine's tracer.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// Extract rebuilds the caller's trace context from the headers carried in a payload.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// Inject returns the W3C headers for ctx, or nil when it carries no trace.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// TraceId returns the id of the trace ctx belongs to, or "" if there is none.
+func TraceId(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
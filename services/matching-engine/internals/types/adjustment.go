@@ -0,0 +1,60 @@
+package types
+
+import "time"
+
+type AdjustmentKind string
+
+const (
+	BalanceAdjustment  AdjustmentKind = "BALANCE"
+	PositionAdjustment AdjustmentKind = "POSITION"
+)
+
+type AdjustmentStatus string
+
+const (
+	AdjustmentPending  AdjustmentStatus = "PENDING_APPROVAL"
+	AdjustmentApplied  AdjustmentStatus = "APPLIED"
+	AdjustmentRejected AdjustmentStatus = "REJECTED"
+)
+
+type ReasonCode string
+
+const (
+	ReasonDepositCorrection  ReasonCode = "DEPOSIT_CORRECTION"
+	ReasonWithdrawalReversal ReasonCode = "WITHDRAWAL_REVERSAL"
+	ReasonTradeCorrection    ReasonCode = "TRADE_CORRECTION"
+	ReasonReconciliation     ReasonCode = "RECONCILIATION"
+	ReasonGoodwill           ReasonCode = "GOODWILL"
+	ReasonOther              ReasonCode = "OTHER"
+)
+
+var ReasonCodes = map[ReasonCode]struct{}{
+	ReasonDepositCorrection:  {},
+	ReasonWithdrawalReversal: {},
+	ReasonTradeCorrection:    {},
+	ReasonReconciliation:     {},
+	ReasonGoodwill:           {},
+	ReasonOther:              {},
+}
+
+// Adjustment is a journaled manual correction to a wallet or a position.
+// Delta is cash for BALANCE and shares of Side for POSITION.
+type Adjustment struct {
+	AdjustmentId string           `json:"adjustmentId"`
+	Kind         AdjustmentKind   `json:"kind"`
+	UserId       string           `json:"userId"`
+	Symbol       string           `json:"symbol,omitempty"`
+	Side         Side             `json:"side,omitempty"`
+	Delta        float64          `json:"delta"`
+	ReasonCode   ReasonCode       `json:"reasonCode"`
+	Note         string           `json:"note"`
+	RequestedBy  string           `json:"requestedBy"`
+	ApprovedBy   string           `json:"approvedBy,omitempty"`
+	Status       AdjustmentStatus `json:"status"`
+	RequestedAt  time.Time        `json:"requestedAt"`
+	ResolvedAt   time.Time        `json:"resolvedAt,omitempty"`
+	// Expected, when set, is what the adjusted wallet or position held when the
+	// adjustment was requested. Such an adjustment always waits for a second operator
+	// and cannot be approved once the value has moved.
+	Expected *float64 `json:"expected,omitempty"`
+}
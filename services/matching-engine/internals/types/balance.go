@@ -8,6 +8,24 @@ type Balance struct {
 type WalletBalance struct {
 	Amount float64
 	Locked float64
+	// Held is withdrawn cash waiting for the payout system to confirm or fail it.
+	Held float64
+}
+
+// BalanceView is a copy of a user's balances for their private stream.
+type BalanceView struct {
+	Cash      float64             `json:"cash"`
+	Locked    float64             `json:"locked"`
+	Held      float64             `json:"held"`
+	Positions map[string]Position `json:"positions"`
+}
+
+// Position is what a user holds in one market, locked shares being escrowed for asks.
+type Position struct {
+	Yes       int `json:"yes"`
+	No        int `json:"no"`
+	LockedYes int `json:"lockedYes"`
+	LockedNo  int `json:"lockedNo"`
 }
 
 type StockBalance struct {
@@ -9,4 +9,20 @@ const (
 	ORDER_PLACED           EVENTS = "ORDER_PLACED"
 	SHARES_SPLIT           EVENTS = "SHARES_SPLIT"
 	SHARES_MERGED          EVENTS = "SHARES_MERGED"
+	ORDER_CANCELLED        EVENTS = "ORDER_CANCELLED"
+	INVARIANT_VIOLATION    EVENTS = "INVARIANT_VIOLATION"
+	ADJUSTMENT             EVENTS = "ADJUSTMENT"
+	ADJUSTMENT_PROPOSED    EVENTS = "ADJUSTMENT_PROPOSED"
+	WITHDRAWAL_REQUESTED   EVENTS = "WITHDRAWAL_REQUESTED"
+	WITHDRAWAL_CONFIRMED   EVENTS = "WITHDRAWAL_CONFIRMED"
+	WITHDRAWAL_FAILED      EVENTS = "WITHDRAWAL_FAILED"
+	WITHDRAWAL_CANCELLED   EVENTS = "WITHDRAWAL_CANCELLED"
+	MARKET_HALTED          EVENTS = "MARKET_HALTED"
+	MARKET_RESTARTED       EVENTS = "MARKET_RESTARTED"
+	MARKET_RESOLVED        EVENTS = "MARKET_RESOLVED"
+	MARKET_CREATED         EVENTS = "MARKET_CREATED"
+	USER_CREATED           EVENTS = "USER_CREATED"
+	USER_VERIFIED          EVENTS = "USER_VERIFIED"
+	BALANCE_INITIALIZED    EVENTS = "BALANCE_INITIALIZED"
+	FUNDS_DEPOSITED        EVENTS = "FUNDS_DEPOSITED"
 )
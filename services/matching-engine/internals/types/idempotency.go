@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// IdempotencyRecord is the first response produced for an idempotency key.
+type IdempotencyRecord struct {
+	EventType string        `json:"eventType"`
+	Response  QueueResponse `json:"response"`
+	StoredAt  time.Time     `json:"storedAt"`
+}
+
+// IdempotencyBucket holds one user's keys; Order is oldest first so the bucket can be bounded.
+type IdempotencyBucket struct {
+	Keys  map[string]IdempotencyRecord `json:"keys"`
+	Order []string                     `json:"order"`
+}
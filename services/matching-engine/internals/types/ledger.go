@@ -0,0 +1,73 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// Ledger tracks the money that entered or left the engine so the invariant
+// checker can tell whether matching created or destroyed anything.
+type Ledger struct {
+	// NetFunding is deposits, referral credits and balance inits minus withdrawals.
+	NetFunding float64
+	// Fees is the platform revenue collected from trades.
+	Fees float64
+	// EvictedCash and EvictedShares hold balances of users purged from RAM.
+	EvictedCash   float64
+	EvictedShares map[string]StockBalance
+	// AdjustedShares are shares created (or burned) by admin position adjustments
+	// without a matching opposite outcome or collateral.
+	AdjustedShares map[string]StockBalance
+	Mu             sync.Mutex
+}
+
+type InvariantCheck string
+
+const (
+	CashNotConserved   InvariantCheck = "CASH_NOT_CONSERVED"
+	LockedMismatch     InvariantCheck = "LOCKED_MISMATCH"
+	EscrowMismatch     InvariantCheck = "ESCROW_MISMATCH"
+	SupplyMismatch     InvariantCheck = "SUPPLY_MISMATCH"
+	CollateralMismatch InvariantCheck = "COLLATERAL_MISMATCH"
+	NegativeBalance    InvariantCheck = "NEGATIVE_BALANCE"
+	BookCorrupt        InvariantCheck = "BOOK_CORRUPT"
+)
+
+type InvariantViolation struct {
+	Check    InvariantCheck `json:"check"`
+	Symbol   string         `json:"symbol,omitempty"`
+	UserId   string         `json:"userId,omitempty"`
+	OrderId  string         `json:"orderId,omitempty"`
+	Expected float64        `json:"expected"`
+	Actual   float64        `json:"actual"`
+	Detail   string         `json:"detail"`
+}
+
+type InvariantTotals struct {
+	Cash       float64 `json:"cash"`
+	Locked     float64 `json:"locked"`
+	Held       float64 `json:"held"`
+	Collateral float64 `json:"collateral"`
+	Fees       float64 `json:"fees"`
+	Evicted    float64 `json:"evicted"`
+	NetFunding float64 `json:"netFunding"`
+}
+
+type MarketDiagnostic struct {
+	Symbol     string              `json:"symbol"`
+	MarketId   string              `json:"marketId"`
+	Status     MarketStatus        `json:"status"`
+	Collateral float64             `json:"collateral"`
+	YesSupply  int                 `json:"yesSupply"`
+	NoSupply   int                 `json:"noSupply"`
+	OrderBook  AggregatedOrderBook `json:"orderbook"`
+}
+
+type InvariantReport struct {
+	CheckedAt     time.Time            `json:"checkedAt"`
+	Commands      uint64               `json:"commands"`
+	Totals        InvariantTotals      `json:"totals"`
+	Violations    []InvariantViolation `json:"violations"`
+	HaltedMarkets []string             `json:"haltedMarkets"`
+	Markets       []MarketDiagnostic   `json:"markets,omitempty"`
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -13,12 +14,32 @@ const (
 	MarketCancelOrder   MarketMessageType = "CANCEL_ORDER"
 	MarketGetOrderBook  MarketMessageType = "GET_ORDERBOOK"
 	MarketResolveMarket MarketMessageType = "RESOLVE_MARKET"
+	MarketHalt          MarketMessageType = "HALT"
+	MarketRestart       MarketMessageType = "RESTART"
+	MarketInspect       MarketMessageType = "INSPECT"
+	MarketBookAt        MarketMessageType = "GET_BOOK_SNAPSHOT"
+	// MarketDrain is queued behind everything else at shutdown; its reply means the inbox is empty.
+	MarketDrain MarketMessageType = "DRAIN"
 )
 
+// IsPriority reports whether a message type skips ahead of queued orders.
+func (t MarketMessageType) IsPriority() bool {
+	return t == MarketCancelOrder || t == MarketResolveMarket || t == MarketHalt || t == MarketRestart || t == MarketInspect
+}
+
+// IsReadOnly reports whether a message type only reads the market, so a halted market
+// still answers it.
+func (t MarketMessageType) IsReadOnly() bool {
+	return t == MarketGetOrderBook || t == MarketInspect || t == MarketBookAt
+}
+
 type MarketMessage struct {
 	Type      MarketMessageType
 	Payload   interface{}
 	ReplyChan chan interface{}
+	// Ctx is the sender's trace context; Dequeued, when set, ends the inbox wait span.
+	Ctx      context.Context
+	Dequeued func()
 }
 
 type Market struct {
@@ -32,20 +53,107 @@ type Market struct {
 	NumberOfTraders int16
 	Traders         map[string]struct{}
 	Volume          float64
+	Collateral      float64
 	Status          MarketStatus
-	OrderBook       *OrderBook
+	// HaltedFrom is the status a halted market returns to when it is restarted.
+	HaltedFrom MarketStatus
+	OrderBook  *OrderBook
 
 	Overview Overview
 	Trades   []TradeExecutedEvent
-	Inbox    chan MarketMessage
-	Mu       sync.RWMutex
+	Inbox    chan MarketMessage `json:"-"`
+	// Priority carries cancels, resolves and halts; the market drains it before Inbox.
+	Priority chan MarketMessage `json:"-"`
+	Stats    *InboxStats        `json:"-"`
+	// Feed is what the market has broadcast about its book; only its goroutine uses it.
+	Feed *L2Feed `json:"-"`
+	Mu   sync.RWMutex
+}
+
+// InboxStats counts how a market's inbox is coping with load. Fields are updated atomically.
+type InboxStats struct {
+	Processed uint64
+	Rejected  uint64
+	HighWater int64
+}
+
+// InboxDepth is a point-in-time view of one market's queues.
+type InboxDepth struct {
+	Symbol        string       `json:"symbol"`
+	Status        MarketStatus `json:"status"`
+	Depth         int          `json:"depth"`
+	Capacity      int          `json:"capacity"`
+	PriorityDepth int          `json:"priorityDepth"`
+	HighWater     int64        `json:"highWater"`
+	Processed     uint64       `json:"processed"`
+	Rejected      uint64       `json:"rejected"`
+}
+
+// MarketPanic is what the supervisor captured when a market goroutine panicked.
+type MarketPanic struct {
+	Symbol      string            `json:"symbol"`
+	MarketId    string            `json:"marketId"`
+	Panic       string            `json:"panic"`
+	Stack       string            `json:"stack"`
+	MessageType MarketMessageType `json:"messageType"`
+	Payload     interface{}       `json:"payload"`
+	At          time.Time         `json:"at"`
+	Book        BookSnapshot      `json:"book"`
+}
+
+// FairValue is a market's estimated YES price and the figures behind it, all on the YES
+// outcome. Figures the market had nothing for are left out.
+type FairValue struct {
+	Strategy string `json:"strategy"`
+	// Source is the strategy the price came from, another one when Strategy had
+	// nothing to price from.
+	Source    string  `json:"source"`
+	YesPrice  float64 `json:"yesPrice"`
+	BestBid   float64 `json:"bestBid,omitempty"`
+	BestAsk   float64 `json:"bestAsk,omitempty"`
+	LastTrade float64 `json:"lastTrade,omitempty"`
+	VWAP      float64 `json:"vwap,omitempty"`
+	// VWAPVolume is the quantity traded in the window VWAP averages.
+	VWAPVolume int `json:"vwapVolume,omitempty"`
+	// DepthBid and DepthAsk average the levels within the depth window, which hold
+	// DepthBidQuantity and DepthAskQuantity.
+	DepthBid         float64 `json:"depthBid,omitempty"`
+	DepthAsk         float64 `json:"depthAsk,omitempty"`
+	DepthBidQuantity int     `json:"depthBidQuantity,omitempty"`
+	DepthAskQuantity int     `json:"depthAskQuantity,omitempty"`
+}
+
+// BookSnapshot copies every resting order on a book, in heap order.
+type BookSnapshot struct {
+	YesBids []Order `json:"yesBids"`
+	YesAsks []Order `json:"yesAsks"`
+	NoBids  []Order `json:"noBids"`
+	NoAsks  []Order `json:"noAsks"`
+}
+
+// MarketView is a consistent read of one market, taken on its goroutine.
+type MarketView struct {
+	MarketId        string        `json:"marketId"`
+	Symbol          string        `json:"symbol"`
+	Title           string        `json:"title"`
+	Status          MarketStatus  `json:"status"`
+	YesPrice        float32       `json:"yesPrice"`
+	NoPrice         float32       `json:"noPrice"`
+	Volume          float64       `json:"volume"`
+	Collateral      float64       `json:"collateral"`
+	NumberOfTraders int16         `json:"numberOfTraders"`
+	RestingOrders   int           `json:"restingOrders"`
+	Book            *BookSnapshot `json:"book,omitempty"`
+	// Error is set in listings when the market could not be asked.
+	Error string `json:"error,omitempty"`
 }
 
 type MarketStatus string
 
 const (
-	Open  MarketStatus = "open"
-	Close MarketStatus = "close"
+	Open   MarketStatus = "open"
+	Close  MarketStatus = "close"
+	Halted MarketStatus = "halted"
 )
 
 type Overview struct {
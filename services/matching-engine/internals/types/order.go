@@ -40,6 +40,47 @@ type Order struct {
 	Action    Action
 	OrderType OrderType
 	Timestamp time.Time
+	// FeeRate is the trading fee in force when the order was accepted. Its fills and
+	// refunds keep using it after a config reload; nil on orders from older snapshots.
+	FeeRate *float64 `json:",omitempty"`
+	// FilledNotional sums price times quantity over the order's fills, each at the
+	// price of the order's own outcome, for its average fill price. Orders from older
+	// snapshots only count fills made since.
+	FilledNotional float64 `json:",omitempty"`
+}
+
+// OrderStatus is where an order stands after an update on its owner's stream.
+type OrderStatus string
+
+const (
+	OrderAccepted        OrderStatus = "ACCEPTED"
+	OrderPartiallyFilled OrderStatus = "PARTIALLY_FILLED"
+	OrderFilled          OrderStatus = "FILLED"
+	OrderCancelled       OrderStatus = "CANCELLED"
+	// OrderExpired is a MARKET order whose unfilled rest was dropped.
+	OrderExpired OrderStatus = "EXPIRED"
+)
+
+// OrderUpdate is an order as it stands after a change, for its owner. Remaining is the
+// unfilled quantity, still on the book only while the order is ACCEPTED or
+// PARTIALLY_FILLED. LastQuantity and LastPrice describe the fill that caused the update.
+type OrderUpdate struct {
+	OrderId      string      `json:"orderId"`
+	MarketId     string      `json:"marketId"`
+	Symbol       string      `json:"symbol"`
+	Side         Side        `json:"side"`
+	Action       Action      `json:"action"`
+	OrderType    OrderType   `json:"orderType"`
+	Status       OrderStatus `json:"status"`
+	Price        float64     `json:"price"`
+	Quantity     int         `json:"quantity"`
+	Filled       int         `json:"filled"`
+	Remaining    int         `json:"remaining"`
+	AvgPrice     float64     `json:"avgPrice"`
+	LastQuantity int         `json:"lastQuantity,omitempty"`
+	LastPrice    float64     `json:"lastPrice,omitempty"`
+	Reason       string      `json:"reason,omitempty"`
+	Timestamp    time.Time   `json:"timestamp"`
 }
 
 type CancelOrderPayload struct {
@@ -9,14 +9,59 @@ type OrderBook struct {
 	NoAsks  *AskHeap
 }
 
-type PriceQuantity struct {
+// NewOrderBook returns a book with all four sides empty.
+func NewOrderBook() *OrderBook {
+	return &OrderBook{
+		YesBids: &BidHeap{OrderHeap: make(OrderHeap, 0)},
+		YesAsks: &AskHeap{OrderHeap: make(OrderHeap, 0)},
+		NoBids:  &BidHeap{OrderHeap: make(OrderHeap, 0)},
+		NoAsks:  &AskHeap{OrderHeap: make(OrderHeap, 0)},
+	}
+}
+
+// BookLevel is what can be traded at one price. Direct comes from resting orders on
+// the outcome itself, Implied from the opposite outcome's other side through MINT or
+// MERGE: a NO bid at p is a YES ask at payout minus p.
+type BookLevel struct {
 	Price    float64 `json:"price"`
 	Quantity int     `json:"quantity"`
+	Direct   int     `json:"direct"`
+	Implied  int     `json:"implied"`
+}
+
+// OutcomeBook holds one outcome's levels, bids highest first and asks lowest first.
+type OutcomeBook struct {
+	Bids []BookLevel `json:"bids"`
+	Asks []BookLevel `json:"asks"`
 }
 
 type AggregatedOrderBook struct {
-	Yes []PriceQuantity `json:"yes"`
-	No  []PriceQuantity `json:"no"`
+	Yes OutcomeBook `json:"yes"`
+	No  OutcomeBook `json:"no"`
+}
+
+// L2Snapshot is a market's whole L2 book as of Seq.
+type L2Snapshot struct {
+	Symbol    string              `json:"symbol"`
+	Seq       uint64              `json:"seq"`
+	OrderBook AggregatedOrderBook `json:"orderbook"`
+}
+
+// L2Diff holds the levels that changed between Seq-1 and Seq, each with its new
+// quantity; a quantity of 0 means the level is gone.
+type L2Diff struct {
+	Seq     uint64              `json:"seq"`
+	Changes AggregatedOrderBook `json:"orderbook"`
+}
+
+// L2Feed numbers a market's book updates and keeps enough of them to rebuild the
+// book at any recent seq: the full books sent, oldest first, and every diff since the
+// oldest of them. Seq starts at 0 with the book the market started with.
+type L2Feed struct {
+	Seq       uint64
+	Book      AggregatedOrderBook
+	Snapshots []L2Snapshot
+	Diffs     []L2Diff
 }
 
 type TradeExecutedEvent struct {
@@ -0,0 +1,64 @@
+package types
+
+import "time"
+
+// BalanceExport is the per-user balance dump written by processor-service from Postgres.
+type BalanceExport struct {
+	GeneratedAt time.Time           `json:"generatedAt"`
+	Users       []UserBalanceExport `json:"users"`
+}
+
+type UserBalanceExport struct {
+	UserId    string           `json:"userId"`
+	Balance   float64          `json:"balance"`
+	Locked    float64          `json:"locked"`
+	Positions []PositionExport `json:"positions"`
+}
+
+type PositionExport struct {
+	MarketId    string `json:"marketId"`
+	Symbol      string `json:"symbol"`
+	YesQuantity int    `json:"yesQuantity"`
+	YesLocked   int    `json:"yesLocked"`
+	NoQuantity  int    `json:"noQuantity"`
+	NoLocked    int    `json:"noLocked"`
+}
+
+type DiscrepancyField string
+
+const (
+	FieldWallet          DiscrepancyField = "WALLET"
+	FieldLocked          DiscrepancyField = "LOCKED"
+	FieldYes             DiscrepancyField = "YES"
+	FieldNo              DiscrepancyField = "NO"
+	FieldLockedYes       DiscrepancyField = "LOCKED_YES"
+	FieldLockedNo        DiscrepancyField = "LOCKED_NO"
+	FieldMissingInEngine DiscrepancyField = "MISSING_IN_ENGINE"
+	FieldMissingInExport DiscrepancyField = "MISSING_IN_EXPORT"
+)
+
+// Discrepancy is a single value where engine memory and the Postgres ledger disagree.
+// Delta is what must be added to the ledger value to match the engine.
+type Discrepancy struct {
+	UserId string           `json:"userId"`
+	Symbol string           `json:"symbol,omitempty"`
+	Field  DiscrepancyField `json:"field"`
+	Engine float64          `json:"engine"`
+	Ledger float64          `json:"ledger"`
+	Delta  float64          `json:"delta"`
+	// Approval and AdjustmentId describe the adjustment proposed to correct it, and
+	// Error why none could be.
+	Approval     string `json:"approval,omitempty"`
+	AdjustmentId string `json:"adjustmentId,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type ReconcileReport struct {
+	ReconciliationId string        `json:"reconciliationId"`
+	Source           string        `json:"source"`
+	ExportedAt       time.Time     `json:"exportedAt"`
+	ReconciledAt     time.Time     `json:"reconciledAt"`
+	UsersChecked     int           `json:"usersChecked"`
+	Discrepancies    []Discrepancy `json:"discrepancies"`
+	AdjustmentsSent  int           `json:"adjustmentsSent"`
+}
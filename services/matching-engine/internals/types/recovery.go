@@ -0,0 +1,76 @@
+package types
+
+import "time"
+
+const (
+	FieldHeld              DiscrepancyField = "HELD"
+	FieldCollateral        DiscrepancyField = "COLLATERAL"
+	FieldStatus            DiscrepancyField = "STATUS"
+	FieldRestingOrder      DiscrepancyField = "RESTING_ORDER"
+	FieldMissingInReplay   DiscrepancyField = "MISSING_IN_REPLAY"
+	FieldMissingInSnapshot DiscrepancyField = "MISSING_IN_SNAPSHOT"
+)
+
+// RecoveryMismatch is a value where state rebuilt from the event history disagrees with
+// the snapshot it was verified against. Resting orders compare their unfilled quantity.
+type RecoveryMismatch struct {
+	UserId   string           `json:"userId,omitempty"`
+	Symbol   string           `json:"symbol,omitempty"`
+	OrderId  string           `json:"orderId,omitempty"`
+	Field    DiscrepancyField `json:"field"`
+	Replayed float64          `json:"replayed"`
+	Snapshot float64          `json:"snapshot"`
+	Detail   string           `json:"detail,omitempty"`
+}
+
+// SeqGap is a run of event seqs missing from the history, From and To included.
+type SeqGap struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// ReplayError is an event that could not be applied.
+type ReplayError struct {
+	Seq   uint64 `json:"seq"`
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+type RecoveryReport struct {
+	Source     string    `json:"source"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// BaseSeq is the seq of the snapshot replay started from, 0 for an empty engine.
+	BaseSeq    uint64        `json:"baseSeq"`
+	FirstSeq   uint64        `json:"firstSeq"`
+	LastSeq    uint64        `json:"lastSeq"`
+	Read       int           `json:"read"`
+	Duplicates int           `json:"duplicates"`
+	Applied    int           `json:"applied"`
+	Gaps       []SeqGap      `json:"gaps"`
+	Errors     []ReplayError `json:"errors"`
+
+	Users         int `json:"users"`
+	Markets       int `json:"markets"`
+	RestingOrders int `json:"restingOrders"`
+
+	// Verified is false when no snapshot could be read to compare against.
+	Verified    bool      `json:"verified"`
+	SnapshotAt  time.Time `json:"snapshotAt,omitempty"`
+	SnapshotSeq uint64    `json:"snapshotSeq,omitempty"`
+	// SnapshotDrained is whether the snapshot was taken with nothing in flight. Against
+	// a periodic one, mismatches can be commands caught halfway and do not fail Clean.
+	SnapshotDrained bool `json:"snapshotDrained"`
+	UsersChecked    int  `json:"usersChecked"`
+	// Evicted counts users the snapshot no longer held and that had nothing at stake.
+	Evicted    int                `json:"evicted"`
+	Mismatches []RecoveryMismatch `json:"mismatches"`
+
+	Written bool `json:"written"`
+}
+
+// Clean reports whether the rebuilt state can be trusted: no missing events, nothing
+// that failed to apply and no disagreement with a drained snapshot.
+func (r RecoveryReport) Clean() bool {
+	return len(r.Gaps) == 0 && len(r.Errors) == 0 && (len(r.Mismatches) == 0 || !r.SnapshotDrained)
+}
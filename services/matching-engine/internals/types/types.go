@@ -1,9 +1,33 @@
 package types
 
+import (
+	"context"
+	"time"
+)
+
 type QueuePayload struct {
 	ResponseId string      `json:"responseId"`
 	EventType  string      `json:"eventType"`
 	Data       interface{} `json:"data"`
+	// IdempotencyKey makes retries of the same command return the first response instead of re-executing.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Release, when set by the dispatcher, lets the next command with the same ordering
+	// key start once this one has been queued on its market.
+	Release func() `json:"-"`
+	// TraceContext carries the caller's W3C trace headers (traceparent, tracestate).
+	TraceContext map[string]string `json:"traceContext,omitempty"`
+	// ReceivedAt is when the consumer read the command off the intake.
+	ReceivedAt time.Time `json:"-"`
+	// Ctx holds the command's span once the consumer has started it.
+	Ctx context.Context `json:"-"`
+}
+
+// Context returns the command's trace context, or an empty one before tracing starts.
+func (p QueuePayload) Context() context.Context {
+	if p.Ctx == nil {
+		return context.Background()
+	}
+	return p.Ctx
 }
 
 type Status string
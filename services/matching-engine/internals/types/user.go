@@ -14,6 +14,9 @@ const (
 	KYC_REJECTED     KycStatus = "REJECTED"
 )
 
+// KycStatuses lists every KycStatus.
+var KycStatuses = []KycStatus{KYC_NOT_VERIFIED, KYC_PENDING, KYC_VERIFIED, KYC_REJECTED}
+
 type PaymentStatus string
 
 const (
@@ -30,6 +33,20 @@ type User struct {
 	KycVerificationStatus     KycStatus
 	PaymentVerificationStatus PaymentStatus
 	Balance                   *Balance
+	Funded                    bool
 	LastActive                time.Time
+	LastDepositAt             time.Time
 	Mutex                     sync.Mutex
 }
+
+// UserView is a copy of a user's account and resting orders for inspection.
+type UserView struct {
+	ID                        string        `json:"id"`
+	Name                      string        `json:"name"`
+	KycVerificationStatus     KycStatus     `json:"kycVerificationStatus"`
+	PaymentVerificationStatus PaymentStatus `json:"paymentVerificationStatus"`
+	Funded                    bool          `json:"funded"`
+	LastActive                time.Time     `json:"lastActive"`
+	Balance                   Balance       `json:"balance"`
+	OpenOrders                []Order       `json:"openOrders"`
+}
@@ -0,0 +1,30 @@
+package types
+
+import "time"
+
+type WithdrawalStatus string
+
+const (
+	WithdrawalHeld      WithdrawalStatus = "HELD"
+	WithdrawalConfirmed WithdrawalStatus = "CONFIRMED"
+	WithdrawalFailed    WithdrawalStatus = "FAILED"
+	WithdrawalCancelled WithdrawalStatus = "CANCELLED"
+)
+
+// Withdrawal tracks funds moved out of the spendable wallet while the payout
+// system settles them.
+type Withdrawal struct {
+	WithdrawalId string           `json:"withdrawalId"`
+	UserId       string           `json:"userId"`
+	Amount       float64          `json:"amount"`
+	Status       WithdrawalStatus `json:"status"`
+	Reason       string           `json:"reason,omitempty"`
+	RequestedAt  time.Time        `json:"requestedAt"`
+	ResolvedAt   time.Time        `json:"resolvedAt,omitempty"`
+}
+
+// WithdrawalLimit caps withdrawals over rolling 24 hour and 30 day windows.
+type WithdrawalLimit struct {
+	Daily   float64 `json:"daily" yaml:"daily"`
+	Monthly float64 `json:"monthly" yaml:"monthly"`
+}
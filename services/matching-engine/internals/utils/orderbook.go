@@ -5,7 +5,8 @@ import (
 	"sort"
 )
 
-func aggregateOrders(orders types.OrderHeap, isAscending bool) []types.PriceQuantity {
+// remainingByPrice sums the unfilled quantity of orders at each price.
+func remainingByPrice(orders types.OrderHeap) map[float64]int {
 	priceMap := make(map[float64]int)
 	for _, order := range orders {
 		remaining := order.Quantity - order.Filled
@@ -13,13 +14,32 @@ func aggregateOrders(orders types.OrderHeap, isAscending bool) []types.PriceQuan
 			priceMap[order.Price] += remaining
 		}
 	}
+	return priceMap
+}
 
-	var result []types.PriceQuantity
-	for price, qty := range priceMap {
-		result = append(result, types.PriceQuantity{
-			Price:    price,
-			Quantity: qty,
-		})
+// bookSide merges the direct orders on one side with the opposite outcome's orders
+// that imply it, each at payout minus its price.
+func bookSide(direct, opposite types.OrderHeap, payout float64, isAscending bool) []types.BookLevel {
+	levels := make(map[float64]*types.BookLevel)
+	level := func(price float64) *types.BookLevel {
+		l, ok := levels[price]
+		if !ok {
+			l = &types.BookLevel{Price: price}
+			levels[price] = l
+		}
+		return l
+	}
+	for price, qty := range remainingByPrice(direct) {
+		level(price).Direct += qty
+	}
+	for price, qty := range remainingByPrice(opposite) {
+		level(payout - price).Implied += qty
+	}
+
+	result := make([]types.BookLevel, 0, len(levels))
+	for _, l := range levels {
+		l.Quantity = l.Direct + l.Implied
+		result = append(result, *l)
 	}
 
 	sort.Slice(result, func(i, j int) bool {
@@ -32,27 +52,18 @@ func aggregateOrders(orders types.OrderHeap, isAscending bool) []types.PriceQuan
 	return result
 }
 
-func AggregateOrderBook(ob *types.OrderBook) types.AggregatedOrderBook {
-	// For the frontend, Yes orderbook is typically the YES Bids (Buy Yes) and YES Asks (Sell Yes).
-	// To keep it simple, we aggregate the YesBids and YesAsks.
-	// Wait, the frontend usually expects 'Yes' and 'No' to represent the available liquidity TO BUY.
-	// That means 'Yes' in the response should be YesAsks (what people are selling, so you can buy).
-	// But let's just return what they expect. Originally it just returned Yes and No orders.
-	// For now, let's aggregate Yes Bids and Asks into one? The struct types.AggregatedOrderBook has Yes and No arrays.
-	// Actually, the frontend probably expects Bids and Asks for the whole market.
-	// The struct AggregatedOrderBook has `Yes` and `No`. We'll just put YesBids/YesAsks into Yes, NoBids/NoAsks into No for now.
-	// Let's preserve the original behavior: returning all Yes orders in `Yes`, all No orders in `No`.
-
-	var yesOrders types.OrderHeap
-	yesOrders = append(yesOrders, ob.YesBids.OrderHeap...)
-	yesOrders = append(yesOrders, ob.YesAsks.OrderHeap...)
-
-	var noOrders types.OrderHeap
-	noOrders = append(noOrders, ob.NoBids.OrderHeap...)
-	noOrders = append(noOrders, ob.NoAsks.OrderHeap...)
-
+// AggregateOrderBook lays the book out as bids and asks for each outcome, with the
+// liquidity ProcessLimitOrder can reach through the opposite outcome shown as implied:
+// NO bids back YES asks (MINT) and NO asks back YES bids (MERGE), and the other way round.
+func AggregateOrderBook(ob *types.OrderBook, payout float64) types.AggregatedOrderBook {
 	return types.AggregatedOrderBook{
-		Yes: aggregateOrders(yesOrders, false), // Descending for orderbook
-		No:  aggregateOrders(noOrders, false),
+		Yes: types.OutcomeBook{
+			Bids: bookSide(ob.YesBids.OrderHeap, ob.NoAsks.OrderHeap, payout, false),
+			Asks: bookSide(ob.YesAsks.OrderHeap, ob.NoBids.OrderHeap, payout, true),
+		},
+		No: types.OutcomeBook{
+			Bids: bookSide(ob.NoBids.OrderHeap, ob.YesAsks.OrderHeap, payout, false),
+			Asks: bookSide(ob.NoAsks.OrderHeap, ob.YesBids.OrderHeap, payout, true),
+		},
 	}
 }
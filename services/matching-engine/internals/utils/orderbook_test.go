@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"matching-engine/internals/types"
+	"reflect"
+	"testing"
+)
+
+func level(price float64, direct, implied int) types.BookLevel {
+	return types.BookLevel{Price: price, Quantity: direct + implied, Direct: direct, Implied: implied}
+}
+
+func TestAggregateOrderBook(t *testing.T) {
+	order := func(side types.Side, action types.Action, price float64, qty, filled int) *types.Order {
+		return &types.Order{Side: side, Action: action, Price: price, Quantity: qty, Filled: filled}
+	}
+
+	tests := []struct {
+		name   string
+		orders []*types.Order
+		want   types.AggregatedOrderBook
+	}{
+		{
+			name: "empty book",
+			want: types.AggregatedOrderBook{
+				Yes: types.OutcomeBook{Bids: []types.BookLevel{}, Asks: []types.BookLevel{}},
+				No:  types.OutcomeBook{Bids: []types.BookLevel{}, Asks: []types.BookLevel{}},
+			},
+		},
+		{
+			name:   "NO bid implies a YES ask",
+			orders: []*types.Order{order(types.No, types.BUY, 3, 5, 0)},
+			want: types.AggregatedOrderBook{
+				Yes: types.OutcomeBook{Bids: []types.BookLevel{}, Asks: []types.BookLevel{level(7, 0, 5)}},
+				No:  types.OutcomeBook{Bids: []types.BookLevel{level(3, 5, 0)}, Asks: []types.BookLevel{}},
+			},
+		},
+		{
+			name:   "YES ask implies a NO bid",
+			orders: []*types.Order{order(types.Yes, types.SELL, 6, 4, 1)},
+			want: types.AggregatedOrderBook{
+				Yes: types.OutcomeBook{Bids: []types.BookLevel{}, Asks: []types.BookLevel{level(6, 3, 0)}},
+				No:  types.OutcomeBook{Bids: []types.BookLevel{level(4, 0, 3)}, Asks: []types.BookLevel{}},
+			},
+		},
+		{
+			name: "direct and implied share a level",
+			orders: []*types.Order{
+				order(types.Yes, types.BUY, 6, 2, 0),
+				order(types.No, types.SELL, 4, 3, 0),
+				order(types.Yes, types.BUY, 5, 1, 0),
+			},
+			want: types.AggregatedOrderBook{
+				Yes: types.OutcomeBook{Bids: []types.BookLevel{level(6, 2, 3), level(5, 1, 0)}, Asks: []types.BookLevel{}},
+				No:  types.OutcomeBook{Bids: []types.BookLevel{}, Asks: []types.BookLevel{level(4, 3, 2), level(5, 0, 1)}},
+			},
+		},
+		{
+			name: "asks lowest first, filled orders left out",
+			orders: []*types.Order{
+				order(types.Yes, types.SELL, 8, 1, 0),
+				order(types.Yes, types.SELL, 6.5, 2, 0),
+				order(types.Yes, types.SELL, 7, 2, 2),
+			},
+			want: types.AggregatedOrderBook{
+				Yes: types.OutcomeBook{Bids: []types.BookLevel{}, Asks: []types.BookLevel{level(6.5, 2, 0), level(8, 1, 0)}},
+				No:  types.OutcomeBook{Bids: []types.BookLevel{level(3.5, 0, 2), level(2, 0, 1)}, Asks: []types.BookLevel{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := types.NewOrderBook()
+			for _, o := range tt.orders {
+				switch {
+				case o.Side == types.Yes && o.Action == types.BUY:
+					ob.YesBids.Push(o)
+				case o.Side == types.Yes:
+					ob.YesAsks.Push(o)
+				case o.Action == types.BUY:
+					ob.NoBids.Push(o)
+				default:
+					ob.NoAsks.Push(o)
+				}
+			}
+
+			if got := AggregateOrderBook(ob, 10); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("book %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
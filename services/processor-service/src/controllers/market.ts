@@ -150,8 +150,13 @@ export const handleMarketResolved = async (data: any) => {
 
 		// Push deposits to the engine so memory balances stay in sync
 		for (const payout of payoutsToEngine) {
-			await redisPublisher.lpush(
-				'engine:queue',
+			await redisPublisher.xadd(
+				'engine:stream',
+				'MAXLEN',
+				'~',
+				'100000',
+				'*',
+				'payload',
 				JSON.stringify({
 					responseId: `payout-${marketId}-${payout.userId}`,
 					eventType: 'DEPOSIT_BALANCE',
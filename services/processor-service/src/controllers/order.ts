@@ -482,10 +482,30 @@ export const recordOrderPlaced = async (data: any) => {
 	}
 };
 
-export const handleOrderCancelled = async (data: any) => {
+// cancelRefund reads what a cancelled order released. v1 sent one `refund` with `type`
+// INR, YES_STOCK or NO_STOCK; v2 sends refundCash, or refundShares with refundSide.
+const cancelRefund = (data: any, schemaVersion: number) => {
+	if (schemaVersion === 1) {
+		const qty = Number(data.refund) || 0;
+		if (data.type === 'INR') return { cash: qty, shares: 0, side: null };
+		if (data.type === 'YES_STOCK') return { cash: 0, shares: qty, side: 'YES' };
+		if (data.type === 'NO_STOCK') return { cash: 0, shares: qty, side: 'NO' };
+		throw new Error(`Unknown ORDER_CANCELLED v1 refund type: ${data.type}`);
+	}
+	if (schemaVersion === 2) {
+		const side = data.refundSide || null;
+		if (side !== null && side !== 'YES' && side !== 'NO') {
+			throw new Error(`Unknown ORDER_CANCELLED refundSide: ${side}`);
+		}
+		return { cash: Number(data.refundCash) || 0, shares: Number(data.refundShares) || 0, side };
+	}
+	throw new Error(`Unsupported ORDER_CANCELLED schemaVersion: ${schemaVersion}`);
+};
+
+export const handleOrderCancelled = async (data: any, schemaVersion: number) => {
 	try {
-		const { userId, orderId, refund, type, marketId } = data;
-		const qty = Number(refund);
+		const { userId, orderId, marketId } = data;
+		const refund = cancelRefund(data, schemaVersion);
 
 		await prisma.$transaction(async (tx) => {
 			if (orderId) {
@@ -495,30 +515,30 @@ export const handleOrderCancelled = async (data: any) => {
 				});
 			}
 
-			if (type === 'INR') {
+			if (refund.cash > 0) {
 				await tx.wallet.updateMany({
 					where: { userId },
 					data: {
-						locked: { decrement: qty },
-						balance: { increment: qty },
+						locked: { decrement: refund.cash },
+						balance: { increment: refund.cash },
 					},
 				});
 				await tx.ledgerEntry.create({
 					data: {
 						fromAccount: 'EXCHANGE_ESCROW',
 						toAccount: userId,
-						amount: qty,
+						amount: refund.cash,
 						type: 'REFUND',
 						referenceId: marketId || 'CANCEL',
 					},
 				});
-			} else if (type === 'YES_STOCK' || type === 'NO_STOCK') {
-				const field = type === 'YES_STOCK' ? 'yes' : 'no';
+			} else if (refund.shares > 0 && refund.side) {
+				const field = refund.side === 'YES' ? 'yes' : 'no';
 				await tx.position.updateMany({
 					where: { userId, marketId },
 					data: {
-						[`${field}Locked`]: { decrement: qty },
-						[`${field}Quantity`]: { increment: qty },
+						[`${field}Locked`]: { decrement: refund.shares },
+						[`${field}Quantity`]: { increment: refund.shares },
 					},
 				});
 			}
@@ -6,7 +6,10 @@ import { KafkaMessageSchema } from '@/schemas/kafka';
 
 export const dbConsumer = async () => {
 	await consumer.connect();
-	await consumer.subscribe({ topics: ['process_db', 'process_db_retry'], fromBeginning: true });
+	await consumer.subscribe({
+		topics: ['process_db.market', 'process_db.wallet', 'process_db.ops', 'process_db_retry'],
+		fromBeginning: true,
+	});
 
 	await consumer.run({
 		autoCommit: false,
@@ -22,7 +25,7 @@ export const dbConsumer = async () => {
 				const eventType: string = parsedEvent.type;
 				const eventData: any = parsedEvent.data;
 
-				await processToDB(eventType, eventData);
+				await processToDB(eventType, eventData, parsedEvent.schemaVersion);
 
 				await consumer.commitOffsets([
 					{ topic, partition, offset: (Number(message.offset) + 1).toString() },
@@ -8,7 +8,7 @@ import {
 	handleSharesMerged,
 } from '@/controllers/order';
 
-export const processToDB = async (eventType: string, data: any) => {
+export const processToDB = async (eventType: string, data: any, schemaVersion: number) => {
 	switch (eventType) {
 		case DB_EVENTS.INCREASE_TRADERS_COUNT:
 			await updateTradersCount(data);
@@ -27,7 +27,7 @@ export const processToDB = async (eventType: string, data: any) => {
 			break;
 
 		case DB_EVENTS.ORDER_CANCELLED:
-			await handleOrderCancelled(data);
+			await handleOrderCancelled(data, schemaVersion);
 			break;
 
 		case DB_EVENTS.MARKET_RESOLVED:
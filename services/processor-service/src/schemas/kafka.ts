@@ -2,5 +2,7 @@ import { z } from 'zod';
 
 export const KafkaMessageSchema = z.object({
 	type: z.string(),
+	// Events from before the engine versioned them are v1
+	schemaVersion: z.number().int().positive().default(1),
 	data: z.any(),
 });
@@ -1,6 +1,7 @@
 import { Hono } from 'hono';
 import { Server } from 'socket.io';
 import { createServer } from 'http';
+import { verifyStreamToken } from '@/lib/auth';
 
 const app = new Hono();
 
@@ -52,32 +53,39 @@ io.on('connection', (socket) => {
 		console.log(`Client ${socket.id} unsubscribed full market: ${symbol}`);
 	});
 
-	// Subscribe to user private notifications (portfolio/orders)
-	socket.on('SUBSCRIBE_USER', (userId: string) => {
+	// Subscribe to user private notifications (portfolio/orders/balances). The room is
+	// only joined for the user the api service issued the stream token to.
+	socket.on('SUBSCRIBE_USER', async (token: string) => {
+		const userId = await verifyStreamToken(token);
+		if (!userId) {
+			socket.emit('UNAUTHORIZED', { event: 'SUBSCRIBE_USER' });
+			console.log(`Client ${socket.id} sent an invalid stream token`);
+			return;
+		}
+		socket.data.userId = userId;
 		socket.join(`user:${userId}`);
-		socket.join(userId);
 		console.log(`Client ${socket.id} subscribed user: ${userId}`);
 	});
 
-	socket.on('UNSUBSCRIBE_USER', (userId: string) => {
+	socket.on('UNSUBSCRIBE_USER', () => {
+		const userId = socket.data.userId;
+		if (!userId) return;
 		socket.leave(`user:${userId}`);
-		socket.leave(userId);
+		delete socket.data.userId;
 		console.log(`Client ${socket.id} unsubscribed user: ${userId}`);
 	});
 
-	// Legacy fallback support
+	// Legacy fallback support, for market data only; user rooms need SUBSCRIBE_USER
 	socket.on('SUBSCRIBE', (room: string) => {
 		socket.join(room);
 		socket.join(`ticker:${room}`);
 		socket.join(`market:${room}`);
-		socket.join(`user:${room}`);
 	});
 
 	socket.on('UNSUBSCRIBE', (room: string) => {
 		socket.leave(room);
 		socket.leave(`ticker:${room}`);
 		socket.leave(`market:${room}`);
-		socket.leave(`user:${room}`);
 	});
 
 	socket.on('disconnect', () => {
@@ -3,4 +3,5 @@ export const ENV = {
 	REDIS_DB: Bun.env.REDIS_DB,
 	REDIS_HOST: Bun.env.REDIS_HOST,
 	REDIS_PORT: Bun.env.REDIS_PORT,
+	STREAM_TOKEN_SECRET: Bun.env.STREAM_TOKEN_SECRET,
 } as const;
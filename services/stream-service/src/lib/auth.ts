@@ -0,0 +1,15 @@
+import { verify } from 'hono/jwt';
+import { ENV } from '@/config/env';
+
+// Resolves the user a stream token from the api service was issued to, or null when it
+// does not verify or was not issued for the stream
+export const verifyStreamToken = async (token: unknown): Promise<string | null> => {
+	if (typeof token !== 'string' || !token) return null;
+	try {
+		const payload = await verify(token, ENV.STREAM_TOKEN_SECRET!, 'HS256');
+		if (payload.scope !== 'stream' || typeof payload.id !== 'string') return null;
+		return payload.id;
+	} catch {
+		return null;
+	}
+};
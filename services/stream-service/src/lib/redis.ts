@@ -2,6 +2,7 @@ import { io } from '@/app';
 import Redis from 'ioredis';
 import { ENV } from '@/config/env';
 import { logger } from '@/utils/logger';
+import { decodeMsgpack } from '@/lib/msgpack';
 
 const redisSubscriber = new Redis({
 	host: ENV.REDIS_HOST,
@@ -17,6 +18,107 @@ redisSubscriber.on('error', () => {
 	logger.error('Failed to connect to Redis');
 });
 
+// The engine publishes each market and each user on their own channel; portfolio
+// updates stay on stream:data
+const ROOM_CHANNELS: Record<string, string> = {
+	'market:': 'stream:market:',
+	'ticker:': 'stream:market:',
+	'user:': 'stream:user:',
+};
+
+// Rooms per engine channel that exist on this instance; only markets and users someone
+// here is watching are subscribed to
+const watchedRooms = new Map<string, number>();
+
+const roomChannel = (room: string) => {
+	for (const [prefix, channel] of Object.entries(ROOM_CHANNELS)) {
+		if (room.startsWith(prefix)) return channel + room.slice(prefix.length);
+	}
+	return null;
+};
+
+const watchRoom = (room: string) => {
+	const channel = roomChannel(room);
+	if (!channel) return;
+	const count = (watchedRooms.get(channel) || 0) + 1;
+	watchedRooms.set(channel, count);
+	if (count === 1) {
+		redisSubscriber.subscribe(channel).catch(() => {
+			logger.error(`Failed to subscribe to ${channel}`);
+		});
+	}
+};
+
+const unwatchRoom = (room: string) => {
+	const channel = roomChannel(room);
+	if (!channel || !watchedRooms.has(channel)) return;
+	const count = watchedRooms.get(channel)! - 1;
+	if (count > 0) {
+		watchedRooms.set(channel, count);
+		return;
+	}
+	watchedRooms.delete(channel);
+	redisSubscriber.unsubscribe(channel).catch(() => {
+		logger.error(`Failed to unsubscribe from ${channel}`);
+	});
+};
+
+// JSON always starts with '{'; anything else is the engine's MessagePack encoding
+const decode = (message: Buffer) =>
+	message[0] === 0x7b ? JSON.parse(message.toString()) : decodeMsgpack(message);
+
+const route = (data: any) => {
+	if (data.userId && (data.type === 'ORDER_UPDATE' || data.type === 'BALANCE' || data.type === 'BATCH')) {
+		routePrivate(data);
+		return;
+	}
+
+	const symbol = data.symbol;
+
+	if (!symbol) {
+		logger.warn('Message missing symbol: ' + JSON.stringify(data));
+		return;
+	}
+
+	const type = data.type;
+	logger.info(`Redis message received: symbol=${symbol} type=${type || 'UNSET'}`);
+
+	if (type === 'BATCH') {
+		// Updates the engine coalesced, in the order they happened
+		(data.updates || []).forEach(route);
+	} else if (type === 'TICKER') {
+		// Ticker goes to both ticker browsers (Events/Wishlist) and full market details viewers
+		io.to(`ticker:${symbol}`).emit('TICKER', data);
+		io.to(`market:${symbol}`).emit('TICKER', data);
+		io.to(symbol).emit('MESSAGE', data);
+	} else if (type === 'BOOK_DIFF' || type === 'BOOK_SNAPSHOT') {
+		// Book updates ONLY go to the market detail viewer, in seq order
+		io.to(`market:${symbol}`).emit(type, data);
+		io.to(symbol).emit('MESSAGE', data);
+	} else if (type === 'ACTIVITY') {
+		// Activity/Trades ONLY go to the market detail viewer
+		io.to(`market:${symbol}`).emit('ACTIVITY', data);
+		io.to(symbol).emit('MESSAGE', data);
+	} else if (type === 'PORTFOLIO_UPDATE') {
+		// Portfolio updates go only to the user's private room
+		io.to(`user:${symbol}`).emit('PORTFOLIO_UPDATE', data);
+	} else {
+		// Fallback for untyped messages
+		io.to(`ticker:${symbol}`).emit('MESSAGE', data);
+		io.to(`market:${symbol}`).emit('MESSAGE', data);
+		io.to(symbol).emit('MESSAGE', data);
+	}
+};
+
+// Order updates and balances go only to the user's private room
+const routePrivate = (data: any) => {
+	if (data.type === 'BATCH') {
+		(data.updates || []).forEach(routePrivate);
+		return;
+	}
+	io.to(`user:${data.userId}`).emit(data.type, data);
+};
+
 export const startStreamSubscriber = async () => {
 	await redisSubscriber.subscribe('stream:data', (err) => {
 		if (err) {
@@ -24,45 +126,14 @@ export const startStreamSubscriber = async () => {
 		}
 	});
 
-	redisSubscriber.on('message', (channel, message) => {
+	io.of('/').adapter.on('create-room', watchRoom);
+	io.of('/').adapter.on('delete-room', unwatchRoom);
+
+	redisSubscriber.on('messageBuffer', (channel: Buffer, message: Buffer) => {
 		try {
-			const data = JSON.parse(message);
-			const symbol = data.symbol;
-
-			if (!symbol) {
-				logger.warn('Message missing symbol: ' + message);
-				return;
-			}
-
-			const type = data.type;
-			logger.info(`Redis message received: symbol=${symbol} type=${type || 'UNSET'}`);
-
-			if (type === 'TICKER') {
-				// Ticker goes to both ticker browsers (Events/Wishlist) and full market details viewers
-				io.to(`ticker:${symbol}`).emit('TICKER', data);
-				io.to(`market:${symbol}`).emit('TICKER', data);
-				io.to(symbol).emit('MESSAGE', data);
-			} else if (type === 'ORDERBOOK') {
-				// Orderbook ONLY goes to the market detail viewer
-				io.to(`market:${symbol}`).emit('ORDERBOOK', data);
-				io.to(symbol).emit('MESSAGE', data);
-			} else if (type === 'ACTIVITY') {
-				// Activity/Trades ONLY go to the market detail viewer
-				io.to(`market:${symbol}`).emit('ACTIVITY', data);
-				io.to(symbol).emit('MESSAGE', data);
-			} else if (type === 'PORTFOLIO_UPDATE') {
-				// Portfolio updates go to user private room
-				io.to(`user:${symbol}`).emit('PORTFOLIO_UPDATE', data);
-				io.to(symbol).emit('PORTFOLIO_UPDATE', data);
-				io.to(symbol).emit('MESSAGE', data);
-			} else {
-				// Fallback for untyped messages
-				io.to(`ticker:${symbol}`).emit('MESSAGE', data);
-				io.to(`market:${symbol}`).emit('MESSAGE', data);
-				io.to(symbol).emit('MESSAGE', data);
-			}
+			route(decode(message));
 		} catch (e) {
-			logger.error('Invalid message format from Redis: ' + message);
+			logger.error(`Invalid message format from Redis on ${channel.toString()}`);
 		}
 	});
 };